@@ -1,6 +1,9 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"log"
 	"os"
 	"strings"
@@ -11,8 +14,14 @@ type Config struct {
 	Port        string
 	Host        string
 	JWTSecret   string
-	CORSOrigins []string
-	Env         string
+	// CAEncryptionKey encrypts the keys of the CAs that sign remote
+	// certificates
+	CAEncryptionKey string
+	// LicenseSigningKey is the base64 Ed25519 key that signs offline
+	// licenses; the CLI embeds its public half
+	LicenseSigningKey string
+	CORSOrigins       []string
+	Env               string
 }
 
 func Load() *Config {
@@ -29,6 +38,8 @@ func Load() *Config {
 	dbURL := os.Getenv("DATABASE_URL")
 	jwt := os.Getenv("JWT_SECRET")
 	cors := os.Getenv("CORS_ORIGINS")
+	caKey := os.Getenv("CA_ENCRYPTION_KEY")
+	licenseKey := os.Getenv("LICENSE_SIGNING_KEY")
 
 	// ✅ In production, these MUST exist (deploy reads them from Render env vars)
 	if env == "production" {
@@ -41,6 +52,12 @@ func Load() *Config {
 		if cors == "" {
 			log.Fatal("CORS_ORIGINS is required in production")
 		}
+		if caKey == "" {
+			log.Fatal("CA_ENCRYPTION_KEY is required in production")
+		}
+		if licenseKey == "" {
+			log.Fatal("LICENSE_SIGNING_KEY is required in production")
+		}
 	}
 
 	// ✅ In development, allow local defaults
@@ -54,6 +71,12 @@ func Load() *Config {
 		if cors == "" {
 			cors = "http://localhost:3000"
 		}
+		if caKey == "" {
+			caKey = "dev-ca-secret"
+		}
+		if licenseKey == "" {
+			licenseKey = devLicenseKey()
+		}
 	}
 
 	// Parse origins (trim spaces!)
@@ -67,12 +90,14 @@ func Load() *Config {
 	}
 
 	return &Config{
-		DatabaseURL: dbURL,
-		Port:        port,
-		Host:        host,
-		JWTSecret:   jwt,
-		CORSOrigins: origins,
-		Env:         env,
+		DatabaseURL:       dbURL,
+		Port:              port,
+		Host:              host,
+		JWTSecret:         jwt,
+		CAEncryptionKey:   caKey,
+		LicenseSigningKey: licenseKey,
+		CORSOrigins:       origins,
+		Env:               env,
 	}
 }
 
@@ -82,3 +107,19 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// devLicenseKey generates a throwaway license signing key, so no private
+// key ever has to be committed. Every restart makes a new key, which no CLI
+// build can verify for long. The CLI goes by the API's answer while it can
+// reach it, so this only leaves it without a cached license for offline
+// use.
+func devLicenseKey() string {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("Failed to generate a license signing key: %v", err)
+	}
+
+	log.Printf("LICENSE_SIGNING_KEY is not set; using a temporary key. Build the CLI with LICENSE_PUBLIC_KEY=%s to accept its licenses",
+		base64.StdEncoding.EncodeToString(pub))
+	return base64.StdEncoding.EncodeToString(priv.Seed())
+}
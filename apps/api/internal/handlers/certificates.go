@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/instanttls/api/internal/issuer"
+	"github.com/instanttls/api/internal/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// GetServerCA returns the certificate of the CA that signs the token
+// organization's remote certificates, creating the CA on first use (PAT
+// auth). Clients install it in their trust stores.
+func (h *Handler) GetServerCA(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+
+	ca, err := h.serverCA(org)
+	if err != nil {
+		h.logger.Errorf("Failed to load server CA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load CA"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ServerCAResponse{
+		Certificate: ca.CertPEM,
+		Fingerprint: ca.Fingerprint,
+	})
+}
+
+// CreateCertificate signs a CSR with the token organization's server CA
+// (PAT auth). The organization's domain policy, maximum validity and plan
+// quotas are enforced here, whatever the client checked.
+func (h *Handler) CreateCertificate(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	org := c.MustGet("org").(models.Organization)
+
+	var req struct {
+		CSR          string `json:"csr" binding:"required"`
+		ValidityDays int    `json:"validity_days" binding:"omitempty,min=1"`
+		// Replaces is the serial number of a certificate this one renews
+		Replaces string `json:"replaces"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	csr, err := issuer.ParseCSR(req.CSR)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "csr: " + err.Error()})
+		return
+	}
+	dnsNames, ips, err := issuer.Names(csr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "csr: " + err.Error()})
+		return
+	}
+
+	policy := issuer.Policy{AllowedDomains: org.AllowedDomains, MaxValidityDays: org.MaxValidityDays}
+	if err := policy.Check(dnsNames, ips); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	validityDays := req.ValidityDays
+	if validityDays == 0 {
+		validityDays = min(issuer.DefaultValidityDays, policy.MaxValidityDays)
+	}
+	if validityDays > policy.MaxValidityDays {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("validity_days exceeds the organization's maximum of %d", policy.MaxValidityDays)})
+		return
+	}
+
+	ca, err := h.serverCA(org)
+	if err != nil {
+		h.logger.Errorf("Failed to load server CA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue certificate"})
+		return
+	}
+
+	keyDER, err := issuer.Open(h.cfg.CAEncryptionKey, ca.EncryptedKey)
+	if err != nil {
+		h.logger.Errorf("Failed to unseal server CA key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue certificate"})
+		return
+	}
+
+	leaf, err := issuer.Sign(ca.CertPEM, keyDER, csr, dnsNames, ips, validityDays)
+	if err != nil {
+		h.logger.Errorf("Failed to sign certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue certificate"})
+		return
+	}
+
+	ipStrings := make([]string, len(ips))
+	for i, ip := range ips {
+		ipStrings[i] = ip.String()
+	}
+
+	record := newCertificate(org, user, leaf.SerialNumber.Text(16), leaf.Subject.CommonName, dnsNames, ipStrings, leaf.NotBefore, leaf.NotAfter)
+	record.Remote = true
+
+	// The certificate is only handed out once it is recorded within quota
+	if msg, err := h.recordCertificate(org, record, req.Replaces); err != nil {
+		h.logger.Errorf("Failed to record certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue certificate"})
+		return
+	} else if msg != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.CertificateCreateResponse{
+		Certificate:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})),
+		CACertificate: ca.CertPEM,
+		Data:          record,
+	})
+}
+
+// ReportCertificate records a certificate signed by a local CA before the
+// CLI saves it (PAT auth). Plan quotas are checked against every
+// certificate reported for the token's organization, so deleting local
+// files does not free any; a 403 tells the CLI to discard the certificate.
+func (h *Handler) ReportCertificate(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	org := c.MustGet("org").(models.Organization)
+
+	var req struct {
+		SerialNumber string    `json:"serial_number" binding:"required,hexadecimal,max=40"`
+		CommonName   string    `json:"common_name" binding:"required,max=255"`
+		DNSNames     []string  `json:"dns_names"`
+		IPAddresses  []string  `json:"ip_addresses"`
+		NotBefore    time.Time `json:"not_before" binding:"required"`
+		NotAfter     time.Time `json:"not_after" binding:"required"`
+		// Replaces is the serial number of a certificate this one renews
+		Replaces string `json:"replaces"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dns_names or ip_addresses is required"})
+		return
+	}
+	if !req.NotAfter.After(req.NotBefore) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not_after must be after not_before"})
+		return
+	}
+
+	dnsNames := make([]string, len(req.DNSNames))
+	for i, name := range req.DNSNames {
+		dnsNames[i] = strings.TrimSuffix(strings.ToLower(name), ".")
+	}
+	ips := make([]string, len(req.IPAddresses))
+	for i, addr := range req.IPAddresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid IP address %q", addr)})
+			return
+		}
+		ips[i] = ip.String()
+	}
+
+	record := newCertificate(org, user, strings.ToLower(req.SerialNumber), req.CommonName, dnsNames, ips, req.NotBefore, req.NotAfter)
+
+	if msg, err := h.recordCertificate(org, record, req.Replaces); err != nil {
+		h.logger.Errorf("Failed to record certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record certificate"})
+		return
+	} else if msg != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+		return
+	}
+
+	c.JSON(http.StatusCreated, record)
+}
+
+// ListCertificates returns the certificates issued for the token's
+// organization, newest first (PAT auth)
+func (h *Handler) ListCertificates(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+
+	var certs []models.Certificate
+	err := h.db.Select(&certs, `
+		SELECT * FROM certificates WHERE org_id = $1 ORDER BY created_at DESC
+	`, org.ID)
+
+	if err != nil {
+		h.logger.Errorf("Failed to list certificates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list certificates"})
+		return
+	}
+
+	if certs == nil {
+		certs = []models.Certificate{}
+	}
+	c.JSON(http.StatusOK, certs)
+}
+
+// GetPolicy returns an organization's certificate issuance policy
+func (h *Handler) GetPolicy(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+
+	c.JSON(http.StatusOK, models.PolicyResponse{
+		AllowedDomains:  org.AllowedDomains,
+		MaxValidityDays: org.MaxValidityDays,
+	})
+}
+
+// UpdatePolicy replaces an organization's certificate issuance policy
+// (owner or admin)
+func (h *Handler) UpdatePolicy(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	if !membership.Role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can change the certificate policy"})
+		return
+	}
+
+	var req models.PolicyResponse
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.AllowedDomains) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "allowed_domains cannot be empty"})
+		return
+	}
+	domains := make([]string, 0, len(req.AllowedDomains))
+	for _, d := range req.AllowedDomains {
+		domain, err := issuer.NormalizeDomain(d)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		domains = append(domains, domain)
+	}
+
+	if req.MaxValidityDays < 1 || req.MaxValidityDays > issuer.MaxValidityDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("max_validity_days must be between 1 and %d", issuer.MaxValidityDays)})
+		return
+	}
+
+	if _, err := h.db.Exec(`
+		UPDATE organizations SET allowed_domains = $1, max_validity_days = $2 WHERE id = $3
+	`, pq.StringArray(domains), req.MaxValidityDays, org.ID); err != nil {
+		h.logger.Errorf("Failed to update policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PolicyResponse{
+		AllowedDomains:  domains,
+		MaxValidityDays: req.MaxValidityDays,
+	})
+}
+
+// serverCA returns the organization's server CA, creating it if needed
+func (h *Handler) serverCA(org models.Organization) (models.ServerCA, error) {
+	var ca models.ServerCA
+	err := h.db.Get(&ca, "SELECT * FROM server_cas WHERE org_id = $1", org.ID)
+	if err == nil {
+		return ca, nil
+	}
+
+	certPEM, keyDER, err := issuer.NewCA(org.Name)
+	if err != nil {
+		return ca, err
+	}
+	sealed, err := issuer.Seal(h.cfg.CAEncryptionKey, keyDER)
+	if err != nil {
+		return ca, err
+	}
+	fingerprint, err := issuer.Fingerprint(certPEM)
+	if err != nil {
+		return ca, err
+	}
+
+	// Another request may have created it meanwhile; keep whichever won
+	_, err = h.db.Exec(`
+		INSERT INTO server_cas (org_id, cert_pem, encrypted_key, fingerprint)
+		VALUES ($1, $2, $3, $4) ON CONFLICT (org_id) DO NOTHING
+	`, org.ID, certPEM, sealed, fingerprint)
+	if err != nil {
+		return ca, err
+	}
+
+	err = h.db.Get(&ca, "SELECT * FROM server_cas WHERE org_id = $1", org.ID)
+	return ca, err
+}
+
+// newCertificate builds the record of a certificate issued by user
+func newCertificate(org models.Organization, user models.User, serial, commonName string, dnsNames, ips []string, notBefore, notAfter time.Time) models.Certificate {
+	wildcard := false
+	for _, name := range dnsNames {
+		wildcard = wildcard || strings.HasPrefix(name, "*.")
+	}
+
+	return models.Certificate{
+		ID:           uuid.New(),
+		OrgID:        org.ID,
+		UserID:       &user.ID,
+		SerialNumber: serial,
+		CommonName:   commonName,
+		DNSNames:     pq.StringArray(append([]string{}, dnsNames...)),
+		IPAddresses:  pq.StringArray(append([]string{}, ips...)),
+		Wildcard:     wildcard,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		CreatedAt:    time.Now(),
+	}
+}
+
+// recordCertificate stores a certificate if the organization's plan quotas
+// allow it, returning a message if they do not. The certificate it
+// replaces, if any, stops counting toward them first. Recording the same
+// serial number twice is a no-op so clients can retry.
+func (h *Handler) recordCertificate(org models.Organization, record models.Certificate, replaces string) (string, error) {
+	tx, err := h.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Serialize issuance per organization so quotas cannot be raced
+	if _, err := tx.Exec("SELECT id FROM organizations WHERE id = $1 FOR UPDATE", org.ID); err != nil {
+		return "", err
+	}
+
+	// A retried report is already counted, and its predecessor already
+	// superseded, so it must not go through the quota checks again
+	var recorded bool
+	err = tx.Get(&recorded, `
+		SELECT EXISTS (SELECT 1 FROM certificates WHERE org_id = $1 AND serial_number = $2)
+	`, org.ID, record.SerialNumber)
+	if err != nil {
+		return "", err
+	}
+	if recorded {
+		return "", nil
+	}
+
+	if replaces != "" {
+		var old models.Certificate
+		err := tx.Get(&old, `
+			SELECT * FROM certificates
+			WHERE org_id = $1 AND serial_number = $2 AND superseded_at IS NULL
+		`, org.ID, strings.ToLower(replaces))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+
+		// Only a renewal for the same names frees its predecessor's quota,
+		// or a wildcard could be traded for another while both stay valid
+		if err == nil && sameNames(old.DNSNames, record.DNSNames) && sameNames(old.IPAddresses, record.IPAddresses) {
+			if _, err := tx.Exec("UPDATE certificates SET superseded_at = NOW() WHERE id = $1", old.ID); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if msg, err := checkQuota(tx, org, record); err != nil || msg != "" {
+		return msg, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO certificates (id, org_id, user_id, serial_number, common_name, dns_names, ip_addresses, wildcard, remote, not_before, not_after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (org_id, serial_number) DO NOTHING
+	`, record.ID, record.OrgID, record.UserID, record.SerialNumber, record.CommonName,
+		record.DNSNames, record.IPAddresses, record.Wildcard, record.Remote, record.NotBefore, record.NotAfter)
+	if err != nil {
+		return "", err
+	}
+
+	return "", tx.Commit()
+}
+
+// sameNames reports whether two lists hold the same names in any order
+func sameNames(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// checkQuota returns a message if recording the certificate would exceed
+// the organization's plan limits. The monthly limit covers certificates the
+// API signs; the wildcard limit covers every unexpired wildcard certificate
+// that has not been renewed, wherever it was signed.
+func checkQuota(tx *sqlx.Tx, org models.Organization, record models.Certificate) (string, error) {
+	limits := planLimits(org.Plan)
+
+	if limit := limits["max_certs_per_month"]; record.Remote && limit >= 0 {
+		var count int
+		err := tx.Get(&count, `
+			SELECT COUNT(*) FROM certificates
+			WHERE org_id = $1 AND remote AND created_at >= date_trunc('month', NOW())
+		`, org.ID)
+		if err != nil {
+			return "", err
+		}
+		if count >= limit {
+			return fmt.Sprintf("Monthly certificate limit reached (%d/%d). Upgrade for more.", count, limit), nil
+		}
+	}
+
+	if limit := limits["max_wildcard_certs"]; record.Wildcard && limit >= 0 {
+		var count int
+		err := tx.Get(&count, `
+			SELECT COUNT(*) FROM certificates
+			WHERE org_id = $1 AND wildcard AND superseded_at IS NULL AND not_after > NOW()
+		`, org.ID)
+		if err != nil {
+			return "", err
+		}
+		if count >= limit {
+			return fmt.Sprintf("Wildcard certificate limit reached (%d/%d). Upgrade to Pro for unlimited certs.", count, limit), nil
+		}
+	}
+
+	return "", nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/instanttls/api/internal/config"
+	"github.com/instanttls/api/internal/license"
 	"github.com/instanttls/api/internal/models"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
@@ -54,12 +55,26 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	// Create user
+	// Create user and their personal organization
 	userID := uuid.New()
-	_, err = h.db.Exec(`
-		INSERT INTO users (id, email, password_hash, plan)
-		VALUES ($1, $2, $3, $4)
-	`, userID, req.Email, string(passwordHash), "free")
+	tx, err := h.db.Beginx()
+	if err != nil {
+		h.logger.Errorf("Failed to create user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO users (id, email, password_hash)
+		VALUES ($1, $2, $3)
+	`, userID, req.Email, string(passwordHash))
+	if err == nil {
+		_, err = createPersonalOrg(tx, userID, req.Email, models.PlanFree)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 
 	if err != nil {
 		h.logger.Errorf("Failed to create user: %v", err)
@@ -67,12 +82,19 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	// Create session token
-	sessionToken := createSessionToken(userID, req.Email, "free")
+	// Create session
+	tokens, err := h.createSession(c, userID)
+	if err != nil {
+		h.logger.Errorf("Failed to create session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "User created successfully",
-		"token":   sessionToken,
+		"message":       "User created successfully",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 		"user": models.UserResponse{
 			ID:        userID,
 			Email:     req.Email,
@@ -96,7 +118,7 @@ func (h *Handler) Login(c *gin.Context) {
 
 	// Get user
 	var user models.User
-	err := h.db.Get(&user, "SELECT * FROM users WHERE email = $1", req.Email)
+	err := h.db.Get(&user, models.UserSelect+"WHERE u.email = $1", req.Email)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
@@ -108,11 +130,18 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	// Create session token
-	sessionToken := createSessionToken(user.ID, user.Email, string(user.Plan))
+	// Create session
+	tokens, err := h.createSession(c, user.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to create session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"token": sessionToken,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 		"user": models.UserResponse{
 			ID:        user.ID,
 			Email:     user.Email,
@@ -138,36 +167,50 @@ func (h *Handler) Me(c *gin.Context) {
 func (h *Handler) GetUser(c *gin.Context) {
 	user := c.MustGet("user").(models.User)
 
-	// Get full user from DB
-	var fullUser models.User
-	err := h.db.Get(&fullUser, "SELECT * FROM users WHERE id = $1", user.ID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
-
 	c.JSON(http.StatusOK, models.UserResponse{
-		ID:        fullUser.ID,
-		Email:     fullUser.Email,
-		Plan:      fullUser.Plan,
-		CreatedAt: fullUser.CreatedAt,
+		ID:        user.ID,
+		Email:     user.Email,
+		Plan:      user.Plan,
+		CreatedAt: user.CreatedAt,
 	})
 }
 
-// License returns user's license info
+// License returns the plan and limits of the organization the token
+// belongs to, along with a signed copy bound to the machine named by the
+// machine query parameter
 func (h *Handler) License(c *gin.Context) {
 	user := c.MustGet("user").(models.User)
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
 
-	limits := map[string]int{
-		"max_wildcard_certs": 1,
+	limits := planLimits(org.Plan)
+
+	key, err := license.ParseKey(h.cfg.LicenseSigningKey)
+	if err != nil {
+		h.logger.Errorf("Failed to load license signing key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign license"})
+		return
 	}
 
-	if user.Plan == models.PlanPro || user.Plan == models.PlanTeam {
-		limits["max_wildcard_certs"] = -1 // unlimited
+	now := time.Now().UTC()
+	signed, err := license.Sign(key, license.Claims{
+		OrgID:     org.ID.String(),
+		OrgName:   org.Name,
+		Email:     user.Email,
+		Plan:      string(org.Plan),
+		Limits:    limits,
+		Machine:   c.Query("machine"),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(license.Validity),
+	})
+	if err != nil {
+		h.logger.Errorf("Failed to sign license: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign license"})
+		return
 	}
 
 	c.JSON(http.StatusOK, models.LicenseResponse{
-		Plan:   user.Plan,
+		Plan:   org.Plan,
 		Limits: limits,
 		User: models.UserResponse{
 			ID:        user.ID,
@@ -175,16 +218,38 @@ func (h *Handler) License(c *gin.Context) {
 			Plan:      user.Plan,
 			CreatedAt: user.CreatedAt,
 		},
+		Org:     orgResponse(org, membership.Role),
+		License: signed,
 	})
 }
 
+// planLimits returns the limits of a plan; -1 means unlimited
+func planLimits(plan models.Plan) map[string]int {
+	limits := map[string]int{
+		"max_wildcard_certs":  1,
+		"max_members":         1,
+		"max_certs_per_month": 25,
+	}
+
+	if plan == models.PlanPro || plan == models.PlanTeam {
+		limits["max_wildcard_certs"] = -1
+		limits["max_certs_per_month"] = 1000
+	}
+	if plan == models.PlanTeam {
+		limits["max_members"] = -1
+		limits["max_certs_per_month"] = -1
+	}
+
+	return limits
+}
+
 // ListTokens returns all tokens for the user
 func (h *Handler) ListTokens(c *gin.Context) {
 	user := c.MustGet("user").(models.User)
 
 	var tokens []models.Token
 	err := h.db.Select(&tokens, `
-		SELECT id, user_id, name, prefix, token_hash, last_used_at, created_at
+		SELECT id, user_id, org_id, name, prefix, token_hash, last_used_at, created_at
 		FROM tokens WHERE user_id = $1 ORDER BY created_at DESC
 	`, user.ID)
 
@@ -198,6 +263,7 @@ func (h *Handler) ListTokens(c *gin.Context) {
 	for i, t := range tokens {
 		response[i] = models.TokenResponse{
 			ID:         t.ID,
+			OrgID:      t.OrgID,
 			Name:       t.Name,
 			Prefix:     t.Prefix,
 			LastUsedAt: t.LastUsedAt,
@@ -214,6 +280,8 @@ func (h *Handler) CreateToken(c *gin.Context) {
 
 	var req struct {
 		Name string `json:"name" binding:"required"`
+		// OrgID scopes the token; it defaults to the personal organization
+		OrgID *uuid.UUID `json:"org_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -221,6 +289,21 @@ func (h *Handler) CreateToken(c *gin.Context) {
 		return
 	}
 
+	var orgID uuid.UUID
+	if req.OrgID != nil {
+		err := h.db.Get(&orgID, `
+			SELECT org_id FROM memberships WHERE org_id = $1 AND user_id = $2
+		`, *req.OrgID, user.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+	} else if err := h.db.Get(&orgID, "SELECT id FROM organizations WHERE personal_user_id = $1", user.ID); err != nil {
+		h.logger.Errorf("Failed to find personal organization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
 	// Generate random token
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
@@ -235,9 +318,9 @@ func (h *Handler) CreateToken(c *gin.Context) {
 
 	tokenID := uuid.New()
 	_, err := h.db.Exec(`
-		INSERT INTO tokens (id, user_id, name, prefix, token_hash)
-		VALUES ($1, $2, $3, $4, $5)
-	`, tokenID, user.ID, req.Name, prefix, tokenHash)
+		INSERT INTO tokens (id, user_id, org_id, name, prefix, token_hash)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, tokenID, user.ID, orgID, req.Name, prefix, tokenHash)
 
 	if err != nil {
 		h.logger.Errorf("Failed to create token: %v", err)
@@ -249,6 +332,7 @@ func (h *Handler) CreateToken(c *gin.Context) {
 		Token: token, // Only shown once!
 		Data: models.TokenResponse{
 			ID:        tokenID,
+			OrgID:     orgID,
 			Name:      req.Name,
 			Prefix:    prefix,
 			CreatedAt: time.Now(),
@@ -280,9 +364,10 @@ func (h *Handler) DeleteToken(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
 }
 
-// MachinePing registers or updates a machine
+// MachinePing registers or updates a machine in the token's organization
 func (h *Handler) MachinePing(c *gin.Context) {
 	user := c.MustGet("user").(models.User)
+	org := c.MustGet("org").(models.Organization)
 
 	var req struct {
 		Hostname string `json:"hostname" binding:"required"`
@@ -297,13 +382,13 @@ func (h *Handler) MachinePing(c *gin.Context) {
 
 	// Upsert machine
 	_, err := h.db.Exec(`
-		INSERT INTO machines (id, user_id, hostname, os, arch, last_seen_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (user_id, hostname) DO UPDATE SET
+		INSERT INTO machines (id, user_id, org_id, hostname, os, arch, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (org_id, user_id, hostname) DO UPDATE SET
 			os = EXCLUDED.os,
 			arch = EXCLUDED.arch,
 			last_seen_at = EXCLUDED.last_seen_at
-	`, uuid.New(), user.ID, req.Hostname, req.OS, req.Arch, time.Now())
+	`, uuid.New(), user.ID, org.ID, req.Hostname, req.OS, req.Arch, time.Now())
 
 	if err != nil {
 		h.logger.Errorf("Failed to ping machine: %v", err)
@@ -314,8 +399,30 @@ func (h *Handler) MachinePing(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Machine registered successfully"})
 }
 
-func createSessionToken(userID uuid.UUID, email, plan string) string {
-	return userID.String() + ":" + email + ":" + plan
+// MachineDeregister removes a machine of the current user from the token's
+// organization
+func (h *Handler) MachineDeregister(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	org := c.MustGet("org").(models.Organization)
+	hostname := c.Param("hostname")
+
+	result, err := h.db.Exec(`
+		DELETE FROM machines WHERE org_id = $1 AND user_id = $2 AND hostname = $3
+	`, org.ID, user.ID, hostname)
+
+	if err != nil {
+		h.logger.Errorf("Failed to deregister machine: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deregister machine"})
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Machine not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Machine deregistered successfully"})
 }
 
 func hashToken(token string) string {
@@ -0,0 +1,654 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/instanttls/api/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	invitationTTLDays    = 7
+	maxInvitationTTLDays = 30
+)
+
+// createPersonalOrg creates the organization every user gets on sign-up,
+// with the user as its only owner
+func createPersonalOrg(tx *sqlx.Tx, userID uuid.UUID, name string, plan models.Plan) (uuid.UUID, error) {
+	orgID := uuid.New()
+	_, err := tx.Exec(`
+		INSERT INTO organizations (id, name, plan, personal_user_id)
+		VALUES ($1, $2, $3, $4)
+	`, orgID, name, plan, userID)
+	if err != nil {
+		return orgID, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO memberships (org_id, user_id, role) VALUES ($1, $2, $3)
+	`, orgID, userID, models.RoleOwner)
+	return orgID, err
+}
+
+func orgResponse(org models.Organization, role models.Role) models.OrgResponse {
+	return models.OrgResponse{
+		ID:        org.ID,
+		Name:      org.Name,
+		Plan:      org.Plan,
+		Personal:  org.PersonalUserID != nil,
+		Role:      role,
+		CreatedAt: org.CreatedAt,
+	}
+}
+
+// ListOrgs returns the organizations the user belongs to
+func (h *Handler) ListOrgs(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	var rows []struct {
+		models.Organization
+		Role models.Role `db:"role"`
+	}
+	err := h.db.Select(&rows, `
+		SELECT o.*, m.role FROM organizations o
+		JOIN memberships m ON m.org_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY o.personal_user_id IS NULL, o.created_at
+	`, user.ID)
+
+	if err != nil {
+		h.logger.Errorf("Failed to list organizations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list organizations"})
+		return
+	}
+
+	response := make([]models.OrgResponse, len(rows))
+	for i, r := range rows {
+		response[i] = orgResponse(r.Organization, r.Role)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateOrg creates an organization owned by the user. New organizations
+// start on the free plan.
+func (h *Handler) CreateOrg(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	var req struct {
+		Name string `json:"name" binding:"required,max=255"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	org := models.Organization{
+		ID:        uuid.New(),
+		Name:      req.Name,
+		Plan:      models.PlanFree,
+		CreatedAt: time.Now(),
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		h.logger.Errorf("Failed to create organization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO organizations (id, name, plan) VALUES ($1, $2, $3)
+	`, org.ID, org.Name, org.Plan)
+	if err == nil {
+		_, err = tx.Exec(`
+			INSERT INTO memberships (org_id, user_id, role) VALUES ($1, $2, $3)
+		`, org.ID, user.ID, models.RoleOwner)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		h.logger.Errorf("Failed to create organization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, orgResponse(org, models.RoleOwner))
+}
+
+// GetOrg returns an organization the user belongs to
+func (h *Handler) GetOrg(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	c.JSON(http.StatusOK, orgResponse(org, membership.Role))
+}
+
+// UpdateOrg renames an organization (owner or admin)
+func (h *Handler) UpdateOrg(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	if !membership.Role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can change the organization"})
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required,max=255"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.db.Exec("UPDATE organizations SET name = $1 WHERE id = $2", req.Name, org.ID); err != nil {
+		h.logger.Errorf("Failed to update organization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organization"})
+		return
+	}
+
+	org.Name = req.Name
+	c.JSON(http.StatusOK, orgResponse(org, membership.Role))
+}
+
+// DeleteOrg deletes an organization with its tokens, machines and
+// invitations (owner only). Personal organizations cannot be deleted.
+func (h *Handler) DeleteOrg(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	if membership.Role != models.RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can delete the organization"})
+		return
+	}
+	if org.PersonalUserID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Personal organizations cannot be deleted"})
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM organizations WHERE id = $1", org.ID); err != nil {
+		h.logger.Errorf("Failed to delete organization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Organization deleted successfully"})
+}
+
+// ListMembers returns the members of an organization
+func (h *Handler) ListMembers(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+
+	var members []models.MemberResponse
+	err := h.db.Select(&members, `
+		SELECT m.user_id, u.email, m.role, m.ca_access, m.created_at
+		FROM memberships m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1 ORDER BY m.created_at
+	`, org.ID)
+
+	if err != nil {
+		h.logger.Errorf("Failed to list members: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// UpdateMember changes a member's role. Owners and admins can switch
+// members between admin and member; only owners can grant or take away
+// ownership, and the last owner cannot be demoted.
+func (h *Handler) UpdateMember(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	var req struct {
+		Role models.Role `json:"role" binding:"required,oneof=owner admin member"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target, ok := h.targetMember(c, org.ID)
+	if !ok {
+		return
+	}
+
+	if !membership.Role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can change roles"})
+		return
+	}
+	if (req.Role == models.RoleOwner || target.Role == models.RoleOwner) && membership.Role != models.RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can grant or remove ownership"})
+		return
+	}
+	if org.PersonalUserID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Roles cannot be changed in a personal organization"})
+		return
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		h.logger.Errorf("Failed to update member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+	defer tx.Rollback()
+
+	if req.Role != models.RoleOwner {
+		last, err := isLastOwner(tx, org.ID, target.UserID)
+		if err != nil {
+			h.logger.Errorf("Failed to update member: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+			return
+		}
+		if last {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An organization needs at least one owner"})
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE memberships SET role = $1 WHERE org_id = $2 AND user_id = $3
+	`, req.Role, org.ID, target.UserID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to update member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully"})
+}
+
+// RemoveMember removes a member, or lets a user leave. Their tokens for the
+// organization are revoked; their machines stay listed.
+func (h *Handler) RemoveMember(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	target, ok := h.targetMember(c, org.ID)
+	if !ok {
+		return
+	}
+
+	if target.UserID != user.ID {
+		if !membership.Role.CanManage() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can remove members"})
+			return
+		}
+		if target.Role == models.RoleOwner && membership.Role != models.RoleOwner {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can remove an owner"})
+			return
+		}
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		h.logger.Errorf("Failed to remove member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+	defer tx.Rollback()
+
+	last, err := isLastOwner(tx, org.ID, target.UserID)
+	if err != nil {
+		h.logger.Errorf("Failed to remove member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+	if last {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An organization needs at least one owner"})
+		return
+	}
+
+	_, err = tx.Exec("DELETE FROM memberships WHERE org_id = $1 AND user_id = $2", org.ID, target.UserID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM tokens WHERE org_id = $1 AND user_id = $2", org.ID, target.UserID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		h.logger.Errorf("Failed to remove member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// targetMember loads the membership named by the :user_id route parameter,
+// writing a 404 if there is none
+func (h *Handler) targetMember(c *gin.Context, orgID uuid.UUID) (models.Membership, bool) {
+	var target models.Membership
+
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err == nil {
+		err = h.db.Get(&target, `
+			SELECT * FROM memberships WHERE org_id = $1 AND user_id = $2
+		`, orgID, userID)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return target, false
+	}
+
+	return target, true
+}
+
+// isLastOwner locks the organization and reports whether the user is its
+// only owner. The lock is held until tx ends, so two owners demoting or
+// removing each other cannot both get through.
+func isLastOwner(tx *sqlx.Tx, orgID, userID uuid.UUID) (bool, error) {
+	if _, err := tx.Exec("SELECT id FROM organizations WHERE id = $1 FOR UPDATE", orgID); err != nil {
+		return false, err
+	}
+
+	var owners int
+	var isOwner bool
+	err := tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(bool_or(user_id = $2), false)
+		FROM memberships WHERE org_id = $1 AND role = $3
+	`, orgID, userID, models.RoleOwner).Scan(&owners, &isOwner)
+	return isOwner && owners <= 1, err
+}
+
+// ListOrgMachines returns every machine registered in an organization
+func (h *Handler) ListOrgMachines(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+
+	var machines []models.Machine
+	err := h.db.Select(&machines, `
+		SELECT * FROM machines WHERE org_id = $1 ORDER BY last_seen_at DESC
+	`, org.ID)
+
+	if err != nil {
+		h.logger.Errorf("Failed to list machines: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list machines"})
+		return
+	}
+
+	if machines == nil {
+		machines = []models.Machine{}
+	}
+	c.JSON(http.StatusOK, machines)
+}
+
+// ListInvitations returns the invitations of an organization (owner or
+// admin)
+func (h *Handler) ListInvitations(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	if !membership.Role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can see invitations"})
+		return
+	}
+
+	var invitations []models.Invitation
+	err := h.db.Select(&invitations, `
+		SELECT * FROM invitations WHERE org_id = $1 ORDER BY created_at DESC
+	`, org.ID)
+
+	if err != nil {
+		h.logger.Errorf("Failed to list invitations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invitations"})
+		return
+	}
+
+	response := make([]models.InvitationResponse, len(invitations))
+	for i, inv := range invitations {
+		response[i] = invitationResponse(inv)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateInvitation issues an invitation token (owner or admin). Whoever
+// accepts it joins with the given role, so it is shown only once and
+// should be shared privately.
+func (h *Handler) CreateInvitation(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	if !membership.Role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can invite members"})
+		return
+	}
+	if org.PersonalUserID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Personal organizations cannot have members"})
+		return
+	}
+	room, err := hasMemberRoom(h.db, org)
+	if err != nil {
+		h.logger.Errorf("Failed to create invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+	if !room {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Member limit reached. Upgrade to the Team plan to invite members."})
+		return
+	}
+
+	var req struct {
+		Role          models.Role `json:"role" binding:"required,oneof=admin member"`
+		ExpiresInDays int         `json:"expires_in_days" binding:"omitempty,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = invitationTTLDays
+	}
+	if days > maxInvitationTTLDays {
+		days = maxInvitationTTLDays
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		h.logger.Errorf("Failed to generate invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	token := "itls_inv_" + hex.EncodeToString(tokenBytes)
+	inv := models.Invitation{
+		ID:        uuid.New(),
+		OrgID:     org.ID,
+		Role:      req.Role,
+		Prefix:    token[:16],
+		TokenHash: hashToken(token),
+		CreatedBy: &user.ID,
+		ExpiresAt: time.Now().AddDate(0, 0, days),
+		CreatedAt: time.Now(),
+	}
+
+	_, err = h.db.Exec(`
+		INSERT INTO invitations (id, org_id, role, prefix, token_hash, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, inv.ID, inv.OrgID, inv.Role, inv.Prefix, inv.TokenHash, inv.CreatedBy, inv.ExpiresAt)
+
+	if err != nil {
+		h.logger.Errorf("Failed to create invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.InvitationCreateResponse{
+		Token: token, // Only shown once!
+		Data:  invitationResponse(inv),
+	})
+}
+
+// DeleteInvitation revokes an invitation (owner or admin)
+func (h *Handler) DeleteInvitation(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	if !membership.Role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can revoke invitations"})
+		return
+	}
+
+	invitationID, err := uuid.Parse(c.Param("invitation_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	result, err := h.db.Exec(`
+		DELETE FROM invitations WHERE id = $1 AND org_id = $2
+	`, invitationID, org.ID)
+
+	if err != nil {
+		h.logger.Errorf("Failed to delete invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete invitation"})
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// AcceptInvitation adds the user to the organization of an invitation
+// token. Each invitation can be accepted once.
+func (h *Handler) AcceptInvitation(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		h.logger.Errorf("Failed to accept invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+	defer tx.Rollback()
+
+	var inv models.Invitation
+	err = tx.Get(&inv, `
+		SELECT * FROM invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`, hashToken(req.Token))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found, used or expired"})
+		return
+	}
+
+	// Lock the organization so concurrent acceptances cannot exceed the
+	// member limit
+	var org models.Organization
+	if err := tx.Get(&org, "SELECT * FROM organizations WHERE id = $1 FOR UPDATE", inv.OrgID); err != nil {
+		h.logger.Errorf("Failed to accept invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	var existing models.Membership
+	err = tx.Get(&existing, "SELECT * FROM memberships WHERE org_id = $1 AND user_id = $2", org.ID, user.ID)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Already a member of this organization"})
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		h.logger.Errorf("Failed to accept invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	room, err := hasMemberRoom(tx, org)
+	if err != nil {
+		h.logger.Errorf("Failed to accept invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+	if !room {
+		c.JSON(http.StatusForbidden, gin.H{"error": "The organization has reached its member limit"})
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO memberships (org_id, user_id, role) VALUES ($1, $2, $3)
+	`, org.ID, user.ID, inv.Role)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE invitations SET accepted_by = $1, accepted_at = NOW() WHERE id = $2
+		`, user.ID, inv.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		h.logger.Errorf("Failed to accept invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orgResponse(org, inv.Role))
+}
+
+// hasMemberRoom reports whether the organization's plan allows another
+// member. Adding one must happen in the same transaction, with the
+// organization row locked.
+func hasMemberRoom(q sqlx.Queryer, org models.Organization) (bool, error) {
+	limit := planLimits(org.Plan)["max_members"]
+	if limit < 0 {
+		return true, nil
+	}
+
+	var count int
+	if err := sqlx.Get(q, &count, "SELECT COUNT(*) FROM memberships WHERE org_id = $1", org.ID); err != nil {
+		return false, err
+	}
+	return count < limit, nil
+}
+
+func invitationResponse(inv models.Invitation) models.InvitationResponse {
+	return models.InvitationResponse{
+		ID:         inv.ID,
+		Role:       inv.Role,
+		Prefix:     inv.Prefix,
+		ExpiresAt:  inv.ExpiresAt,
+		AcceptedAt: inv.AcceptedAt,
+		CreatedAt:  inv.CreatedAt,
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/instanttls/api/internal/models"
+	"github.com/instanttls/api/internal/session"
+)
+
+type sessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+}
+
+// createSession stores a new server-side session and issues its token pair
+func (h *Handler) createSession(c *gin.Context, userID uuid.UUID) (*sessionTokens, error) {
+	refreshToken, refreshHash, err := session.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID := uuid.New()
+	_, err = h.db.Exec(`
+		INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, sessionID, userID, refreshHash, truncate(c.Request.UserAgent(), 512), c.ClientIP(), time.Now().Add(session.RefreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := session.Issue(h.cfg.JWTSecret, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(session.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Refresh rotates a refresh token and issues a new access token
+func (h *Handler) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	presentedHash := session.HashRefreshToken(req.RefreshToken)
+
+	var sess models.Session
+	err := h.db.Get(&sess, `
+		SELECT * FROM sessions WHERE refresh_token_hash = $1
+	`, presentedHash)
+	if err != nil {
+		// A rotated-out refresh token being replayed means it leaked; kill the session
+		result, _ := h.db.Exec(`
+			UPDATE sessions SET revoked_at = NOW()
+			WHERE previous_refresh_token_hash = $1 AND revoked_at IS NULL
+		`, presentedHash)
+		if result != nil {
+			if rows, _ := result.RowsAffected(); rows > 0 {
+				h.logger.Warnf("Refresh token reuse detected, session revoked")
+			}
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if sess.RevokedAt != nil || time.Now().After(sess.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked or expired"})
+		return
+	}
+
+	refreshToken, refreshHash, err := session.NewRefreshToken()
+	if err != nil {
+		h.logger.Errorf("Failed to generate refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// Rotate only if nobody else rotated this token concurrently
+	result, err := h.db.Exec(`
+		UPDATE sessions
+		SET refresh_token_hash = $1, previous_refresh_token_hash = $2, last_used_at = NOW()
+		WHERE id = $3 AND refresh_token_hash = $2 AND revoked_at IS NULL
+	`, refreshHash, presentedHash, sess.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to rotate refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	accessToken, err := session.Issue(h.cfg.JWTSecret, sess.UserID, sess.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to create session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(session.AccessTokenTTL.Seconds()),
+	})
+}
+
+// Logout revokes the current session
+func (h *Handler) Logout(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	claims := c.MustGet("session").(*session.Claims)
+	sessionID, _ := claims.SID()
+
+	_, err := h.db.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, user.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to revoke session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll revokes every active session of the user ("sign out everywhere")
+func (h *Handler) LogoutAll(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	result, err := h.db.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, user.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to revoke sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Signed out of all sessions",
+		"revoked": rows,
+	})
+}
+
+// ListSessions returns the user's active sessions
+func (h *Handler) ListSessions(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	claims := c.MustGet("session").(*session.Claims)
+	currentID, _ := claims.SID()
+
+	var sessions []models.Session
+	err := h.db.Select(&sessions, `
+		SELECT * FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`, user.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to list sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	response := make([]models.SessionResponse, len(sessions))
+	for i, s := range sessions {
+		response[i] = models.SessionResponse{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IPAddress:  s.IPAddress,
+			Current:    s.ID == currentID,
+			ExpiresAt:  s.ExpiresAt,
+			LastUsedAt: s.LastUsedAt,
+			CreatedAt:  s.CreatedAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/instanttls/api/internal/models"
+)
+
+// encryptedKeyBlockType is the PEM type of an encrypted PKCS#8 key. The
+// team CA key is only accepted in this form.
+const encryptedKeyBlockType = "ENCRYPTED PRIVATE KEY"
+
+type teamCARequest struct {
+	RootCert         string `json:"root_cert" binding:"required"`
+	IntermediateCert string `json:"intermediate_cert" binding:"required"`
+	IntermediateKey  string `json:"intermediate_key" binding:"required"`
+}
+
+// GetTeamCA returns the current team CA of the token's organization, with
+// its passphrase-encrypted intermediate key (PAT auth)
+func (h *Handler) GetTeamCA(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	if !h.checkTeamCAPlan(c, org) {
+		return
+	}
+	if !membership.CAAccess {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your access to the team CA has been revoked"})
+		return
+	}
+
+	var ca models.TeamCA
+	err := h.db.Get(&ca, `
+		SELECT * FROM team_cas WHERE org_id = $1 AND retired_at IS NULL
+	`, org.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization has no team CA"})
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to load team CA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load team CA"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ca)
+}
+
+// CreateTeamCA uploads the first team CA of the token's organization
+// (owner or admin, PAT auth)
+func (h *Handler) CreateTeamCA(c *gin.Context) {
+	h.saveTeamCA(c, false)
+}
+
+// RotateTeamCA replaces the team CA of the token's organization with a new
+// version and retires the old one (owner or admin, PAT auth). Members pick
+// it up on their next 'instanttls trust'.
+func (h *Handler) RotateTeamCA(c *gin.Context) {
+	h.saveTeamCA(c, true)
+}
+
+func (h *Handler) saveTeamCA(c *gin.Context, rotate bool) {
+	user := c.MustGet("user").(models.User)
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	if !h.checkTeamCAPlan(c, org) {
+		return
+	}
+	if !membership.Role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can change the team CA"})
+		return
+	}
+
+	var req teamCARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fingerprint, err := validateTeamCA(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		h.logger.Errorf("Failed to save team CA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save team CA"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the organization so concurrent uploads get distinct versions
+	if _, err := tx.Exec("SELECT id FROM organizations WHERE id = $1 FOR UPDATE", org.ID); err != nil {
+		h.logger.Errorf("Failed to save team CA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save team CA"})
+		return
+	}
+
+	var current struct {
+		Active  int `db:"active"`
+		Version int `db:"version"`
+	}
+	err = tx.Get(&current, `
+		SELECT COUNT(*) FILTER (WHERE retired_at IS NULL) AS active, COALESCE(MAX(version), 0) AS version
+		FROM team_cas WHERE org_id = $1
+	`, org.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to save team CA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save team CA"})
+		return
+	}
+
+	if rotate && current.Active == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization has no team CA to rotate"})
+		return
+	}
+	if !rotate && current.Active > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Organization already has a team CA; rotate it instead"})
+		return
+	}
+
+	ca := models.TeamCA{
+		ID:               uuid.New(),
+		OrgID:            org.ID,
+		Version:          current.Version + 1,
+		Fingerprint:      fingerprint,
+		RootCert:         req.RootCert,
+		IntermediateCert: req.IntermediateCert,
+		IntermediateKey:  req.IntermediateKey,
+		CreatedBy:        &user.ID,
+		CreatedAt:        time.Now(),
+	}
+
+	_, err = tx.Exec(`
+		UPDATE team_cas SET retired_at = NOW() WHERE org_id = $1 AND retired_at IS NULL
+	`, org.ID)
+	if err == nil {
+		_, err = tx.Exec(`
+			INSERT INTO team_cas (id, org_id, version, fingerprint, root_cert, intermediate_cert, intermediate_key, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, ca.ID, ca.OrgID, ca.Version, ca.Fingerprint, ca.RootCert, ca.IntermediateCert, ca.IntermediateKey, ca.CreatedBy)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		h.logger.Errorf("Failed to save team CA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save team CA"})
+		return
+	}
+
+	status := http.StatusCreated
+	if rotate {
+		status = http.StatusOK
+	}
+	c.JSON(status, teamCAResponse(ca))
+}
+
+// ListTeamCAs returns every version of an organization's team CA, newest
+// first, without key material
+func (h *Handler) ListTeamCAs(c *gin.Context) {
+	org := c.MustGet("org").(models.Organization)
+
+	var cas []models.TeamCA
+	err := h.db.Select(&cas, `
+		SELECT * FROM team_cas WHERE org_id = $1 ORDER BY version DESC
+	`, org.ID)
+
+	if err != nil {
+		h.logger.Errorf("Failed to list team CAs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list team CAs"})
+		return
+	}
+
+	response := make([]models.TeamCAResponse, len(cas))
+	for i, ca := range cas {
+		response[i] = teamCAResponse(ca)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GrantCAAccess lets a member download the team CA again (owner or admin)
+func (h *Handler) GrantCAAccess(c *gin.Context) {
+	h.setCAAccess(c, true)
+}
+
+// RevokeCAAccess stops a member from downloading the team CA (owner or
+// admin). Copies they already pulled keep working until the team CA is
+// rotated with a new passphrase.
+func (h *Handler) RevokeCAAccess(c *gin.Context) {
+	h.setCAAccess(c, false)
+}
+
+func (h *Handler) setCAAccess(c *gin.Context, access bool) {
+	org := c.MustGet("org").(models.Organization)
+	membership := c.MustGet("membership").(models.Membership)
+
+	target, ok := h.targetMember(c, org.ID)
+	if !ok {
+		return
+	}
+
+	if !membership.Role.CanManage() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and admins can change team CA access"})
+		return
+	}
+	if target.Role == models.RoleOwner && membership.Role != models.RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can change an owner's team CA access"})
+		return
+	}
+
+	if _, err := h.db.Exec(`
+		UPDATE memberships SET ca_access = $1 WHERE org_id = $2 AND user_id = $3
+	`, access, org.ID, target.UserID); err != nil {
+		h.logger.Errorf("Failed to update team CA access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update team CA access"})
+		return
+	}
+
+	if access {
+		c.JSON(http.StatusOK, gin.H{"message": "Team CA access granted"})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "Team CA access revoked"})
+	}
+}
+
+// checkTeamCAPlan writes an error unless the organization can have a team
+// CA
+func (h *Handler) checkTeamCAPlan(c *gin.Context, org models.Organization) bool {
+	if org.PersonalUserID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Team CAs belong to a team organization. Use a token created for your team."})
+		return false
+	}
+	if org.Plan != models.PlanTeam {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Team CAs require the Team plan"})
+		return false
+	}
+	return true
+}
+
+// validateTeamCA checks that an upload is a self-signed root, an
+// intermediate it issued, and an encrypted key, and returns the root's
+// SHA-256 fingerprint
+func validateTeamCA(req teamCARequest) (string, error) {
+	root, err := parseCertPEM(req.RootCert)
+	if err != nil {
+		return "", fmt.Errorf("root_cert: %w", err)
+	}
+	if !root.IsCA || root.CheckSignatureFrom(root) != nil {
+		return "", errors.New("root_cert: not a self-signed CA certificate")
+	}
+
+	intermediate, err := parseCertPEM(req.IntermediateCert)
+	if err != nil {
+		return "", fmt.Errorf("intermediate_cert: %w", err)
+	}
+	if !intermediate.IsCA || intermediate.CheckSignatureFrom(root) != nil {
+		return "", errors.New("intermediate_cert: not a CA certificate issued by root_cert")
+	}
+
+	block, _ := pem.Decode([]byte(req.IntermediateKey))
+	if block == nil || block.Type != encryptedKeyBlockType {
+		return "", errors.New("intermediate_key: must be an encrypted PKCS#8 key")
+	}
+
+	sum := sha256.Sum256(root.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func parseCertPEM(s string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("not a PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func teamCAResponse(ca models.TeamCA) models.TeamCAResponse {
+	return models.TeamCAResponse{
+		ID:          ca.ID,
+		Version:     ca.Version,
+		Fingerprint: ca.Fingerprint,
+		CreatedBy:   ca.CreatedBy,
+		CreatedAt:   ca.CreatedAt,
+		RetiredAt:   ca.RetiredAt,
+	}
+}
@@ -0,0 +1,322 @@
+// Package issuer holds the server-side CA that signs certificate signing
+// requests from authenticated CLIs, so organizations can issue certificates
+// without handing a CA key to every machine.
+package issuer
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	CAValidityDays = 3650 // 10 years
+
+	// DefaultValidityDays is used when a request does not ask for a validity
+	DefaultValidityDays = 365
+	// MaxValidityDays caps any organization policy; browsers reject longer
+	// lived leaves
+	MaxValidityDays = 825
+)
+
+// Policy limits what an organization's CA will sign
+type Policy struct {
+	// AllowedDomains are the domains names must equal or fall under
+	AllowedDomains []string
+	// MaxValidityDays is the longest validity a request may ask for
+	MaxValidityDays int
+}
+
+// NewCA creates the self-signed CA that signs an organization's remote
+// certificates and returns it as PEM along with its PKCS#8 key
+func NewCA(orgName string) (string, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := "InstantTLS CA for " + orgName
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"InstantTLS"},
+			CommonName:   name,
+		},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, CAValidityDays),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode CA key: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), keyDER, nil
+}
+
+// Fingerprint returns the hex SHA-256 fingerprint of a PEM certificate
+func Fingerprint(certPEM string) (string, error) {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Seal encrypts a CA key with AES-256-GCM under a key derived from secret
+func Seal(secret string, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a CA key sealed with Seal
+func Open(secret string, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed key is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ParseCSR decodes a PEM certificate signing request and checks its
+// signature and key
+func ParseCSR(csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("not a PEM certificate request")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() && pub.Curve != elliptic.P384() {
+			return nil, errors.New("ECDSA keys must use P-256 or P-384")
+		}
+	case ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	return csr, nil
+}
+
+// Names returns the DNS names and IP addresses a CSR asks for: its SANs, or
+// its common name if it has none. DNS names are lower-cased.
+func Names(csr *x509.CertificateRequest) ([]string, []net.IP, error) {
+	var dnsNames []string
+	seen := make(map[string]bool)
+	for _, name := range csr.DNSNames {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if !seen[name] {
+			seen[name] = true
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	ips := csr.IPAddresses
+
+	if len(dnsNames) == 0 && len(ips) == 0 && csr.Subject.CommonName != "" {
+		cn := strings.TrimSuffix(strings.ToLower(csr.Subject.CommonName), ".")
+		if ip := net.ParseIP(cn); ip != nil {
+			ips = []net.IP{ip}
+		} else {
+			dnsNames = []string{cn}
+		}
+	}
+
+	if len(dnsNames) == 0 && len(ips) == 0 {
+		return nil, nil, errors.New("CSR does not name any domain or IP address")
+	}
+	return dnsNames, ips, nil
+}
+
+// Check refuses names outside the allowed domains and IP addresses that are
+// not loopback or private
+func (p Policy) Check(dnsNames []string, ips []net.IP) error {
+	for _, name := range dnsNames {
+		if !p.allows(name) {
+			return fmt.Errorf("%s is not under an allowed domain (%s)", name, strings.Join(p.AllowedDomains, ", "))
+		}
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() && !ip.IsPrivate() {
+			return fmt.Errorf("%s is not a loopback or private address", ip)
+		}
+	}
+	return nil
+}
+
+func (p Policy) allows(name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	for _, domain := range p.AllowedDomains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeDomain turns a policy entry such as "*.Corp.Internal" or
+// ".test" into the bare domain the policy matches against
+func NormalizeDomain(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "*")
+	domain = strings.Trim(domain, ".")
+	if domain == "" || strings.ContainsAny(domain, " */:") {
+		return "", fmt.Errorf("invalid domain %q", domain)
+	}
+	return domain, nil
+}
+
+// Sign issues a leaf certificate for the CSR's key and the given names,
+// with the same profile the CLI uses for local certificates. The common
+// name is the first of the names.
+func Sign(caCertPEM string, caKeyDER []byte, csr *x509.CertificateRequest, dnsNames []string, ips []net.IP, validityDays int) (*x509.Certificate, error) {
+	if len(dnsNames) == 0 && len(ips) == 0 {
+		return nil, errors.New("no names to sign for")
+	}
+
+	caCert, err := parseCertificate(caCertPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(caKeyDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	caKey, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type %T", parsed)
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	// The CSR's own subject is ignored: when it has SANs, Names does not
+	// return its common name, so the policy never saw it
+	var commonName string
+	if len(dnsNames) > 0 {
+		commonName = dnsNames[0]
+	} else {
+		commonName = ips[0].String()
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, isRSA := csr.PublicKey.(*rsa.PublicKey); isRSA {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"InstantTLS"},
+			CommonName:   commonName,
+		},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, validityDays),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+
+	// A leaf cannot outlive the CA that signs it
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+func parseCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("not a PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func serialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
@@ -0,0 +1,170 @@
+package issuer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// newCSR returns a PEM CSR for a fresh P-256 key
+func newCSR(t *testing.T, commonName string, dnsNames []string, ips []net.IP) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    dnsNames,
+		IPAddresses: ips,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// signChecked runs a CSR through the steps the sign handler takes: parse,
+// extract the names, check them against the policy and sign
+func signChecked(t *testing.T, policy Policy, csrPEM string) (*x509.Certificate, error) {
+	t.Helper()
+
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsNames, ips, err := Names(csr)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.Check(dnsNames, ips); err != nil {
+		return nil, err
+	}
+
+	caPEM, caKey, err := NewCA("Test Org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Sign(caPEM, caKey, csr, dnsNames, ips, DefaultValidityDays)
+}
+
+func TestSignIgnoresCommonName(t *testing.T) {
+	policy := Policy{AllowedDomains: []string{"allowed.test"}, MaxValidityDays: MaxValidityDays}
+
+	tests := []struct {
+		name    string
+		csr     string
+		wantCN  string
+		wantDNS []string
+		wantIPs int
+		refused bool
+	}{
+		{
+			name:    "out-of-policy CN next to an allowed SAN",
+			csr:     newCSR(t, "bank.example.com", []string{"app.allowed.test"}, nil),
+			wantCN:  "app.allowed.test",
+			wantDNS: []string{"app.allowed.test"},
+		},
+		{
+			name:    "CN only",
+			csr:     newCSR(t, "App.Allowed.Test.", nil, nil),
+			wantCN:  "app.allowed.test",
+			wantDNS: []string{"app.allowed.test"},
+		},
+		{
+			name:    "IP SAN with a domain CN",
+			csr:     newCSR(t, "bank.example.com", nil, []net.IP{net.ParseIP("10.0.0.7")}),
+			wantCN:  "10.0.0.7",
+			wantIPs: 1,
+		},
+		{
+			name:    "CN only, outside the policy",
+			csr:     newCSR(t, "bank.example.com", nil, nil),
+			refused: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			leaf, err := signChecked(t, policy, tc.csr)
+			if tc.refused {
+				if err == nil {
+					t.Fatalf("signed a certificate for %q", leaf.Subject.CommonName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leaf.Subject.CommonName != tc.wantCN {
+				t.Errorf("common name = %q, want %q", leaf.Subject.CommonName, tc.wantCN)
+			}
+			if !reflect.DeepEqual(leaf.DNSNames, tc.wantDNS) {
+				t.Errorf("DNS names = %v, want %v", leaf.DNSNames, tc.wantDNS)
+			}
+			if len(leaf.IPAddresses) != tc.wantIPs {
+				t.Errorf("IP addresses = %v, want %d", leaf.IPAddresses, tc.wantIPs)
+			}
+		})
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := Policy{AllowedDomains: []string{"allowed.test", "corp.internal"}}
+
+	tests := []struct {
+		name     string
+		dnsNames []string
+		ips      []string
+		ok       bool
+	}{
+		{"apex", []string{"allowed.test"}, nil, true},
+		{"subdomain", []string{"a.b.allowed.test"}, nil, true},
+		{"wildcard", []string{"*.corp.internal"}, nil, true},
+		{"suffix without a dot", []string{"notallowed.test"}, nil, false},
+		{"other domain", []string{"allowed.test", "example.com"}, nil, false},
+		{"loopback and private", nil, []string{"127.0.0.1", "::1", "192.168.1.4"}, true},
+		{"public address", nil, []string{"8.8.8.8"}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ips []net.IP
+			for _, ip := range tc.ips {
+				ips = append(ips, net.ParseIP(ip))
+			}
+			err := policy.Check(tc.dnsNames, ips)
+			if tc.ok && err != nil {
+				t.Errorf("refused: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("allowed")
+			}
+		})
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	for in, want := range map[string]string{
+		"*.Corp.Internal": "corp.internal",
+		".test":           "test",
+		" allowed.test. ": "allowed.test",
+	} {
+		got, err := NormalizeDomain(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeDomain(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "*", "a b.test", "https://allowed.test"} {
+		if _, err := NormalizeDomain(in); err == nil {
+			t.Errorf("NormalizeDomain(%q) succeeded", in)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Package license signs the offline licenses the CLI caches, so it can
+// enforce a plan without the API and without trusting its own config file.
+package license
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Validity is how long a signed license is current. The CLI honors it for
+// a grace period beyond that while it cannot reach the API.
+const Validity = 7 * 24 * time.Hour
+
+// Claims is what a license vouches for
+type Claims struct {
+	OrgID   string         `json:"org_id"`
+	OrgName string         `json:"org_name"`
+	Email   string         `json:"email"`
+	Plan    string         `json:"plan"`
+	Limits  map[string]int `json:"limits"`
+	// Machine is the ID of the machine the license was issued to
+	Machine   string    `json:"machine"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ParseKey decodes a base64 Ed25519 private key, given either as its
+// 32-byte seed or in full
+func ParseKey(encoded string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid license signing key: %w", err)
+	}
+
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, errors.New("invalid license signing key: expected a 32-byte seed or a 64-byte key")
+}
+
+// Sign encodes claims as "<payload>.<signature>", both base64url without
+// padding, with the signature covering the encoded payload
+func Sign(key ed25519.PrivateKey, claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(key, []byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
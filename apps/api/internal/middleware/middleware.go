@@ -8,8 +8,10 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/instanttls/api/internal/config"
 	"github.com/instanttls/api/internal/models"
+	"github.com/instanttls/api/internal/session"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -35,7 +37,7 @@ func PATAuth(db *sqlx.DB) gin.HandlerFunc {
 
 		var tokenRecord models.Token
 		err := db.Get(&tokenRecord, `
-			SELECT id, user_id, name, prefix, token_hash, last_used_at, created_at
+			SELECT id, user_id, org_id, name, prefix, token_hash, last_used_at, created_at
 			FROM tokens WHERE token_hash = $1
 		`, tokenHash)
 
@@ -52,23 +54,74 @@ func PATAuth(db *sqlx.DB) gin.HandlerFunc {
 
 		// Get user
 		var user models.User
-		err = db.Get(&user, "SELECT * FROM users WHERE id = $1", tokenRecord.UserID)
+		err = db.Get(&user, models.UserSelect+"WHERE u.id = $1", tokenRecord.UserID)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 			c.Abort()
 			return
 		}
 
+		// Tokens stop working once their user leaves the organization
+		org, membership, err := loadMembership(db, tokenRecord.OrgID, user.ID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token organization membership revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("user", user)
 		c.Set("token", tokenRecord)
+		c.Set("org", org)
+		c.Set("membership", membership)
 		c.Next()
 	}
 }
 
-// SessionAuth validates session cookie for web dashboard
-func SessionAuth(cfg *config.Config) gin.HandlerFunc {
+// OrgAccess loads the organization named by the :id route parameter and
+// the current user's membership in it. Non-members get a 404 so that
+// organization IDs cannot be probed. Must run after SessionAuth.
+func OrgAccess(db *sqlx.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+
+		orgID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			c.Abort()
+			return
+		}
+
+		org, membership, err := loadMembership(db, orgID, user.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			c.Abort()
+			return
+		}
+
+		c.Set("org", org)
+		c.Set("membership", membership)
+		c.Next()
+	}
+}
+
+func loadMembership(db *sqlx.DB, orgID, userID uuid.UUID) (models.Organization, models.Membership, error) {
+	var org models.Organization
+	var membership models.Membership
+
+	err := db.Get(&membership, `
+		SELECT * FROM memberships WHERE org_id = $1 AND user_id = $2
+	`, orgID, userID)
+	if err != nil {
+		return org, membership, err
+	}
+
+	err = db.Get(&org, "SELECT * FROM organizations WHERE id = $1", orgID)
+	return org, membership, err
+}
+
+// SessionAuth validates the signed session token for web dashboard
+func SessionAuth(db *sqlx.DB, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// For MVP, we use a simple JWT-like token in cookie or header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			// Try cookie
@@ -84,27 +137,41 @@ func SessionAuth(cfg *config.Config) gin.HandlerFunc {
 		// Remove Bearer prefix if present
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Decode the simple session token (user_id:email:plan encoded)
-		parts := strings.Split(token, ":")
-		if len(parts) != 3 {
+		claims, err := session.Parse(cfg.JWTSecret, token)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
 			c.Abort()
 			return
 		}
 
-		user := models.User{
-			Email: parts[1],
-			Plan:  models.Plan(parts[2]),
+		userID, _ := claims.UserID()
+		sessionID, _ := claims.SID()
+
+		// Reject tokens whose server-side session was revoked or has expired
+		var active bool
+		err = db.Get(&active, `
+			SELECT EXISTS (
+				SELECT 1 FROM sessions
+				WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+			)
+		`, sessionID, userID)
+		if err != nil || !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked or expired"})
+			c.Abort()
+			return
 		}
 
-		// Parse UUID
-		if err := user.ID.UnmarshalText([]byte(parts[0])); err != nil {
+		// Email and plan always come from the database, never from the token
+		var user models.User
+		err = db.Get(&user, models.UserSelect+"WHERE u.id = $1", userID)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
 			c.Abort()
 			return
 		}
 
 		c.Set("user", user)
+		c.Set("session", claims)
 		c.Next()
 	}
 }
@@ -0,0 +1,14 @@
+-- Unscope machines and tokens. Machines registered under several
+-- organizations keep only their most recent row.
+DELETE FROM machines m USING machines newer
+WHERE m.user_id = newer.user_id AND m.hostname = newer.hostname
+  AND (m.last_seen_at, m.id) < (newer.last_seen_at, newer.id);
+ALTER TABLE machines DROP CONSTRAINT IF EXISTS machines_org_id_user_id_hostname_key;
+ALTER TABLE machines ADD CONSTRAINT machines_user_id_hostname_key UNIQUE (user_id, hostname);
+ALTER TABLE machines DROP COLUMN IF EXISTS org_id;
+ALTER TABLE tokens DROP COLUMN IF EXISTS org_id;
+
+-- Drop tables
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,60 @@
+-- Create organizations table. Every user has a personal organization, so
+-- tokens and machines always belong to one.
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    plan VARCHAR(20) NOT NULL DEFAULT 'free',
+    personal_user_id UUID UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create memberships table
+CREATE TABLE IF NOT EXISTS memberships (
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+-- Create invitations table. Invitations are bearer tokens handed out by an
+-- admin; whoever accepts one joins the organization.
+CREATE TABLE IF NOT EXISTS invitations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'member')),
+    prefix VARCHAR(20) NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    accepted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Give every existing user a personal organization on their current plan
+WITH created AS (
+    INSERT INTO organizations (name, plan, personal_user_id)
+    SELECT email, plan, id FROM users
+    RETURNING id, personal_user_id
+)
+INSERT INTO memberships (org_id, user_id, role)
+SELECT id, personal_user_id, 'owner' FROM created;
+
+-- Scope tokens and machines to an organization
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS org_id UUID REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE tokens SET org_id = o.id FROM organizations o WHERE o.personal_user_id = tokens.user_id;
+ALTER TABLE tokens ALTER COLUMN org_id SET NOT NULL;
+
+ALTER TABLE machines ADD COLUMN IF NOT EXISTS org_id UUID REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE machines SET org_id = o.id FROM organizations o WHERE o.personal_user_id = machines.user_id;
+ALTER TABLE machines ALTER COLUMN org_id SET NOT NULL;
+ALTER TABLE machines DROP CONSTRAINT IF EXISTS machines_user_id_hostname_key;
+ALTER TABLE machines ADD CONSTRAINT machines_org_id_user_id_hostname_key UNIQUE (org_id, user_id, hostname);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_memberships_user_id ON memberships(user_id);
+CREATE INDEX IF NOT EXISTS idx_invitations_org_id ON invitations(org_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invitations_token_hash ON invitations(token_hash);
+CREATE INDEX IF NOT EXISTS idx_tokens_org_id ON tokens(org_id);
+CREATE INDEX IF NOT EXISTS idx_machines_org_id ON machines(org_id);
@@ -0,0 +1,4 @@
+ALTER TABLE memberships DROP COLUMN IF EXISTS ca_access;
+
+-- Drop tables
+DROP TABLE IF EXISTS team_cas;
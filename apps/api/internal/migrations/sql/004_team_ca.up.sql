@@ -0,0 +1,23 @@
+-- Team CAs shared by the members of an organization. The intermediate key
+-- is encrypted by the CLI with the team passphrase before upload, so the
+-- server never sees it in the clear. Rotating adds a version and retires
+-- the previous one.
+CREATE TABLE IF NOT EXISTS team_cas (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    root_cert TEXT NOT NULL,
+    intermediate_cert TEXT NOT NULL,
+    intermediate_key TEXT NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    retired_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE(org_id, version)
+);
+
+-- Members can be cut off from the team CA without leaving the organization
+ALTER TABLE memberships ADD COLUMN IF NOT EXISTS ca_access BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Create indexes
+CREATE UNIQUE INDEX IF NOT EXISTS idx_team_cas_active ON team_cas(org_id) WHERE retired_at IS NULL;
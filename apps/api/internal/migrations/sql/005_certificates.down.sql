@@ -0,0 +1,6 @@
+ALTER TABLE organizations DROP COLUMN IF EXISTS max_validity_days;
+ALTER TABLE organizations DROP COLUMN IF EXISTS allowed_domains;
+
+-- Drop tables
+DROP TABLE IF EXISTS certificates;
+DROP TABLE IF EXISTS server_cas;
@@ -0,0 +1,31 @@
+-- CAs held by the API to sign remote certificates, one per organization.
+-- The key is sealed with CA_ENCRYPTION_KEY.
+CREATE TABLE IF NOT EXISTS server_cas (
+    org_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    cert_pem TEXT NOT NULL,
+    encrypted_key BYTEA NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Every certificate the API has issued
+CREATE TABLE IF NOT EXISTS certificates (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    serial_number VARCHAR(40) UNIQUE NOT NULL,
+    common_name VARCHAR(255) NOT NULL,
+    dns_names TEXT[] NOT NULL DEFAULT '{}',
+    ip_addresses TEXT[] NOT NULL DEFAULT '{}',
+    wildcard BOOLEAN NOT NULL DEFAULT FALSE,
+    not_before TIMESTAMP WITH TIME ZONE NOT NULL,
+    not_after TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Issuance policy, enforced when signing
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS allowed_domains TEXT[] NOT NULL DEFAULT '{test,local,localhost,internal}';
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS max_validity_days INTEGER NOT NULL DEFAULT 365;
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_certificates_org_id_created_at ON certificates(org_id, created_at);
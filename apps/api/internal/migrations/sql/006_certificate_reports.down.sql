@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_certificates_org_id_wildcard;
+
+-- Reported certificates have no place in the old schema
+DELETE FROM certificates WHERE NOT remote;
+
+ALTER TABLE certificates DROP CONSTRAINT IF EXISTS certificates_org_id_serial_number_key;
+ALTER TABLE certificates ADD CONSTRAINT certificates_serial_number_key UNIQUE (serial_number);
+
+ALTER TABLE certificates DROP COLUMN IF EXISTS superseded_at;
+ALTER TABLE certificates DROP COLUMN IF EXISTS remote;
@@ -0,0 +1,16 @@
+-- Certificates signed by local CAs are reported too, so quotas no longer
+-- depend on what is left on a machine's disk. Every row so far was signed
+-- by the API.
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS remote BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE certificates SET remote = TRUE;
+
+-- Set when a renewal replaces the certificate, which then stops counting
+-- toward quotas
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS superseded_at TIMESTAMP WITH TIME ZONE;
+
+-- Serial numbers of local CAs are only unique within an organization
+ALTER TABLE certificates DROP CONSTRAINT IF EXISTS certificates_serial_number_key;
+ALTER TABLE certificates ADD CONSTRAINT certificates_org_id_serial_number_key UNIQUE (org_id, serial_number);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_certificates_org_id_wildcard ON certificates(org_id) WHERE wildcard AND superseded_at IS NULL;
@@ -0,0 +1,6 @@
+ALTER TABLE organizations DROP CONSTRAINT IF EXISTS organizations_plan_check;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan VARCHAR(20) NOT NULL DEFAULT 'free';
+UPDATE users SET plan = organizations.plan
+FROM organizations
+WHERE organizations.personal_user_id = users.id;
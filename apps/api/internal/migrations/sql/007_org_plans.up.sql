@@ -0,0 +1,10 @@
+-- Plans belong to organizations. A user's plan is that of their personal
+-- organization, so the copy on users can no longer drift from it.
+UPDATE organizations SET plan = users.plan
+FROM users
+WHERE organizations.personal_user_id = users.id AND organizations.plan <> users.plan;
+
+ALTER TABLE users DROP COLUMN IF EXISTS plan;
+
+ALTER TABLE organizations DROP CONSTRAINT IF EXISTS organizations_plan_check;
+ALTER TABLE organizations ADD CONSTRAINT organizations_plan_check CHECK (plan IN ('free', 'pro', 'team'));
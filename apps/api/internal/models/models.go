@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type Plan string
@@ -14,17 +15,49 @@ const (
 	PlanTeam Plan = "team"
 )
 
+// Valid reports whether the plan is one the API knows
+func (p Plan) Valid() bool {
+	return p == PlanFree || p == PlanPro || p == PlanTeam
+}
+
+// Role is a member's role in an organization. Owners can do everything,
+// admins manage members and invitations, members use the organization's
+// plan.
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// CanManage reports whether the role may manage members and invitations
+func (r Role) CanManage() bool {
+	return r == RoleOwner || r == RoleAdmin
+}
+
+// UserSelect selects users along with the plan of their personal
+// organization, which is where plans are kept. Callers append a WHERE
+// clause on u.
+const UserSelect = `
+	SELECT u.id, u.email, u.password_hash, o.plan, u.created_at
+	FROM users u
+	JOIN organizations o ON o.personal_user_id = u.id
+`
+
 type User struct {
 	ID           uuid.UUID `db:"id" json:"id"`
 	Email        string    `db:"email" json:"email"`
 	PasswordHash string    `db:"password_hash" json:"-"`
-	Plan         Plan      `db:"plan" json:"plan"`
-	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	// Plan is that of the user's personal organization
+	Plan      Plan      `db:"plan" json:"plan"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
 }
 
 type Token struct {
 	ID         uuid.UUID  `db:"id" json:"id"`
 	UserID     uuid.UUID  `db:"user_id" json:"user_id"`
+	OrgID      uuid.UUID  `db:"org_id" json:"org_id"`
 	Name       string     `db:"name" json:"name"`
 	Prefix     string     `db:"prefix" json:"prefix"`
 	TokenHash  string     `db:"token_hash" json:"-"`
@@ -35,6 +68,7 @@ type Token struct {
 type Machine struct {
 	ID         uuid.UUID `db:"id" json:"id"`
 	UserID     uuid.UUID `db:"user_id" json:"user_id"`
+	OrgID      uuid.UUID `db:"org_id" json:"org_id"`
 	Hostname   string    `db:"hostname" json:"hostname"`
 	OS         string    `db:"os" json:"os"`
 	Arch       string    `db:"arch" json:"arch"`
@@ -42,6 +76,99 @@ type Machine struct {
 	CreatedAt  time.Time `db:"created_at" json:"created_at"`
 }
 
+type Organization struct {
+	ID   uuid.UUID `db:"id" json:"id"`
+	Name string    `db:"name" json:"name"`
+	Plan Plan      `db:"plan" json:"plan"`
+	// PersonalUserID is set for the organization created with each user,
+	// which cannot be shared or deleted
+	PersonalUserID *uuid.UUID `db:"personal_user_id" json:"-"`
+	// AllowedDomains and MaxValidityDays are the policy for certificates
+	// the API signs
+	AllowedDomains  pq.StringArray `db:"allowed_domains" json:"allowed_domains"`
+	MaxValidityDays int            `db:"max_validity_days" json:"max_validity_days"`
+	CreatedAt       time.Time      `db:"created_at" json:"created_at"`
+}
+
+type Membership struct {
+	OrgID  uuid.UUID `db:"org_id" json:"org_id"`
+	UserID uuid.UUID `db:"user_id" json:"user_id"`
+	Role   Role      `db:"role" json:"role"`
+	// CAAccess is whether the member may download the team CA
+	CAAccess  bool      `db:"ca_access" json:"ca_access"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type Invitation struct {
+	ID         uuid.UUID  `db:"id" json:"id"`
+	OrgID      uuid.UUID  `db:"org_id" json:"org_id"`
+	Role       Role       `db:"role" json:"role"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	TokenHash  string     `db:"token_hash" json:"-"`
+	CreatedBy  *uuid.UUID `db:"created_by" json:"created_by"`
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	AcceptedBy *uuid.UUID `db:"accepted_by" json:"accepted_by"`
+	AcceptedAt *time.Time `db:"accepted_at" json:"accepted_at"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+// TeamCA is a version of an organization's shared CA. IntermediateKey is
+// an encrypted PKCS#8 PEM that only holders of the team passphrase can
+// open; the root key never leaves the admin who created the CA.
+type TeamCA struct {
+	ID               uuid.UUID  `db:"id" json:"id"`
+	OrgID            uuid.UUID  `db:"org_id" json:"org_id"`
+	Version          int        `db:"version" json:"version"`
+	Fingerprint      string     `db:"fingerprint" json:"fingerprint"`
+	RootCert         string     `db:"root_cert" json:"root_cert"`
+	IntermediateCert string     `db:"intermediate_cert" json:"intermediate_cert"`
+	IntermediateKey  string     `db:"intermediate_key" json:"intermediate_key"`
+	CreatedBy        *uuid.UUID `db:"created_by" json:"created_by"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	RetiredAt        *time.Time `db:"retired_at" json:"retired_at"`
+}
+
+// ServerCA is the CA the API holds to sign an organization's remote
+// certificates. EncryptedKey is sealed with the CA encryption key.
+type ServerCA struct {
+	OrgID        uuid.UUID `db:"org_id" json:"org_id"`
+	CertPEM      string    `db:"cert_pem" json:"cert_pem"`
+	EncryptedKey []byte    `db:"encrypted_key" json:"-"`
+	Fingerprint  string    `db:"fingerprint" json:"fingerprint"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+// Certificate records a certificate the API issued, or one a local CA
+// signed and reported so it counts toward the organization's quotas
+type Certificate struct {
+	ID           uuid.UUID      `db:"id" json:"id"`
+	OrgID        uuid.UUID      `db:"org_id" json:"org_id"`
+	UserID       *uuid.UUID     `db:"user_id" json:"user_id"`
+	SerialNumber string         `db:"serial_number" json:"serial_number"`
+	CommonName   string         `db:"common_name" json:"common_name"`
+	DNSNames     pq.StringArray `db:"dns_names" json:"dns_names"`
+	IPAddresses  pq.StringArray `db:"ip_addresses" json:"ip_addresses"`
+	Wildcard     bool           `db:"wildcard" json:"wildcard"`
+	Remote       bool           `db:"remote" json:"remote"`
+	NotBefore    time.Time      `db:"not_before" json:"not_before"`
+	NotAfter     time.Time      `db:"not_after" json:"not_after"`
+	SupersededAt *time.Time     `db:"superseded_at" json:"superseded_at"`
+	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
+}
+
+type Session struct {
+	ID                       uuid.UUID  `db:"id" json:"id"`
+	UserID                   uuid.UUID  `db:"user_id" json:"user_id"`
+	RefreshTokenHash         string     `db:"refresh_token_hash" json:"-"`
+	PreviousRefreshTokenHash *string    `db:"previous_refresh_token_hash" json:"-"`
+	UserAgent                string     `db:"user_agent" json:"user_agent"`
+	IPAddress                string     `db:"ip_address" json:"ip_address"`
+	ExpiresAt                time.Time  `db:"expires_at" json:"expires_at"`
+	RevokedAt                *time.Time `db:"revoked_at" json:"revoked_at"`
+	LastUsedAt               *time.Time `db:"last_used_at" json:"last_used_at"`
+	CreatedAt                time.Time  `db:"created_at" json:"created_at"`
+}
+
 // API response types
 type UserResponse struct {
 	ID        uuid.UUID `json:"id"`
@@ -54,10 +181,45 @@ type LicenseResponse struct {
 	Plan   Plan           `json:"plan"`
 	Limits map[string]int `json:"limits"`
 	User   UserResponse   `json:"user"`
+	Org    OrgResponse    `json:"org"`
+	// License is the signed form of the above the CLI caches for offline use
+	License string `json:"license"`
+}
+
+type OrgResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Plan      Plan      `json:"plan"`
+	Personal  bool      `json:"personal"`
+	Role      Role      `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MemberResponse struct {
+	UserID    uuid.UUID `db:"user_id" json:"user_id"`
+	Email     string    `db:"email" json:"email"`
+	Role      Role      `db:"role" json:"role"`
+	CAAccess  bool      `db:"ca_access" json:"ca_access"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type InvitationResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Role       Role       `json:"role"`
+	Prefix     string     `json:"prefix"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type InvitationCreateResponse struct {
+	Token string             `json:"token"`
+	Data  InvitationResponse `json:"data"`
 }
 
 type TokenResponse struct {
 	ID         uuid.UUID  `json:"id"`
+	OrgID      uuid.UUID  `json:"org_id"`
 	Name       string     `json:"name"`
 	Prefix     string     `json:"prefix"`
 	LastUsedAt *time.Time `json:"last_used_at"`
@@ -68,3 +230,38 @@ type TokenCreateResponse struct {
 	Token string        `json:"token"`
 	Data  TokenResponse `json:"data"`
 }
+
+type SessionResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	Current    bool       `json:"current"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type TeamCAResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	Version     int        `json:"version"`
+	Fingerprint string     `json:"fingerprint"`
+	CreatedBy   *uuid.UUID `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RetiredAt   *time.Time `json:"retired_at"`
+}
+
+type PolicyResponse struct {
+	AllowedDomains  []string `json:"allowed_domains"`
+	MaxValidityDays int      `json:"max_validity_days"`
+}
+
+type ServerCAResponse struct {
+	Certificate string `json:"certificate"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type CertificateCreateResponse struct {
+	Certificate   string      `json:"certificate"`
+	CACertificate string      `json:"ca_certificate"`
+	Data          Certificate `json:"data"`
+}
@@ -24,11 +24,52 @@ func SeedDemoUser(db *sqlx.DB) error {
 		return err
 	}
 
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	userID := uuid.New()
-	_, err = db.Exec(`
-		INSERT INTO users (id, email, password_hash, plan)
-		VALUES ($1, $2, $3, $4)
-	`, userID, "demo@instanttls.dev", string(passwordHash), "pro")
+	_, err = tx.Exec(`
+		INSERT INTO users (id, email, password_hash)
+		VALUES ($1, $2, $3)
+	`, userID, "demo@instanttls.dev", string(passwordHash))
+	if err != nil {
+		return err
+	}
+
+	// Personal organization, plus a team one to try members and invitations
+	orgs := []struct {
+		name     string
+		plan     string
+		personal bool
+	}{
+		{"demo@instanttls.dev", "pro", true},
+		{"Demo Team", "team", false},
+	}
+	for _, o := range orgs {
+		orgID := uuid.New()
+		var personalUserID *uuid.UUID
+		if o.personal {
+			personalUserID = &userID
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO organizations (id, name, plan, personal_user_id)
+			VALUES ($1, $2, $3, $4)
+		`, orgID, o.name, o.plan, personalUserID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO memberships (org_id, user_id, role) VALUES ($1, $2, 'owner')
+		`, orgID, userID)
+		if err != nil {
+			return err
+		}
+	}
 
-	return err
+	return tx.Commit()
 }
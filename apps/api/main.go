@@ -10,10 +10,12 @@ import (
 	"github.com/instanttls/api/internal/handlers"
 	"github.com/instanttls/api/internal/middleware"
 	"github.com/instanttls/api/internal/migrations"
+	"github.com/instanttls/api/internal/models"
 	"github.com/instanttls/api/internal/seed"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
@@ -63,6 +65,30 @@ func main() {
 			}
 			fmt.Println("✅ Demo user seeded successfully")
 			return
+		case "plan":
+			if len(os.Args) != 4 || !models.Plan(os.Args[3]).Valid() {
+				fmt.Println("Usage: go run . plan <org-id> [free|pro|team]")
+				return
+			}
+			orgID, err := uuid.Parse(os.Args[2])
+			if err != nil {
+				log.Fatalf("Invalid organization ID: %v", err)
+			}
+			db, err := database.Connect(cfg.DatabaseURL)
+			if err != nil {
+				log.Fatalf("Failed to connect to database: %v", err)
+			}
+			// Plans are kept on organizations; a user's plan is that of
+			// their personal organization
+			result, err := db.Exec("UPDATE organizations SET plan = $1 WHERE id = $2", os.Args[3], orgID)
+			if err != nil {
+				log.Fatalf("Failed to set plan: %v", err)
+			}
+			if rows, _ := result.RowsAffected(); rows == 0 {
+				log.Fatalf("Organization %s not found", orgID)
+			}
+			fmt.Printf("✅ Organization %s is now on the %s plan\n", orgID, os.Args[3])
+			return
 		}
 	}
 
@@ -107,6 +133,9 @@ func main() {
 		{
 			auth.POST("/register", h.Register)
 			auth.POST("/login", h.Login)
+			auth.POST("/refresh", h.Refresh)
+			auth.POST("/logout", middleware.SessionAuth(db, cfg), h.Logout)
+			auth.POST("/logout-all", middleware.SessionAuth(db, cfg), h.LogoutAll)
 		}
 
 		// Protected routes (PAT auth)
@@ -118,19 +147,69 @@ func main() {
 		machines.Use(middleware.PATAuth(db))
 		{
 			machines.POST("/ping", h.MachinePing)
+			machines.DELETE("/:hostname", h.MachineDeregister)
+		}
+
+		// Team CA routes (PAT auth, for the token's organization)
+		ca := v1.Group("/ca")
+		ca.Use(middleware.PATAuth(db))
+		{
+			ca.GET("", h.GetTeamCA)
+			ca.POST("", h.CreateTeamCA)
+			ca.POST("/rotate", h.RotateTeamCA)
+		}
+
+		// Certificate routes (PAT auth, for the token's organization)
+		certificates := v1.Group("/certificates")
+		certificates.Use(middleware.PATAuth(db))
+		{
+			certificates.GET("", h.ListCertificates)
+			certificates.POST("", h.CreateCertificate)
+			certificates.POST("/report", h.ReportCertificate)
+			certificates.GET("/ca", h.GetServerCA)
 		}
 
 		// Token routes (session auth for web)
 		tokens := v1.Group("/tokens")
-		tokens.Use(middleware.SessionAuth(cfg))
+		tokens.Use(middleware.SessionAuth(db, cfg))
 		{
 			tokens.GET("", h.ListTokens)
 			tokens.POST("", h.CreateToken)
 			tokens.DELETE("/:id", h.DeleteToken)
 		}
 
+		// Organization routes (session auth for web)
+		orgs := v1.Group("/orgs")
+		orgs.Use(middleware.SessionAuth(db, cfg))
+		{
+			orgs.GET("", h.ListOrgs)
+			orgs.POST("", h.CreateOrg)
+
+			org := orgs.Group("/:id")
+			org.Use(middleware.OrgAccess(db))
+			{
+				org.GET("", h.GetOrg)
+				org.PATCH("", h.UpdateOrg)
+				org.DELETE("", h.DeleteOrg)
+				org.GET("/members", h.ListMembers)
+				org.PATCH("/members/:user_id", h.UpdateMember)
+				org.DELETE("/members/:user_id", h.RemoveMember)
+				org.PUT("/members/:user_id/ca-access", h.GrantCAAccess)
+				org.DELETE("/members/:user_id/ca-access", h.RevokeCAAccess)
+				org.GET("/ca", h.ListTeamCAs)
+				org.GET("/policy", h.GetPolicy)
+				org.PUT("/policy", h.UpdatePolicy)
+				org.GET("/machines", h.ListOrgMachines)
+				org.GET("/invitations", h.ListInvitations)
+				org.POST("/invitations", h.CreateInvitation)
+				org.DELETE("/invitations/:invitation_id", h.DeleteInvitation)
+			}
+		}
+		v1.POST("/invitations/accept", middleware.SessionAuth(db, cfg), h.AcceptInvitation)
+
 		// User routes (session auth for web)
-		v1.GET("/user", middleware.SessionAuth(cfg), h.GetUser)
+		v1.GET("/user", middleware.SessionAuth(db, cfg), h.GetUser)
+		v1.GET("/sessions", middleware.SessionAuth(db, cfg), h.ListSessions)
 	}
 
 	// Health check
@@ -30,25 +30,22 @@ export default function AppLayout({ children }: { children: React.ReactNode }) {
   const { toast } = useToast()
 
   useEffect(() => {
-    const token = localStorage.getItem('auth_token')
-    if (!token) {
+    if (!api.restoreSession()) {
       router.push('/login')
       return
     }
 
-    api.setAuthToken(token)
     api.getUser()
       .then(setUser)
       .catch(() => {
-        localStorage.removeItem('auth_token')
+        api.clearSession()
         router.push('/login')
       })
       .finally(() => setIsLoading(false))
   }, [router])
 
-  const handleLogout = () => {
-    localStorage.removeItem('auth_token')
-    api.setAuthToken(null)
+  const handleLogout = async () => {
+    await api.logout().catch(() => {})
     router.push('/login')
   }
 
@@ -1,7 +1,9 @@
 'use client'
 
 import { useEffect, useState } from 'react'
-import { User as UserIcon, Mail, Calendar, Shield } from 'lucide-react'
+import { useRouter } from 'next/navigation'
+import { User as UserIcon, Mail, Calendar, Shield, LogOut } from 'lucide-react'
+import { Button } from '@/components/ui/button'
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card'
 import { api, User } from '@/lib/api'
 
@@ -21,11 +23,17 @@ function PlanBadge({ plan }: { plan: string }) {
 
 export default function SettingsPage() {
   const [user, setUser] = useState<User | null>(null)
+  const router = useRouter()
 
   useEffect(() => {
     api.getUser().then(setUser).catch(console.error)
   }, [])
 
+  const handleLogoutAll = async () => {
+    await api.logoutAll().catch(() => {})
+    router.push('/login')
+  }
+
   const formatDate = (date: string) => {
     return new Date(date).toLocaleDateString('en-US', {
       year: 'numeric',
@@ -128,6 +136,19 @@ export default function SettingsPage() {
           )}
         </CardContent>
       </Card>
+
+      <Card>
+        <CardHeader>
+          <CardTitle>Sessions</CardTitle>
+          <CardDescription>Sign out of every browser where you are logged in</CardDescription>
+        </CardHeader>
+        <CardContent>
+          <Button variant="outline" onClick={handleLogoutAll}>
+            <LogOut className="h-4 w-4 mr-2" />
+            Sign out everywhere
+          </Button>
+        </CardContent>
+      </Card>
     </div>
   )
 }
@@ -24,8 +24,7 @@ export default function LoginPage() {
 
     try {
       const response = await api.login(email, password)
-      localStorage.setItem('auth_token', response.token)
-      api.setAuthToken(response.token)
+      api.setSession(response)
       toast({
         title: 'Welcome back!',
         description: 'You have successfully logged in.',
@@ -44,8 +44,7 @@ export default function RegisterPage() {
 
     try {
       const response = await api.register(email, password)
-      localStorage.setItem('auth_token', response.token)
-      api.setAuthToken(response.token)
+      api.setSession(response)
       toast({
         title: 'Account created!',
         description: 'Welcome to InstantTLS.',
@@ -22,17 +22,75 @@ export interface TokenCreateResponse {
 
 export interface AuthResponse {
   token: string
+  refresh_token: string
+  expires_in: number
   user: User
 }
 
+export interface RefreshResponse {
+  token: string
+  refresh_token: string
+  expires_in: number
+}
+
+const ACCESS_TOKEN_KEY = 'auth_token'
+const REFRESH_TOKEN_KEY = 'refresh_token'
+
 class ApiClient {
   private authToken: string | null = null
+  private refreshing: Promise<boolean> | null = null
 
   setAuthToken(token: string | null) {
     this.authToken = token
   }
 
-  private async request<T>(method: string, path: string, body?: unknown): Promise<T> {
+  // Persist a freshly issued access/refresh token pair
+  setSession(session: { token: string; refresh_token: string }) {
+    localStorage.setItem(ACCESS_TOKEN_KEY, session.token)
+    localStorage.setItem(REFRESH_TOKEN_KEY, session.refresh_token)
+    this.authToken = session.token
+  }
+
+  // Load a previously stored session, returns false if there is none
+  restoreSession(): boolean {
+    const token = localStorage.getItem(ACCESS_TOKEN_KEY)
+    this.authToken = token
+    return token !== null || localStorage.getItem(REFRESH_TOKEN_KEY) !== null
+  }
+
+  clearSession() {
+    localStorage.removeItem(ACCESS_TOKEN_KEY)
+    localStorage.removeItem(REFRESH_TOKEN_KEY)
+    this.authToken = null
+  }
+
+  // Exchange the stored refresh token for a new token pair (deduplicated)
+  private async refresh(): Promise<boolean> {
+    if (!this.refreshing) {
+      this.refreshing = (async () => {
+        const refreshToken = localStorage.getItem(REFRESH_TOKEN_KEY)
+        if (!refreshToken) return false
+
+        const response = await fetch(`${API_URL}/v1/auth/refresh`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        })
+        if (!response.ok) {
+          this.clearSession()
+          return false
+        }
+
+        this.setSession(await response.json() as RefreshResponse)
+        return true
+      })().finally(() => {
+        this.refreshing = null
+      })
+    }
+    return this.refreshing
+  }
+
+  private async request<T>(method: string, path: string, body?: unknown, retry = true): Promise<T> {
     const headers: Record<string, string> = {
       'Content-Type': 'application/json',
     }
@@ -47,6 +105,12 @@ class ApiClient {
       body: body ? JSON.stringify(body) : undefined,
     })
 
+    if (response.status === 401 && retry && !path.startsWith('/v1/auth/')) {
+      if (await this.refresh()) {
+        return this.request(method, path, body, false)
+      }
+    }
+
     if (!response.ok) {
       const error = await response.json().catch(() => ({ error: 'Request failed' }))
       throw new Error(error.error || 'Request failed')
@@ -63,6 +127,22 @@ class ApiClient {
     return this.request('POST', '/v1/auth/login', { email, password })
   }
 
+  async logout(): Promise<void> {
+    try {
+      await this.request('POST', '/v1/auth/logout')
+    } finally {
+      this.clearSession()
+    }
+  }
+
+  async logoutAll(): Promise<void> {
+    try {
+      await this.request('POST', '/v1/auth/logout-all')
+    } finally {
+      this.clearSession()
+    }
+  }
+
   async getUser(): Promise<User> {
     return this.request('GET', '/v1/user')
   }
@@ -20,16 +20,13 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   const [isLoading, setIsLoading] = useState(true)
 
   useEffect(() => {
-    const savedToken = localStorage.getItem('auth_token')
-    if (savedToken) {
-      setToken(savedToken)
-      api.setAuthToken(savedToken)
+    if (api.restoreSession()) {
+      setToken(localStorage.getItem('auth_token'))
       api.getUser()
         .then(setUser)
         .catch(() => {
-          localStorage.removeItem('auth_token')
+          api.clearSession()
           setToken(null)
-          api.setAuthToken(null)
         })
         .finally(() => setIsLoading(false))
     } else {
@@ -41,23 +38,20 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     const response = await api.login(email, password)
     setToken(response.token)
     setUser(response.user)
-    localStorage.setItem('auth_token', response.token)
-    api.setAuthToken(response.token)
+    api.setSession(response)
   }
 
   const register = async (email: string, password: string) => {
     const response = await api.register(email, password)
     setToken(response.token)
     setUser(response.user)
-    localStorage.setItem('auth_token', response.token)
-    api.setAuthToken(response.token)
+    api.setSession(response)
   }
 
   const logout = () => {
     setToken(null)
     setUser(null)
-    localStorage.removeItem('auth_token')
-    api.setAuthToken(null)
+    api.logout().catch(() => {})
   }
 
   return (
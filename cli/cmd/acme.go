@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/instanttls/cli/internal/acme"
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/config"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var acmeCmd = &cobra.Command{
+	Use:   "acme",
+	Short: "Run a local ACME server backed by your CA",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var acmeServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the ACME protocol so any ACME client can get local certificates",
+	Long: `Run a local ACME (RFC 8555) server that issues certificates from your
+InstantTLS CA. Point certbot, Caddy, Traefik, cert-manager or any other ACME
+client at its directory URL instead of Let's Encrypt.
+
+Challenges are validated like a public CA would, except that http-01 and
+tls-alpn-01 connect to --validation-host (127.0.0.1 by default) instead of
+resolving the name. Use --always-valid to skip validation entirely during
+development; it also allows wildcard names, which count toward your plan
+and so need a login.
+
+The server's own HTTPS certificate is issued from your CA, so clients that
+trust the CA trust the server too. State is kept in memory only.
+
+Examples:
+  instanttls acme serve
+  instanttls acme serve --always-valid
+  instanttls acme serve --addr 0.0.0.0:14000 --hostname host.docker.internal`,
+	Args: cobra.NoArgs,
+	Run:  runACMEServe,
+}
+
+var (
+	acmeAddr           string
+	acmeAlwaysValid    bool
+	acmeValidationHost string
+	acmeHTTPPort       int
+	acmeTLSPort        int
+	acmeDays           int
+	acmeHostnames      []string
+	acmeInsecureHTTP   bool
+)
+
+func init() {
+	acmeServeCmd.Flags().StringVar(&acmeAddr, "addr", "127.0.0.1:14000", "Address to listen on")
+	acmeServeCmd.Flags().BoolVar(&acmeAlwaysValid, "always-valid", false, "Accept every challenge without validating it (development only)")
+	acmeServeCmd.Flags().StringVar(&acmeValidationHost, "validation-host", "127.0.0.1", "Host that challenge validation connects to (empty: resolve the name)")
+	acmeServeCmd.Flags().IntVar(&acmeHTTPPort, "http-port", 80, "Port for http-01 validation")
+	acmeServeCmd.Flags().IntVar(&acmeTLSPort, "tls-port", 443, "Port for tls-alpn-01 validation")
+	acmeServeCmd.Flags().IntVar(&acmeDays, "days", cert.CertValidityDays, "Validity of issued certificates in days")
+	acmeServeCmd.Flags().StringSliceVar(&acmeHostnames, "hostname", nil, "Extra name for the server's own certificate (repeatable)")
+	acmeServeCmd.Flags().BoolVar(&acmeInsecureHTTP, "insecure-http", false, "Serve plain HTTP instead of HTTPS")
+	acmeCmd.AddCommand(acmeServeCmd)
+	rootCmd.AddCommand(acmeCmd)
+}
+
+func runACMEServe(cmd *cobra.Command, args []string) {
+	if !cert.CAExists() {
+		exitWithError("CA not found. Run 'instanttls init' first.")
+	}
+
+	host, port, err := net.SplitHostPort(acmeAddr)
+	if err != nil {
+		exitWithError(fmt.Sprintf("invalid --addr: %v", err))
+	}
+
+	// Unlock the CA now; the server cannot prompt for a passphrase later
+	if _, _, err := cert.LoadCA(); err != nil {
+		exitWithError(err.Error())
+	}
+
+	// Certificates are counted like those of 'instanttls cert' when logged in
+	var report cert.IssuanceReporter
+	if cfg, err := config.Load(); err == nil && cfg != nil && cfg.Token != "" {
+		report = issuanceReporter(cfg)
+	}
+
+	server := acme.NewServer(acme.Options{
+		AlwaysValid:    acmeAlwaysValid,
+		ValidationHost: acmeValidationHost,
+		HTTPPort:       acmeHTTPPort,
+		TLSPort:        acmeTLSPort,
+		ValidityDays:   acmeDays,
+		Report:         report,
+		Logf: func(format string, args ...interface{}) {
+			pterm.Info.Printfln("%s  %s", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+		},
+	})
+
+	httpServer := &http.Server{
+		Addr:              acmeAddr,
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	scheme := "https"
+	if acmeInsecureHTTP {
+		scheme = "http"
+	} else {
+		names := append([]string{"localhost", "127.0.0.1", "::1"}, acmeHostnames...)
+		if host != "" && host != "0.0.0.0" && host != "::" {
+			names = append(names, host)
+		}
+
+		serverCert, err := cert.IssueTLSCertificate(names, 30)
+		if err != nil {
+			exitWithError(fmt.Sprintf("failed to issue the server certificate: %v", err))
+		}
+		httpServer.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{*serverCert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	urlHost := host
+	if urlHost == "" || urlHost == "0.0.0.0" || urlHost == "::" || urlHost == "127.0.0.1" {
+		urlHost = "localhost"
+	}
+	directoryURL := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(urlHost, port), acme.DirectoryPath)
+	printACMEInstructions(directoryURL)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	if acmeInsecureHTTP {
+		err = httpServer.ListenAndServe()
+	} else {
+		err = httpServer.ListenAndServeTLS("", "")
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		exitWithError(err.Error())
+	}
+
+	pterm.Println()
+	printInfo("ACME server stopped")
+}
+
+func printACMEInstructions(directoryURL string) {
+	caFile := filepath.Join(config.GetCADir(), cert.RootCertFile)
+
+	mode := "validating challenges against " + acmeValidationHost
+	if acmeValidationHost == "" {
+		mode = "validating challenges through DNS"
+	}
+	if acmeAlwaysValid {
+		mode = "accepting every challenge (--always-valid)"
+	}
+
+	pterm.Println()
+	pterm.DefaultBox.WithTitle("🔐 InstantTLS ACME Server").
+		WithTitleTopCenter().
+		Println(fmt.Sprintf(`
+  Directory: %s
+  Mode:      %s
+  CA:        %s
+`, directoryURL, mode, caFile))
+
+	pterm.Println()
+	pterm.Info.Println("certbot:")
+	pterm.Println(fmt.Sprintf("  REQUESTS_CA_BUNDLE=%s certbot certonly --standalone \\", caFile))
+	pterm.Println(fmt.Sprintf("    --server %s -d app.local.test", directoryURL))
+	pterm.Println()
+	pterm.Info.Println("Caddyfile:")
+	pterm.Println("  {")
+	pterm.Println("    acme_ca " + directoryURL)
+	pterm.Println("    acme_ca_root " + caFile)
+	pterm.Println("  }")
+	pterm.Println()
+	pterm.Info.Println("lego:")
+	pterm.Println(fmt.Sprintf("  LEGO_CA_CERTIFICATES=%s lego --server %s --http -d app.local.test run", caFile, directoryURL))
+	pterm.Println()
+	pterm.Info.Println("Press Ctrl+C to stop")
+	pterm.Println()
+}
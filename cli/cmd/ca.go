@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/secret"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the local CA hierarchy",
+	Long: `Manage the local root and intermediate Certificate Authorities.
+
+Only the root CA is installed in your trust store. Leaf certificates are
+signed by an intermediate CA, so the root key can be kept offline.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var caRotateCmd = &cobra.Command{
+	Use:   "rotate-intermediate",
+	Short: "Issue a new intermediate CA from the existing root",
+	Long: `Issue a new intermediate CA signed by your existing root CA.
+
+The root stays the same, so nothing has to be re-trusted. Certificates
+issued by the previous intermediate remain valid; new certificates are
+signed by the new intermediate.
+
+If you keep the root key outside ~/.instanttls, pass its location with
+--root-key.
+
+For a team CA, the new intermediate is uploaded as the next version of
+the team CA. This needs the root key, so it is done by whoever created
+the team CA.
+
+Examples:
+  instanttls ca rotate-intermediate
+  instanttls ca rotate-intermediate --root-key /media/usb/instanttls-ca.key`,
+	Args: cobra.NoArgs,
+	Run:  runCARotate,
+}
+
+var caEncryptCmd = &cobra.Command{
+	Use:   "encrypt-key",
+	Short: "Encrypt the CA private keys with a passphrase",
+	Long: `Encrypt the root and intermediate CA private keys with a passphrase.
+
+Run it again to change the passphrase. Keys are stored as standard encrypted
+PKCS#8 files. Afterwards, commands that sign certificates unlock the keys with
+INSTANTTLS_CA_PASSPHRASE, INSTANTTLS_CA_PASSPHRASE_COMMAND, the OS keyring,
+or an interactive prompt.
+
+For a team CA this changes the team passphrase: the re-encrypted key is
+uploaded as a new version of the team CA. After revoking a member's team
+CA access, change the passphrase and rotate the intermediate so copies
+they already have stop being useful for new certificates.
+
+Examples:
+  instanttls ca encrypt-key
+  instanttls ca encrypt-key --keyring`,
+	Args: cobra.NoArgs,
+	Run:  runCAEncrypt,
+}
+
+var caDecryptCmd = &cobra.Command{
+	Use:   "decrypt-key",
+	Short: "Store the CA private keys unencrypted again",
+	Args:  cobra.NoArgs,
+	Run:   runCADecrypt,
+}
+
+var (
+	caRootKeyPath string
+	caKeyType     string
+	caKeyring     bool
+)
+
+func init() {
+	caEncryptCmd.Flags().BoolVar(&caKeyring, "keyring", false, "Store the passphrase in the OS keyring")
+	caCmd.AddCommand(caEncryptCmd)
+	caCmd.AddCommand(caDecryptCmd)
+
+	caRotateCmd.Flags().StringVar(&caRootKeyPath, "root-key", "", "Path to the root CA private key (default: ~/.instanttls/ca/ca.key)")
+	caRotateCmd.Flags().StringVar(&caKeyType, "key-type", "", "Intermediate key type (default: same as the current intermediate)")
+	caCmd.AddCommand(caRotateCmd)
+	rootCmd.AddCommand(caCmd)
+}
+
+func runCARotate(cmd *cobra.Command, args []string) {
+	pterm.Println()
+	pterm.DefaultHeader.WithBackgroundStyle(pterm.NewStyle(pterm.BgMagenta)).
+		WithTextStyle(pterm.NewStyle(pterm.FgWhite)).
+		Println("🔁 Rotate Intermediate CA")
+	pterm.Println()
+
+	if !cert.CAExists() {
+		printError("CA not found. Run 'instanttls init' first.")
+		return
+	}
+
+	var keyType cert.KeyType
+	if caKeyType != "" {
+		kt, err := cert.ParseKeyType(caKeyType)
+		if err != nil {
+			printError(err.Error())
+			return
+		}
+		keyType = kt
+	}
+
+	spinner, _ := pterm.DefaultSpinner.Start("Issuing new intermediate CA...")
+
+	intermediate, err := cert.RotateIntermediate(caRootKeyPath, keyType)
+	if err != nil {
+		spinner.Fail("Failed to rotate intermediate CA")
+		printError(err.Error())
+		return
+	}
+
+	spinner.Success("Intermediate CA rotated!")
+	pterm.Println()
+
+	caDir := config.GetCADir()
+	pterm.DefaultBox.WithTitle("🔗 New Intermediate").
+		WithTitleTopCenter().
+		Println(fmt.Sprintf(`
+  Subject:     %s
+  Key Type:    %s
+  Valid Until: %s
+  Fingerprint: %s
+  Certificate: %s/%s
+`, intermediate.Subject.CommonName, cert.KeyTypeOf(intermediate.PublicKey),
+			intermediate.NotAfter.Format("2006-01-02"), cert.Fingerprint(intermediate),
+			caDir, cert.IntermediateCertFile))
+
+	pterm.Println()
+	pushTeamCA()
+	pterm.Info.Println("Existing certificates keep working. Run 'instanttls renew' or 'instanttls cert' to reissue them with the new intermediate.")
+	pterm.Println()
+}
+
+func runCAEncrypt(cmd *cobra.Command, args []string) {
+	if !cert.CAExists() {
+		printError("CA not found. Run 'instanttls init' first.")
+		return
+	}
+
+	// Unlock the current keys before asking for the new passphrase
+	if cert.CAKeyEncrypted() {
+		if _, _, err := cert.LoadCA(); err != nil {
+			printError(err.Error())
+			return
+		}
+	}
+
+	passphrase, err := newPassphrase()
+	if err != nil {
+		printError(err.Error())
+		return
+	}
+
+	if err := cert.SetCAKeyPassphrase(passphrase); err != nil {
+		printError(err.Error())
+		return
+	}
+
+	if caKeyring {
+		if err := (secret.KeyringProvider{}).Store(passphrase); err != nil {
+			printWarning(err.Error())
+		} else {
+			printInfo("Passphrase stored in the OS keyring")
+		}
+	}
+
+	printSuccess("CA private keys are now encrypted")
+	pushTeamCA()
+}
+
+func runCADecrypt(cmd *cobra.Command, args []string) {
+	if info, _ := cert.ReadTeamInfo(); info != nil {
+		printError("A team CA key must stay encrypted with the team passphrase")
+		return
+	}
+
+	if !cert.CAKeyEncrypted() {
+		printInfo("CA private keys are not encrypted")
+		return
+	}
+
+	if err := cert.SetCAKeyPassphrase(nil); err != nil {
+		printError(err.Error())
+		return
+	}
+
+	_ = (secret.KeyringProvider{}).Delete()
+	printSuccess("CA private keys are now stored unencrypted")
+}
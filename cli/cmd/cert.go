@@ -7,32 +7,84 @@ import (
 	"github.com/instanttls/cli/internal/api"
 	"github.com/instanttls/cli/internal/cert"
 	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/hosts"
+	"github.com/instanttls/cli/internal/license"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 )
 
 var certCmd = &cobra.Command{
-	Use:   "cert <domain>",
-	Short: "Generate a certificate for a domain",
-	Long: `Generate a TLS certificate for a domain or wildcard pattern.
+	Use:   "cert <domain> [domain...]",
+	Short: "Generate a certificate for one or more domains",
+	Long: `Generate a TLS certificate for domains, wildcard patterns and IP addresses.
 
-The certificate will be signed by your local CA. Make sure you have run
-'instanttls init' first.
+All names given are merged into a single certificate. The first name is used
+as the certificate's common name. The certificate will be signed by your
+local CA. Make sure you have run 'instanttls init' first.
+
+Every certificate is recorded with the API, which enforces your plan's
+limits. Offline, the signed license cached by 'login' and 'whoami' is used
+instead, and only certificates your plan does not limit can be made.
+
+With --remote, or after 'instanttls init --remote', the API signs it
+instead: the key is generated here and only a CSR is sent. The
+organization's allowed domains, maximum validity and plan quotas are
+enforced by the API. Without --days its policy picks the validity.
 
 Examples:
   instanttls cert "*.local.test"     # Wildcard certificate
   instanttls cert "myapp.local"      # Single domain
-  instanttls cert "localhost"        # Localhost certificate`,
-	Args: cobra.ExactArgs(1),
+  instanttls cert "localhost"        # Localhost certificate
+  instanttls cert localhost 127.0.0.1 ::1 api.local.test "*.app.local.test"
+  instanttls cert --key-type ecdsa-p256 "*.local.test"
+  instanttls cert --remote api.corp.internal`,
+	Args: cobra.MinimumNArgs(1),
 	Run:  runCert,
 }
 
+var (
+	certKeyType string
+	certDays    int
+	certHosts   bool
+	certFormats []string
+	certRemote  bool
+)
+
 func init() {
+	certCmd.Flags().StringVar(&certKeyType, "key-type", string(cert.DefaultKeyType), "Certificate key type (rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519)")
+	certCmd.Flags().IntVar(&certDays, "days", cert.CertValidityDays, "Certificate validity in days")
+	certCmd.Flags().BoolVar(&certRemote, "remote", false, "Have the API sign the certificate with your organization's server CA")
+	certCmd.Flags().BoolVar(&certHosts, "hosts", false, "Also point every non-wildcard domain at 127.0.0.1 in the hosts file")
+	certCmd.Flags().StringSliceVar(&certFormats, "format", nil, "Also write the certificate as p12, jks, der, pem-bundle or pkcs8 (see 'instanttls export')")
+	certCmd.Flags().StringVar(&exportPassword, "password", "", "Password for --format p12, jks and pkcs8 (default: $"+exportPasswordEnv+" or a prompt)")
 	rootCmd.AddCommand(certCmd)
 }
 
 func runCert(cmd *cobra.Command, args []string) {
-	domain := args[0]
+	sans, err := cert.ParseSANs(args)
+	if err != nil {
+		printError(err.Error())
+		return
+	}
+	names := strings.Join(sans.Names(), ", ")
+
+	keyType, err := cert.ParseKeyType(certKeyType)
+	if err != nil {
+		printError(err.Error())
+		return
+	}
+
+	if certDays <= 0 {
+		printError("--days must be a positive number")
+		return
+	}
+
+	for _, f := range certFormats {
+		if _, err := cert.ParseExportFormat(f); err != nil {
+			printError(err.Error())
+			return
+		}
+	}
 
 	cfg, err := config.Load()
 	if err != nil || cfg == nil || cfg.Token == "" {
@@ -46,56 +98,81 @@ func runCert(cmd *cobra.Command, args []string) {
 		Println("📜 Generate Certificate")
 	pterm.Println()
 
+	// A machine set up with 'init --remote' has no CA key to sign with
+	remoteInfo, _ := cert.ReadRemoteInfo()
+	remote := certRemote || remoteInfo != nil
+
 	// Check CA exists
-	if !cert.CAExists() {
+	if !remote && !cert.CAExists() {
 		printError("CA not found. Run 'instanttls init' first.")
 		return
 	}
 
-	// Check plan limits
-	isWildcard := strings.HasPrefix(domain, "*.")
+	// Generate certificate
+	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Generating certificate for %s...", names))
 
-	if isWildcard && cfg.Plan == "free" {
-		// Check license from API
-		client := api.NewClient(cfg.APIBaseURL, cfg.Token)
-		license, err := client.License()
-		if err != nil {
-			printWarning("Could not verify license, proceeding with local check")
-		} else {
-			maxCerts := license.Limits["max_wildcard_certs"]
-			currentCount := cert.CountWildcardCerts()
-
-			if maxCerts > 0 && currentCount >= maxCerts {
-				printError(fmt.Sprintf(
-					"Free plan limit reached (%d/%d wildcard certs). Upgrade to Pro for unlimited certs.",
-					currentCount, maxCerts,
-				))
-				pterm.Println()
-				pterm.Info.Println("Upgrade at: https://instanttls.dev/pricing")
-				return
-			}
-		}
+	req := cert.CertRequest{
+		Names:        args,
+		KeyType:      keyType,
+		ValidityDays: certDays,
+		Remote:       remote,
 	}
 
-	// Generate certificate
-	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Generating certificate for %s...", domain))
-
-	certDir, err := cert.GenerateCert(domain)
+	var certDir string
+	if remote {
+		if !cmd.Flags().Changed("days") {
+			req.ValidityDays = 0
+		}
+		certDir, err = cert.GenerateRemoteCert(req, remoteSigner(cfg))
+	} else {
+		certDir, err = cert.GenerateCert(req, issuanceReporter(cfg))
+	}
 	if err != nil {
 		spinner.Fail("Failed to generate certificate")
 		printError(err.Error())
+		if strings.Contains(err.Error(), "limit reached") {
+			pterm.Println()
+			pterm.Info.Println("Upgrade at: https://instanttls.dev/pricing")
+		}
 		return
 	}
 
-	spinner.Success(fmt.Sprintf("Certificate generated for %s", domain))
+	spinner.Success(fmt.Sprintf("Certificate generated for %s", names))
 	pterm.Println()
 
+	if remote && remoteInfo == nil {
+		printWarning("This certificate is signed by your organization's server CA. Run 'instanttls init --remote' to trust it.")
+		pterm.Println()
+	}
+
+	if certHosts {
+		if hostnames := hostsNames(sans); len(hostnames) > 0 {
+			if err := addHostsEntries(hosts.DefaultPath(), hosts.DefaultIP, hostnames, false); err != nil {
+				printWarning(err.Error())
+			}
+			pterm.Println()
+		}
+	}
+
+	if len(certFormats) > 0 {
+		info, err := cert.ReadCertInfo(certDir)
+		if err == nil {
+			err = exportCert(info, certFormats, "")
+		}
+		if err != nil {
+			printWarning(err.Error())
+		}
+		pterm.Println()
+	}
+
 	pterm.DefaultBox.WithTitle("📁 Certificate Files").
 		WithTitleTopCenter().
 		Println(fmt.Sprintf(`
   Certificate: %s/cert.pem
+  Full Chain:  %s/fullchain.pem
   Private Key: %s/key.pem
-`, certDir, certDir))
+  Manifest:    %s/meta.json
+`, certDir, certDir, certDir, certDir))
 
 	pterm.Println()
 	pterm.DefaultSection.Println("Usage Examples")
@@ -108,7 +185,7 @@ const fs = require('fs');
 
 const options = {
   key: fs.readFileSync('%s/key.pem'),
-  cert: fs.readFileSync('%s/cert.pem')
+  cert: fs.readFileSync('%s/fullchain.pem')
 };
 
 https.createServer(options, (req, res) => {
@@ -124,23 +201,88 @@ https.createServer(options, (req, res) => {
     listen 443 ssl;
     server_name %s;
 
-    ssl_certificate     %s/cert.pem;
+    ssl_certificate     %s/fullchain.pem;
     ssl_certificate_key %s/key.pem;
 
     location / {
         # your config here
     }
-}`, domain, certDir, certDir))
+}`, strings.Join(sans.Names(), " "), certDir, certDir))
 
 	pterm.Println()
 
 	// Caddy example
 	pterm.FgCyan.Println("Caddy:")
 	pterm.DefaultBox.Println(fmt.Sprintf(`%s {
-    tls %s/cert.pem %s/key.pem
+    tls %s/fullchain.pem %s/key.pem
 
     respond "Hello HTTPS!"
-}`, strings.TrimPrefix(domain, "*."), certDir, certDir))
+}`, caddySiteAddresses(sans), certDir, certDir))
 
 	pterm.Println()
 }
+
+// issuanceReporter reports certificates signed by the local CA to the API,
+// which refuses them once the organization's plan quota is used up. The
+// quota is counted on the server, so it holds whatever is on disk. While
+// the API cannot be reached, the verified cached license decides instead:
+// only certificates its plan does not limit are allowed.
+func issuanceReporter(cfg *config.Config) cert.IssuanceReporter {
+	client := api.NewClient(cfg.APIBaseURL, cfg.Token)
+	return func(r cert.IssuanceReport) error {
+		err := client.ReportCertificate(api.CertificateReport{
+			SerialNumber: r.SerialNumber,
+			CommonName:   r.CommonName,
+			DNSNames:     r.DNSNames,
+			IPAddresses:  r.IPAddresses,
+			NotBefore:    r.NotBefore,
+			NotAfter:     r.NotAfter,
+			Replaces:     r.Replaces,
+		})
+		if err == nil || !api.IsUnreachable(err) {
+			return err
+		}
+
+		lic, licErr := license.Load()
+		if licErr != nil {
+			return fmt.Errorf("could not reach the API (%v), and %v", err, licErr)
+		}
+
+		wildcard := false
+		for _, name := range r.DNSNames {
+			wildcard = wildcard || strings.HasPrefix(name, "*.")
+		}
+		if wildcard && !lic.Unlimited("max_wildcard_certs") {
+			return fmt.Errorf("could not reach the API, and wildcard certificates on the %s plan must be recorded there", lic.Plan)
+		}
+		return nil
+	}
+}
+
+// hostsNames returns the domains a hosts file can map: wildcards cannot be
+// expressed there and localhost already resolves
+func hostsNames(sans *cert.SANs) []string {
+	var names []string
+	for _, name := range sans.DNSNames {
+		if strings.HasPrefix(name, "*.") || name == "localhost" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// caddySiteAddresses lists the site addresses for a Caddy block, with
+// wildcards collapsed into their apex domain
+func caddySiteAddresses(sans *cert.SANs) string {
+	var sites []string
+	seen := make(map[string]bool)
+	for _, name := range sans.Names() {
+		name = strings.TrimPrefix(name, "*.")
+		if !seen[name] {
+			seen[name] = true
+			sites = append(sites, name)
+		}
+	}
+	return strings.Join(sites, ", ")
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/instanttls/cli/internal/dns"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Resolve local development domains",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var dnsServeCmd = &cobra.Command{
+	Use:   "serve [zone...]",
+	Short: "Run a DNS server that answers for local development zones",
+	Long: `Run a small UDP/TCP DNS server that answers A and AAAA queries for local
+development zones. Queries for other names are forwarded to --forward
+resolvers, or refused if none are given.
+
+A zone is domain=ip[,ip...]. A leading "*." also matches every name below
+the domain. Without any zones, *.local.test resolves to 127.0.0.1 and ::1.
+
+Point your system resolver at the server with 'instanttls dns config'.
+
+Examples:
+  instanttls dns serve
+  instanttls dns serve "*.local.test=127.0.0.1" "*.internal=10.0.0.5"
+  instanttls dns serve --forward 1.1.1.1:53 "*.local.test=127.0.0.1"`,
+	Run: runDNSServe,
+}
+
+var dnsConfigCmd = &cobra.Command{
+	Use:   "config [zone...]",
+	Short: "Print resolver config that routes local zones to 'instanttls dns serve'",
+	Long: `Print configuration snippets that make the system resolver send queries for
+the local zones, and only those, to 'instanttls dns serve'.
+
+Supported resolvers are systemd-resolved (default) and NetworkManager with
+its dnsmasq plugin. Use the same zones and --addr as for 'dns serve'.
+
+Examples:
+  instanttls dns config
+  instanttls dns config --resolver networkmanager "*.local.test=127.0.0.1"`,
+	Run: runDNSConfig,
+}
+
+var (
+	dnsAddr     string
+	dnsForward  []string
+	dnsTTL      uint32
+	dnsQuiet    bool
+	dnsResolver string
+	dnsWrite    bool
+)
+
+func init() {
+	dnsServeCmd.Flags().StringVar(&dnsAddr, "addr", dns.DefaultAddr, "Address to listen on (UDP and TCP)")
+	dnsServeCmd.Flags().StringSliceVar(&dnsForward, "forward", nil, "Upstream resolver for other names, host:port (repeatable)")
+	dnsServeCmd.Flags().Uint32Var(&dnsTTL, "ttl", dns.DefaultTTL, "TTL of answers in seconds")
+	dnsServeCmd.Flags().BoolVarP(&dnsQuiet, "quiet", "q", false, "Do not log queries")
+
+	dnsConfigCmd.Flags().StringVar(&dnsAddr, "addr", dns.DefaultAddr, "Address 'dns serve' listens on")
+	dnsConfigCmd.Flags().StringVar(&dnsResolver, "resolver", "resolved", "Resolver to configure (resolved, networkmanager)")
+	dnsConfigCmd.Flags().BoolVar(&dnsWrite, "write", false, "Write the files instead of printing them (needs root)")
+
+	dnsCmd.AddCommand(dnsServeCmd)
+	dnsCmd.AddCommand(dnsConfigCmd)
+	rootCmd.AddCommand(dnsCmd)
+}
+
+func parseZones(args []string) []dns.Zone {
+	if len(args) == 0 {
+		args = []string{dns.DefaultZone}
+	}
+
+	zones := make([]dns.Zone, 0, len(args))
+	for _, arg := range args {
+		zone, err := dns.ParseZone(arg)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
+func runDNSServe(cmd *cobra.Command, args []string) {
+	zones := parseZones(args)
+
+	opts := dns.Options{
+		Zones:   zones,
+		Forward: dnsForward,
+		TTL:     dnsTTL,
+	}
+	if !dnsQuiet {
+		opts.Logf = func(format string, args ...interface{}) {
+			pterm.Info.Printfln("%s  %s", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+		}
+	}
+	server := dns.NewServer(opts)
+
+	pterm.Println()
+	pterm.DefaultHeader.WithBackgroundStyle(pterm.NewStyle(pterm.BgBlue)).
+		WithTextStyle(pterm.NewStyle(pterm.FgWhite)).
+		Println("🌐 InstantTLS DNS")
+	pterm.Println()
+
+	for _, z := range zones {
+		pterm.Println("  " + z.String())
+	}
+	pterm.Println()
+	if len(dnsForward) > 0 {
+		pterm.Info.Printfln("Listening on %s (udp+tcp), forwarding other names to %v", dnsAddr, dnsForward)
+	} else {
+		pterm.Info.Printfln("Listening on %s (udp+tcp), refusing other names", dnsAddr)
+	}
+	pterm.Info.Println("Press Ctrl+C to stop")
+	pterm.Println()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		server.Shutdown()
+	}()
+
+	if err := server.ListenAndServe(dnsAddr); err != nil {
+		exitWithError(err.Error())
+	}
+
+	pterm.Println()
+	printInfo("DNS server stopped")
+}
+
+func runDNSConfig(cmd *cobra.Command, args []string) {
+	zones := parseZones(args)
+
+	var snippets []dns.ConfigSnippet
+	switch dnsResolver {
+	case "resolved", "systemd-resolved":
+		snippets = []dns.ConfigSnippet{dns.ResolvedConfig(zones, dnsAddr)}
+	case "networkmanager", "nm":
+		snippets = dns.NetworkManagerConfig(zones, dnsAddr)
+	default:
+		exitWithError(fmt.Sprintf("unsupported resolver %q (supported: resolved, networkmanager)", dnsResolver))
+	}
+
+	if dnsWrite {
+		for _, s := range snippets {
+			if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+				exitWithError(err.Error())
+			}
+			if err := os.WriteFile(s.Path, []byte(s.Contents), 0644); err != nil {
+				exitWithError(fmt.Sprintf("%v (try again with sudo)", err))
+			}
+			printSuccess("Wrote " + s.Path)
+		}
+		for _, s := range snippets {
+			if s.Apply != "" {
+				pterm.Info.Println("Apply the change with: " + s.Apply)
+			}
+		}
+		return
+	}
+
+	pterm.Println()
+	for _, s := range snippets {
+		pterm.FgCyan.Println(s.Path + ":")
+		pterm.DefaultBox.Println(strings.TrimSuffix(s.Contents, "\n"))
+		pterm.Println()
+	}
+
+	pterm.Info.Println("Install the files with 'sudo instanttls dns config --write' (same flags), then:")
+	for _, s := range snippets {
+		if s.Apply != "" {
+			pterm.Println("  " + s.Apply)
+		}
+	}
+	pterm.Println()
+}
@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/docker"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var dockerCmd = &cobra.Command{
+	Use:   "docker",
+	Short: "Trust the InstantTLS CA inside containers",
+	Long: `Get the InstantTLS CA trusted inside Docker images and containers, which
+have their own trust stores and ignore the host's.
+
+  bundle      write a directory holding ca.crt and ca-bundle.pem to mount
+  dockerfile  print Dockerfile lines that add the CA to an image
+  compose     write a compose override that mounts the CA and leaf certs
+  inject      add the CA to running containers with docker cp
+
+Mounted bundles live at /etc/instanttls in the container, with the leaf
+certificates under /etc/instanttls/certs. ca-bundle.pem holds the host's
+system roots plus the CA, for the SSL_CERT_FILE, REQUESTS_CA_BUNDLE and
+CURL_CA_BUNDLE variables.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var dockerBundleCmd = &cobra.Command{
+	Use:   "bundle [dir]",
+	Short: "Write a volume-mountable CA bundle directory",
+	Long: `Write ca.crt and ca-bundle.pem into a directory (default ./instanttls),
+ready to be mounted read-only at /etc/instanttls.
+
+Examples:
+  instanttls docker bundle
+  docker run -v "$PWD/instanttls:/etc/instanttls:ro" \
+    -e SSL_CERT_FILE=/etc/instanttls/ca-bundle.pem myimage`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runDockerBundle,
+}
+
+var dockerDockerfileCmd = &cobra.Command{
+	Use:   "dockerfile",
+	Short: "Print Dockerfile lines that add the CA to an image",
+	Long: `Copy the CA into the build context as instanttls-ca.crt and print the
+Dockerfile lines that install it in the image's system trust store. The
+lines work on Debian, Ubuntu, Alpine and Red Hat based images.
+
+Images without a shell, such as distroless or scratch, cannot run the
+install step. With --distroless a bundle directory is written to the
+context instead and the runtimes are pointed at it.
+
+Examples:
+  instanttls docker dockerfile >> Dockerfile
+  instanttls docker dockerfile --context ./api --distroless`,
+	Args: cobra.NoArgs,
+	Run:  runDockerDockerfile,
+}
+
+var dockerComposeCmd = &cobra.Command{
+	Use:   "compose [service...]",
+	Short: "Write a compose override that trusts the CA",
+	Long: `Write docker-compose.instanttls.yml next to the project's compose file.
+For each service (default: every service in the compose file) it mounts
+a CA bundle directory at /etc/instanttls and ~/.instanttls/certs at
+/etc/instanttls/certs, both read-only, and sets SSL_CERT_FILE,
+REQUESTS_CA_BUNDLE, CURL_CA_BUNDLE and NODE_EXTRA_CA_CERTS.
+
+Examples:
+  instanttls docker compose
+  instanttls docker compose web worker -f deploy/compose.yaml
+  docker compose -f compose.yaml -f docker-compose.instanttls.yml up`,
+	Run: runDockerCompose,
+}
+
+var dockerInjectCmd = &cobra.Command{
+	Use:   "inject [container...]",
+	Short: "Add the CA to running containers",
+	Long: `Copy the CA into running containers with docker cp and add it to their
+system trust stores, as root. Nothing is rebuilt, so the change is lost
+when a container is recreated; use 'instanttls docker dockerfile' or
+'instanttls docker compose' for that.
+
+Processes that load the trust store at startup need a restart. Node and
+Python need NODE_EXTRA_CA_CERTS or REQUESTS_CA_BUNDLE as well, which
+cannot be set in a running container.
+
+Examples:
+  instanttls docker inject web
+  instanttls docker inject --all`,
+	Run: runDockerInject,
+}
+
+var (
+	dockerContext    string
+	dockerDistroless bool
+	dockerFile       string
+	dockerOutput     string
+	dockerInjectAll  bool
+)
+
+func init() {
+	dockerDockerfileCmd.Flags().StringVar(&dockerContext, "context", ".", "Docker build context to copy the CA into")
+	dockerDockerfileCmd.Flags().BoolVar(&dockerDistroless, "distroless", false, "Use a bundle directory, for images without a shell")
+	dockerComposeCmd.Flags().StringVarP(&dockerFile, "file", "f", "", "Compose file (default: the one docker compose would use)")
+	dockerComposeCmd.Flags().StringVarP(&dockerOutput, "output", "o", "", "Override file to write (default: "+docker.DefaultOverrideFile+" next to the compose file)")
+	dockerInjectCmd.Flags().BoolVar(&dockerInjectAll, "all", false, "Inject into every running container")
+
+	dockerCmd.AddCommand(dockerBundleCmd)
+	dockerCmd.AddCommand(dockerDockerfileCmd)
+	dockerCmd.AddCommand(dockerComposeCmd)
+	dockerCmd.AddCommand(dockerInjectCmd)
+	rootCmd.AddCommand(dockerCmd)
+}
+
+func runDockerBundle(cmd *cobra.Command, args []string) {
+	dir := "instanttls"
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	if err := docker.WriteBundleDir(dir); err != nil {
+		exitWithError(err.Error())
+	}
+	printSuccess(fmt.Sprintf("Wrote %s and %s to %s", docker.CAFile, docker.BundleFile, dir))
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	lines := []string{fmt.Sprintf(`-v "%s:%s:ro"`, abs, docker.MountDir)}
+	for _, v := range docker.MountedEnv() {
+		lines = append(lines, fmt.Sprintf("-e %s=%s", v.Name, v.Value))
+	}
+
+	pterm.Println()
+	pterm.DefaultBox.WithTitle("docker run flags").Println(strings.Join(lines, "\n"))
+	pterm.Println()
+}
+
+func runDockerDockerfile(cmd *cobra.Command, args []string) {
+	// The snippet goes to stdout so it can be appended to a Dockerfile;
+	// progress goes to stderr
+	pterm.SetDefaultOutput(os.Stderr)
+	defer pterm.SetDefaultOutput(os.Stdout)
+
+	if dockerDistroless {
+		dir := filepath.Join(dockerContext, "instanttls")
+		if err := docker.WriteBundleDir(dir); err != nil {
+			exitWithError(err.Error())
+		}
+		printSuccess(fmt.Sprintf("Wrote %s", dir))
+		fmt.Print(docker.DistrolessDockerfile("instanttls"))
+		return
+	}
+
+	path, err := docker.WriteContextCA(dockerContext)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	printSuccess(fmt.Sprintf("Wrote %s", path))
+	fmt.Print(docker.Dockerfile())
+}
+
+func runDockerCompose(cmd *cobra.Command, args []string) {
+	composeFile := dockerFile
+	if composeFile == "" {
+		var err error
+		if composeFile, err = docker.FindComposeFile("."); err != nil {
+			exitWithError(err.Error())
+		}
+	}
+
+	services := args
+	if len(services) == 0 {
+		var err error
+		if services, err = docker.ComposeServices(composeFile); err != nil {
+			exitWithError(err.Error())
+		}
+	}
+
+	// Paths in a compose file are relative to the first file given, so
+	// the bundle sits next to it
+	projectDir := filepath.Dir(composeFile)
+	bundleDir := filepath.Join(projectDir, "instanttls")
+	if err := docker.WriteBundleDir(bundleDir); err != nil {
+		exitWithError(err.Error())
+	}
+	printSuccess(fmt.Sprintf("Wrote %s", bundleDir))
+
+	output := dockerOutput
+	if output == "" {
+		output = filepath.Join(projectDir, docker.DefaultOverrideFile)
+	}
+
+	override := docker.ComposeOverride(services, "./instanttls", config.GetCertsDir())
+	if err := os.WriteFile(output, []byte(override), 0644); err != nil {
+		exitWithError(fmt.Sprintf("Failed to write %s: %v", output, err))
+	}
+	printSuccess(fmt.Sprintf("Wrote %s for %s", output, strings.Join(services, ", ")))
+
+	pterm.Println()
+	printInfo("Start the project with both files:")
+	pterm.Println(fmt.Sprintf("  docker compose -f %s -f %s up", composeFile, output))
+	pterm.Println()
+}
+
+func runDockerInject(cmd *cobra.Command, args []string) {
+	containers := args
+	if dockerInjectAll {
+		var err error
+		if containers, err = docker.RunningContainers(); err != nil {
+			exitWithError(err.Error())
+		}
+		if len(containers) == 0 {
+			printInfo("No running containers")
+			return
+		}
+	}
+	if len(containers) == 0 {
+		exitWithError("Name the containers to inject into, or pass --all")
+	}
+
+	failed := 0
+	for _, c := range containers {
+		if err := docker.Inject(c); err != nil {
+			printWarning(fmt.Sprintf("%s: %v", c, err))
+			failed++
+			continue
+		}
+		printSuccess(fmt.Sprintf("%s: CA added to the trust store", c))
+	}
+
+	pterm.Println()
+	printInfo("Restart the processes in these containers to pick up the CA")
+	if failed > 0 {
+		exitWithError(fmt.Sprintf("%d of %d containers could not be updated", failed, len(containers)))
+	}
+}
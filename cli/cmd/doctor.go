@@ -2,7 +2,7 @@ package cmd
 
 import (
 	"fmt"
-	"runtime"
+	"strings"
 
 	"github.com/instanttls/cli/internal/api"
 	"github.com/instanttls/cli/internal/cert"
@@ -20,7 +20,9 @@ var doctorCmd = &cobra.Command{
 This checks:
   - Login status
   - CA certificate existence
-  - Trust store installation
+  - Trust stores, including Firefox/Chromium certificate databases,
+    by CA fingerprint
+  - That a freshly issued certificate verifies against the system roots
   - Generated certificates
 
 Example:
@@ -47,29 +49,73 @@ func runDoctor(cmd *cobra.Command, args []string) {
 		pterm.Error.Println("Logged in: ❌")
 		issues = append(issues, "Not logged in. Run 'instanttls login'")
 	} else {
-		pterm.Success.Println(fmt.Sprintf("Logged in: ✅ (%s - %s)", cfg.Email, cfg.Plan))
+		pterm.Success.Println(fmt.Sprintf("Logged in: ✅ (%s)", cfg.Email))
 
 		// Validate token with API
 		client := api.NewClient(cfg.APIBaseURL, cfg.Token)
 		if _, err := client.Me(); err != nil {
 			pterm.Warning.Println("Token validation: ⚠️ Could not validate token")
 		}
+
+		// The signed license is what plan checks rely on offline
+		if lic, _, err := verifiedLicense(cfg); err != nil {
+			pterm.Error.Println(fmt.Sprintf("License: ❌ (%v)", err))
+			issues = append(issues, "No valid license. Connect to the API and run 'instanttls login' again")
+		} else {
+			pterm.Success.Println(fmt.Sprintf("License: ✅ (%s - %s, %s)", lic.OrgName, lic.Plan, licenseStatus(lic)))
+		}
 	}
 
-	// Check 2: CA exists
-	if cert.CAExists() {
+	// Check 2: CA exists. After 'init --remote' only the server CA's
+	// certificate is kept locally.
+	remoteInfo, _ := cert.ReadRemoteInfo()
+	caExists := cert.CAExists() || remoteInfo != nil
+	if remoteInfo != nil {
+		pterm.Success.Println(fmt.Sprintf("CA certificate: ✅ (server CA of %s, signed by the API)", remoteInfo.OrgName))
+	} else if caExists {
 		pterm.Success.Println("CA certificate: ✅")
 	} else {
 		pterm.Error.Println("CA certificate: ❌")
 		issues = append(issues, "CA not found. Run 'instanttls init'")
 	}
 
-	// Check 3: Trust store
-	if trust.IsTrusted() {
-		pterm.Success.Println("Trust store: ✅")
-	} else {
-		pterm.Warning.Println("Trust store: ⚠️ (may not be installed)")
-		issues = append(issues, "Trust may not be installed. Run 'instanttls trust'")
+	// Check 3: Trust stores, matched by fingerprint
+	if caExists {
+		if fingerprint, err := cert.CAFingerprint(); err == nil {
+			pterm.Info.Println(fmt.Sprintf("CA fingerprint (SHA-256): %s", fingerprint))
+		}
+
+		statuses, err := trust.VerifyStores()
+		if err != nil {
+			pterm.Warning.Println(fmt.Sprintf("Trust store: ⚠️ (%v)", err))
+		} else {
+			untrusted := 0
+			for _, s := range statuses {
+				if s.Trusted {
+					pterm.Success.Println(fmt.Sprintf("Trust store: ✅ %s", s.Store))
+				} else {
+					pterm.Warning.Println(fmt.Sprintf("Trust store: ⚠️ %s (%v)", s.Store, s.Err))
+					untrusted++
+				}
+			}
+			if untrusted > 0 {
+				issues = append(issues, fmt.Sprintf("The current CA is not trusted by %d store(s). Run 'instanttls trust'", untrusted))
+			}
+		}
+
+		// A leaf that verifies against the system pool proves the whole
+		// chain, including the intermediate, is accepted. There is no local
+		// key to issue one with for a server CA.
+		if remoteInfo != nil {
+			pterm.Info.Println("Chain verification: skipped (certificates are signed by the API)")
+		} else if leaf, err := cert.IssueTestCertificate(); err != nil {
+			pterm.Warning.Println(fmt.Sprintf("Chain verification: ⚠️ could not issue a test certificate (%v)", err))
+		} else if err := trust.VerifyChain(leaf); err != nil {
+			pterm.Error.Println(fmt.Sprintf("Chain verification: ❌ (%v)", err))
+			issues = append(issues, "A freshly issued certificate does not verify against the system roots. Run 'instanttls trust'")
+		} else {
+			pterm.Success.Println("Chain verification: ✅ (test certificate verifies against the system roots)")
+		}
 	}
 
 	// Check 4: Certificates
@@ -84,40 +130,39 @@ func runDoctor(cmd *cobra.Command, args []string) {
 		pterm.Println()
 		pterm.DefaultSection.Println("Generated Certificates")
 
+		caFingerprint, _ := cert.CAFingerprint()
+		stale := 0
+
 		tableData := pterm.TableData{
-			{"Domain", "Valid Until", "Path"},
+			{"Domain", "Names", "Key", "Valid Until", "Issuer", "Path"},
 		}
 
 		for _, c := range certs {
+			issuer := "unknown (no meta.json)"
+			if c.Meta != nil {
+				if c.Meta.IssuerFingerprint == caFingerprint {
+					issuer = "current CA"
+				} else {
+					issuer = "previous CA"
+					stale++
+				}
+			}
+
 			tableData = append(tableData, []string{
 				c.Domain,
+				strings.Join(c.Names, ", "),
+				string(c.KeyType),
 				c.NotAfter.Format("2006-01-02"),
+				issuer,
 				c.Path,
 			})
 		}
 
 		pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
-	}
 
-	// Check 5: Firefox warning
-	pterm.Println()
-	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
-		pterm.DefaultBox.WithTitle("⚠️ Firefox Note").
-			WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).
-			Println(`
-Firefox uses its own certificate store and may not trust
-your system CA by default.
-
-To fix this in Firefox:
-1. Open about:config
-2. Set security.enterprise_roots.enabled to true
-3. Restart Firefox
-
-Or manually import the CA certificate:
-1. Open Firefox Settings → Privacy & Security
-2. Click "View Certificates" → "Authorities"
-3. Import: ` + config.GetCADir() + `/ca.crt
-`)
+		if stale > 0 {
+			issues = append(issues, fmt.Sprintf("%d certificate(s) were issued by a previous CA and will not be trusted. Re-issue them with 'instanttls cert'", stale))
+		}
 	}
 
 	// Summary
@@ -126,10 +171,11 @@ Or manually import the CA certificate:
 		pterm.DefaultBox.WithTitle("✅ All Good!").
 			WithTitleTopCenter().
 			WithBoxStyle(pterm.NewStyle(pterm.FgGreen)).
-			Println(`
+			Print(`
 Your InstantTLS setup is working correctly.
 Generate certificates with: instanttls cert "*.local.test"
 `)
+		pterm.Println()
 	} else {
 		pterm.DefaultBox.WithTitle("⚠️ Issues Found").
 			WithTitleTopCenter().
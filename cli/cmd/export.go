@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/k8s"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <domain>",
+	Short: "Export certificates and the CA for other tools",
+	Long: `Write an issued certificate in the formats other servers and runtimes
+expect. Files are saved next to cert.pem unless --output is given.
+
+  p12         PKCS#12 (.p12/.pfx) with key and chain, for .NET, Windows, Java
+  jks         Java keystore with key and chain, for Tomcat
+  der         the certificate alone, DER encoded
+  pem-bundle  chain and key in one PEM file, for HAProxy
+  pkcs8       the key as PKCS#8 PEM, encrypted if a password is given
+
+p12 and jks need a password: --password, the INSTANTTLS_EXPORT_PASSWORD
+environment variable, or a prompt. The key entry in a JKS keystore uses
+the same password as the keystore.
+
+Examples:
+  instanttls export app.local.test --format p12 -o app.pfx
+  instanttls export "*.local.test" --format jks,pem-bundle
+  instanttls export k8s "*.local.test"`,
+	Args: cobra.ExactArgs(1),
+	Run:  runExport,
+}
+
+var exportK8sCmd = &cobra.Command{
+	Use:   "k8s <domain>",
+	Short: "Print a certificate as a Kubernetes TLS Secret",
+	Long: `Print a kubernetes.io/tls Secret holding the certificate for a domain,
+as issued by 'instanttls cert'. tls.crt is the full chain, leaf plus
+intermediate; --with-ca adds the root as ca.crt.
+
+The domain can be the certificate's first name or any other name it
+covers. The Secret is named after the domain unless --name is given.
+
+Examples:
+  instanttls export k8s "*.local.test" | kubectl apply -f -
+  instanttls export k8s app.local.test -n web --name app-tls -o app-tls.yaml`,
+	Args: cobra.ExactArgs(1),
+	Run:  runExportK8s,
+}
+
+var exportK8sIssuerCmd = &cobra.Command{
+	Use:   "k8s-issuer",
+	Short: "Print the CA as a cert-manager CA issuer",
+	Long: `Print a Secret holding the CA keypair and a cert-manager ClusterIssuer
+(or Issuer, with --kind) that signs certificates with it, so workloads in
+a kind or minikube cluster get certificates your machine already trusts.
+
+The CA that signs for 'instanttls cert' is exported: the intermediate if
+there is one, with the root appended to tls.crt. The root key never
+leaves your machine in that case. A ClusterIssuer's Secret goes in the
+cert-manager namespace; an Issuer's in --namespace.
+
+The output contains an unencrypted CA private key. Apply it to local
+development clusters only, and do not commit it.
+
+Examples:
+  instanttls export k8s-issuer | kubectl apply -f -
+  instanttls export k8s-issuer --kind Issuer -n web`,
+	Args: cobra.NoArgs,
+	Run:  runExportK8sIssuer,
+}
+
+var (
+	exportNamespace string
+	exportName      string
+	exportOutput    string
+	exportWithCA    bool
+	exportKind      string
+	exportFormats   []string
+	exportPassword  string
+)
+
+// exportPasswordEnv supplies the password for p12 and jks exports
+const exportPasswordEnv = "INSTANTTLS_EXPORT_PASSWORD"
+
+func init() {
+	exportCmd.PersistentFlags().StringVarP(&exportOutput, "output", "o", "", "File to write (default: stdout for manifests, the certificate's directory for formats)")
+	for _, c := range []*cobra.Command{exportK8sCmd, exportK8sIssuerCmd} {
+		c.Flags().StringVarP(&exportNamespace, "namespace", "n", "", "Namespace (default: default, or cert-manager for a ClusterIssuer's Secret)")
+		c.Flags().StringVar(&exportName, "name", "", "Secret or issuer name")
+	}
+	exportCmd.Flags().StringSliceVar(&exportFormats, "format", nil, "Formats to write: p12, jks, der, pem-bundle, pkcs8")
+	exportCmd.Flags().StringVar(&exportPassword, "password", "", "Password for p12, jks and pkcs8 (default: $"+exportPasswordEnv+" or a prompt)")
+	exportCmd.MarkFlagRequired("format")
+	exportK8sCmd.Flags().BoolVar(&exportWithCA, "with-ca", false, "Add the root CA certificate as ca.crt")
+	exportK8sIssuerCmd.Flags().StringVar(&exportKind, "kind", k8s.KindClusterIssuer, "Issuer or ClusterIssuer")
+
+	exportCmd.AddCommand(exportK8sCmd)
+	exportCmd.AddCommand(exportK8sIssuerCmd)
+	rootCmd.AddCommand(exportCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) {
+	info, err := cert.FindCert(args[0])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if err := exportCert(info, exportFormats, exportOutput); err != nil {
+		exitWithError(err.Error())
+	}
+}
+
+// exportCert writes a certificate in each of formats, asking for a
+// password if one is needed. It is shared with 'cert --format'.
+func exportCert(info *cert.CertInfo, names []string, output string) error {
+	var formats []cert.ExportFormat
+	needsPassword := false
+	for _, name := range names {
+		format, err := cert.ParseExportFormat(name)
+		if err != nil {
+			return err
+		}
+		formats = append(formats, format)
+		needsPassword = needsPassword || format.NeedsPassword()
+	}
+	if output != "" && len(formats) > 1 {
+		return fmt.Errorf("--output can only be used with a single format")
+	}
+
+	password := []byte(exportPassword)
+	if len(password) == 0 {
+		password = []byte(os.Getenv(exportPasswordEnv))
+	}
+	if len(password) == 0 && needsPassword {
+		var err error
+		if password, err = newExportPassword(); err != nil {
+			return err
+		}
+	}
+
+	for _, format := range formats {
+		path, err := info.Export(format, output, password)
+		if err != nil {
+			return err
+		}
+		printSuccess(fmt.Sprintf("Wrote %s", path))
+	}
+	return nil
+}
+
+func runExportK8s(cmd *cobra.Command, args []string) {
+	// Manifests go to stdout for kubectl; messages go to stderr
+	pterm.SetDefaultOutput(os.Stderr)
+	defer pterm.SetDefaultOutput(os.Stdout)
+
+	info, err := cert.FindCert(args[0])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	chainPEM, keyPEM, err := info.ReadPEM()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	var caPEM []byte
+	if exportWithCA {
+		if caPEM, err = cert.RootPEM(); err != nil {
+			exitWithError(err.Error())
+		}
+	}
+
+	name := exportName
+	if name == "" {
+		name = k8s.SecretName(info.Domain)
+	}
+	namespace := exportNamespace
+	if namespace == "" {
+		namespace = k8s.DefaultNamespace
+	}
+
+	writeManifest(k8s.TLSSecret(name, namespace, chainPEM, keyPEM, caPEM))
+}
+
+func runExportK8sIssuer(cmd *cobra.Command, args []string) {
+	pterm.SetDefaultOutput(os.Stderr)
+	defer pterm.SetDefaultOutput(os.Stdout)
+
+	kind, err := k8s.ParseKind(exportKind)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if !cert.CAExists() {
+		exitWithError("CA not found. Run 'instanttls init' first.")
+	}
+
+	chainPEM, keyPEM, err := cert.ExportSigningCA()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	name := exportName
+	if name == "" {
+		name = k8s.DefaultIssuerName
+	}
+	namespace := exportNamespace
+	if namespace == "" {
+		namespace = k8s.DefaultNamespace
+		if kind == k8s.KindClusterIssuer {
+			namespace = k8s.ClusterResourceNamespace
+		}
+	}
+
+	printWarning("The manifest contains the CA private key; keep it out of version control")
+	writeManifest(k8s.CAIssuer(kind, name, namespace, chainPEM, keyPEM))
+}
+
+// writeManifest prints a manifest, or saves it to --output readable only
+// by the user since it holds a private key
+func writeManifest(manifest string) {
+	if exportOutput == "" {
+		fmt.Print(manifest)
+		return
+	}
+
+	if err := os.WriteFile(exportOutput, []byte(manifest), 0600); err != nil {
+		exitWithError(fmt.Sprintf("Failed to write %s: %v", exportOutput, err))
+	}
+	printSuccess(fmt.Sprintf("Wrote %s", exportOutput))
+}
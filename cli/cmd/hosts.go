@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/instanttls/cli/internal/hosts"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var hostsCmd = &cobra.Command{
+	Use:   "hosts",
+	Short: "Manage InstantTLS entries in the hosts file",
+	Long: `Manage a clearly delimited InstantTLS block in the hosts file, as an
+alternative to running 'instanttls dns serve'.
+
+Only lines inside the block are ever changed. Removing the last entry
+removes the block, leaving the rest of the file as it was. Writes are
+atomic; if the file needs root, sudo is used for the final step.
+
+The hosts file location can be overridden with --hosts-file or the
+INSTANTTLS_HOSTS_FILE environment variable.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var hostsAddCmd = &cobra.Command{
+	Use:   "add <hostname> [hostname...]",
+	Short: "Point hostnames at 127.0.0.1 (or --ip)",
+	Long: `Point hostnames at 127.0.0.1, or the address given with --ip.
+
+Examples:
+  instanttls hosts add app.local.test api.local.test
+  instanttls hosts add --ip 192.168.1.20 nas.local.test
+  instanttls hosts add --dry-run app.local.test`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runHostsAdd,
+}
+
+var hostsRemoveCmd = &cobra.Command{
+	Use:   "remove [hostname...]",
+	Short: "Remove hostnames from the InstantTLS block",
+	Run:   runHostsRemove,
+}
+
+var hostsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List hostnames in the InstantTLS block",
+	Args:  cobra.NoArgs,
+	Run:   runHostsList,
+}
+
+var (
+	hostsFile      string
+	hostsDryRun    bool
+	hostsIP        string
+	hostsRemoveAll bool
+)
+
+func init() {
+	hostsCmd.PersistentFlags().StringVar(&hostsFile, "hosts-file", "", "Hosts file to manage (default: the system hosts file)")
+	hostsCmd.PersistentFlags().BoolVar(&hostsDryRun, "dry-run", false, "Show the changes without writing them")
+	hostsAddCmd.Flags().StringVar(&hostsIP, "ip", hosts.DefaultIP, "Address the hostnames point at")
+	hostsRemoveCmd.Flags().BoolVar(&hostsRemoveAll, "all", false, "Remove every InstantTLS entry and the block itself")
+
+	hostsCmd.AddCommand(hostsAddCmd)
+	hostsCmd.AddCommand(hostsRemoveCmd)
+	hostsCmd.AddCommand(hostsListCmd)
+	rootCmd.AddCommand(hostsCmd)
+}
+
+func hostsPath() string {
+	if hostsFile != "" {
+		return hostsFile
+	}
+	return hosts.DefaultPath()
+}
+
+func runHostsAdd(cmd *cobra.Command, args []string) {
+	if err := addHostsEntries(hostsPath(), hostsIP, args, hostsDryRun); err != nil {
+		exitWithError(err.Error())
+	}
+}
+
+// addHostsEntries is shared with 'cert --hosts'
+func addHostsEntries(path, ip string, hostnames []string, dryRun bool) error {
+	f, err := hosts.Load(path)
+	if err != nil {
+		return err
+	}
+
+	before := f.String()
+	if _, err := f.Add(ip, hostnames...); err != nil {
+		return err
+	}
+
+	for _, hostname := range f.Unmanaged(hostnames...) {
+		printWarning(fmt.Sprintf("%s is also mapped outside the InstantTLS block in %s", hostname, path))
+	}
+
+	return saveHosts(f, before, dryRun)
+}
+
+func runHostsRemove(cmd *cobra.Command, args []string) {
+	if len(args) == 0 && !hostsRemoveAll {
+		exitWithError("Name the hostnames to remove, or pass --all")
+	}
+
+	f, err := hosts.Load(hostsPath())
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	before := f.String()
+	if hostsRemoveAll {
+		f.RemoveAll()
+	} else {
+		f.Remove(args...)
+	}
+
+	if err := saveHosts(f, before, hostsDryRun); err != nil {
+		exitWithError(err.Error())
+	}
+}
+
+func saveHosts(f *hosts.File, before string, dryRun bool) error {
+	after := f.String()
+	if after == before {
+		printInfo(fmt.Sprintf("%s is already up to date", f.Path))
+		return nil
+	}
+
+	if dryRun {
+		printInfo(fmt.Sprintf("Dry run: %s would change as follows", f.Path))
+		pterm.Println()
+		printLineDiff(before, after)
+		pterm.Println()
+		return nil
+	}
+
+	if err := f.Save(); err != nil {
+		return fmt.Errorf("failed to update %s: %w", f.Path, err)
+	}
+
+	printSuccess(fmt.Sprintf("Updated %s", f.Path))
+	return nil
+}
+
+// printLineDiff shows removed and added lines. The InstantTLS block is the
+// only part that changes, so a set difference is enough.
+func printLineDiff(before, after string) {
+	count := func(s string) map[string]int {
+		m := make(map[string]int)
+		for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+			m[line]++
+		}
+		return m
+	}
+	oldLines, newLines := count(before), count(after)
+
+	for _, line := range strings.Split(strings.ReplaceAll(before, "\r\n", "\n"), "\n") {
+		if newLines[line] > 0 {
+			newLines[line]--
+			continue
+		}
+		pterm.FgRed.Println("- " + line)
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(after, "\r\n", "\n"), "\n") {
+		if oldLines[line] > 0 {
+			oldLines[line]--
+			continue
+		}
+		pterm.FgGreen.Println("+ " + line)
+	}
+}
+
+func runHostsList(cmd *cobra.Command, args []string) {
+	f, err := hosts.Load(hostsPath())
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	entries := f.Entries()
+	if len(entries) == 0 {
+		printInfo(fmt.Sprintf("No InstantTLS entries in %s", f.Path))
+		return
+	}
+
+	tableData := pterm.TableData{{"Hostname", "Address"}}
+	for _, e := range entries {
+		tableData = append(tableData, []string{e.Hostname, e.IP})
+	}
+
+	pterm.Println()
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	pterm.Println()
+}
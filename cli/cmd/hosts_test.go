@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/instanttls/cli/internal/hosts"
+)
+
+func TestAddHostsEntriesDryRun(t *testing.T) {
+	original := "127.0.0.1\tlocalhost\n"
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addHostsEntries(path, hosts.DefaultIP, []string{"app.local.test"}, true); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != original {
+		t.Fatalf("dry run wrote the hosts file:\n%s", data)
+	}
+
+	if err := addHostsEntries(path, hosts.DefaultIP, []string{"app.local.test"}, false); err != nil {
+		t.Fatal(err)
+	}
+	f, err := hosts.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := f.Entries(); len(entries) != 1 || entries[0].Hostname != "app.local.test" {
+		t.Fatalf("entries after add = %+v", entries)
+	}
+}
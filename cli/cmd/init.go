@@ -2,11 +2,14 @@ package cmd
 
 import (
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/instanttls/cli/internal/api"
 	"github.com/instanttls/cli/internal/cert"
 	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/secret"
 	"github.com/instanttls/cli/internal/trust"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
@@ -18,22 +21,92 @@ var initCmd = &cobra.Command{
 	Long: `Generate a new local Certificate Authority and install it in your OS trust store.
 
 This command will:
-  1. Generate a new CA certificate and private key
+  1. Generate a new root CA and an intermediate CA that signs certificates
   2. Install the CA in your system's trust store
   3. Send a machine ping to the API
 
 After running this, browsers will trust certificates signed by your local CA.
 
-Example:
-  instanttls init`,
+Key types: rsa2048 (default), rsa4096, ecdsa-p256, ecdsa-p384, ed25519.
+Note that browsers do not accept Ed25519 certificates yet.
+
+With --name-constraints the CA can only sign for development domains
+(.test, .local, .localhost, .internal) and loopback/private IP ranges, so a
+leaked CA key cannot be used to impersonate real websites. Use --permit-domain
+and --permit-ip to choose your own lists.
+
+With --encrypt-key the CA private keys are encrypted with a passphrase.
+It is read from INSTANTTLS_CA_PASSPHRASE, INSTANTTLS_CA_PASSPHRASE_COMMAND,
+the OS keyring (--keyring), or asked for interactively.
+
+With --team, the shared CA of a Team plan organization is used instead, so
+certificates from any member's machine are trusted by all of them. It is
+downloaded from the API and unlocked with the team passphrase. If the
+organization has none yet, an owner or admin running this creates it: the
+root key stays on their machine and the intermediate is uploaded encrypted
+with the team passphrase. Your token must belong to the organization.
+
+With --remote, no CA key is kept on this machine at all: the API signs
+certificates with a CA it holds for your token's organization, enforcing
+the organization's domain policy and plan quotas. Only that CA's
+certificate is installed, and 'instanttls cert' then sends the API a CSR
+for a key generated locally.
+
+Examples:
+  instanttls init
+  instanttls init --key-type ecdsa-p256
+  instanttls init --encrypt-key --keyring
+  instanttls init --team acme
+  instanttls init --remote
+  instanttls init --name-constraints
+  instanttls init --permit-domain test --permit-domain corp.internal --permit-ip 10.0.0.0/8`,
 	Run: runInit,
 }
 
+var (
+	initKeyType         string
+	initNameConstraints bool
+	initPermitDomains   []string
+	initPermitIPs       []string
+	initEncryptKey      bool
+	initKeyring         bool
+	initTeam            string
+	initRemote          bool
+)
+
 func init() {
+	initCmd.Flags().StringVar(&initKeyType, "key-type", string(cert.DefaultKeyType), "CA key type (rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519)")
+	initCmd.Flags().BoolVar(&initNameConstraints, "name-constraints", false, "Restrict the CA to development domains and private IP ranges")
+	initCmd.Flags().StringSliceVar(&initPermitDomains, "permit-domain", nil, "Permitted DNS domain for the CA (implies --name-constraints, repeatable)")
+	initCmd.Flags().BoolVar(&initEncryptKey, "encrypt-key", false, "Encrypt the CA private keys with a passphrase")
+	initCmd.Flags().BoolVar(&initKeyring, "keyring", false, "Store the CA key passphrase in the OS keyring (implies --encrypt-key)")
+	initCmd.Flags().StringVar(&initTeam, "team", "", "Use the shared CA of an organization (name or ID)")
+	initCmd.Flags().BoolVar(&initRemote, "remote", false, "Have the API sign certificates with your organization's server CA")
+	initCmd.Flags().StringSliceVar(&initPermitIPs, "permit-ip", nil, "Permitted IP range in CIDR notation (implies --name-constraints, repeatable)")
 	rootCmd.AddCommand(initCmd)
 }
 
 func runInit(cmd *cobra.Command, args []string) {
+	keyType, err := cert.ParseKeyType(initKeyType)
+	if err != nil {
+		printError(err.Error())
+		return
+	}
+
+	if initTeam != "" && initRemote {
+		printError("--team and --remote cannot be used together")
+		return
+	}
+
+	var constraints *cert.NameConstraints
+	if initNameConstraints || len(initPermitDomains) > 0 || len(initPermitIPs) > 0 {
+		constraints, err = cert.NewNameConstraints(initPermitDomains, initPermitIPs)
+		if err != nil {
+			printError(err.Error())
+			return
+		}
+	}
+
 	cfg, err := config.Load()
 	if err != nil || cfg == nil || cfg.Token == "" {
 		printError("Not logged in. Run 'instanttls login' first.")
@@ -46,6 +119,21 @@ func runInit(cmd *cobra.Command, args []string) {
 		Println("🔧 InstantTLS Init")
 	pterm.Println()
 
+	if initTeam != "" {
+		opts := cert.CAOptions{KeyType: keyType, NameConstraints: constraints}
+		if setupTeamCA(cfg, initTeam, opts) {
+			installAndPing(cfg)
+		}
+		return
+	}
+
+	if initRemote {
+		if setupRemoteCA(cfg) {
+			installAndPing(cfg)
+		}
+		return
+	}
+
 	// Step 1: Check if CA already exists
 	if cert.CAExists() {
 		result, _ := pterm.DefaultInteractiveConfirm.
@@ -74,17 +162,49 @@ func runInit(cmd *cobra.Command, args []string) {
 	}
 
 	// Step 2: Generate CA
+	var passphrase []byte
+	if initEncryptKey || initKeyring {
+		passphrase, err = newPassphrase()
+		if err != nil {
+			printError(err.Error())
+			return
+		}
+	}
+
 	spinner, _ := pterm.DefaultSpinner.Start("Generating local CA...")
 
-	if err := cert.GenerateCA(); err != nil {
+	if err := cert.GenerateCA(cert.CAOptions{
+		KeyType:         keyType,
+		NameConstraints: constraints,
+		Passphrase:      passphrase,
+	}); err != nil {
 		spinner.Fail("Failed to generate CA")
 		printError(err.Error())
 		return
 	}
 
 	spinner.Success("CA generated successfully!")
+	if constraints != nil {
+		pterm.Info.Println("CA is name-constrained to: " + strings.Join(constraints.PermittedDNSDomains, ", "))
+	}
+	if passphrase != nil {
+		pterm.Info.Println("CA private keys are encrypted with your passphrase")
+		if initKeyring {
+			if err := (secret.KeyringProvider{}).Store(passphrase); err != nil {
+				printWarning(err.Error())
+			} else {
+				pterm.Info.Println("Passphrase stored in the OS keyring")
+			}
+		}
+	}
 	pterm.Println()
 
+	installAndPing(cfg)
+}
+
+// installAndPing installs the new CA in the trust store and registers the
+// machine
+func installAndPing(cfg *config.Config) {
 	// Step 3: Install in trust store
 	if err := trust.InstallCA(); err != nil {
 		printError(err.Error())
@@ -110,15 +230,29 @@ func printSuccessBox() {
 	pterm.DefaultBox.WithTitle("🎉 Success: Green Lock Enabled!").
 		WithTitleTopCenter().
 		WithBoxStyle(pterm.NewStyle(pterm.FgGreen)).
-		Println(`
+		Print(`
 Your local CA has been created and trusted.
 Browsers will now trust certificates signed by this CA.
 `)
 
+	pterm.Println()
 	pterm.Println()
 	pterm.Info.Println("Files created:")
-	pterm.Println("  CA Certificate: " + caDir + "/ca.crt")
-	pterm.Println("  CA Private Key: " + caDir + "/ca.key")
+	pterm.Println("  Root CA Certificate:         " + caDir + "/ca.crt")
+	_, rootKeyErr := os.Stat(filepath.Join(caDir, cert.RootKeyFile))
+	if rootKeyErr == nil {
+		pterm.Println("  Root CA Private Key:         " + caDir + "/ca.key")
+	}
+	if cert.HasIntermediate() {
+		pterm.Println("  Intermediate CA Certificate: " + caDir + "/intermediate.crt")
+		pterm.Println("  Intermediate CA Private Key: " + caDir + "/intermediate.key")
+		pterm.Println()
+		pterm.Info.Println("Leaf certificates are signed by the intermediate CA.")
+		if rootKeyErr == nil {
+			pterm.Println("  You can move ca.key offline and pass it to")
+			pterm.Println("  'instanttls ca rotate-intermediate --root-key <path>' when needed.")
+		}
+	}
 	pterm.Println()
 	pterm.Info.Println("Next steps:")
 	pterm.Println("  1. Generate a certificate: instanttls cert \"*.local.test\"")
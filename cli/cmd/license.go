@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/instanttls/cli/internal/api"
+	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/license"
+)
+
+// refreshLicense fetches the token organization's license from the API and
+// caches its signed form once verified. A license that does not verify
+// also drops the cached one, so it cannot outlive a change of account. A
+// build without a license public key never caches one, so it only has the
+// API to go by.
+func refreshLicense(client *api.Client) (*api.LicenseResponse, error) {
+	resp, err := client.License(license.MachineID())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := license.Save(resp.License); err != nil {
+		_ = license.Remove()
+		if !errors.Is(err, license.ErrNoPublicKey) {
+			printWarning(fmt.Sprintf("The license from the API could not be verified: %v", err))
+		}
+	}
+	return resp, nil
+}
+
+// verifiedLicense returns the license plan checks rely on: the one the API
+// answers with when it can be reached, otherwise the signed cached one for
+// as long as its grace period lasts. online reports whether the API
+// answered.
+func verifiedLicense(cfg *config.Config) (l *license.License, online bool, err error) {
+	client := api.NewClient(cfg.APIBaseURL, cfg.Token)
+	resp, err := refreshLicense(client)
+	if err != nil {
+		if !api.IsUnreachable(err) {
+			return nil, true, err
+		}
+		l, err = license.Load()
+		return l, false, err
+	}
+
+	// The response is trusted as it stands; the signed form only matters
+	// offline, and builds without a public key cannot verify it
+	if l, err := license.Load(); err == nil {
+		return l, true, nil
+	}
+	return apiLicense(resp), true, nil
+}
+
+// apiLicense describes the license the API answered with when its signed
+// form could not be verified and cached. It has no expiry.
+func apiLicense(resp *api.LicenseResponse) *license.License {
+	return &license.License{
+		OrgID:   resp.Org.ID,
+		OrgName: resp.Org.Name,
+		Email:   resp.User.Email,
+		Plan:    resp.Plan,
+		Limits:  resp.Limits,
+		Machine: license.MachineID(),
+	}
+}
+
+// licenseStatus describes how long a license is good for. Only a license
+// that could not be refreshed is ever past its expiry.
+func licenseStatus(l *license.License) string {
+	switch {
+	case l.ExpiresAt.IsZero():
+		return "checked with the API, not cached for offline use"
+	case !l.Expired():
+		return "valid until " + l.ExpiresAt.Local().Format("2006-01-02")
+	default:
+		return "offline, honored until " + l.GraceEnds().Local().Format("2006-01-02")
+	}
+}
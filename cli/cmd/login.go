@@ -84,6 +84,14 @@ func runLogin(cmd *cobra.Command, args []string) {
 
 	spinner.Success("Token validated!")
 
+	// The plan comes from the signed license, which is cached for offline use
+	plan := user.Plan
+	if lic, err := refreshLicense(client); err != nil {
+		printWarning(fmt.Sprintf("Could not fetch your license: %v", err))
+	} else {
+		plan = lic.Plan
+	}
+
 	// Save config
 	tokenPrefix := token
 	if len(tokenPrefix) > 12 {
@@ -95,7 +103,6 @@ func runLogin(cmd *cobra.Command, args []string) {
 		Token:       token,
 		TokenPrefix: tokenPrefix,
 		Email:       user.Email,
-		Plan:        user.Plan,
 	}
 
 	if err := config.Save(cfg); err != nil {
@@ -111,7 +118,7 @@ func runLogin(cmd *cobra.Command, args []string) {
   Email: %s
   Plan:  %s
   API:   %s
-`, user.Email, planBadge(user.Plan), apiBaseURL))
+`, user.Email, planBadge(plan), apiBaseURL))
 
 	pterm.Println()
 	pterm.Info.Println("Next steps:")
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/secret"
+	"github.com/pterm/pterm"
+	"golang.org/x/term"
+)
+
+func init() {
+	cert.SetPassphraseSource(unlockPassphrase)
+}
+
+// unlockPassphrase supplies the passphrase for an encrypted CA key from the
+// environment, a passphrase command or the OS keyring, and falls back to
+// prompting when running interactively
+func unlockPassphrase(keyPath string) ([]byte, error) {
+	passphrase, err := secret.Lookup(secret.Providers()...)
+	if err == nil {
+		return passphrase, nil
+	}
+	if !errors.Is(err, secret.ErrNotFound) {
+		return nil, err
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("%s is encrypted. Set %s or %s to unlock it",
+			filepath.Base(keyPath), secret.PassphraseEnv, secret.PassphraseCommandEnv)
+	}
+
+	return readPassword(fmt.Sprintf("Passphrase for %s: ", filepath.Base(keyPath)))
+}
+
+// newPassphrase asks for a passphrase to encrypt the CA keys with. The
+// environment variable wins so that init can run unattended.
+func newPassphrase() ([]byte, error) {
+	if passphrase, err := (secret.EnvProvider{}).Passphrase(); err == nil {
+		return passphrase, nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("set %s to choose a CA key passphrase non-interactively", secret.PassphraseEnv)
+	}
+
+	passphrase, err := readPassword("New CA key passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) < 8 {
+		return nil, fmt.Errorf("passphrase must be at least 8 characters")
+	}
+
+	confirm, err := readPassword("Confirm passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, confirm) {
+		return nil, fmt.Errorf("passphrases do not match")
+	}
+
+	return passphrase, nil
+}
+
+// newExportPassword asks for the password protecting an exported keystore
+func newExportPassword() ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("set --password or %s to export keystores non-interactively", exportPasswordEnv)
+	}
+
+	password, err := readPassword("Keystore password: ")
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := readPassword("Confirm password: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(password, confirm) {
+		return nil, fmt.Errorf("passwords do not match")
+	}
+
+	return password, nil
+}
+
+func readPassword(prompt string) ([]byte, error) {
+	pterm.FgGray.Print("  " + prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	pterm.Println()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+	return passphrase, nil
+}
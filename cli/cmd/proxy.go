@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/proxy"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy <host=upstream> [host=upstream...]",
+	Short: "Run an HTTPS reverse proxy in front of local dev servers",
+	Long: `Terminate HTTPS with certificates from your local CA and forward each host
+to a local upstream server.
+
+Certificates are picked by SNI from ~/.instanttls/certs. Hosts without a
+certificate get one issued on demand. WebSockets are forwarded, clients
+can use HTTP/2, and upstreams receive X-Forwarded-For, X-Forwarded-Host,
+X-Forwarded-Proto and X-Forwarded-Port.
+
+An upstream can be a port, host:port, or an http:// or https:// URL.
+
+Examples:
+  instanttls proxy app.local.test=localhost:3000 api.local.test=localhost:8081
+  instanttls proxy "*.local.test=3000"
+  instanttls proxy --addr :8443 app.local.test=https://localhost:5173`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runProxy,
+}
+
+var (
+	proxyAddr         string
+	proxyRedirectAddr string
+	proxyPreserveHost bool
+	proxyNoIssue      bool
+)
+
+func init() {
+	proxyCmd.Flags().StringVar(&proxyAddr, "addr", ":443", "HTTPS address to listen on")
+	proxyCmd.Flags().StringVar(&proxyRedirectAddr, "redirect-http", "", "Also listen on this address and redirect HTTP to HTTPS (e.g. :80)")
+	proxyCmd.Flags().BoolVar(&proxyPreserveHost, "preserve-host", false, "Forward the original Host header instead of the upstream's")
+	proxyCmd.Flags().BoolVar(&proxyNoIssue, "no-issue", false, "Only use existing certificates; never issue on demand")
+	rootCmd.AddCommand(proxyCmd)
+}
+
+func runProxy(cmd *cobra.Command, args []string) {
+	var routes []proxy.Route
+	for _, arg := range args {
+		route, err := proxy.ParseRoute(arg)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		routes = append(routes, route)
+	}
+
+	cfg, err := config.Load()
+	if err != nil || cfg == nil || cfg.Token == "" {
+		exitWithError("Not logged in. Run 'instanttls login' first.")
+	}
+
+	if !cert.CAExists() {
+		exitWithError("CA not found. Run 'instanttls init' first.")
+	}
+
+	// Unlock the CA now; certificates are issued during TLS handshakes,
+	// where there is no way to prompt for a passphrase
+	if !proxyNoIssue {
+		if _, _, err := cert.LoadCA(); err != nil {
+			exitWithError(err.Error())
+		}
+	}
+
+	_, httpsPort, err := net.SplitHostPort(proxyAddr)
+	if err != nil {
+		exitWithError(fmt.Sprintf("invalid --addr: %v", err))
+	}
+
+	logf := func(format string, args ...interface{}) {
+		pterm.Info.Printfln("%s  %s", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+	}
+
+	certs := proxy.NewCertSource(routes)
+	certs.Issue = !proxyNoIssue
+	certs.OnIssue = func(host, certDir string) {
+		logf("issued certificate for %s (%s)", host, certDir)
+	}
+
+	server := &http.Server{
+		Addr:              proxyAddr,
+		Handler:           proxy.NewHandler(routes, proxy.Options{PreserveHost: proxyPreserveHost, Logf: logf}),
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+			NextProtos:     []string{"h2", "http/1.1"},
+		},
+	}
+
+	listener, err := net.Listen("tcp", proxyAddr)
+	if err != nil {
+		printError(err.Error())
+		if httpsPort == "443" {
+			pterm.Info.Println("Ports below 1024 may need elevated privileges. Try --addr :8443, or on Linux:")
+			pterm.Println("  sudo setcap cap_net_bind_service=+ep $(which instanttls)")
+		}
+		os.Exit(1)
+	}
+
+	var redirectServer *http.Server
+	if proxyRedirectAddr != "" {
+		redirectServer = &http.Server{
+			Addr:              proxyRedirectAddr,
+			Handler:           proxy.RedirectHandler(httpsPort),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				printWarning(fmt.Sprintf("HTTP redirect disabled: %v", err))
+			}
+		}()
+	}
+
+	printProxyRoutes(routes, httpsPort)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		if redirectServer != nil {
+			redirectServer.Shutdown(shutdownCtx)
+		}
+	}()
+
+	if err := server.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		exitWithError(err.Error())
+	}
+
+	pterm.Println()
+	printInfo("Proxy stopped")
+}
+
+func printProxyRoutes(routes []proxy.Route, httpsPort string) {
+	pterm.Println()
+	pterm.DefaultHeader.WithBackgroundStyle(pterm.NewStyle(pterm.BgBlue)).
+		WithTextStyle(pterm.NewStyle(pterm.FgWhite)).
+		Println("🔀 InstantTLS Proxy")
+	pterm.Println()
+
+	port := ""
+	if httpsPort != "443" {
+		port = ":" + httpsPort
+	}
+
+	tableData := pterm.TableData{{"URL", "Upstream"}}
+	for _, route := range routes {
+		tableData = append(tableData, []string{"https://" + route.Host + port, route.Upstream.String()})
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+
+	pterm.Println()
+	pterm.Info.Println("Press Ctrl+C to stop")
+	pterm.Println()
+}
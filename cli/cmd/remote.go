@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/instanttls/cli/internal/api"
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/config"
+	"github.com/pterm/pterm"
+)
+
+// setupRemoteCA is 'init --remote': it installs the certificate of the CA
+// the API signs the organization's certificates with, in place of a local
+// CA. It reports whether the CA is ready to be trusted.
+func setupRemoteCA(cfg *config.Config) bool {
+	client := api.NewClient(cfg.APIBaseURL, cfg.Token)
+
+	license, err := refreshLicense(client)
+	if err != nil {
+		printError(fmt.Sprintf("Failed to look up your organization: %v", err))
+		return false
+	}
+	org := license.Org
+
+	serverCA, err := client.ServerCA()
+	if err != nil {
+		printError(err.Error())
+		return false
+	}
+
+	current, _ := cert.ReadRemoteInfo()
+	if cert.CAExists() && (current == nil || current.OrgID != org.ID) {
+		result, _ := pterm.DefaultInteractiveConfirm.
+			WithDefaultValue(false).
+			Show(fmt.Sprintf("Replace your local CA with the server CA of %s?", org.Name))
+		if !result {
+			return false
+		}
+	}
+
+	backup, err := cert.InstallRemoteCA([]byte(serverCA.Certificate), cert.RemoteInfo{
+		OrgID:       org.ID,
+		OrgName:     org.Name,
+		Fingerprint: serverCA.Fingerprint,
+	})
+	if err != nil {
+		printError(err.Error())
+		return false
+	}
+
+	printSuccess(fmt.Sprintf("Installed the server CA of %s", org.Name))
+	pterm.Info.Println("Certificates are now signed by the API; no CA key is kept on this machine.")
+	if backup != "" {
+		printInfo("Your previous CA was moved to " + backup)
+	}
+	pterm.Println()
+	return true
+}
+
+// remoteSigner signs CSRs with the server CA of the token's organization
+func remoteSigner(cfg *config.Config) cert.RemoteSigner {
+	client := api.NewClient(cfg.APIBaseURL, cfg.Token)
+	return func(csrPEM string, validityDays int, replaces string) (string, string, error) {
+		signed, err := client.SignCSR(api.CertificateRequest{CSR: csrPEM, ValidityDays: validityDays, Replaces: replaces})
+		if err != nil {
+			return "", "", err
+		}
+		return signed.Certificate, signed.CACertificate, nil
+	}
+}
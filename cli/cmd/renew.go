@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/config"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 )
@@ -13,6 +14,11 @@ var renewCmd = &cobra.Command{
 	Short: "Renew certificates expiring within 30 days",
 	Long: `Check all certificates and renew any that are expiring within 30 days.
 
+Renewals are reported to the API like new certificates, and those signed
+by the API (see 'instanttls cert --remote') are signed by it again, so you
+need to be logged in. A renewal replaces the old certificate and does not
+use up any more of your plan's quota.
+
 Example:
   instanttls renew`,
 	Run: runRenew,
@@ -29,14 +35,22 @@ func runRenew(cmd *cobra.Command, args []string) {
 		Println("🔄 Renew Certificates")
 	pterm.Println()
 
-	if !cert.CAExists() {
+	remoteInfo, _ := cert.ReadRemoteInfo()
+	if !cert.CAExists() && remoteInfo == nil {
 		printError("CA not found. Run 'instanttls init' first.")
 		return
 	}
 
+	var report cert.IssuanceReporter
+	var sign cert.RemoteSigner
+	if cfg, err := config.Load(); err == nil && cfg != nil && cfg.Token != "" {
+		report = issuanceReporter(cfg)
+		sign = remoteSigner(cfg)
+	}
+
 	spinner, _ := pterm.DefaultSpinner.Start("Checking certificates...")
 
-	renewed, err := cert.RenewExpiring(30)
+	renewed, err := cert.RenewExpiring(30, report, sign)
 	if err != nil {
 		spinner.Fail("Renewal failed")
 		printError(err.Error())
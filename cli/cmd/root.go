@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/instanttls/cli/internal/version"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 )
@@ -56,6 +57,7 @@ Get started:
 
 Learn more at https://instanttls.dev
 `,
+	Version: version.Version,
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Help()
 	},
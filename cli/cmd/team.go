@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/instanttls/cli/internal/api"
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/config"
+	"github.com/pterm/pterm"
+)
+
+// setupTeamCA is 'init --team': it pulls the organization's team CA, or
+// creates and uploads one if the organization has none and the user may
+// manage it. It reports whether a CA is ready to be trusted.
+func setupTeamCA(cfg *config.Config, team string, opts cert.CAOptions) bool {
+	client := api.NewClient(cfg.APIBaseURL, cfg.Token)
+
+	license, err := refreshLicense(client)
+	if err != nil {
+		printError(fmt.Sprintf("Failed to look up your organization: %v", err))
+		return false
+	}
+	org := license.Org
+	if !strings.EqualFold(team, org.Name) && team != org.ID {
+		printError(fmt.Sprintf("Your token belongs to %q, not %q. Create a token for %s and run 'instanttls login' with it.", org.Name, team, team))
+		return false
+	}
+
+	teamCA, err := client.TeamCA()
+	if err != nil {
+		printError(err.Error())
+		return false
+	}
+
+	if teamCA == nil {
+		if !org.CanManage() {
+			printError(fmt.Sprintf("%s has no team CA yet. Ask an owner or admin to run 'instanttls init --team %q'.", org.Name, org.Name))
+			return false
+		}
+		return createTeamCA(client, org, opts)
+	}
+	return pullTeamCA(org, teamCA)
+}
+
+// createTeamCA generates a CA with an encrypted key and uploads it as the
+// organization's team CA. The root key stays on this machine.
+func createTeamCA(client *api.Client, org api.OrgResponse, opts cert.CAOptions) bool {
+	if cert.CAExists() {
+		result, _ := pterm.DefaultInteractiveConfirm.
+			WithDefaultValue(false).
+			Show(fmt.Sprintf("%s has no team CA yet. Replace your local CA with a new team CA?", org.Name))
+		if !result {
+			return false
+		}
+	}
+
+	pterm.Info.Println("Choose the team passphrase. Members need it to use the team CA; share it privately.")
+	passphrase, err := newPassphrase()
+	if err != nil {
+		printError(err.Error())
+		return false
+	}
+	opts.Passphrase = passphrase
+
+	backup, err := cert.MoveCAAside()
+	if err != nil {
+		printError(err.Error())
+		return false
+	}
+
+	spinner, _ := pterm.DefaultSpinner.Start("Generating team CA...")
+
+	if err := cert.GenerateCA(opts); err != nil {
+		spinner.Fail("Failed to generate CA")
+		printError(err.Error())
+		if backup != "" {
+			printInfo("Your previous CA was moved to " + backup)
+		}
+		return false
+	}
+
+	info := cert.TeamInfo{OrgID: org.ID, OrgName: org.Name}
+	if err := uploadTeamCA(client, &info, false); err != nil {
+		spinner.Fail("Failed to upload team CA")
+		printError(err.Error())
+		printWarning("The new CA was not shared and is now your local CA")
+		if backup != "" {
+			printInfo("Your previous CA was moved to " + backup)
+		}
+		return false
+	}
+
+	spinner.Success(fmt.Sprintf("Team CA created for %s!", org.Name))
+	pterm.Info.Println("The root CA key stays on this machine; members only get the intermediate.")
+	if backup != "" {
+		printInfo("Your previous CA was moved to " + backup)
+	}
+	pterm.Println()
+	return true
+}
+
+// pullTeamCA installs a downloaded team CA, moving any other local CA aside
+func pullTeamCA(org api.OrgResponse, teamCA *api.TeamCA) bool {
+	current, _ := cert.ReadTeamInfo()
+	if cert.CAExists() && (current == nil || current.OrgID != org.ID) {
+		result, _ := pterm.DefaultInteractiveConfirm.
+			WithDefaultValue(false).
+			Show(fmt.Sprintf("Replace your local CA with the team CA of %s?", org.Name))
+		if !result {
+			return false
+		}
+	}
+
+	pterm.Info.Println("Enter the team passphrase shared by your organization's admins.")
+	backup, err := installTeamCA(org, teamCA)
+	if err != nil {
+		printError(err.Error())
+		return false
+	}
+
+	printSuccess(fmt.Sprintf("Installed the team CA of %s (version %d)", org.Name, teamCA.Version))
+	if backup != "" {
+		printInfo("Your previous CA was moved to " + backup)
+	}
+	pterm.Println()
+	return true
+}
+
+// syncTeamCA updates a local team CA that was rotated on the API. It is run
+// by 'trust' and only warns on failure, so trust still works offline.
+func syncTeamCA() {
+	info, err := cert.ReadTeamInfo()
+	if err != nil || info == nil {
+		return
+	}
+
+	client, org, err := teamClient(info)
+	if err != nil {
+		printWarning(fmt.Sprintf("Could not check for a newer team CA: %v", err))
+		return
+	}
+
+	teamCA, err := client.TeamCA()
+	if err != nil {
+		printWarning(fmt.Sprintf("Could not check for a newer team CA: %v", err))
+		return
+	}
+	if teamCA == nil {
+		printWarning(fmt.Sprintf("%s no longer has a team CA", org.Name))
+		return
+	}
+	if teamCA.Version == info.Version {
+		return
+	}
+
+	pterm.Info.Println(fmt.Sprintf("The team CA of %s was rotated; enter the team passphrase to update it.", org.Name))
+	if _, err := installTeamCA(*org, teamCA); err != nil {
+		printWarning(fmt.Sprintf("Failed to update the team CA: %v", err))
+		return
+	}
+	printSuccess(fmt.Sprintf("Updated the team CA of %s to version %d", org.Name, teamCA.Version))
+}
+
+// pushTeamCA uploads a team CA changed locally, by rotating its
+// intermediate or passphrase, as a new version for the organization
+func pushTeamCA() {
+	info, err := cert.ReadTeamInfo()
+	if err != nil || info == nil {
+		return
+	}
+
+	client, org, err := teamClient(info)
+	if err == nil {
+		err = uploadTeamCA(client, info, true)
+	}
+	if err != nil {
+		printWarning(fmt.Sprintf("The team CA was changed locally but not uploaded: %v", err))
+		return
+	}
+
+	printSuccess(fmt.Sprintf("Uploaded team CA version %d for %s. Members get it on their next 'instanttls trust'.", info.Version, org.Name))
+}
+
+func installTeamCA(org api.OrgResponse, teamCA *api.TeamCA) (string, error) {
+	return cert.InstallTeamCA(cert.TeamBundle{
+		RootPEM:         []byte(teamCA.RootCert),
+		IntermediatePEM: []byte(teamCA.IntermediateCert),
+		KeyPEM:          []byte(teamCA.IntermediateKey),
+	}, cert.TeamInfo{
+		OrgID:       org.ID,
+		OrgName:     org.Name,
+		Version:     teamCA.Version,
+		Fingerprint: teamCA.Fingerprint,
+	})
+}
+
+// uploadTeamCA sends the local CA to the API and records the new version
+func uploadTeamCA(client *api.Client, info *cert.TeamInfo, rotate bool) error {
+	bundle, err := cert.ReadTeamBundle()
+	if err != nil {
+		return err
+	}
+
+	version, err := client.UploadTeamCA(api.TeamCARequest{
+		RootCert:         string(bundle.RootPEM),
+		IntermediateCert: string(bundle.IntermediatePEM),
+		IntermediateKey:  string(bundle.KeyPEM),
+	}, rotate)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := cert.CAFingerprint()
+	if err != nil {
+		return err
+	}
+	info.Version = version
+	info.Fingerprint = fingerprint
+	return cert.WriteTeamInfo(*info)
+}
+
+// teamClient returns an API client for the organization of a team CA.
+// Team CA endpoints act on the token's organization, so the token must
+// belong to it.
+func teamClient(info *cert.TeamInfo) (*api.Client, *api.OrgResponse, error) {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil || cfg.Token == "" {
+		return nil, nil, fmt.Errorf("not logged in")
+	}
+
+	client := api.NewClient(cfg.APIBaseURL, cfg.Token)
+	license, err := refreshLicense(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if license.Org.ID != info.OrgID {
+		return nil, nil, fmt.Errorf("your token belongs to %q, not the team CA's organization %q", license.Org.Name, info.OrgName)
+	}
+
+	return client, &license.Org, nil
+}
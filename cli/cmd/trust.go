@@ -1,6 +1,11 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
 	"github.com/instanttls/cli/internal/trust"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
@@ -11,27 +16,66 @@ var trustCmd = &cobra.Command{
 	Short: "Reinstall CA certificate in OS trust store",
 	Long: `Reinstall the CA certificate in your operating system's trust store.
 
+On Linux and macOS the CA is also added to the NSS certificate databases
+of Firefox profiles and, on Linux, Chromium (~/.pki/nssdb). certutil is
+used when installed; otherwise the databases are written directly.
+
 Use this if:
   - Trust store was reset
   - You reinstalled your OS
   - Browsers don't trust your local certificates
 
-Example:
-  instanttls trust`,
+With a team CA (see 'instanttls init --team'), a newer version rotated by
+your organization's admins is downloaded first.
+
+With --runtime, language runtimes that ignore the OS trust store are set
+up instead, and the OS trust store is left alone:
+  java    imports the CA into the cacerts keystore of every JDK found
+  node    sets NODE_EXTRA_CA_CERTS
+  python  sets REQUESTS_CA_BUNDLE and SSL_CERT_FILE
+  go      sets SSL_CERT_FILE
+  curl    sets CURL_CA_BUNDLE and SSL_CERT_FILE
+  all     all of the above
+
+The variables point at ~/.instanttls/ca/ca-bundle.pem, the system roots
+plus the InstantTLS CA. They are printed for your shell to evaluate, or
+made permanent with --persist.
+
+Examples:
+  instanttls trust
+  instanttls trust --runtime java
+  instanttls trust --runtime node,python --persist
+  eval "$(instanttls trust --runtime all --quiet)"`,
 	Run: runTrust,
 }
 
+var (
+	trustRuntimes []string
+	trustPersist  bool
+	trustQuiet    bool
+)
+
 func init() {
+	trustCmd.Flags().StringSliceVar(&trustRuntimes, "runtime", nil, "Set up runtimes instead of the OS store: java, node, python, go, curl or all")
+	trustCmd.Flags().BoolVar(&trustPersist, "persist", false, "With --runtime, add the variables to your shell startup files")
+	trustCmd.Flags().BoolVarP(&trustQuiet, "quiet", "q", false, "With --runtime, print only the variable assignments")
 	rootCmd.AddCommand(trustCmd)
 }
 
 func runTrust(cmd *cobra.Command, args []string) {
+	if len(trustRuntimes) > 0 {
+		runTrustRuntimes()
+		return
+	}
+
 	pterm.Println()
 	pterm.DefaultHeader.WithBackgroundStyle(pterm.NewStyle(pterm.BgYellow)).
 		WithTextStyle(pterm.NewStyle(pterm.FgBlack)).
 		Println("🔒 Install CA Trust")
 	pterm.Println()
 
+	syncTeamCA()
+
 	if err := trust.InstallCA(); err != nil {
 		printError(err.Error())
 		return
@@ -41,3 +85,105 @@ func runTrust(cmd *cobra.Command, args []string) {
 	pterm.Success.Println("CA certificate installed in trust store!")
 	pterm.Println()
 }
+
+func runTrustRuntimes() {
+	runtimes, err := trust.ParseRuntimes(trustRuntimes)
+	if err != nil {
+		printError(err.Error())
+		return
+	}
+
+	// Quiet output is meant for eval, so everything else is suppressed
+	if trustQuiet {
+		pterm.DisableOutput()
+		defer pterm.EnableOutput()
+	}
+
+	pterm.Println()
+	pterm.DefaultHeader.WithBackgroundStyle(pterm.NewStyle(pterm.BgYellow)).
+		WithTextStyle(pterm.NewStyle(pterm.FgBlack)).
+		Println("🔒 Runtime CA Trust")
+	pterm.Println()
+
+	for _, rt := range runtimes {
+		if rt == trust.RuntimeJava {
+			trustJava()
+		}
+	}
+
+	// Java is the only runtime without variables
+	if len(trust.RuntimeEnv(runtimes, "")) == 0 {
+		return
+	}
+
+	bundle, err := trust.WriteBundle()
+	if err != nil {
+		runtimeError(err)
+		return
+	}
+	vars := trust.RuntimeEnv(runtimes, bundle)
+	printSuccess(fmt.Sprintf("Wrote %s (system roots plus the InstantTLS CA)", bundle))
+
+	if trustPersist {
+		written, err := trust.PersistEnv(vars)
+		if err != nil {
+			runtimeError(err)
+			return
+		}
+		for _, path := range written {
+			printSuccess(fmt.Sprintf("Updated %s", path))
+		}
+		printInfo("Open a new shell, or set the variables below in this one")
+	}
+
+	pterm.Println()
+	var lines []string
+	for _, v := range vars {
+		lines = append(lines, envAssignment(v))
+	}
+	if trustQuiet {
+		fmt.Println(strings.Join(lines, "\n"))
+		return
+	}
+	pterm.DefaultBox.WithTitle("Environment").Println(strings.Join(lines, "\n"))
+	pterm.Println()
+}
+
+func trustJava() {
+	statuses, err := trust.InstallJava()
+	if err != nil {
+		printError(err.Error())
+		return
+	}
+	if len(statuses) == 0 {
+		printInfo("Java: no JDK cacerts keystores found (set JAVA_HOME or put java on PATH)")
+		return
+	}
+
+	for _, s := range statuses {
+		if s.Err != nil {
+			printWarning(fmt.Sprintf("%s: %v", s.Store, s.Err))
+			continue
+		}
+		printSuccess(s.Store)
+	}
+	printInfo("Restart running JVMs for the change to take effect")
+	pterm.Println()
+}
+
+// runtimeError reports a failure even when --quiet silences other output
+func runtimeError(err error) {
+	if trustQuiet {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	printError(err.Error())
+}
+
+// envAssignment formats a variable for the user's shell
+func envAssignment(v trust.EnvVar) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf(`$env:%s = "%s"`, v.Name, v.Value)
+	}
+	return fmt.Sprintf(`export %s="%s"`, v.Name, v.Value)
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/instanttls/cli/internal/api"
+	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/trust"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var untrustCmd = &cobra.Command{
+	Use:     "untrust",
+	Aliases: []string{"uninstall"},
+	Short:   "Remove the CA from every trust store",
+	Long: `Remove the CA certificate from every trust store it may have been
+installed into: the OS trust store, Firefox and Chromium certificate
+databases, and Java keystores.
+
+Stores that do not hold the CA are left alone, so it is safe to run
+this more than once.
+
+With --purge, ~/.instanttls (the CA, its keys and all certificates) is
+deleted afterwards, unless the CA could not be removed from a store. With --deregister, this machine is removed from your
+InstantTLS account.
+
+Examples:
+  instanttls untrust
+  instanttls uninstall --purge --deregister`,
+	Args: cobra.NoArgs,
+	Run:  runUntrust,
+}
+
+var (
+	untrustPurge      bool
+	untrustDeregister bool
+	untrustYes        bool
+)
+
+func init() {
+	untrustCmd.Flags().BoolVar(&untrustPurge, "purge", false, "Delete ~/.instanttls, including the CA and all certificates")
+	untrustCmd.Flags().BoolVar(&untrustDeregister, "deregister", false, "Remove this machine from your InstantTLS account")
+	untrustCmd.Flags().BoolVarP(&untrustYes, "yes", "y", false, "Do not ask before deleting ~/.instanttls")
+	rootCmd.AddCommand(untrustCmd)
+}
+
+func runUntrust(cmd *cobra.Command, args []string) {
+	pterm.Println()
+	pterm.DefaultHeader.WithBackgroundStyle(pterm.NewStyle(pterm.BgYellow)).
+		WithTextStyle(pterm.NewStyle(pterm.FgBlack)).
+		Println("🔓 Remove CA Trust")
+	pterm.Println()
+
+	failed, storeFailed := false, false
+
+	results, err := trust.UninstallCA()
+	if err != nil {
+		// Without ca.crt there is nothing to identify the CA by
+		printInfo(fmt.Sprintf("No CA to remove (%v)", err))
+	} else {
+		pterm.Println()
+		tableData := pterm.TableData{{"Store", "Result"}}
+		for _, r := range results {
+			status := "not installed"
+			switch {
+			case r.Err != nil:
+				status = "failed: " + r.Err.Error()
+				failed, storeFailed = true, true
+			case r.Removed:
+				status = "removed"
+			}
+			tableData = append(tableData, []string{r.Store, status})
+		}
+		pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		pterm.Println()
+	}
+
+	if untrustDeregister {
+		if err := deregisterMachine(); err != nil {
+			printWarning(fmt.Sprintf("Could not deregister this machine: %v", err))
+			failed = true
+		}
+	}
+
+	// ca.crt is all a later run can identify the CA by, so it stays until
+	// every store is clean
+	if untrustPurge && storeFailed {
+		printWarning(fmt.Sprintf("Kept %s because the CA could not be removed from every store", config.GetCertDir()))
+	} else if untrustPurge {
+		if err := purgeCertDir(); err != nil {
+			printWarning(err.Error())
+			failed = true
+		}
+	}
+
+	pterm.Println()
+	if failed {
+		printWarning("Some steps failed; fix the problems above and run this command again")
+		os.Exit(1)
+	}
+	printSuccess("InstantTLS CA removed")
+	pterm.Println()
+}
+
+func deregisterMachine() error {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil || cfg.Token == "" {
+		return fmt.Errorf("not logged in")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	client := api.NewClient(cfg.APIBaseURL, cfg.Token)
+	if err := client.MachineDeregister(hostname); err != nil {
+		return err
+	}
+
+	printSuccess(fmt.Sprintf("Deregistered %s from your account", hostname))
+	return nil
+}
+
+func purgeCertDir() error {
+	dir := config.GetCertDir()
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		printInfo(fmt.Sprintf("%s does not exist", dir))
+		return nil
+	}
+
+	if !untrustYes {
+		result, _ := pterm.DefaultInteractiveConfirm.
+			WithDefaultValue(false).
+			Show(fmt.Sprintf("Delete %s, including the CA and all certificates?", dir))
+		if !result {
+			printInfo(fmt.Sprintf("Kept %s", dir))
+			return nil
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", dir, err)
+	}
+	printSuccess(fmt.Sprintf("Deleted %s", dir))
+	return nil
+}
@@ -13,6 +13,10 @@ var whoamiCmd = &cobra.Command{
 	Short: "Display current user and plan information",
 	Long: `Show information about the currently logged-in user.
 
+The plan shown comes from the API, not from the config file. When the API
+cannot be reached the signed license cached for this machine is shown
+instead, for up to 7 days after it expires.
+
 Example:
   instanttls whoami`,
 	Run: runWhoami,
@@ -29,14 +33,25 @@ func runWhoami(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	lic, online, err := verifiedLicense(cfg)
+	if err != nil {
+		printError(fmt.Sprintf("No valid license: %v", err))
+		return
+	}
+	if !online {
+		printWarning("Could not reach the API; showing your cached license")
+	}
+
 	pterm.Println()
 	pterm.DefaultBox.WithTitle("👤 Current User").
 		WithTitleTopCenter().
 		Println(fmt.Sprintf(`
   Email:        %s
+  Organization: %s
   Plan:         %s
+  License:      %s
   API URL:      %s
   Token Prefix: %s...
-`, cfg.Email, planBadge(cfg.Plan), cfg.APIBaseURL, cfg.TokenPrefix))
+`, lic.Email, lic.OrgName, planBadge(lic.Plan), licenseStatus(lic), cfg.APIBaseURL, cfg.TokenPrefix))
 	pterm.Println()
 }
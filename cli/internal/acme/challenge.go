@@ -0,0 +1,194 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Challenge types
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeDNS01     = "dns-01"
+)
+
+const (
+	validationTimeout = 10 * time.Second
+	acmeTLSProtocol   = "acme-tls/1"
+)
+
+// idPeACMEIdentifier is the tls-alpn-01 certificate extension (RFC 8737)
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// challengeTypes returns the challenges offered for an identifier. Wildcards
+// can only be proven with dns-01, which this server does not check, so they
+// are only offered when every challenge is accepted anyway.
+func (s *Server) challengeTypes(id identifier, wildcard bool) []string {
+	if wildcard {
+		if s.opts.AlwaysValid {
+			return []string{ChallengeDNS01}
+		}
+		return nil
+	}
+
+	if id.Type == identifierIP {
+		return []string{ChallengeHTTP01}
+	}
+
+	types := []string{ChallengeHTTP01, ChallengeTLSALPN01}
+	if s.opts.AlwaysValid {
+		types = append(types, ChallengeDNS01)
+	}
+	return types
+}
+
+// validate checks a challenge response. It returns nil once the client has
+// proven control of the identifier.
+func (s *Server) validate(ctx context.Context, chalType string, id identifier, token, keyAuthorization string) *problem {
+	if s.opts.AlwaysValid {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, validationTimeout)
+	defer cancel()
+
+	switch chalType {
+	case ChallengeHTTP01:
+		return s.validateHTTP01(ctx, id, token, keyAuthorization)
+	case ChallengeTLSALPN01:
+		return s.validateTLSALPN01(ctx, id, keyAuthorization)
+	default:
+		return newProblem(errMalformed, http.StatusBadRequest, "%s challenges are only supported with --always-valid", chalType)
+	}
+}
+
+// validationAddr returns where to connect when validating an identifier:
+// the configured validation host, or the identifier itself
+func (s *Server) validationAddr(id identifier, port int) string {
+	host := id.Value
+	if s.opts.ValidationHost != "" {
+		host = s.opts.ValidationHost
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func (s *Server) validateHTTP01(ctx context.Context, id identifier, token, keyAuthorization string) *problem {
+	addr := s.validationAddr(id, s.opts.HTTPPort)
+	dialer := &net.Dialer{}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			// Connect to the validation address but present the identifier
+			// as the Host, like a real CA resolving the name would
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	host := id.Value
+	if s.opts.HTTPPort != 80 {
+		host = net.JoinHostPort(id.Value, strconv.Itoa(s.opts.HTTPPort))
+	} else if strings.Contains(id.Value, ":") {
+		host = "[" + id.Value + "]"
+	}
+	url := "http://" + host + "/.well-known/acme-challenge/" + token
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return newProblem(errMalformed, http.StatusBadRequest, "invalid challenge URL: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return newProblem(errConnection, http.StatusBadRequest, "fetching %s via %s: %v", url, addr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newProblem(errIncorrectResponse, http.StatusForbidden, "%s returned HTTP %d", url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	if err != nil {
+		return newProblem(errConnection, http.StatusBadRequest, "reading %s: %v", url, err)
+	}
+
+	if subtle.ConstantTimeCompare(bytes.TrimSpace(body), []byte(keyAuthorization)) != 1 {
+		return newProblem(errIncorrectResponse, http.StatusForbidden, "%s returned the wrong key authorization", url)
+	}
+
+	return nil
+}
+
+func (s *Server) validateTLSALPN01(ctx context.Context, id identifier, keyAuthorization string) *problem {
+	addr := s.validationAddr(id, s.opts.TLSPort)
+
+	dialer := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName: id.Value,
+			NextProtos: []string{acmeTLSProtocol},
+			// The challenge certificate is self-signed by design
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return newProblem(errConnection, http.StatusBadRequest, "connecting to %s: %v", addr, err)
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != acmeTLSProtocol {
+		return newProblem(errTLS, http.StatusBadRequest, "%s did not negotiate %s", addr, acmeTLSProtocol)
+	}
+	if len(state.PeerCertificates) == 0 {
+		return newProblem(errTLS, http.StatusBadRequest, "%s presented no certificate", addr)
+	}
+
+	leaf := state.PeerCertificates[0]
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != id.Value || len(leaf.IPAddresses) != 0 {
+		return newProblem(errIncorrectResponse, http.StatusForbidden, "challenge certificate must contain exactly the SAN %s", id.Value)
+	}
+
+	want := sha256.Sum256([]byte(keyAuthorization))
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifier) {
+			continue
+		}
+		if !ext.Critical {
+			return newProblem(errIncorrectResponse, http.StatusForbidden, "acmeIdentifier extension must be critical")
+		}
+
+		var got []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &got); err != nil || len(rest) != 0 {
+			return newProblem(errIncorrectResponse, http.StatusForbidden, "malformed acmeIdentifier extension")
+		}
+		if subtle.ConstantTimeCompare(got, want[:]) != 1 {
+			return newProblem(errIncorrectResponse, http.StatusForbidden, "acmeIdentifier does not match the key authorization")
+		}
+		return nil
+	}
+
+	return newProblem(errIncorrectResponse, http.StatusForbidden, "challenge certificate has no acmeIdentifier extension")
+}
+
+// keyAuthorization joins a challenge token with the account key thumbprint
+func keyAuthorization(token, thumbprint string) string {
+	return fmt.Sprintf("%s.%s", token, thumbprint)
+}
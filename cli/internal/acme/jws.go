@@ -0,0 +1,129 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net/http"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+const maxRequestBody = 1 << 20
+
+// supportedAlgorithms are the JWS algorithms accepted for ACME requests
+var supportedAlgorithms = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+// signedRequest is a verified JWS request body
+type signedRequest struct {
+	payload []byte
+	// key is the key the request was signed with
+	key *jose.JSONWebKey
+	// account is set when the request identified itself with "kid"
+	account *account
+}
+
+// postAsGet reports whether the request is a POST-as-GET (empty payload)
+func (r *signedRequest) postAsGet() bool {
+	return len(r.payload) == 0
+}
+
+// keyMode says how a request may identify its signing key
+type keyMode int
+
+const (
+	keyFromKID keyMode = iota
+	keyFromJWK
+	// keyFromEither is only used for revocation, which can be signed by the
+	// account or by the certificate's own key
+	keyFromEither
+)
+
+// verify checks the JWS envelope of an ACME request: algorithm, nonce, URL
+// and signature (RFC 8555 section 6.2)
+func (s *Server) verify(r *http.Request, url string, mode keyMode) (*signedRequest, *problem) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/jose+json" {
+		return nil, newProblem(errMalformed, http.StatusUnsupportedMediaType, "Content-Type must be application/jose+json")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+	if err != nil {
+		return nil, newProblem(errMalformed, http.StatusBadRequest, "failed to read request body")
+	}
+
+	jws, err := jose.ParseSigned(string(body))
+	if err != nil {
+		return nil, newProblem(errMalformed, http.StatusBadRequest, "invalid JWS: %v", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, newProblem(errMalformed, http.StatusBadRequest, "JWS must have exactly one signature")
+	}
+
+	header := jws.Signatures[0].Protected
+	if !supportedAlgorithms[header.Algorithm] {
+		return nil, newProblem(errBadSignatureAlgorithm, http.StatusBadRequest, "unsupported JWS algorithm %q", header.Algorithm)
+	}
+
+	if !s.nonces.consume(header.Nonce) {
+		return nil, newProblem(errBadNonce, http.StatusBadRequest, "invalid or reused nonce")
+	}
+
+	if headerURL, _ := header.ExtraHeaders[jose.HeaderKey("url")].(string); headerURL != url {
+		return nil, newProblem(errUnauthorized, http.StatusUnauthorized, "JWS url %q does not match request URL %q", headerURL, url)
+	}
+
+	req := &signedRequest{}
+
+	switch {
+	case header.JSONWebKey != nil && header.KeyID != "":
+		return nil, newProblem(errMalformed, http.StatusBadRequest, "JWS must not contain both jwk and kid")
+	case header.JSONWebKey != nil:
+		if mode == keyFromKID {
+			return nil, newProblem(errMalformed, http.StatusBadRequest, "this request must be signed with an account key (kid)")
+		}
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			return nil, newProblem(errBadPublicKey, http.StatusBadRequest, "invalid jwk")
+		}
+		req.key = header.JSONWebKey
+	case header.KeyID != "":
+		if mode == keyFromJWK {
+			return nil, newProblem(errMalformed, http.StatusBadRequest, "this request must be signed with a jwk")
+		}
+		acct := s.accountByURL(r, header.KeyID)
+		if acct == nil {
+			return nil, newProblem(errAccountDoesNotExist, http.StatusBadRequest, "unknown account %q", header.KeyID)
+		}
+		if acct.Status != statusValid {
+			return nil, newProblem(errUnauthorized, http.StatusUnauthorized, "account is %s", acct.Status)
+		}
+		req.key = acct.Key
+		req.account = acct
+	default:
+		return nil, newProblem(errMalformed, http.StatusBadRequest, "JWS must contain jwk or kid")
+	}
+
+	payload, err := jws.Verify(req.key)
+	if err != nil {
+		return nil, newProblem(errMalformed, http.StatusBadRequest, "JWS signature verification failed")
+	}
+	req.payload = payload
+
+	return req, nil
+}
+
+// thumbprint returns the RFC 7638 thumbprint of a key, as used in key
+// authorizations
+func thumbprint(key *jose.JSONWebKey) (string, error) {
+	sum, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sum), nil
+}
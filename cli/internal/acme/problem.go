@@ -0,0 +1,50 @@
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ACME error types (RFC 8555 section 6.7)
+const (
+	errAccountDoesNotExist   = "accountDoesNotExist"
+	errAlreadyRevoked        = "alreadyRevoked"
+	errBadCSR                = "badCSR"
+	errBadNonce              = "badNonce"
+	errBadPublicKey          = "badPublicKey"
+	errBadSignatureAlgorithm = "badSignatureAlgorithm"
+	errConnection            = "connection"
+	errIncorrectResponse     = "incorrectResponse"
+	errMalformed             = "malformed"
+	errOrderNotReady         = "orderNotReady"
+	errRejectedIdentifier    = "rejectedIdentifier"
+	errTLS                   = "tls"
+	errUnauthorized          = "unauthorized"
+	errUnsupportedIdentifier = "unsupportedIdentifier"
+)
+
+// problem is an RFC 7807 problem document
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func newProblem(kind string, status int, format string, args ...interface{}) *problem {
+	return &problem{
+		Type:   "urn:ietf:params:acme:error:" + kind,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func (p *problem) Error() string {
+	return p.Type + ": " + p.Detail
+}
+
+func writeProblem(w http.ResponseWriter, p *problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,1146 @@
+// Package acme implements a local ACME (RFC 8555) server that issues
+// certificates from the InstantTLS CA, so ACME clients such as certbot, Caddy,
+// Traefik or cert-manager can obtain trusted local certificates.
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/instanttls/cli/internal/cert"
+)
+
+// Object statuses (RFC 8555 section 7.1.6)
+const (
+	statusPending     = "pending"
+	statusProcessing  = "processing"
+	statusReady       = "ready"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+	statusRevoked     = "revoked"
+	statusExpired     = "expired"
+)
+
+const (
+	identifierDNS = "dns"
+	identifierIP  = "ip"
+)
+
+const (
+	orderLifetime        = 24 * time.Hour
+	pendingAuthzLifetime = 7 * 24 * time.Hour
+	validAuthzLifetime   = 30 * 24 * time.Hour
+	nonceLifetime        = time.Hour
+
+	defaultHTTPPort = 80
+	defaultTLSPort  = 443
+
+	certificateMediaType = "application/pem-certificate-chain"
+)
+
+// DirectoryPath is the path of the ACME directory that clients are pointed at
+const DirectoryPath = "/directory"
+
+// Resource paths live under /acme/<resource>[/<id>]
+const (
+	pathPrefix            = "/acme/"
+	accountOrdersSuffix   = "/orders"
+	resourceNewNonce      = "new-nonce"
+	resourceNewAccount    = "new-account"
+	resourceNewOrder      = "new-order"
+	resourceRevokeCert    = "revoke-cert"
+	resourceKeyChange     = "key-change"
+	resourceAccount       = "account"
+	resourceOrder         = "order"
+	resourceAuthorization = "authz"
+	resourceChallenge     = "challenge"
+	resourceFinalize      = "finalize"
+	resourceCertificate   = "cert"
+)
+
+// Options configures the ACME server
+type Options struct {
+	// AlwaysValid accepts every challenge without contacting the client.
+	// Only meant for development; it also enables wildcard identifiers.
+	AlwaysValid bool
+	// ValidationHost is where http-01 and tls-alpn-01 validation connects.
+	// Empty means resolve the identifier through DNS like a public CA.
+	ValidationHost string
+	// HTTPPort and TLSPort are the ports http-01 and tls-alpn-01 validation
+	// connect to
+	HTTPPort int
+	TLSPort  int
+	// ValidityDays is the lifetime of issued certificates
+	ValidityDays int
+	// Report, if set, records each certificate with the API before it is
+	// handed out, so plan quotas apply. Without it wildcard identifiers are
+	// refused.
+	Report cert.IssuanceReporter
+	// Logf, if set, receives one line per notable event
+	Logf func(format string, args ...interface{})
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type account struct {
+	ID         string
+	Key        *jose.JSONWebKey
+	Thumbprint string
+	Status     string
+	Contact    []string
+	OrderIDs   []string
+}
+
+type order struct {
+	ID            string
+	AccountID     string
+	Status        string
+	Expires       time.Time
+	Identifiers   []identifier
+	AuthzIDs      []string
+	CertificateID string
+	Error         *problem
+}
+
+type authorization struct {
+	ID           string
+	AccountID    string
+	Identifier   identifier
+	Wildcard     bool
+	Status       string
+	Expires      time.Time
+	ChallengeIDs []string
+}
+
+type challenge struct {
+	ID        string
+	AuthzID   string
+	Type      string
+	Token     string
+	Status    string
+	Validated *time.Time
+	Error     *problem
+}
+
+type certificate struct {
+	ID        string
+	AccountID string
+	Chain     [][]byte
+	Status    string
+}
+
+// Server is an in-memory ACME server. State does not survive a restart; ACME
+// clients simply register a new account when theirs is unknown.
+type Server struct {
+	opts   Options
+	nonces *nonceStore
+
+	mu             sync.Mutex
+	accounts       map[string]*account
+	accountsByKey  map[string]*account
+	orders         map[string]*order
+	authorizations map[string]*authorization
+	challenges     map[string]*challenge
+	certificates   map[string]*certificate
+}
+
+// NewServer creates an ACME server issuing from the local CA
+func NewServer(opts Options) *Server {
+	if opts.HTTPPort == 0 {
+		opts.HTTPPort = defaultHTTPPort
+	}
+	if opts.TLSPort == 0 {
+		opts.TLSPort = defaultTLSPort
+	}
+	if opts.ValidityDays <= 0 {
+		opts.ValidityDays = cert.CertValidityDays
+	}
+
+	return &Server{
+		opts:           opts,
+		nonces:         newNonceStore(),
+		accounts:       make(map[string]*account),
+		accountsByKey:  make(map[string]*account),
+		orders:         make(map[string]*order),
+		authorizations: make(map[string]*authorization),
+		challenges:     make(map[string]*challenge),
+		certificates:   make(map[string]*certificate),
+	}
+}
+
+// ServeHTTP routes ACME requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Link", `<`+s.baseURL(r)+DirectoryPath+`>;rel="index"`)
+
+	if r.URL.Path == DirectoryPath {
+		s.handleDirectory(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, pathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	resource, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, pathPrefix), "/")
+
+	if resource == resourceNewNonce {
+		s.handleNewNonce(w, r)
+		return
+	}
+
+	// Everything else is a JWS POST, and every response carries a fresh nonce
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeProblem(w, newProblem(errMalformed, http.StatusMethodNotAllowed, "use POST (or POST-as-GET)"))
+		return
+	}
+	w.Header().Set("Replay-Nonce", s.nonces.issue())
+
+	switch resource {
+	case resourceNewAccount:
+		s.handleNewAccount(w, r)
+	case resourceNewOrder:
+		s.handleNewOrder(w, r)
+	case resourceRevokeCert:
+		s.handleRevokeCert(w, r)
+	case resourceKeyChange:
+		s.handleKeyChange(w, r)
+	case resourceAccount:
+		if accountID, ok := strings.CutSuffix(id, accountOrdersSuffix); ok {
+			s.handleAccountOrders(w, r, accountID)
+			return
+		}
+		s.handleAccount(w, r, id)
+	case resourceOrder:
+		s.handleOrder(w, r, id)
+	case resourceAuthorization:
+		s.handleAuthorization(w, r, id)
+	case resourceChallenge:
+		s.handleChallenge(w, r, id)
+	case resourceFinalize:
+		s.handleFinalize(w, r, id)
+	case resourceCertificate:
+		s.handleCertificate(w, r, id)
+	default:
+		writeProblem(w, newProblem(errMalformed, http.StatusNotFound, "unknown resource"))
+	}
+}
+
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, newProblem(errMalformed, http.StatusMethodNotAllowed, "use GET"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"newNonce":   s.url(r, resourceNewNonce),
+		"newAccount": s.url(r, resourceNewAccount),
+		"newOrder":   s.url(r, resourceNewOrder),
+		"revokeCert": s.url(r, resourceRevokeCert),
+		"keyChange":  s.url(r, resourceKeyChange),
+		"meta": map[string]interface{}{
+			"website":                 "https://instanttls.dev",
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (s *Server) handleNewNonce(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.nonces.issue())
+
+	switch r.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, newProblem(errMalformed, http.StatusMethodNotAllowed, "use HEAD or GET"))
+	}
+}
+
+func (s *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, s.url(r, resourceNewAccount), keyFromJWK)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "invalid account request: %v", err))
+		return
+	}
+
+	tp, err := thumbprint(req.key)
+	if err != nil {
+		writeProblem(w, newProblem(errBadPublicKey, http.StatusBadRequest, "failed to compute key thumbprint"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing := s.accountsByKey[tp]; existing != nil {
+		w.Header().Set("Location", s.url(r, resourceAccount, existing.ID))
+		writeJSON(w, http.StatusOK, s.accountJSON(r, existing))
+		return
+	}
+
+	if payload.OnlyReturnExisting {
+		writeProblem(w, newProblem(errAccountDoesNotExist, http.StatusBadRequest, "no account exists for this key"))
+		return
+	}
+
+	acct := &account{
+		ID:         newID(),
+		Key:        req.key,
+		Thumbprint: tp,
+		Status:     statusValid,
+		Contact:    payload.Contact,
+	}
+	s.accounts[acct.ID] = acct
+	s.accountsByKey[tp] = acct
+	s.logf("registered account %s", acct.ID)
+
+	w.Header().Set("Location", s.url(r, resourceAccount, acct.ID))
+	writeJSON(w, http.StatusCreated, s.accountJSON(r, acct))
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request, id string) {
+	req, prob := s.verify(r, s.url(r, resourceAccount, id), keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.account.ID != id {
+		writeProblem(w, newProblem(errUnauthorized, http.StatusForbidden, "account does not belong to this key"))
+		return
+	}
+
+	if !req.postAsGet() {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "invalid account update: %v", err))
+			return
+		}
+
+		if payload.Contact != nil {
+			req.account.Contact = payload.Contact
+		}
+		switch payload.Status {
+		case "":
+		case statusDeactivated:
+			req.account.Status = statusDeactivated
+			s.logf("deactivated account %s", id)
+		default:
+			writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "accounts can only be deactivated"))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, s.accountJSON(r, req.account))
+}
+
+func (s *Server) handleAccountOrders(w http.ResponseWriter, r *http.Request, id string) {
+	req, prob := s.verify(r, s.url(r, resourceAccount, id+accountOrdersSuffix), keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.account.ID != id {
+		writeProblem(w, newProblem(errUnauthorized, http.StatusForbidden, "account does not belong to this key"))
+		return
+	}
+
+	urls := make([]string, 0, len(req.account.OrderIDs))
+	for _, orderID := range req.account.OrderIDs {
+		if o := s.orders[orderID]; o != nil && s.refreshOrder(o) != statusInvalid {
+			urls = append(urls, s.url(r, resourceOrder, orderID))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"orders": urls})
+}
+
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, s.url(r, resourceNewOrder), keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	var payload struct {
+		Identifiers []identifier `json:"identifiers"`
+		NotBefore   string       `json:"notBefore"`
+		NotAfter    string       `json:"notAfter"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "invalid order: %v", err))
+		return
+	}
+	if payload.NotBefore != "" || payload.NotAfter != "" {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "notBefore and notAfter are not supported"))
+		return
+	}
+
+	identifiers, prob := s.checkIdentifiers(payload.Identifiers)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	o := &order{
+		ID:          newID(),
+		AccountID:   req.account.ID,
+		Status:      statusPending,
+		Expires:     now.Add(orderLifetime),
+		Identifiers: identifiers,
+	}
+
+	for _, id := range identifiers {
+		o.AuthzIDs = append(o.AuthzIDs, s.authorizationFor(req.account, id, now).ID)
+	}
+
+	s.orders[o.ID] = o
+	req.account.OrderIDs = append(req.account.OrderIDs, o.ID)
+	s.refreshOrder(o)
+	s.logf("new order %s for %s", o.ID, identifierList(identifiers))
+
+	w.Header().Set("Location", s.url(r, resourceOrder, o.ID))
+	writeJSON(w, http.StatusCreated, s.orderJSON(r, o))
+}
+
+// checkIdentifiers normalizes the identifiers of a new order and refuses
+// anything the local CA cannot or will not issue for
+func (s *Server) checkIdentifiers(ids []identifier) ([]identifier, *problem) {
+	if len(ids) == 0 {
+		return nil, newProblem(errMalformed, http.StatusBadRequest, "order has no identifiers")
+	}
+
+	var normalized []identifier
+	var names []string
+	seen := make(map[identifier]bool)
+
+	for _, id := range ids {
+		switch id.Type {
+		case identifierDNS:
+			id.Value = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(id.Value)), ".")
+			if net.ParseIP(id.Value) != nil {
+				return nil, newProblem(errMalformed, http.StatusBadRequest, "%q is an IP address; use an ip identifier", id.Value)
+			}
+			if strings.HasPrefix(id.Value, "*.") && !s.opts.AlwaysValid {
+				return nil, newProblem(errRejectedIdentifier, http.StatusBadRequest,
+					"wildcard %q needs dns-01, which is only available with --always-valid", id.Value)
+			}
+			if strings.HasPrefix(id.Value, "*.") && s.opts.Report == nil {
+				return nil, newProblem(errRejectedIdentifier, http.StatusBadRequest,
+					"wildcard %q counts toward your plan and must be reported to the API; run 'instanttls login' first", id.Value)
+			}
+		case identifierIP:
+			ip := net.ParseIP(id.Value)
+			if ip == nil {
+				return nil, newProblem(errMalformed, http.StatusBadRequest, "invalid IP address %q", id.Value)
+			}
+			id.Value = ip.String()
+		default:
+			return nil, newProblem(errUnsupportedIdentifier, http.StatusBadRequest, "unsupported identifier type %q", id.Type)
+		}
+
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		normalized = append(normalized, id)
+		names = append(names, id.Value)
+	}
+
+	// Refuse names outside the CA's name constraints up front instead of at
+	// finalization, after the client has already proven control
+	if err := cert.CheckNames(names); err != nil {
+		return nil, newProblem(errRejectedIdentifier, http.StatusBadRequest, "%v", err)
+	}
+
+	return normalized, nil
+}
+
+// authorizationFor reuses a still-valid authorization of the account for
+// the identifier, or creates a new pending one. Callers hold s.mu.
+func (s *Server) authorizationFor(acct *account, id identifier, now time.Time) *authorization {
+	wildcard := strings.HasPrefix(id.Value, "*.")
+	authzID := id
+	if wildcard {
+		authzID.Value = strings.TrimPrefix(id.Value, "*.")
+	}
+
+	for _, az := range s.authorizations {
+		if az.AccountID == acct.ID && az.Identifier == authzID && az.Wildcard == wildcard &&
+			s.refreshAuthorization(az) == statusValid {
+			return az
+		}
+	}
+
+	az := &authorization{
+		ID:         newID(),
+		AccountID:  acct.ID,
+		Identifier: authzID,
+		Wildcard:   wildcard,
+		Status:     statusPending,
+		Expires:    now.Add(pendingAuthzLifetime),
+	}
+
+	for _, chalType := range s.challengeTypes(authzID, wildcard) {
+		chal := &challenge{
+			ID:      newID(),
+			AuthzID: az.ID,
+			Type:    chalType,
+			Token:   newToken(),
+			Status:  statusPending,
+		}
+		s.challenges[chal.ID] = chal
+		az.ChallengeIDs = append(az.ChallengeIDs, chal.ID)
+	}
+
+	s.authorizations[az.ID] = az
+	return az
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request, id string) {
+	req, prob := s.verify(r, s.url(r, resourceOrder, id), keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.orders[id]
+	if o == nil || o.AccountID != req.account.ID {
+		writeProblem(w, newProblem(errMalformed, http.StatusNotFound, "order not found"))
+		return
+	}
+
+	s.refreshOrder(o)
+	writeJSON(w, http.StatusOK, s.orderJSON(r, o))
+}
+
+func (s *Server) handleAuthorization(w http.ResponseWriter, r *http.Request, id string) {
+	req, prob := s.verify(r, s.url(r, resourceAuthorization, id), keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	az := s.authorizations[id]
+	if az == nil || az.AccountID != req.account.ID {
+		writeProblem(w, newProblem(errMalformed, http.StatusNotFound, "authorization not found"))
+		return
+	}
+
+	if !req.postAsGet() {
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil || payload.Status != statusDeactivated {
+			writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "authorizations can only be deactivated"))
+			return
+		}
+		az.Status = statusDeactivated
+	}
+
+	s.refreshAuthorization(az)
+	writeJSON(w, http.StatusOK, s.authorizationJSON(r, az))
+}
+
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request, id string) {
+	req, prob := s.verify(r, s.url(r, resourceChallenge, id), keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	s.mu.Lock()
+	chal := s.challenges[id]
+	var az *authorization
+	if chal != nil {
+		az = s.authorizations[chal.AuthzID]
+	}
+	if chal == nil || az == nil || az.AccountID != req.account.ID {
+		s.mu.Unlock()
+		writeProblem(w, newProblem(errMalformed, http.StatusNotFound, "challenge not found"))
+		return
+	}
+
+	// A POST with a payload of {} asks the server to validate; POST-as-GET
+	// only polls
+	validate := !req.postAsGet() && chal.Status == statusPending && s.refreshAuthorization(az) == statusPending
+	if validate {
+		chal.Status = statusProcessing
+	}
+	ident, token, chalType := az.Identifier, chal.Token, chal.Type
+	keyAuth := keyAuthorization(token, req.account.Thumbprint)
+	s.mu.Unlock()
+
+	if validate {
+		prob := s.validate(r.Context(), chalType, ident, token, keyAuth)
+
+		s.mu.Lock()
+		now := time.Now()
+		if prob == nil {
+			chal.Status = statusValid
+			chal.Validated = &now
+			az.Status = statusValid
+			az.Expires = now.Add(validAuthzLifetime)
+			s.logf("validated %s for %s", chalType, ident.Value)
+		} else {
+			chal.Status = statusInvalid
+			chal.Error = prob
+			az.Status = statusInvalid
+			s.logf("%s for %s failed: %s", chalType, ident.Value, prob.Detail)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Add("Link", `<`+s.url(r, resourceAuthorization, az.ID)+`>;rel="up"`)
+	writeJSON(w, http.StatusOK, s.challengeJSON(r, chal))
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request, id string) {
+	req, prob := s.verify(r, s.url(r, resourceFinalize, id), keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "invalid finalize request: %v", err))
+		return
+	}
+
+	csrDER, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		writeProblem(w, newProblem(errBadCSR, http.StatusBadRequest, "CSR is not base64url-encoded"))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		writeProblem(w, newProblem(errBadCSR, http.StatusBadRequest, "invalid CSR: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	o := s.orders[id]
+	if o == nil || o.AccountID != req.account.ID {
+		s.mu.Unlock()
+		writeProblem(w, newProblem(errMalformed, http.StatusNotFound, "order not found"))
+		return
+	}
+	if status := s.refreshOrder(o); status != statusReady {
+		s.mu.Unlock()
+		writeProblem(w, newProblem(errOrderNotReady, http.StatusForbidden, "order is %s", status))
+		return
+	}
+	if prob := csrMatchesOrder(csr, o); prob != nil {
+		s.mu.Unlock()
+		writeProblem(w, prob)
+		return
+	}
+	o.Status = statusProcessing
+	s.mu.Unlock()
+
+	// Issue through the same signing path and quota checks as "instanttls cert"
+	chain, err := cert.SignCSR(csr, s.opts.ValidityDays, s.opts.Report)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		o.Status = statusInvalid
+		o.Error = newProblem(errBadCSR, http.StatusBadRequest, "%v", err)
+		s.logf("order %s failed: %v", o.ID, err)
+		writeProblem(w, o.Error)
+		return
+	}
+
+	c := &certificate{
+		ID:        newID(),
+		AccountID: req.account.ID,
+		Chain:     chain,
+		Status:    statusValid,
+	}
+	s.certificates[c.ID] = c
+	o.CertificateID = c.ID
+	o.Status = statusValid
+	s.logf("issued certificate for %s", identifierList(o.Identifiers))
+
+	w.Header().Set("Location", s.url(r, resourceOrder, o.ID))
+	writeJSON(w, http.StatusOK, s.orderJSON(r, o))
+}
+
+// csrMatchesOrder checks that a CSR asks for exactly the order's identifiers
+func csrMatchesOrder(csr *x509.CertificateRequest, o *order) *problem {
+	want := make([]string, len(o.Identifiers))
+	for i, id := range o.Identifiers {
+		want[i] = id.Value
+	}
+
+	var got []string
+	seen := make(map[string]bool)
+	for _, name := range cert.CSRNames(csr) {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if !seen[name] {
+			seen[name] = true
+			got = append(got, name)
+		}
+	}
+	// The common name, if present, must be one of the SANs
+	if cn := strings.ToLower(csr.Subject.CommonName); cn != "" && !seen[cn] {
+		got = append(got, cn)
+	}
+
+	sort.Strings(want)
+	sort.Strings(got)
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		return newProblem(errBadCSR, http.StatusBadRequest,
+			"CSR names (%s) do not match the order (%s)", strings.Join(got, ", "), strings.Join(want, ", "))
+	}
+	return nil
+}
+
+func (s *Server) handleCertificate(w http.ResponseWriter, r *http.Request, id string) {
+	req, prob := s.verify(r, s.url(r, resourceCertificate, id), keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.certificates[id]
+	if c == nil || c.AccountID != req.account.ID {
+		writeProblem(w, newProblem(errMalformed, http.StatusNotFound, "certificate not found"))
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, der := range c.Chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+
+	w.Header().Set("Content-Type", certificateMediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// handleRevokeCert marks a certificate revoked. The local CA publishes no CRL
+// or OCSP, so revocation only stops the server from handing it out again.
+func (s *Server) handleRevokeCert(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, s.url(r, resourceRevokeCert), keyFromEither)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "invalid revocation request: %v", err))
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "certificate is not base64url-encoded"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c *certificate
+	for _, candidate := range s.certificates {
+		if bytes.Equal(candidate.Chain[0], der) {
+			c = candidate
+			break
+		}
+	}
+	if c == nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusNotFound, "certificate was not issued by this server"))
+		return
+	}
+
+	// Either the issuing account or the certificate's own key may revoke
+	authorized := req.account != nil && req.account.ID == c.AccountID
+	if req.account == nil {
+		if leaf, err := x509.ParseCertificate(der); err == nil {
+			authorized = keysEqual(leaf.PublicKey, req.key.Key)
+		}
+	}
+	if !authorized {
+		writeProblem(w, newProblem(errUnauthorized, http.StatusForbidden, "not authorized to revoke this certificate"))
+		return
+	}
+
+	if c.Status == statusRevoked {
+		writeProblem(w, newProblem(errAlreadyRevoked, http.StatusBadRequest, "certificate is already revoked"))
+		return
+	}
+	c.Status = statusRevoked
+	s.logf("revoked certificate %s", c.ID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleKeyChange rolls an account over to a new key (RFC 8555 section 7.3.5)
+func (s *Server) handleKeyChange(w http.ResponseWriter, r *http.Request) {
+	url := s.url(r, resourceKeyChange)
+	req, prob := s.verify(r, url, keyFromKID)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	inner, err := jose.ParseSigned(string(req.payload))
+	if err != nil || len(inner.Signatures) != 1 {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "payload must be a JWS signed by the new key"))
+		return
+	}
+	header := inner.Signatures[0].Protected
+	if header.JSONWebKey == nil || header.KeyID != "" || !header.JSONWebKey.IsPublic() {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "inner JWS must carry the new key as jwk"))
+		return
+	}
+	if !supportedAlgorithms[header.Algorithm] {
+		writeProblem(w, newProblem(errBadSignatureAlgorithm, http.StatusBadRequest, "unsupported JWS algorithm %q", header.Algorithm))
+		return
+	}
+	if innerURL, _ := header.ExtraHeaders[jose.HeaderKey("url")].(string); innerURL != url {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "inner JWS url does not match"))
+		return
+	}
+
+	innerPayload, err := inner.Verify(header.JSONWebKey)
+	if err != nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "inner JWS signature verification failed"))
+		return
+	}
+
+	var payload struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}
+	if err := json.Unmarshal(innerPayload, &payload); err != nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "invalid key change request: %v", err))
+		return
+	}
+
+	newThumbprint, err := thumbprint(header.JSONWebKey)
+	if err != nil {
+		writeProblem(w, newProblem(errBadPublicKey, http.StatusBadRequest, "failed to compute key thumbprint"))
+		return
+	}
+	oldThumbprint, err := thumbprint(&payload.OldKey)
+	if err != nil {
+		writeProblem(w, newProblem(errMalformed, http.StatusBadRequest, "invalid oldKey"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acct := req.account
+	if payload.Account != s.url(r, resourceAccount, acct.ID) || oldThumbprint != acct.Thumbprint {
+		writeProblem(w, newProblem(errUnauthorized, http.StatusForbidden, "key change does not match the requesting account"))
+		return
+	}
+	if existing := s.accountsByKey[newThumbprint]; existing != nil {
+		w.Header().Set("Location", s.url(r, resourceAccount, existing.ID))
+		writeProblem(w, newProblem(errMalformed, http.StatusConflict, "new key is already in use by another account"))
+		return
+	}
+
+	delete(s.accountsByKey, acct.Thumbprint)
+	acct.Key = header.JSONWebKey
+	acct.Thumbprint = newThumbprint
+	s.accountsByKey[newThumbprint] = acct
+	s.logf("rolled over key of account %s", acct.ID)
+
+	writeJSON(w, http.StatusOK, s.accountJSON(r, acct))
+}
+
+// refreshOrder moves an order along as its authorizations change and
+// returns its current status. Callers hold s.mu.
+func (s *Server) refreshOrder(o *order) string {
+	switch o.Status {
+	case statusValid, statusInvalid, statusProcessing:
+		return o.Status
+	}
+
+	if time.Now().After(o.Expires) {
+		o.Status = statusInvalid
+		return o.Status
+	}
+
+	ready := true
+	for _, azID := range o.AuthzIDs {
+		switch s.refreshAuthorization(s.authorizations[azID]) {
+		case statusValid:
+		case statusPending:
+			ready = false
+		default:
+			o.Status = statusInvalid
+			return o.Status
+		}
+	}
+
+	if ready {
+		o.Status = statusReady
+	}
+	return o.Status
+}
+
+// refreshAuthorization expires an authorization once its time is up and
+// returns its current status. Callers hold s.mu.
+func (s *Server) refreshAuthorization(az *authorization) string {
+	if (az.Status == statusPending || az.Status == statusValid) && time.Now().After(az.Expires) {
+		az.Status = statusExpired
+	}
+	return az.Status
+}
+
+func (s *Server) accountByURL(r *http.Request, kid string) *account {
+	id, ok := strings.CutPrefix(kid, s.url(r, resourceAccount)+"/")
+	if !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accounts[id]
+}
+
+func (s *Server) accountJSON(r *http.Request, acct *account) map[string]interface{} {
+	contact := acct.Contact
+	if contact == nil {
+		contact = []string{}
+	}
+	return map[string]interface{}{
+		"status":  acct.Status,
+		"contact": contact,
+		"orders":  s.url(r, resourceAccount, acct.ID+accountOrdersSuffix),
+	}
+}
+
+func (s *Server) orderJSON(r *http.Request, o *order) map[string]interface{} {
+	authzURLs := make([]string, len(o.AuthzIDs))
+	for i, id := range o.AuthzIDs {
+		authzURLs[i] = s.url(r, resourceAuthorization, id)
+	}
+
+	obj := map[string]interface{}{
+		"status":         o.Status,
+		"expires":        o.Expires.UTC().Format(time.RFC3339),
+		"identifiers":    o.Identifiers,
+		"authorizations": authzURLs,
+		"finalize":       s.url(r, resourceFinalize, o.ID),
+	}
+	if o.CertificateID != "" {
+		obj["certificate"] = s.url(r, resourceCertificate, o.CertificateID)
+	}
+	if o.Error != nil {
+		obj["error"] = o.Error
+	}
+	return obj
+}
+
+func (s *Server) authorizationJSON(r *http.Request, az *authorization) map[string]interface{} {
+	challenges := make([]map[string]interface{}, 0, len(az.ChallengeIDs))
+	for _, id := range az.ChallengeIDs {
+		challenges = append(challenges, s.challengeJSON(r, s.challenges[id]))
+	}
+
+	obj := map[string]interface{}{
+		"identifier": az.Identifier,
+		"status":     az.Status,
+		"expires":    az.Expires.UTC().Format(time.RFC3339),
+		"challenges": challenges,
+	}
+	if az.Wildcard {
+		obj["wildcard"] = true
+	}
+	return obj
+}
+
+func (s *Server) challengeJSON(r *http.Request, chal *challenge) map[string]interface{} {
+	obj := map[string]interface{}{
+		"type":   chal.Type,
+		"url":    s.url(r, resourceChallenge, chal.ID),
+		"status": chal.Status,
+		"token":  chal.Token,
+	}
+	if chal.Validated != nil {
+		obj["validated"] = chal.Validated.UTC().Format(time.RFC3339)
+	}
+	if chal.Error != nil {
+		obj["error"] = chal.Error
+	}
+	return obj
+}
+
+// baseURL derives the server's own URL from the request, so the directory
+// works under whatever host name clients use to reach it
+func (s *Server) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s *Server) url(r *http.Request, resource string, id ...string) string {
+	u := s.baseURL(r) + pathPrefix + resource
+	if len(id) > 0 {
+		u += "/" + id[0]
+	}
+	return u
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.opts.Logf != nil {
+		s.opts.Logf(format, args...)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func identifierList(ids []identifier) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = id.Value
+	}
+	return strings.Join(values, ", ")
+}
+
+func keysEqual(a, b interface{}) bool {
+	type equaler interface {
+		Equal(x crypto.PublicKey) bool
+	}
+	k, ok := a.(equaler)
+	return ok && k.Equal(b)
+}
+
+func newID() string {
+	return randomString(12)
+}
+
+func newToken() string {
+	return randomString(32)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// nonceStore hands out single-use anti-replay nonces
+type nonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func newNonceStore() *nonceStore {
+	return &nonceStore{nonces: make(map[string]time.Time)}
+}
+
+func (n *nonceStore) issue() string {
+	nonce := randomString(16)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	n.nonces[nonce] = now.Add(nonceLifetime)
+
+	// Prune expired nonces so clients that never use theirs cannot grow
+	// the store without bound
+	if len(n.nonces) > 1000 {
+		for k, exp := range n.nonces {
+			if now.After(exp) {
+				delete(n.nonces, k)
+			}
+		}
+	}
+
+	return nonce
+}
+
+func (n *nonceStore) consume(nonce string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	exp, ok := n.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(n.nonces, nonce)
+	return time.Now().Before(exp)
+}
@@ -3,9 +3,11 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -26,6 +28,77 @@ type LicenseResponse struct {
 	Plan   string         `json:"plan"`
 	Limits map[string]int `json:"limits"`
 	User   UserResponse   `json:"user"`
+	Org    OrgResponse    `json:"org"`
+	// License is the signed form the CLI verifies and caches
+	License string `json:"license"`
+}
+
+// OrgResponse is the organization a token belongs to, with the user's role
+type OrgResponse struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Plan     string `json:"plan"`
+	Personal bool   `json:"personal"`
+	Role     string `json:"role"`
+}
+
+// CanManage reports whether the user is an owner or admin of the
+// organization
+func (o OrgResponse) CanManage() bool {
+	return o.Role == "owner" || o.Role == "admin"
+}
+
+// TeamCA is an organization's shared CA. IntermediateKey is an encrypted
+// PKCS#8 PEM that only the team passphrase opens.
+type TeamCA struct {
+	Version          int       `json:"version"`
+	Fingerprint      string    `json:"fingerprint"`
+	RootCert         string    `json:"root_cert"`
+	IntermediateCert string    `json:"intermediate_cert"`
+	IntermediateKey  string    `json:"intermediate_key"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// TeamCARequest uploads a team CA
+type TeamCARequest struct {
+	RootCert         string `json:"root_cert"`
+	IntermediateCert string `json:"intermediate_cert"`
+	IntermediateKey  string `json:"intermediate_key"`
+}
+
+// ServerCA is the CA the API signs an organization's remote certificates
+// with. Only its certificate ever leaves the server.
+type ServerCA struct {
+	Certificate string `json:"certificate"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// CertificateRequest asks the API to sign a CSR. A zero ValidityDays lets
+// the organization's policy decide.
+type CertificateRequest struct {
+	CSR          string `json:"csr"`
+	ValidityDays int    `json:"validity_days,omitempty"`
+	// Replaces is the serial number of the certificate being renewed
+	Replaces string `json:"replaces,omitempty"`
+}
+
+// CertificateReport describes a certificate signed by a local CA, reported
+// so the API can count it toward the organization's quotas
+type CertificateReport struct {
+	SerialNumber string    `json:"serial_number"`
+	CommonName   string    `json:"common_name"`
+	DNSNames     []string  `json:"dns_names"`
+	IPAddresses  []string  `json:"ip_addresses"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	Replaces     string    `json:"replaces,omitempty"`
+}
+
+// CertificateResponse is a certificate signed by the API, with the CA that
+// signed it
+type CertificateResponse struct {
+	Certificate   string `json:"certificate"`
+	CACertificate string `json:"ca_certificate"`
 }
 
 type MachineRequest struct {
@@ -64,8 +137,10 @@ func (c *Client) Me() (*UserResponse, error) {
 	return &user, nil
 }
 
-func (c *Client) License() (*LicenseResponse, error) {
-	resp, err := c.request("GET", "/v1/license", nil)
+// License returns the token organization's plan, with a signed license
+// bound to the given machine ID
+func (c *Client) License(machine string) (*LicenseResponse, error) {
+	resp, err := c.request("GET", "/v1/license?machine="+url.QueryEscape(machine), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -105,6 +180,155 @@ func (c *Client) MachinePing(hostname, osName, arch string) error {
 	return nil
 }
 
+// MachineDeregister removes a machine registered with MachinePing. A
+// machine that is not registered is not an error.
+func (c *Client) MachineDeregister(hostname string) error {
+	resp, err := c.request("DELETE", "/v1/machines/"+url.PathEscape(hostname), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// TeamCA returns the team CA of the token's organization, or nil if it
+// has none yet
+func (c *Client) TeamCA() (*TeamCA, error) {
+	resp, err := c.request("GET", "/v1/ca", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var ca TeamCA
+	if err := json.NewDecoder(resp.Body).Decode(&ca); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &ca, nil
+}
+
+// UploadTeamCA stores the first team CA of the token's organization, or
+// with rotate replaces the current one, and returns the version created
+func (c *Client) UploadTeamCA(req TeamCARequest, rotate bool) (int, error) {
+	path := "/v1/ca"
+	if rotate {
+		path += "/rotate"
+	}
+
+	resp, err := c.request("POST", path, req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return 0, apiError(resp)
+	}
+
+	var created struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return created.Version, nil
+}
+
+// ServerCA returns the CA that signs the token organization's remote
+// certificates
+func (c *Client) ServerCA() (*ServerCA, error) {
+	resp, err := c.request("GET", "/v1/certificates/ca", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var ca ServerCA
+	if err := json.NewDecoder(resp.Body).Decode(&ca); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &ca, nil
+}
+
+// SignCSR has the API sign a certificate signing request with the token
+// organization's server CA
+func (c *Client) SignCSR(req CertificateRequest) (*CertificateResponse, error) {
+	resp, err := c.request("POST", "/v1/certificates", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, apiError(resp)
+	}
+
+	var signed CertificateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &signed, nil
+}
+
+// ReportCertificate records a locally signed certificate. An error means
+// the certificate must not be used, typically because a plan quota is
+// exhausted.
+func (c *Client) ReportCertificate(report CertificateReport) error {
+	resp, err := c.request("POST", "/v1/certificates/report", report)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return apiError(resp)
+	}
+
+	return nil
+}
+
+// IsUnreachable reports whether err means the API could not be reached at
+// all, rather than that it answered with an error
+func IsUnreachable(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// apiError turns an error response into an error, preferring the API's
+// own message
+func apiError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		return fmt.Errorf("API error (%d): %s", resp.StatusCode, e.Error)
+	}
+	return fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+}
+
 func (c *Client) request(method, path string, body interface{}) (*http.Response, error) {
 	var bodyReader io.Reader
 	if body != nil {
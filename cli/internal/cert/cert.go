@@ -1,8 +1,9 @@
 package cert
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
@@ -16,23 +17,61 @@ import (
 	"time"
 
 	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/version"
 )
 
 const (
 	CAValidityDays   = 3650 // 10 years
 	CertValidityDays = 365  // 1 year
-	KeySize          = 2048
 )
 
-// GenerateCA creates a new Certificate Authority
-func GenerateCA() error {
+// CAOptions configures a newly generated CA
+type CAOptions struct {
+	KeyType KeyType
+	// NameConstraints, if set, limits both CAs to the permitted names
+	NameConstraints *NameConstraints
+	// Passphrase, if set, encrypts the root and intermediate keys at rest
+	Passphrase []byte
+}
+
+// CertRequest describes a leaf certificate to issue
+type CertRequest struct {
+	// Names holds the domains, wildcards and IP addresses to cover. The first
+	// one becomes the subject common name.
+	Names        []string
+	KeyType      KeyType
+	ValidityDays int
+	// Remote has the API sign the certificate instead of the local CA
+	Remote bool
+}
+
+// IssuanceReport describes a certificate signed by the local CA to the API
+type IssuanceReport struct {
+	SerialNumber string
+	CommonName   string
+	DNSNames     []string
+	IPAddresses  []string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	// Replaces is the serial number of the certificate being renewed, if any
+	Replaces string
+}
+
+// IssuanceReporter reports a certificate to the API before it is saved. An
+// error, such as an exhausted plan quota, discards the certificate.
+type IssuanceReporter func(IssuanceReport) error
+
+// GenerateCA creates a new root Certificate Authority together with an
+// intermediate CA that signs leaf certificates. Only the root is installed in
+// trust stores, so its key can be moved offline once the intermediate exists.
+func GenerateCA(opts CAOptions) error {
 	caDir := config.GetCADir()
 	if err := os.MkdirAll(caDir, 0700); err != nil {
 		return fmt.Errorf("failed to create CA directory: %w", err)
 	}
 
 	// Generate private key
-	privateKey, err := rsa.GenerateKey(rand.Reader, KeySize)
+	privateKey, err := GenerateKey(opts.KeyType)
 	if err != nil {
 		return fmt.Errorf("failed to generate private key: %w", err)
 	}
@@ -62,183 +101,267 @@ func GenerateCA() error {
 		BasicConstraintsValid: true,
 		MaxPathLen:            1,
 	}
+	opts.NameConstraints.apply(template)
 
 	// Self-sign the CA certificate
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
 	if err != nil {
 		return fmt.Errorf("failed to create CA certificate: %w", err)
 	}
 
-	// Save CA certificate
-	certPath := filepath.Join(caDir, "ca.crt")
-	certFile, err := os.Create(certPath)
+	rootCert, err := x509.ParseCertificate(derBytes)
 	if err != nil {
-		return fmt.Errorf("failed to create CA certificate file: %w", err)
+		return fmt.Errorf("failed to parse CA certificate: %w", err)
 	}
-	defer certFile.Close()
 
-	if err := pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		return fmt.Errorf("failed to write CA certificate: %w", err)
+	// Drop any intermediate belonging to a previous root, and the markers of
+	// a team or server CA it replaces
+	os.Remove(filepath.Join(caDir, IntermediateCertFile))
+	os.Remove(filepath.Join(caDir, IntermediateKeyFile))
+	os.Remove(filepath.Join(caDir, TeamFile))
+	os.Remove(filepath.Join(caDir, RemoteFile))
+
+	// Save CA certificate
+	if err := writeCertPEM(filepath.Join(caDir, RootCertFile), derBytes); err != nil {
+		return fmt.Errorf("failed to save CA certificate: %w", err)
 	}
 
 	// Save CA private key
-	keyPath := filepath.Join(caDir, "ca.key")
-	keyFile, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to create CA key file: %w", err)
+	if err := writePrivateKey(filepath.Join(caDir, RootKeyFile), privateKey, opts.Passphrase); err != nil {
+		return fmt.Errorf("failed to save CA key: %w", err)
+	}
+
+	// Issue the intermediate that signs leaves
+	if err := generateIntermediate(rootCert, privateKey, opts.KeyType, opts.Passphrase); err != nil {
+		return err
 	}
-	defer keyFile.Close()
 
-	keyBytes := x509.MarshalPKCS1PrivateKey(privateKey)
-	if err := pem.Encode(keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: keyBytes}); err != nil {
-		return fmt.Errorf("failed to write CA key: %w", err)
+	if opts.Passphrase != nil {
+		cachedPassphrase = opts.Passphrase
 	}
 
 	return nil
 }
 
-// CAExists checks if the CA has been generated
+// CAExists checks if the CA has been generated and can sign certificates,
+// either through an intermediate or, for older setups, the root key itself
 func CAExists() bool {
 	caDir := config.GetCADir()
-	certPath := filepath.Join(caDir, "ca.crt")
-	keyPath := filepath.Join(caDir, "ca.key")
 
-	_, certErr := os.Stat(certPath)
-	_, keyErr := os.Stat(keyPath)
+	if _, err := os.Stat(filepath.Join(caDir, RootCertFile)); err != nil {
+		return false
+	}
+
+	if HasIntermediate() {
+		return true
+	}
 
-	return certErr == nil && keyErr == nil
+	_, keyErr := os.Stat(filepath.Join(caDir, RootKeyFile))
+	return keyErr == nil
 }
 
-// LoadCA loads the CA certificate and key
-func LoadCA() (*x509.Certificate, *rsa.PrivateKey, error) {
+// LoadCA loads the certificate and key used to sign leaf certificates: the
+// intermediate CA if there is one, otherwise the root. The key may be RSA,
+// ECDSA or Ed25519, in PKCS#8 or legacy PKCS#1/SEC 1 form.
+func LoadCA() (*x509.Certificate, crypto.Signer, error) {
 	caDir := config.GetCADir()
 
-	// Load CA certificate
-	certPEM, err := os.ReadFile(filepath.Join(caDir, "ca.crt"))
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	if HasIntermediate() {
+		return loadKeyPair(
+			filepath.Join(caDir, IntermediateCertFile),
+			filepath.Join(caDir, IntermediateKeyFile),
+		)
 	}
 
-	certBlock, _ := pem.Decode(certPEM)
-	if certBlock == nil {
-		return nil, nil, fmt.Errorf("failed to decode CA certificate PEM")
+	return loadKeyPair(filepath.Join(caDir, RootCertFile), filepath.Join(caDir, RootKeyFile))
+}
+
+// LoadRootCA loads the root certificate and its key. keyPath overrides the
+// default location for roots whose key is kept offline.
+func LoadRootCA(keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	if keyPath == "" {
+		keyPath = filepath.Join(config.GetCADir(), RootKeyFile)
 	}
+	return loadKeyPair(filepath.Join(config.GetCADir(), RootCertFile), keyPath)
+}
 
-	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+func loadKeyPair(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	// Load CA certificate
+	caCert, err := loadCertFile(certPath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+		return nil, nil, err
 	}
 
 	// Load CA private key
-	keyPEM, err := os.ReadFile(filepath.Join(caDir, "ca.key"))
+	keyPEM, err := os.ReadFile(keyPath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read CA key: %w", err)
 	}
 
-	keyBlock, _ := pem.Decode(keyPEM)
-	if keyBlock == nil {
-		return nil, nil, fmt.Errorf("failed to decode CA key PEM")
-	}
-
-	caKey, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	caKey, err := parsePrivateKey(keyPEM, keyPath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to parse CA key: %w", err)
 	}
 
+	if !bytes.Equal(publicKeyBytes(caKey.Public()), publicKeyBytes(caCert.PublicKey)) {
+		return nil, nil, fmt.Errorf("%s does not match %s", filepath.Base(keyPath), filepath.Base(certPath))
+	}
+
 	return caCert, caKey, nil
 }
 
-// GenerateCert creates a certificate for the given domain
-func GenerateCert(domain string) (string, error) {
-	if !CAExists() {
-		return "", fmt.Errorf("CA not found. Run 'instanttls init' first")
+func loadCertFile(path string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("failed to decode CA certificate PEM")
+	}
+
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
 	}
 
-	caCert, caKey, err := LoadCA()
+	return caCert, nil
+}
+
+func writeCertPEM(path string, derBytes ...[]byte) error {
+	certFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer certFile.Close()
+
+	for _, der := range derBytes {
+		if err := pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GenerateCert creates a single certificate covering all of the requested
+// domains, wildcards and IP addresses. It is reported through report before
+// anything is written. Wildcard certificates count toward the plan quota, so
+// they are refused when report is nil.
+func GenerateCert(req CertRequest, report IssuanceReporter) (string, error) {
+	sans, err := ParseSANs(req.Names)
 	if err != nil {
 		return "", err
 	}
+	primary := primaryName(req.Names, sans)
 
-	// Sanitize domain for directory name
-	sanitizedDomain := sanitizeDomain(domain)
-	certDir := filepath.Join(config.GetCertsDir(), sanitizedDomain)
-	if err := os.MkdirAll(certDir, 0700); err != nil {
-		return "", fmt.Errorf("failed to create cert directory: %w", err)
+	keyType := req.KeyType
+	if keyType == "" {
+		keyType = DefaultKeyType
+	}
+
+	validityDays := req.ValidityDays
+	if validityDays <= 0 {
+		validityDays = CertValidityDays
+	}
+
+	if report == nil && sans.HasWildcard() {
+		return "", fmt.Errorf("wildcard certificates count toward your plan and must be reported to the API; run 'instanttls login' first")
 	}
 
+	certDir := filepath.Join(config.GetCertsDir(), certDirName(primary, sans))
+
 	// Generate private key
-	privateKey, err := rsa.GenerateKey(rand.Reader, KeySize)
+	privateKey, err := GenerateKey(keyType)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate private key: %w", err)
 	}
 
-	// Create certificate template
-	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	leaf, err := signLeaf(primary, sans, privateKey.Public(), validityDays)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate serial number: %w", err)
+		return "", err
 	}
 
-	template := &x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{"InstantTLS"},
-			CommonName:   domain,
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(0, 0, CertValidityDays),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
+	ips := make([]string, len(sans.IPAddresses))
+	for i, ip := range sans.IPAddresses {
+		ips[i] = ip.String()
 	}
 
-	// Handle wildcard and regular domains
-	if strings.HasPrefix(domain, "*.") {
-		baseDomain := strings.TrimPrefix(domain, "*.")
-		template.DNSNames = []string{domain, baseDomain}
-	} else {
-		template.DNSNames = []string{domain}
-		// Check if it's an IP address
-		if ip := net.ParseIP(domain); ip != nil {
-			template.IPAddresses = []net.IP{ip}
-			template.DNSNames = nil
+	// Let the API count it before it can be used
+	if report != nil {
+		if err := report(IssuanceReport{
+			SerialNumber: leaf.cert.SerialNumber.Text(16),
+			CommonName:   primary,
+			DNSNames:     sans.DNSNames,
+			IPAddresses:  ips,
+			NotBefore:    leaf.cert.NotBefore,
+			NotAfter:     leaf.cert.NotAfter,
+			Replaces:     previousSerial(certDir),
+		}); err != nil {
+			return "", err
 		}
 	}
 
-	// Sign the certificate
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, &privateKey.PublicKey, caKey)
-	if err != nil {
-		return "", fmt.Errorf("failed to create certificate: %w", err)
+	// Save manifest
+
+	meta := &Metadata{
+		Version:           metadataVersion,
+		CommonName:        primary,
+		DNSNames:          sans.DNSNames,
+		IPAddresses:       ips,
+		KeyType:           keyType,
+		ValidityDays:      validityDays,
+		SerialNumber:      leaf.cert.SerialNumber.Text(16),
+		NotBefore:         leaf.cert.NotBefore,
+		NotAfter:          leaf.cert.NotAfter,
+		IssuerFingerprint: leaf.issuerFingerprint,
+		RootFingerprint:   leaf.rootFingerprint,
+		CreatedAt:         leaf.cert.NotBefore,
+		CLIVersion:        version.Version,
+	}
+	if err := writeCertFiles(certDir, leaf.chain, privateKey, meta); err != nil {
+		return "", err
 	}
 
-	// Save certificate
-	certPath := filepath.Join(certDir, "cert.pem")
-	certFile, err := os.Create(certPath)
+	return certDir, nil
+}
+
+// previousSerial returns the serial number of the certificate already in
+// certDir, which a new one for the same names replaces
+func previousSerial(certDir string) string {
+	meta, err := ReadMetadata(certDir)
 	if err != nil {
-		return "", fmt.Errorf("failed to create certificate file: %w", err)
+		return ""
 	}
-	defer certFile.Close()
+	return meta.SerialNumber
+}
 
-	if err := pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		return "", fmt.Errorf("failed to write certificate: %w", err)
+// writeCertFiles saves an issued certificate: the leaf, the chain servers
+// should present (leaf plus intermediate, if any), its key and its manifest
+func writeCertFiles(certDir string, chain [][]byte, key crypto.Signer, meta *Metadata) error {
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cert directory: %w", err)
 	}
 
-	// Save private key
-	keyPath := filepath.Join(certDir, "key.pem")
-	keyFile, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return "", fmt.Errorf("failed to create key file: %w", err)
+	if err := writeCertPEM(filepath.Join(certDir, "cert.pem"), chain[0]); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
 	}
-	defer keyFile.Close()
 
-	keyBytes := x509.MarshalPKCS1PrivateKey(privateKey)
-	if err := pem.Encode(keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: keyBytes}); err != nil {
-		return "", fmt.Errorf("failed to write key: %w", err)
+	if err := writeCertPEM(filepath.Join(certDir, "fullchain.pem"), chain...); err != nil {
+		return fmt.Errorf("failed to write certificate chain: %w", err)
 	}
 
-	return certDir, nil
+	if err := writePrivateKey(filepath.Join(certDir, "key.pem"), key, nil); err != nil {
+		return err
+	}
+
+	return writeMetadata(certDir, meta)
 }
 
-// ListCerts returns all generated certificates
+// ListCerts returns all generated certificates. The meta.json manifest is the
+// source of truth; certificates issued before manifests existed are described
+// from their cert.pem instead.
 func ListCerts() ([]CertInfo, error) {
 	certsDir := config.GetCertsDir()
 	var certs []CertInfo
@@ -256,51 +379,76 @@ func ListCerts() ([]CertInfo, error) {
 			continue
 		}
 
-		certPath := filepath.Join(certsDir, entry.Name(), "cert.pem")
-		certPEM, err := os.ReadFile(certPath)
+		info, err := ReadCertInfo(filepath.Join(certsDir, entry.Name()))
 		if err != nil {
 			continue
 		}
+		certs = append(certs, *info)
+	}
 
-		certBlock, _ := pem.Decode(certPEM)
-		if certBlock == nil {
-			continue
-		}
+	return certs, nil
+}
 
-		cert, err := x509.ParseCertificate(certBlock.Bytes)
-		if err != nil {
-			continue
-		}
+// ReadCertInfo describes the certificate in certDir, from its manifest if
+// it has one
+func ReadCertInfo(certDir string) (*CertInfo, error) {
+	meta, err := ReadMetadata(certDir)
+	if err != nil {
+		return legacyCertInfo(certDir)
+	}
+
+	return &CertInfo{
+		Domain:    meta.CommonName,
+		Names:     meta.Names(),
+		KeyType:   meta.KeyType,
+		NotBefore: meta.NotBefore,
+		NotAfter:  meta.NotAfter,
+		Path:      certDir,
+		Meta:      meta,
+	}, nil
+}
 
-		certs = append(certs, CertInfo{
-			Domain:    entry.Name(),
-			NotBefore: cert.NotBefore,
-			NotAfter:  cert.NotAfter,
-			Path:      filepath.Join(certsDir, entry.Name()),
-		})
+// legacyCertInfo describes a certificate directory without a manifest
+func legacyCertInfo(certDir string) (*CertInfo, error) {
+	certPEM, err := os.ReadFile(filepath.Join(certDir, "cert.pem"))
+	if err != nil {
+		return nil, err
 	}
 
-	return certs, nil
-}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("failed to decode certificate PEM")
+	}
 
-// CountWildcardCerts returns the number of wildcard certificates
-func CountWildcardCerts() int {
-	certs, err := ListCerts()
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
 	if err != nil {
-		return 0
+		return nil, err
 	}
 
-	count := 0
-	for _, cert := range certs {
-		if strings.HasPrefix(cert.Domain, "_") { // sanitized wildcard
-			count++
-		}
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	domain := cert.Subject.CommonName
+	if domain == "" && len(names) > 0 {
+		domain = names[0]
 	}
-	return count
+
+	return &CertInfo{
+		Domain:    domain,
+		Names:     names,
+		KeyType:   KeyTypeOf(cert.PublicKey),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Path:      certDir,
+	}, nil
 }
 
-// RenewExpiring renews certificates expiring within the given days
-func RenewExpiring(daysThreshold int) ([]string, error) {
+// RenewExpiring renews certificates expiring within the given days. Local
+// renewals are reported through report and those signed by the API are
+// renewed through sign, as for GenerateCert and GenerateRemoteCert.
+func RenewExpiring(daysThreshold int, report IssuanceReporter, sign RemoteSigner) ([]string, error) {
 	certs, err := ListCerts()
 	if err != nil {
 		return nil, err
@@ -311,12 +459,18 @@ func RenewExpiring(daysThreshold int) ([]string, error) {
 
 	for _, cert := range certs {
 		if cert.NotAfter.Before(threshold) {
-			// Re-generate the certificate
-			domain := unsanitizeDomain(cert.Domain)
-			if _, err := GenerateCert(domain); err != nil {
-				return renewed, fmt.Errorf("failed to renew %s: %w", domain, err)
+			// Re-generate the certificate exactly as it was requested
+			req := cert.Request()
+			var err error
+			if req.Remote {
+				_, err = GenerateRemoteCert(req, sign)
+			} else {
+				_, err = GenerateCert(req, report)
 			}
-			renewed = append(renewed, domain)
+			if err != nil {
+				return renewed, fmt.Errorf("failed to renew %s: %w", cert.Domain, err)
+			}
+			renewed = append(renewed, cert.Domain)
 		}
 	}
 
@@ -325,9 +479,25 @@ func RenewExpiring(daysThreshold int) ([]string, error) {
 
 type CertInfo struct {
 	Domain    string
+	Names     []string
+	KeyType   KeyType
 	NotBefore time.Time
 	NotAfter  time.Time
 	Path      string
+	Meta      *Metadata // nil for certificates issued without a manifest
+}
+
+// Request returns the CertRequest that reissues this certificate
+func (c CertInfo) Request() CertRequest {
+	if c.Meta != nil {
+		return c.Meta.Request()
+	}
+
+	return CertRequest{
+		Names:        append([]string{c.Domain}, c.Names...),
+		KeyType:      c.KeyType,
+		ValidityDays: int(c.NotAfter.Sub(c.NotBefore).Round(24*time.Hour).Hours() / 24),
+	}
 }
 
 func sanitizeDomain(domain string) string {
@@ -338,10 +508,17 @@ func sanitizeDomain(domain string) string {
 	return reg.ReplaceAllString(sanitized, "_")
 }
 
-func unsanitizeDomain(sanitized string) string {
-	// Replace leading _ back to *
-	if strings.HasPrefix(sanitized, "_") {
-		return "*" + strings.TrimPrefix(sanitized, "_")
+// primaryName returns the normalized form of the first requested name
+func primaryName(names []string, sans *SANs) string {
+	for _, raw := range names {
+		name := strings.TrimSpace(raw)
+		if name == "" {
+			continue
+		}
+		if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+			return ip.String()
+		}
+		return strings.TrimSuffix(strings.ToLower(name), ".")
 	}
-	return sanitized
+	return sans.Names()[0]
 }
@@ -0,0 +1,145 @@
+package cert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DefaultPermittedDomains are the DNS subtrees a constrained CA may issue for.
+// None of them can ever be registered on the public internet.
+var DefaultPermittedDomains = []string{"test", "local", "localhost", "internal"}
+
+// DefaultPermittedIPRanges are loopback and private address ranges
+var DefaultPermittedIPRanges = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+}
+
+// NameConstraints limits which names a CA can sign certificates for, so a
+// leaked CA key cannot be used to impersonate real domains
+type NameConstraints struct {
+	PermittedDNSDomains []string
+	PermittedIPRanges   []*net.IPNet
+}
+
+// NewNameConstraints builds constraints from domain suffixes and CIDR ranges,
+// falling back to the defaults for whichever list is empty
+func NewNameConstraints(domains, cidrs []string) (*NameConstraints, error) {
+	if len(domains) == 0 {
+		domains = DefaultPermittedDomains
+	}
+	if len(cidrs) == 0 {
+		cidrs = DefaultPermittedIPRanges
+	}
+
+	nc := &NameConstraints{}
+
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if err := validateDNSName(strings.TrimPrefix(d, ".")); err != nil || strings.Contains(d, "*") {
+			return nil, fmt.Errorf("invalid permitted domain %q", d)
+		}
+		nc.PermittedDNSDomains = append(nc.PermittedDNSDomains, d)
+	}
+
+	for _, c := range cidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return nil, fmt.Errorf("invalid permitted IP range %q: %w", c, err)
+		}
+		nc.PermittedIPRanges = append(nc.PermittedIPRanges, ipNet)
+	}
+
+	return nc, nil
+}
+
+// apply copies the constraints onto a CA certificate template
+func (nc *NameConstraints) apply(template *x509.Certificate) {
+	if nc == nil {
+		return
+	}
+	template.PermittedDNSDomainsCritical = true
+	template.PermittedDNSDomains = nc.PermittedDNSDomains
+	template.PermittedIPRanges = nc.PermittedIPRanges
+}
+
+// constraintsOf extracts the name constraints of an existing CA certificate
+func constraintsOf(ca *x509.Certificate) *NameConstraints {
+	if len(ca.PermittedDNSDomains) == 0 && len(ca.PermittedIPRanges) == 0 {
+		return nil
+	}
+	return &NameConstraints{
+		PermittedDNSDomains: ca.PermittedDNSDomains,
+		PermittedIPRanges:   ca.PermittedIPRanges,
+	}
+}
+
+// checkNameConstraints refuses SANs that a CA's name constraints would make
+// clients reject, instead of issuing a certificate that can never validate
+func checkNameConstraints(ca *x509.Certificate, sans *SANs) error {
+	if len(ca.PermittedDNSDomains) > 0 {
+		for _, name := range sans.DNSNames {
+			if !dnsNamePermitted(name, ca.PermittedDNSDomains) {
+				return fmt.Errorf(
+					"%q is outside this CA's name constraints (permitted domains: %s)",
+					name, strings.Join(ca.PermittedDNSDomains, ", "),
+				)
+			}
+		}
+	}
+
+	if len(ca.PermittedIPRanges) > 0 {
+		for _, ip := range sans.IPAddresses {
+			if !ipPermitted(ip, ca.PermittedIPRanges) {
+				ranges := make([]string, len(ca.PermittedIPRanges))
+				for i, r := range ca.PermittedIPRanges {
+					ranges[i] = r.String()
+				}
+				return fmt.Errorf(
+					"%s is outside this CA's name constraints (permitted IP ranges: %s)",
+					ip, strings.Join(ranges, ", "),
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// dnsNamePermitted follows RFC 5280: "example.test" permits the domain and
+// all of its subdomains, ".example.test" only its subdomains
+func dnsNamePermitted(name string, permitted []string) bool {
+	// A wildcard stands for any single label below its parent
+	if strings.HasPrefix(name, "*.") {
+		name = "x" + strings.TrimPrefix(name, "*")
+	}
+
+	for _, p := range permitted {
+		p = strings.ToLower(p)
+		if strings.HasPrefix(p, ".") {
+			if strings.HasSuffix(name, p) {
+				return true
+			}
+			continue
+		}
+		if name == p || strings.HasSuffix(name, "."+p) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipPermitted(ip net.IP, permitted []*net.IPNet) bool {
+	for _, r := range permitted {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+// FindCert returns the certificate whose common name is name, or failing
+// that the first one covering name as a SAN
+func FindCert(name string) (*CertInfo, error) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+
+	certs, err := ListCerts()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range certs {
+		if strings.EqualFold(certs[i].Domain, name) {
+			return &certs[i], nil
+		}
+	}
+	for i := range certs {
+		for _, n := range certs[i].Names {
+			if strings.EqualFold(n, name) {
+				return &certs[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no certificate for %s (run 'instanttls cert %s' first)", name, name)
+}
+
+// ReadPEM returns the certificate chain servers should present and the
+// private key, as GenerateCert wrote them. Certificates issued before
+// fullchain.pem existed were signed by the root, so cert.pem is the chain.
+func (c CertInfo) ReadPEM() (chainPEM, keyPEM []byte, err error) {
+	chainPEM, err = os.ReadFile(filepath.Join(c.Path, "fullchain.pem"))
+	if os.IsNotExist(err) {
+		chainPEM, err = os.ReadFile(filepath.Join(c.Path, "cert.pem"))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	keyPEM, err = os.ReadFile(filepath.Join(c.Path, "key.pem"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	return chainPEM, keyPEM, nil
+}
+
+// RootPEM returns the root CA certificate that trust stores hold
+func RootPEM() ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(config.GetCADir(), RootCertFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	return data, nil
+}
+
+// ExportSigningCA returns the CA that LoadCA signs with, for handing to
+// another issuer such as cert-manager: its certificate followed by the
+// root when it is an intermediate, and its key as unencrypted PKCS#8.
+// Encrypted keys are unlocked through the passphrase source.
+func ExportSigningCA() (chainPEM, keyPEM []byte, err error) {
+	caCert, caKey, err := LoadCA()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chainPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	if !isSelfSigned(caCert) {
+		root, err := RootPEM()
+		if err != nil {
+			return nil, nil, err
+		}
+		chainPEM = append(chainPEM, root...)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode CA key: %w", err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return chainPEM, keyPEM, nil
+}
+
+// ExportFormat is a file format issued certificates can be exported in
+type ExportFormat string
+
+const (
+	// FormatP12 is a password-protected PKCS#12 (.p12/.pfx) file with the
+	// key and chain, for .NET, Windows and Java
+	FormatP12 ExportFormat = "p12"
+	// FormatJKS is a password-protected Java keystore, for Tomcat
+	FormatJKS ExportFormat = "jks"
+	// FormatDER is the leaf certificate alone, DER encoded
+	FormatDER ExportFormat = "der"
+	// FormatPEMBundle is the chain followed by the key in one PEM file, for
+	// HAProxy
+	FormatPEMBundle ExportFormat = "pem-bundle"
+	// FormatPKCS8 is the key as PKCS#8 PEM, encrypted if given a password
+	FormatPKCS8 ExportFormat = "pkcs8"
+)
+
+// ExportFormats lists every export format in display order
+var ExportFormats = []ExportFormat{FormatP12, FormatJKS, FormatDER, FormatPEMBundle, FormatPKCS8}
+
+// ParseExportFormat validates a --format flag value. "pfx" is accepted for
+// p12.
+func ParseExportFormat(s string) (ExportFormat, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "pfx" {
+		return FormatP12, nil
+	}
+	for _, f := range ExportFormats {
+		if s == string(f) {
+			return f, nil
+		}
+	}
+
+	names := make([]string, len(ExportFormats))
+	for i, f := range ExportFormats {
+		names[i] = string(f)
+	}
+	return "", fmt.Errorf("unsupported format %q (supported: %s)", s, strings.Join(names, ", "))
+}
+
+// FileName is the name an export is saved under in the certificate's
+// directory
+func (f ExportFormat) FileName() string {
+	switch f {
+	case FormatP12:
+		return "cert.p12"
+	case FormatJKS:
+		return "keystore.jks"
+	case FormatDER:
+		return "cert.der"
+	case FormatPEMBundle:
+		return "bundle.pem"
+	case FormatPKCS8:
+		return "key.pkcs8.pem"
+	}
+	return ""
+}
+
+// NeedsPassword reports whether the format cannot be written without a
+// password
+func (f ExportFormat) NeedsPassword() bool {
+	return f == FormatP12 || f == FormatJKS
+}
+
+// Export writes the certificate in format to path, or to the format's file
+// name in the certificate's directory if path is empty, and returns the
+// path written. Files holding the key are readable by the user only.
+func (c CertInfo) Export(format ExportFormat, path string, password []byte) (string, error) {
+	if format.NeedsPassword() && len(password) == 0 {
+		return "", fmt.Errorf("%s export needs a password", format)
+	}
+	if path == "" {
+		path = filepath.Join(c.Path, format.FileName())
+	}
+
+	chainPEM, keyPEM, err := c.ReadPEM()
+	if err != nil {
+		return "", err
+	}
+
+	var chain []*x509.Certificate
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return "", fmt.Errorf("no certificate found in %s", c.Path)
+	}
+
+	key, err := parsePrivateKey(keyPEM, filepath.Join(c.Path, "key.pem"))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	var data []byte
+	mode := os.FileMode(0600)
+	switch format {
+	case FormatP12:
+		data, err = encodePKCS12(key, chain, c.Domain, password)
+	case FormatJKS:
+		data, err = encodeJKS(key, chain, c.Domain, password)
+	case FormatDER:
+		data, mode = chain[0].Raw, 0644
+	case FormatPEMBundle:
+		data = append(append([]byte{}, chainPEM...), keyPEM...)
+	case FormatPKCS8:
+		data, err = encodePKCS8PEM(key, password)
+	default:
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %w", format, err)
+	}
+
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// encodePKCS8PEM encodes a key as PKCS#8 PEM, encrypted when a password is
+// given
+func encodePKCS8PEM(key crypto.Signer, password []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	der, err = encryptPKCS8(der, password)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: encryptedKeyBlockType, Bytes: der}), nil
+}
@@ -0,0 +1,153 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+const (
+	RootCertFile         = "ca.crt"
+	RootKeyFile          = "ca.key"
+	IntermediateCertFile = "intermediate.crt"
+	IntermediateKeyFile  = "intermediate.key"
+
+	IntermediateValidityDays = 1825 // 5 years
+)
+
+// HasIntermediate reports whether an intermediate CA is available for signing
+func HasIntermediate() bool {
+	caDir := config.GetCADir()
+
+	_, certErr := os.Stat(filepath.Join(caDir, IntermediateCertFile))
+	_, keyErr := os.Stat(filepath.Join(caDir, IntermediateKeyFile))
+
+	return certErr == nil && keyErr == nil
+}
+
+// LoadIntermediate returns the current intermediate certificate, if any
+func LoadIntermediate() (*x509.Certificate, error) {
+	return loadCertFile(filepath.Join(config.GetCADir(), IntermediateCertFile))
+}
+
+// RotateIntermediate issues a new intermediate CA from the existing root.
+// Certificates issued by the previous intermediate keep working because they
+// still chain to the same trusted root. rootKeyPath may point at a root key
+// that is kept outside ~/.instanttls; keyType defaults to the current
+// intermediate's key type.
+func RotateIntermediate(rootKeyPath string, keyType KeyType) (*x509.Certificate, error) {
+	rootCert, rootKey, err := LoadRootCA(rootKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("root CA key is required to rotate the intermediate: %w", err)
+	}
+
+	if keyType == "" {
+		keyType = KeyTypeOf(rootCert.PublicKey)
+		if current, err := LoadIntermediate(); err == nil {
+			keyType = KeyTypeOf(current.PublicKey)
+		}
+	}
+
+	// Keep the new intermediate key encrypted if the CA keys are encrypted
+	passphrase, err := caKeyPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := generateIntermediate(rootCert, rootKey, keyType, passphrase); err != nil {
+		return nil, err
+	}
+
+	return LoadIntermediate()
+}
+
+// generateIntermediate issues an intermediate CA signed by the root and
+// writes it to the CA directory, encrypting its key if a passphrase is given
+func generateIntermediate(rootCert *x509.Certificate, rootKey crypto.Signer, keyType KeyType, passphrase []byte) error {
+	caDir := config.GetCADir()
+
+	privateKey, err := GenerateKey(keyType)
+	if err != nil {
+		return fmt.Errorf("failed to generate intermediate key: %w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"InstantTLS Local CA"},
+			CommonName:   "InstantTLS Intermediate CA " + now.Format("2006-01-02"),
+		},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, IntermediateValidityDays),
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+	}
+
+	// The intermediate carries the same name constraints as its root
+	constraintsOf(rootCert).apply(template)
+
+	if template.NotAfter.After(rootCert.NotAfter) {
+		template.NotAfter = rootCert.NotAfter
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, rootCert, privateKey.Public(), rootKey)
+	if err != nil {
+		return fmt.Errorf("failed to create intermediate certificate: %w", err)
+	}
+
+	// Both files are written in full under temporary names before either
+	// replaces the current pair, so a failure while writing leaves the old
+	// intermediate and its key in place. Only a crash between the two
+	// renames can split the pair, which loading the CA then reports.
+	keyPath := filepath.Join(caDir, IntermediateKeyFile)
+	certPath := filepath.Join(caDir, IntermediateCertFile)
+	defer os.Remove(keyPath + ".new")
+	defer os.Remove(certPath + ".new")
+
+	if err := writePrivateKey(keyPath+".new", privateKey, passphrase); err != nil {
+		return fmt.Errorf("failed to save intermediate key: %w", err)
+	}
+	if err := writeCertPEM(certPath+".new", derBytes); err != nil {
+		return fmt.Errorf("failed to save intermediate certificate: %w", err)
+	}
+
+	if err := os.Rename(keyPath+".new", keyPath); err != nil {
+		return fmt.Errorf("failed to save intermediate key: %w", err)
+	}
+	if err := os.Rename(certPath+".new", certPath); err != nil {
+		return fmt.Errorf("failed to save intermediate certificate: %w", err)
+	}
+
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func publicKeyBytes(pub crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+	return der
+}
@@ -0,0 +1,64 @@
+package cert
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+func TestRotateIntermediate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cachedPassphrase = nil
+
+	if err := GenerateCA(CAOptions{KeyType: KeyTypeECDSAP256}); err != nil {
+		t.Fatal(err)
+	}
+	old, oldKey, err := LoadCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caDir := config.GetCADir()
+	oldKeyPEM, err := os.ReadFile(filepath.Join(caDir, IntermediateKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := RotateIntermediate("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.SerialNumber.Cmp(old.SerialNumber) == 0 {
+		t.Fatal("intermediate was not replaced")
+	}
+
+	current, key, err := LoadCA()
+	if err != nil {
+		t.Fatalf("CA after rotation: %v", err)
+	}
+	if !current.Equal(rotated) || bytes.Equal(publicKeyBytes(key.Public()), publicKeyBytes(oldKey.Public())) {
+		t.Error("the new intermediate and its key were not both put in place")
+	}
+
+	entries, err := os.ReadDir(caDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".new") {
+			t.Errorf("temporary file %s was left behind", e.Name())
+		}
+	}
+
+	// A pair split by a crash between the renames is refused rather than
+	// signing with a key the certificate does not name
+	if err := os.WriteFile(filepath.Join(caDir, IntermediateKeyFile), oldKeyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadCA(); err == nil {
+		t.Error("loaded an intermediate certificate with another key")
+	}
+}
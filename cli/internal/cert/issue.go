@@ -0,0 +1,266 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+// issuedLeaf is a freshly signed leaf certificate
+type issuedLeaf struct {
+	cert *x509.Certificate
+	// chain is the leaf followed by the intermediate, if any, in DER form
+	chain             [][]byte
+	issuerFingerprint string
+	rootFingerprint   string
+}
+
+// signLeaf signs pub for the given names with the current CA. Every way of
+// obtaining a leaf (the cert command, CSRs, on-demand TLS) goes through here
+// so they all get the same profile and the same name constraint checks.
+func signLeaf(primary string, sans *SANs, pub crypto.PublicKey, validityDays int) (*issuedLeaf, error) {
+	if validityDays <= 0 {
+		validityDays = CertValidityDays
+	}
+
+	if !CAExists() {
+		return nil, fmt.Errorf("CA not found. Run 'instanttls init' first")
+	}
+
+	caCert, caKey, err := LoadCA()
+	if err != nil {
+		return nil, err
+	}
+
+	rootCert, err := checkIssuable(caCert, sans)
+	if err != nil {
+		return nil, err
+	}
+
+	keyType := KeyTypeOf(pub)
+	if keyType == "" {
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"InstantTLS"},
+			CommonName:   primary,
+		},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, validityDays),
+		KeyUsage:              leafKeyUsage(keyType),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              sans.DNSNames,
+		IPAddresses:           sans.IPAddresses,
+	}
+
+	// A leaf cannot outlive the CA that signs it
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, pub, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	leafCert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	chain := [][]byte{derBytes}
+	if !isSelfSigned(caCert) {
+		chain = append(chain, caCert.Raw)
+	}
+
+	return &issuedLeaf{
+		cert:              leafCert,
+		chain:             chain,
+		issuerFingerprint: Fingerprint(caCert),
+		rootFingerprint:   Fingerprint(rootCert),
+	}, nil
+}
+
+// checkIssuable refuses names that the signing CA or its root are not allowed
+// to sign, and returns the root certificate
+func checkIssuable(caCert *x509.Certificate, sans *SANs) (*x509.Certificate, error) {
+	rootCert, err := loadCertFile(filepath.Join(config.GetCADir(), RootCertFile))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ca := range []*x509.Certificate{caCert, rootCert} {
+		if err := checkNameConstraints(ca, sans); err != nil {
+			return nil, err
+		}
+	}
+
+	return rootCert, nil
+}
+
+// CheckNames reports whether the local CA could issue a certificate for the
+// given names, without needing to unlock the CA key
+func CheckNames(names []string) error {
+	sans, err := exactSANs(names)
+	if err != nil {
+		return err
+	}
+
+	caCert, err := LoadIntermediate()
+	if err != nil {
+		caCert, err = loadCertFile(filepath.Join(config.GetCADir(), RootCertFile))
+		if err != nil {
+			return fmt.Errorf("CA not found. Run 'instanttls init' first")
+		}
+	}
+
+	_, err = checkIssuable(caCert, sans)
+	return err
+}
+
+// SignCSR issues a certificate for the key and names in a certificate signing
+// request. Unlike GenerateCert, the names are used exactly as requested and
+// nothing is written to disk. Like GenerateCert, it is reported through
+// report before it is returned, and wildcards are refused without one. The
+// returned chain is the leaf followed by the intermediate.
+func SignCSR(csr *x509.CertificateRequest, validityDays int, report IssuanceReporter) ([][]byte, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+
+	names := CSRNames(csr)
+	sans, err := exactSANs(names)
+	if err != nil {
+		return nil, err
+	}
+
+	if report == nil && sans.HasWildcard() {
+		return nil, fmt.Errorf("wildcard certificates count toward your plan and must be reported to the API; run 'instanttls login' first")
+	}
+
+	leaf, err := signLeaf(names[0], sans, csr.PublicKey, validityDays)
+	if err != nil {
+		return nil, err
+	}
+
+	if report != nil {
+		ips := make([]string, len(sans.IPAddresses))
+		for i, ip := range sans.IPAddresses {
+			ips[i] = ip.String()
+		}
+		if err := report(IssuanceReport{
+			SerialNumber: leaf.cert.SerialNumber.Text(16),
+			CommonName:   names[0],
+			DNSNames:     sans.DNSNames,
+			IPAddresses:  ips,
+			NotBefore:    leaf.cert.NotBefore,
+			NotAfter:     leaf.cert.NotAfter,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return leaf.chain, nil
+}
+
+// CSRNames returns the names a CSR asks for: its SANs, or the common name if
+// it has none
+func CSRNames(csr *x509.CertificateRequest) []string {
+	var names []string
+	names = append(names, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && csr.Subject.CommonName != "" {
+		names = append(names, csr.Subject.CommonName)
+	}
+	return names
+}
+
+// IssueTLSCertificate issues a short-lived in-memory certificate for the local
+// servers InstantTLS runs itself
+func IssueTLSCertificate(names []string, validityDays int) (*tls.Certificate, error) {
+	sans, err := ParseSANs(names)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := GenerateKey(KeyTypeECDSAP256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	leaf, err := signLeaf(primaryName(names, sans), sans, key.Public(), validityDays)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: leaf.chain,
+		PrivateKey:  key,
+		Leaf:        leaf.cert,
+	}, nil
+}
+
+// IssueTestCertificate issues a throwaway one-day certificate for checking
+// that clients trust the CA. Its name is picked to satisfy the CA's name
+// constraints.
+func IssueTestCertificate() (*tls.Certificate, error) {
+	rootCert, err := loadCertFile(filepath.Join(config.GetCADir(), RootCertFile))
+	if err != nil {
+		return nil, err
+	}
+
+	name := "localhost"
+	switch {
+	case len(rootCert.PermittedDNSDomains) > 0:
+		name = "instanttls-check." + strings.TrimPrefix(rootCert.PermittedDNSDomains[0], ".")
+	case len(rootCert.PermittedIPRanges) > 0:
+		name = rootCert.PermittedIPRanges[0].IP.String()
+	}
+
+	return IssueTLSCertificate([]string{name}, 1)
+}
+
+// exactSANs parses names like ParseSANs but without adding wildcard apexes,
+// for callers that must issue exactly what was asked for
+func exactSANs(names []string) (*SANs, error) {
+	sans, err := ParseSANs(names)
+	if err != nil {
+		return nil, err
+	}
+
+	requested := make(map[string]bool)
+	for _, name := range names {
+		requested[strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")] = true
+	}
+
+	var dnsNames []string
+	for _, name := range sans.DNSNames {
+		if requested[name] || !sans.impliedApex(name) {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	sans.DNSNames = dnsNames
+
+	return sans, nil
+}
@@ -0,0 +1,137 @@
+package cert
+
+import (
+	"crypto"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+const encryptedKeyBlockType = "ENCRYPTED PRIVATE KEY"
+
+// PassphraseFunc supplies the passphrase protecting an encrypted CA key.
+// keyPath identifies the key being unlocked.
+type PassphraseFunc func(keyPath string) ([]byte, error)
+
+var (
+	passphraseSource PassphraseFunc
+	// cachedPassphrase holds the passphrase once a CA key has been unlocked,
+	// so commands that sign many certificates only ask for it once
+	cachedPassphrase []byte
+)
+
+// SetPassphraseSource installs the function used to unlock encrypted CA keys
+func SetPassphraseSource(fn PassphraseFunc) {
+	passphraseSource = fn
+}
+
+// CAKeyEncrypted reports whether any CA key on disk is passphrase-protected
+func CAKeyEncrypted() bool {
+	caDir := config.GetCADir()
+	return isEncryptedKeyFile(filepath.Join(caDir, RootKeyFile)) ||
+		isEncryptedKeyFile(filepath.Join(caDir, IntermediateKeyFile))
+}
+
+// SetCAKeyPassphrase re-writes the CA keys on disk encrypted with a new
+// passphrase, or unencrypted if passphrase is nil. Keys that are not present
+// (such as a root key kept offline) are left alone.
+func SetCAKeyPassphrase(passphrase []byte) error {
+	caDir := config.GetCADir()
+
+	type keyFile struct {
+		path string
+		key  crypto.Signer
+	}
+
+	// Unlock everything first so a wrong passphrase changes nothing
+	var keys []keyFile
+	for _, name := range []string{RootKeyFile, IntermediateKeyFile} {
+		path := filepath.Join(caDir, name)
+		keyPEM, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		key, err := parsePrivateKey(keyPEM, path)
+		if err != nil {
+			return fmt.Errorf("failed to unlock %s: %w", name, err)
+		}
+		keys = append(keys, keyFile{path: path, key: key})
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no CA keys found in %s", caDir)
+	}
+
+	for _, k := range keys {
+		if err := writePrivateKey(k.path, k.key, passphrase); err != nil {
+			return err
+		}
+	}
+
+	cachedPassphrase = passphrase
+	return nil
+}
+
+// caKeyPassphrase returns the passphrase new CA keys should be encrypted
+// with, or nil if the existing CA keys are stored unencrypted
+func caKeyPassphrase() ([]byte, error) {
+	if cachedPassphrase != nil {
+		return cachedPassphrase, nil
+	}
+	if !CAKeyEncrypted() {
+		return nil, nil
+	}
+
+	// Unlock the current signing key to obtain and verify the passphrase
+	if _, _, err := LoadCA(); err != nil {
+		return nil, err
+	}
+	return cachedPassphrase, nil
+}
+
+// unlockKey decrypts an encrypted PKCS#8 key, asking the passphrase source
+// only if the cached passphrase does not fit
+func unlockKey(der []byte, keyPath string) ([]byte, error) {
+	if cachedPassphrase != nil {
+		if plain, err := decryptPKCS8(der, cachedPassphrase); err == nil {
+			return plain, nil
+		}
+	}
+
+	if passphraseSource == nil {
+		return nil, fmt.Errorf("%s is encrypted and no passphrase is available", filepath.Base(keyPath))
+	}
+
+	passphrase, err := passphraseSource(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := decryptPKCS8(der, passphrase)
+	if err != nil {
+		if errors.Is(err, ErrIncorrectPassphrase) {
+			return nil, fmt.Errorf("failed to unlock %s: %w", filepath.Base(keyPath), err)
+		}
+		return nil, err
+	}
+
+	cachedPassphrase = passphrase
+	return plain, nil
+}
+
+func isEncryptedKeyFile(path string) bool {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(keyPEM)
+	return block != nil && block.Type == encryptedKeyBlockType
+}
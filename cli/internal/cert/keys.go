@@ -0,0 +1,168 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyType identifies the algorithm and size of a CA or leaf private key
+type KeyType string
+
+const (
+	KeyTypeRSA2048   KeyType = "rsa2048"
+	KeyTypeRSA4096   KeyType = "rsa4096"
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeECDSAP384 KeyType = "ecdsa-p384"
+	KeyTypeEd25519   KeyType = "ed25519"
+
+	DefaultKeyType = KeyTypeRSA2048
+)
+
+// KeyTypes lists every supported key type in display order
+var KeyTypes = []KeyType{
+	KeyTypeRSA2048,
+	KeyTypeRSA4096,
+	KeyTypeECDSAP256,
+	KeyTypeECDSAP384,
+	KeyTypeEd25519,
+}
+
+// ParseKeyType validates a --key-type flag value
+func ParseKeyType(s string) (KeyType, error) {
+	if s == "" {
+		return DefaultKeyType, nil
+	}
+
+	for _, kt := range KeyTypes {
+		if strings.EqualFold(s, string(kt)) {
+			return kt, nil
+		}
+	}
+
+	names := make([]string, len(KeyTypes))
+	for i, kt := range KeyTypes {
+		names[i] = string(kt)
+	}
+	return "", fmt.Errorf("unsupported key type %q (supported: %s)", s, strings.Join(names, ", "))
+}
+
+// GenerateKey creates a new private key of the given type
+func GenerateKey(kt KeyType) (crypto.Signer, error) {
+	switch kt {
+	case "", KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", kt)
+	}
+}
+
+// KeyTypeOf reports the key type of a public key
+func KeyTypeOf(pub crypto.PublicKey) KeyType {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() > 2048 {
+			return KeyTypeRSA4096
+		}
+		return KeyTypeRSA2048
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P384() {
+			return KeyTypeECDSAP384
+		}
+		return KeyTypeECDSAP256
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
+	default:
+		return ""
+	}
+}
+
+// leafKeyUsage returns the key usage bits appropriate for a leaf key. Key
+// encipherment only makes sense for RSA key exchange.
+func leafKeyUsage(kt KeyType) x509.KeyUsage {
+	if kt == KeyTypeRSA2048 || kt == KeyTypeRSA4096 {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+// writePrivateKey saves a private key as a PKCS#8 PEM file, encrypted with
+// the passphrase if one is given
+func writePrivateKey(path string, key crypto.Signer, passphrase []byte) error {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	blockType := "PRIVATE KEY"
+	if passphrase != nil {
+		keyBytes, err = encryptPKCS8(keyBytes, passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt private key: %w", err)
+		}
+		blockType = encryptedKeyBlockType
+	}
+
+	keyFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer keyFile.Close()
+
+	if err := pem.Encode(keyFile, &pem.Block{Type: blockType, Bytes: keyBytes}); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+
+	return nil
+}
+
+// parsePrivateKey decodes a PEM private key in PKCS#8, PKCS#1 or SEC 1 form.
+// Encrypted keys are unlocked through the configured passphrase source.
+func parsePrivateKey(keyPEM []byte, keyPath string) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode key PEM")
+	}
+
+	switch block.Type {
+	case encryptedKeyBlockType:
+		der, err := unlockKey(block.Bytes, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		return parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), keyPath)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
@@ -0,0 +1,222 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/instanttls/cli/internal/keystore"
+)
+
+// Keystores are written the way current tools write them: PKCS#12 as
+// OpenSSL 3 does (PBES2 with AES-256 for the key and certificates, an
+// HMAC-SHA256 MAC), which .NET, Java 8u301+ and Windows 10+ read; JKS as
+// keytool does, for Tomcat and older JDKs.
+
+// keystoreIterations matches OpenSSL's default for PKCS#12 files
+const keystoreIterations = 2048
+
+// oidJKSKeyProtector is Sun's proprietary private key protection algorithm,
+// the only one JKS keystores use
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// encodePKCS12 builds a PKCS#12 file holding key and its certificate chain,
+// leaf first, under alias. The leaf and key share a local key ID so that
+// importers pair them.
+func encodePKCS12(key crypto.Signer, chain []*x509.Certificate, alias string, password []byte) ([]byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	localKeyID := sha1.Sum(chain[0].Raw)
+	attrs, err := bagAttributes(alias, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificates go in an encrypted safe
+	var certBags []keystore.SafeBag
+	for i, c := range chain {
+		value, err := asn1.Marshal(keystore.CertBag{ID: keystore.OIDX509Certificate, Data: c.Raw})
+		if err != nil {
+			return nil, err
+		}
+		bag := keystore.SafeBag{ID: keystore.OIDCertBag, Value: keystore.ExplicitContent(value)}
+		if i == 0 {
+			bag.Attributes = attrs
+		}
+		certBags = append(certBags, bag)
+	}
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	alg, ciphertext, err := pbes2Encrypt(certContents, password, keystoreIterations)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := asn1.Marshal(keystore.EncryptedData{
+		EncryptedContentInfo: keystore.EncryptedContentInfo{
+			ContentType:                keystore.OIDDataContentType,
+			ContentEncryptionAlgorithm: alg,
+			EncryptedContent:           ciphertext,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	certSafe, err := asn1.Marshal(keystore.ContentInfo{ContentType: keystore.OIDEncryptedDataContentType, Content: keystore.ExplicitContent(encrypted)})
+	if err != nil {
+		return nil, err
+	}
+
+	// The key is encrypted in its own bag, inside a plain safe
+	alg, ciphertext, err = pbes2Encrypt(keyDER, password, keystoreIterations)
+	if err != nil {
+		return nil, err
+	}
+	shrouded, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	keyContents, err := asn1.Marshal([]keystore.SafeBag{{ID: keystore.OIDShroudedKeyBag, Value: keystore.ExplicitContent(shrouded), Attributes: attrs}})
+	if err != nil {
+		return nil, err
+	}
+	keySafe, err := keystore.DataContentInfo(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]asn1.RawValue{{FullBytes: certSafe}, {FullBytes: keySafe}})
+	if err != nil {
+		return nil, err
+	}
+	octets, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(keystore.PFXPDU{
+		Version:  3,
+		AuthSafe: keystore.ContentInfo{ContentType: keystore.OIDDataContentType, Content: keystore.ExplicitContent(octets)},
+		MacData: keystore.MacData{
+			Mac: keystore.DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: keystore.OIDSHA256, Parameters: asn1.NullRawValue},
+				Digest:    keystore.MAC(sha256.New, authSafe, string(password), salt, keystoreIterations),
+			},
+			MacSalt:    salt,
+			Iterations: keystoreIterations,
+		},
+	})
+}
+
+// bagAttributes returns the friendly name and local key ID attributes
+func bagAttributes(alias string, localKeyID []byte) ([]keystore.Attribute, error) {
+	name, err := keystore.FriendlyName(alias)
+	if err != nil {
+		return nil, err
+	}
+	id, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []keystore.Attribute{
+		name,
+		{ID: keystore.OIDLocalKeyID, Value: keystore.Set(id)},
+	}, nil
+}
+
+// encodeJKS builds a JKS keystore holding key and its certificate chain as
+// a single private key entry. The store and the key share the password, as
+// Tomcat expects by default.
+func encodeJKS(key crypto.Signer, chain []*x509.Certificate, alias string, password []byte) ([]byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	protected, err := jksProtectKey(keyDER, password)
+	if err != nil {
+		return nil, err
+	}
+	if len(alias) > 0xFFFF {
+		return nil, fmt.Errorf("alias too long for keystore")
+	}
+
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		buf.WriteString(s)
+	}
+
+	write(uint32(keystore.JKSMagic))
+	write(uint32(keystore.JKSVersion))
+	write(uint32(1)) // entries
+
+	write(uint32(keystore.JKSPrivateKeyTag))
+	writeUTF(alias)
+	write(time.Now().UnixMilli())
+	write(uint32(len(protected)))
+	buf.Write(protected)
+	write(uint32(len(chain)))
+	for _, c := range chain {
+		writeUTF("X.509")
+		write(uint32(len(c.Raw)))
+		buf.Write(c.Raw)
+	}
+
+	buf.Write(keystore.JKSDigest(buf.Bytes(), string(password)))
+
+	return buf.Bytes(), nil
+}
+
+// jksProtectKey encrypts a PKCS#8 key the way sun.security.provider's
+// KeyProtector does: XOR with a SHA-1 keystream seeded by a random salt,
+// followed by a SHA-1 check over the password and plaintext
+func jksProtectKey(keyDER, password []byte) ([]byte, error) {
+	pw := keystore.EncodeBMPString(string(password))
+
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	protected := append([]byte{}, salt...)
+	digest := salt
+	for i := 0; i < len(keyDER); i += sha1.Size {
+		h := sha1.New()
+		h.Write(pw)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(keyDER); j++ {
+			protected = append(protected, keyDER[i+j]^digest[j])
+		}
+	}
+
+	check := sha1.New()
+	check.Write(pw)
+	check.Write(keyDER)
+	protected = check.Sum(protected)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
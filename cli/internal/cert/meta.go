@@ -0,0 +1,112 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+const (
+	MetadataFile    = "meta.json"
+	metadataVersion = 1
+)
+
+// Metadata is the manifest stored next to every issued cert.pem. It records
+// everything needed to reissue the certificate exactly as it was requested.
+type Metadata struct {
+	Version           int       `json:"version"`
+	CommonName        string    `json:"common_name"`
+	DNSNames          []string  `json:"dns_names"`
+	IPAddresses       []string  `json:"ip_addresses"`
+	KeyType           KeyType   `json:"key_type"`
+	ValidityDays      int       `json:"validity_days"`
+	SerialNumber      string    `json:"serial_number"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	IssuerFingerprint string    `json:"issuer_fingerprint"`
+	RootFingerprint   string    `json:"root_fingerprint,omitempty"`
+	Remote            bool      `json:"remote,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	CLIVersion        string    `json:"cli_version"`
+}
+
+// Names returns every SAN in the manifest, DNS names first
+func (m *Metadata) Names() []string {
+	names := make([]string, 0, len(m.DNSNames)+len(m.IPAddresses))
+	names = append(names, m.DNSNames...)
+	names = append(names, m.IPAddresses...)
+	return names
+}
+
+// RootCA returns the fingerprint of the root the certificate chains to.
+// Manifests written before intermediates existed were signed by the root.
+func (m *Metadata) RootCA() string {
+	if m.RootFingerprint != "" {
+		return m.RootFingerprint
+	}
+	return m.IssuerFingerprint
+}
+
+// Request rebuilds the CertRequest that produced this certificate
+func (m *Metadata) Request() CertRequest {
+	return CertRequest{
+		Names:        append([]string{m.CommonName}, m.Names()...),
+		KeyType:      m.KeyType,
+		ValidityDays: m.ValidityDays,
+		Remote:       m.Remote,
+	}
+}
+
+// ReadMetadata loads the manifest from a certificate directory
+func ReadMetadata(certDir string) (*Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(certDir, MetadataFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", MetadataFile, err)
+	}
+
+	if meta.CommonName == "" || len(meta.Names()) == 0 {
+		return nil, fmt.Errorf("%s has no names", MetadataFile)
+	}
+
+	return &meta, nil
+}
+
+func writeMetadata(certDir string, meta *Metadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(certDir, MetadataFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", MetadataFile, err)
+	}
+
+	return nil
+}
+
+// Fingerprint returns the hex SHA-256 fingerprint of a certificate
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// CAFingerprint returns the fingerprint of the current root CA certificate
+func CAFingerprint() (string, error) {
+	caCert, err := loadCertFile(filepath.Join(config.GetCADir(), RootCertFile))
+	if err != nil {
+		return "", err
+	}
+	return Fingerprint(caCert), nil
+}
@@ -0,0 +1,189 @@
+package cert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"github.com/instanttls/cli/internal/keystore"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encrypted keys use the standard PKCS#8 "ENCRYPTED PRIVATE KEY" envelope
+// (PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC), so they can also be read by
+// openssl and other tools.
+
+const pbkdf2Iterations = 600000
+
+// maxPBKDF2Iterations bounds the work a key file can demand before its
+// passphrase is checked
+const maxPBKDF2Iterations = 10000000
+
+// ErrIncorrectPassphrase is returned when an encrypted key cannot be decrypted
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// encryptPKCS8 wraps a DER PKCS#8 private key in a PBES2 envelope
+func encryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	alg, ciphertext, err := pbes2Encrypt(der, passphrase, pbkdf2Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     alg,
+		EncryptedData: ciphertext,
+	})
+}
+
+// pbes2Encrypt encrypts data with AES-256-CBC under a key derived from the
+// passphrase with PBKDF2-HMAC-SHA256, returning the PBES2 algorithm
+// identifier that describes how to decrypt it
+func pbes2Encrypt(data, passphrase []byte, iterations int) (pkix.AlgorithmIdentifier, []byte, error) {
+	var alg pkix.AlgorithmIdentifier
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return alg, nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return alg, nil, err
+	}
+
+	key := pbkdf2.Key(passphrase, salt, iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return alg, nil, err
+	}
+
+	// PKCS#7 padding
+	padLen := aes.BlockSize - len(data)%aes.BlockSize
+	plaintext := make([]byte, len(data), len(data)+padLen)
+	copy(plaintext, data)
+	for i := 0; i < padLen; i++ {
+		plaintext = append(plaintext, byte(padLen))
+	}
+
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	kdfParams, err := asn1.Marshal(keystore.PBKDF2Params{
+		Salt:           salt,
+		IterationCount: iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: keystore.OIDHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return alg, nil, err
+	}
+
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return alg, nil, err
+	}
+
+	params, err := asn1.Marshal(keystore.PBES2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: keystore.OIDPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: keystore.OIDAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return alg, nil, err
+	}
+
+	alg = pkix.AlgorithmIdentifier{Algorithm: keystore.OIDPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+	return alg, ciphertext, nil
+}
+
+// decryptPKCS8 unwraps a PBES2 "ENCRYPTED PRIVATE KEY" into DER PKCS#8
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(keystore.OIDPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %s (only PBES2 is supported)", info.Algorithm.Algorithm)
+	}
+
+	var params keystore.PBES2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 parameters: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(keystore.OIDPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdf keystore.PBKDF2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("failed to parse PBKDF2 parameters: %w", err)
+	}
+	if kdf.IterationCount < 1 || kdf.IterationCount > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("unsupported key derivation iteration count %d (at most %d)", kdf.IterationCount, maxPBKDF2Iterations)
+	}
+
+	prf, err := keystore.PRFHash(kdf.PRF.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(keystore.OIDAES128CBC):
+		keyLen = 16
+	case params.EncryptionScheme.Algorithm.Equal(keystore.OIDAES192CBC):
+		keyLen = 24
+	case params.EncryptionScheme.Algorithm.Equal(keystore.OIDAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported key cipher %s", params.EncryptionScheme.Algorithm)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid cipher IV")
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted key length")
+	}
+
+	key := pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keyLen, prf)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, info.EncryptedData)
+
+	// Check PKCS#7 padding in constant time; bad padding means a wrong passphrase
+	padLen := int(plaintext[len(plaintext)-1])
+	good := padLen > 0 && padLen <= aes.BlockSize
+	if good {
+		expected := make([]byte, padLen)
+		for i := range expected {
+			expected[i] = byte(padLen)
+		}
+		good = hmac.Equal(plaintext[len(plaintext)-padLen:], expected)
+	}
+	if !good {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	// A wrong passphrase can still yield valid padding by chance, leaving
+	// garbage that only fails to parse
+	plaintext = plaintext[:len(plaintext)-padLen]
+	if _, err := x509.ParsePKCS8PrivateKey(plaintext); err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plaintext, nil
+}
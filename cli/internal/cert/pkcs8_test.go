@@ -0,0 +1,93 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"testing"
+
+	"github.com/instanttls/cli/internal/keystore"
+)
+
+// testEncryptedKey wraps data in a PBES2 envelope that records the given
+// iteration count. The data is always encrypted with 1000 iterations, so
+// out-of-range counts cost nothing to test.
+func testEncryptedKey(t *testing.T, data, passphrase []byte, iterations int) []byte {
+	t.Helper()
+
+	alg, ciphertext, err := pbes2Encrypt(data, passphrase, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var params keystore.PBES2Params
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	var kdf keystore.PBKDF2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatal(err)
+	}
+	kdf.IterationCount = iterations
+	if params.KeyDerivationFunc.Parameters.FullBytes, err = asn1.Marshal(kdf); err != nil {
+		t.Fatal(err)
+	}
+	if alg.Parameters.FullBytes, err = asn1.Marshal(params); err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: ciphertext})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestDecryptPKCS8(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := []byte("correct horse")
+
+	t.Run("round trip", func(t *testing.T) {
+		got, err := decryptPKCS8(testEncryptedKey(t, plain, passphrase, 1000), passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Error("decrypted key differs from the original")
+		}
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		_, err := decryptPKCS8(testEncryptedKey(t, plain, passphrase, 1000), []byte("wrong"))
+		if !errors.Is(err, ErrIncorrectPassphrase) {
+			t.Errorf("err = %v, want ErrIncorrectPassphrase", err)
+		}
+	})
+
+	// Valid padding around data that is not a key is what a wrong
+	// passphrase occasionally produces
+	t.Run("valid padding, not a key", func(t *testing.T) {
+		_, err := decryptPKCS8(testEncryptedKey(t, []byte("not a private key"), passphrase, 1000), passphrase)
+		if !errors.Is(err, ErrIncorrectPassphrase) {
+			t.Errorf("err = %v, want ErrIncorrectPassphrase", err)
+		}
+	})
+
+	for _, iterations := range []int{0, maxPBKDF2Iterations + 1} {
+		_, err := decryptPKCS8(testEncryptedKey(t, plain, passphrase, iterations), passphrase)
+		if err == nil || errors.Is(err, ErrIncorrectPassphrase) {
+			t.Errorf("iteration count %d: err = %v, want a refusal", iterations, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/version"
+)
+
+// RemoteFile marks the CA directory as holding an organization's server
+// CA, whose key never leaves the API
+const RemoteFile = "remote.json"
+
+// RemoteInfo records which server CA is installed in the CA directory
+type RemoteInfo struct {
+	OrgID       string    `json:"org_id"`
+	OrgName     string    `json:"org_name"`
+	Fingerprint string    `json:"fingerprint"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RemoteSigner sends a PEM certificate signing request to the API and
+// returns the signed certificate and the CA that signed it, both as PEM. A
+// zero validityDays leaves the validity to the organization's policy;
+// replaces is the serial number of the certificate being renewed, if any.
+type RemoteSigner func(csrPEM string, validityDays int, replaces string) (certPEM, caPEM string, err error)
+
+// ReadRemoteInfo returns the server CA installed locally, or nil if there
+// is none
+func ReadRemoteInfo() (*RemoteInfo, error) {
+	data, err := os.ReadFile(filepath.Join(config.GetCADir(), RemoteFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var info RemoteInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", RemoteFile, err)
+	}
+	return &info, nil
+}
+
+// InstallRemoteCA makes an organization's server CA the local root so it
+// can be installed in trust stores. Only its certificate is stored; any
+// other local CA is moved aside first and the directory it was moved to is
+// returned.
+func InstallRemoteCA(certPEM []byte, info RemoteInfo) (string, error) {
+	root, err := parseCertPEMBytes(certPEM)
+	if err != nil {
+		return "", fmt.Errorf("invalid server CA certificate: %w", err)
+	}
+	if !root.IsCA || !isSelfSigned(root) {
+		return "", fmt.Errorf("server CA certificate is not a self-signed CA")
+	}
+	if Fingerprint(root) != info.Fingerprint {
+		return "", fmt.Errorf("server CA certificate does not match fingerprint %s", info.Fingerprint)
+	}
+
+	current, err := ReadRemoteInfo()
+	if err != nil {
+		return "", err
+	}
+
+	backup := ""
+	if current == nil || current.OrgID != info.OrgID {
+		if backup, err = MoveCAAside(); err != nil {
+			return "", err
+		}
+	}
+
+	caDir := config.GetCADir()
+	if err := os.MkdirAll(caDir, 0700); err != nil {
+		return backup, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(caDir, RootCertFile), certPEM, 0644); err != nil {
+		return backup, fmt.Errorf("failed to write %s: %w", RootCertFile, err)
+	}
+
+	info.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return backup, err
+	}
+	if err := os.WriteFile(filepath.Join(caDir, RemoteFile), data, 0600); err != nil {
+		return backup, fmt.Errorf("failed to write %s: %w", RemoteFile, err)
+	}
+
+	return backup, nil
+}
+
+// MoveCAAside renames an existing CA directory to a timestamped backup and
+// returns its new path, or "" if there was no CA to move
+func MoveCAAside() (string, error) {
+	caDir := config.GetCADir()
+	if _, err := os.Stat(filepath.Join(caDir, RootCertFile)); err != nil {
+		return "", nil
+	}
+
+	backup := fmt.Sprintf("%s.%s.bak", caDir, time.Now().Format("20060102150405"))
+	if err := os.Rename(caDir, backup); err != nil {
+		return "", fmt.Errorf("failed to move the current CA aside: %w", err)
+	}
+	return backup, nil
+}
+
+// GenerateRemoteCert creates a certificate like GenerateCert, but the key
+// stays local and only a CSR for it is sent to the API to be signed
+func GenerateRemoteCert(req CertRequest, sign RemoteSigner) (string, error) {
+	if sign == nil {
+		return "", fmt.Errorf("certificates signed by the API need a login; run 'instanttls login' first")
+	}
+
+	sans, err := ParseSANs(req.Names)
+	if err != nil {
+		return "", err
+	}
+	primary := primaryName(req.Names, sans)
+
+	keyType := req.KeyType
+	if keyType == "" {
+		keyType = DefaultKeyType
+	}
+
+	privateKey, err := GenerateKey(keyType)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: primary},
+		DNSNames:    sans.DNSNames,
+		IPAddresses: sans.IPAddresses,
+	}, privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	certDir := filepath.Join(config.GetCertsDir(), certDirName(primary, sans))
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+
+	certPEM, caPEM, err := sign(csrPEM, req.ValidityDays, previousSerial(certDir))
+	if err != nil {
+		return "", err
+	}
+
+	leaf, err := parseCertPEMBytes([]byte(certPEM))
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate from the API: %w", err)
+	}
+	caCert, err := parseCertPEMBytes([]byte(caPEM))
+	if err != nil {
+		return "", fmt.Errorf("invalid CA certificate from the API: %w", err)
+	}
+	if !bytes.Equal(publicKeyBytes(leaf.PublicKey), publicKeyBytes(privateKey.Public())) {
+		return "", fmt.Errorf("certificate from the API does not match the local key")
+	}
+	if err := leaf.CheckSignatureFrom(caCert); err != nil {
+		return "", fmt.Errorf("certificate from the API is not signed by its CA: %w", err)
+	}
+
+	ips := make([]string, len(sans.IPAddresses))
+	for i, ip := range sans.IPAddresses {
+		ips[i] = ip.String()
+	}
+
+	meta := &Metadata{
+		Version:           metadataVersion,
+		CommonName:        primary,
+		DNSNames:          sans.DNSNames,
+		IPAddresses:       ips,
+		KeyType:           keyType,
+		ValidityDays:      req.ValidityDays,
+		SerialNumber:      leaf.SerialNumber.Text(16),
+		NotBefore:         leaf.NotBefore,
+		NotAfter:          leaf.NotAfter,
+		IssuerFingerprint: Fingerprint(caCert),
+		RootFingerprint:   Fingerprint(caCert),
+		Remote:            true,
+		CreatedAt:         leaf.NotBefore,
+		CLIVersion:        version.Version,
+	}
+
+	// The server CA is a root, so the chain servers present is the leaf alone
+	if err := writeCertFiles(certDir, [][]byte{leaf.Raw}, privateKey, meta); err != nil {
+		return "", err
+	}
+
+	return certDir, nil
+}
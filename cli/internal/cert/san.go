@@ -0,0 +1,155 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// SANs is the normalized set of subject alternative names for a certificate
+type SANs struct {
+	DNSNames    []string
+	IPAddresses []net.IP
+}
+
+// ParseSANs splits a mixed list of hostnames, wildcards and IP addresses into
+// DNS and IP SANs. Names are lowercased and deduplicated, and the apex of every
+// wildcard is added so that "*.local.test" also covers "local.test".
+func ParseSANs(names []string) (*SANs, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("at least one domain or IP address is required")
+	}
+
+	sans := &SANs{}
+	seen := make(map[string]bool)
+
+	addDNS := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			sans.DNSNames = append(sans.DNSNames, name)
+		}
+	}
+
+	for _, raw := range names {
+		name := strings.TrimSpace(raw)
+		if name == "" {
+			continue
+		}
+
+		// Accept bracketed IPv6 literals such as "[::1]"
+		if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				sans.IPAddresses = append(sans.IPAddresses, ip)
+			}
+			continue
+		}
+
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if err := validateDNSName(name); err != nil {
+			return nil, err
+		}
+
+		addDNS(name)
+		if strings.HasPrefix(name, "*.") {
+			addDNS(strings.TrimPrefix(name, "*."))
+		}
+	}
+
+	if len(sans.DNSNames) == 0 && len(sans.IPAddresses) == 0 {
+		return nil, fmt.Errorf("at least one domain or IP address is required")
+	}
+
+	return sans, nil
+}
+
+// Names returns every SAN as a string, DNS names first
+func (s *SANs) Names() []string {
+	names := make([]string, 0, len(s.DNSNames)+len(s.IPAddresses))
+	names = append(names, s.DNSNames...)
+	for _, ip := range s.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// HasWildcard reports whether any DNS name is a wildcard
+func (s *SANs) HasWildcard() bool {
+	for _, name := range s.DNSNames {
+		if strings.HasPrefix(name, "*.") {
+			return true
+		}
+	}
+	return false
+}
+
+// certDirName returns a deterministic directory name for a certificate. A
+// certificate covering a single name (plus the implied wildcard apex) keeps
+// the plain sanitized name; anything else becomes "<primary>+<n>-<hash>",
+// where the hash covers the sorted SAN set so argument order does not matter.
+func certDirName(primary string, sans *SANs) string {
+	key := make([]string, 0, len(sans.DNSNames)+len(sans.IPAddresses))
+	for _, name := range sans.Names() {
+		if sans.impliedApex(name) {
+			continue
+		}
+		key = append(key, name)
+	}
+
+	if len(key) == 1 {
+		return sanitizeDomain(key[0])
+	}
+
+	sort.Strings(key)
+	sum := sha256.Sum256([]byte(strings.Join(key, ",")))
+	return fmt.Sprintf("%s+%d-%s", sanitizeDomain(primary), len(key)-1, hex.EncodeToString(sum[:4]))
+}
+
+// impliedApex reports whether name is only present as the apex of a wildcard
+func (s *SANs) impliedApex(name string) bool {
+	for _, dns := range s.DNSNames {
+		if dns == "*."+name {
+			return true
+		}
+	}
+	return false
+}
+
+func validateDNSName(name string) error {
+	if len(name) > 253 {
+		return fmt.Errorf("invalid domain %q: longer than 253 characters", name)
+	}
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if label == "*" {
+			if i != 0 {
+				return fmt.Errorf("invalid domain %q: wildcard is only allowed as the leftmost label", name)
+			}
+			if len(labels) < 2 {
+				return fmt.Errorf("invalid domain %q: wildcard needs a parent domain", name)
+			}
+			continue
+		}
+
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid domain %q: empty or overlong label", name)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid domain %q: labels cannot start or end with '-'", name)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fmt.Errorf("invalid domain %q: unexpected character %q", name, r)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+// TeamFile marks the CA directory as holding an organization's team CA
+const TeamFile = "team.json"
+
+// TeamInfo records which team CA is installed in the CA directory
+type TeamInfo struct {
+	OrgID       string    `json:"org_id"`
+	OrgName     string    `json:"org_name"`
+	Version     int       `json:"version"`
+	Fingerprint string    `json:"fingerprint"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TeamBundle is a team CA as stored on the API: the root and intermediate
+// certificates and the intermediate key, encrypted with the team
+// passphrase. The root key is never part of it.
+type TeamBundle struct {
+	RootPEM         []byte
+	IntermediatePEM []byte
+	KeyPEM          []byte
+}
+
+// ReadTeamInfo returns the team CA installed locally, or nil if the CA is
+// a personal one
+func ReadTeamInfo() (*TeamInfo, error) {
+	data, err := os.ReadFile(filepath.Join(config.GetCADir(), TeamFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var info TeamInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", TeamFile, err)
+	}
+	return &info, nil
+}
+
+// WriteTeamInfo marks the CA directory as holding a team CA
+func WriteTeamInfo(info TeamInfo) error {
+	info.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(config.GetCADir(), TeamFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", TeamFile, err)
+	}
+	return nil
+}
+
+// ReadTeamBundle returns the local CA in the form it is shared with a team.
+// The intermediate key must already be encrypted, since the API only ever
+// holds it encrypted.
+func ReadTeamBundle() (*TeamBundle, error) {
+	if !HasIntermediate() {
+		return nil, fmt.Errorf("a team CA needs an intermediate CA; run 'instanttls ca rotate-intermediate' first")
+	}
+
+	caDir := config.GetCADir()
+	keyPath := filepath.Join(caDir, IntermediateKeyFile)
+	if !isEncryptedKeyFile(keyPath) {
+		return nil, fmt.Errorf("%s is not encrypted; run 'instanttls ca encrypt-key' to choose a team passphrase", IntermediateKeyFile)
+	}
+
+	var bundle TeamBundle
+	for _, f := range []struct {
+		name string
+		dst  *[]byte
+	}{
+		{RootCertFile, &bundle.RootPEM},
+		{IntermediateCertFile, &bundle.IntermediatePEM},
+		{IntermediateKeyFile, &bundle.KeyPEM},
+	} {
+		data, err := os.ReadFile(filepath.Join(caDir, f.name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.name, err)
+		}
+		*f.dst = data
+	}
+
+	return &bundle, nil
+}
+
+// InstallTeamCA makes a team CA the local CA. The intermediate key is
+// unlocked once to check the team passphrase, then stored still encrypted.
+// A personal CA, a server CA or another organization's team CA is moved
+// aside first; the directory it was moved to is returned.
+func InstallTeamCA(bundle TeamBundle, info TeamInfo) (string, error) {
+	root, err := parseCertPEMBytes(bundle.RootPEM)
+	if err != nil {
+		return "", fmt.Errorf("invalid team root certificate: %w", err)
+	}
+	if !root.IsCA || !isSelfSigned(root) {
+		return "", fmt.Errorf("team root certificate is not a self-signed CA")
+	}
+	if Fingerprint(root) != info.Fingerprint {
+		return "", fmt.Errorf("team root certificate does not match fingerprint %s", info.Fingerprint)
+	}
+
+	intermediate, err := parseCertPEMBytes(bundle.IntermediatePEM)
+	if err != nil {
+		return "", fmt.Errorf("invalid team intermediate certificate: %w", err)
+	}
+	if !intermediate.IsCA || intermediate.CheckSignatureFrom(root) != nil {
+		return "", fmt.Errorf("team intermediate certificate is not issued by the team root")
+	}
+
+	caDir := config.GetCADir()
+	key, err := parsePrivateKey(bundle.KeyPEM, filepath.Join(caDir, IntermediateKeyFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to unlock the team CA key: %w", err)
+	}
+	if !bytes.Equal(publicKeyBytes(key.Public()), publicKeyBytes(intermediate.PublicKey)) {
+		return "", fmt.Errorf("team CA key does not match its certificate")
+	}
+
+	backup := ""
+	current, err := ReadTeamInfo()
+	if err != nil {
+		return "", err
+	}
+	if current == nil || current.OrgID != info.OrgID {
+		if backup, err = MoveCAAside(); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(caDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create CA directory: %w", err)
+	}
+
+	// A root key left from before a root rotation no longer matches
+	if current != nil && current.Fingerprint != info.Fingerprint {
+		os.Remove(filepath.Join(caDir, RootKeyFile))
+	}
+
+	for _, f := range []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{RootCertFile, bundle.RootPEM, 0644},
+		{IntermediateCertFile, bundle.IntermediatePEM, 0644},
+		{IntermediateKeyFile, bundle.KeyPEM, 0600},
+	} {
+		if err := os.WriteFile(filepath.Join(caDir, f.name), f.data, f.mode); err != nil {
+			return backup, fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+
+	return backup, WriteTeamInfo(info)
+}
+
+func parseCertPEMBytes(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("not a PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -12,7 +12,6 @@ type Config struct {
 	Token       string `json:"token"`
 	TokenPrefix string `json:"token_prefix"`
 	Email       string `json:"email"`
-	Plan        string `json:"plan"`
 }
 
 func GetConfigDir() string {
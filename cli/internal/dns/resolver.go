@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ConfigSnippet is a resolver configuration file that routes the zones to
+// the InstantTLS DNS server
+type ConfigSnippet struct {
+	Path     string
+	Contents string
+	// Apply is the command that makes the resolver pick up the change
+	Apply string
+}
+
+// ResolvedConfig returns a systemd-resolved drop-in that sends queries for
+// the zones, and only those, to addr. Ports other than 53 need
+// systemd-resolved 246 or later.
+func ResolvedConfig(zones []Zone, addr string) ConfigSnippet {
+	domains := make([]string, len(zones))
+	for i, z := range zones {
+		// "~" marks a routing-only domain: it is not added to the search list
+		domains[i] = "~" + z.Domain
+	}
+
+	return ConfigSnippet{
+		Path: "/etc/systemd/resolved.conf.d/instanttls.conf",
+		Contents: fmt.Sprintf(`# Generated by instanttls dns config
+[Resolve]
+DNS=%s
+Domains=%s
+`, addr, strings.Join(domains, " ")),
+		Apply: "sudo systemctl restart systemd-resolved",
+	}
+}
+
+// NetworkManagerConfig returns snippets for NetworkManager's dnsmasq plugin:
+// one enabling the plugin and one forwarding the zones to addr
+func NetworkManagerConfig(zones []Zone, addr string) []ConfigSnippet {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "53"
+	}
+
+	var servers strings.Builder
+	servers.WriteString("# Generated by instanttls dns config\n")
+	for _, z := range zones {
+		fmt.Fprintf(&servers, "server=/%s/%s#%s\n", z.Domain, host, port)
+	}
+
+	return []ConfigSnippet{
+		{
+			Path: "/etc/NetworkManager/conf.d/instanttls.conf",
+			Contents: `# Generated by instanttls dns config
+[main]
+dns=dnsmasq
+`,
+		},
+		{
+			Path:     "/etc/NetworkManager/dnsmasq.d/instanttls.conf",
+			Contents: servers.String(),
+			Apply:    "sudo systemctl reload NetworkManager",
+		},
+	}
+}
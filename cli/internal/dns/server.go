@@ -0,0 +1,313 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultAddr avoids port 53, which usually needs root and is often
+	// taken by the system resolver, and 5353, which is mDNS
+	DefaultAddr = "127.0.0.1:5354"
+	DefaultTTL  = 60
+
+	maxUDPSize     = 512
+	forwardTimeout = 5 * time.Second
+	tcpIdleTimeout = 10 * time.Second
+)
+
+// Options configures the DNS server
+type Options struct {
+	Zones []Zone
+	// Forward lists upstream resolvers (host:port) for names outside the
+	// zones. Without any, such queries are refused.
+	Forward []string
+	TTL     uint32
+	// Logf, if set, receives one line per query
+	Logf func(format string, args ...interface{})
+}
+
+// Server answers DNS queries over UDP and TCP
+type Server struct {
+	opts Options
+
+	mu       sync.Mutex
+	udp      net.PacketConn
+	tcp      net.Listener
+	shutdown bool
+}
+
+// NewServer creates a DNS server for the given options
+func NewServer(opts Options) *Server {
+	if opts.TTL == 0 {
+		opts.TTL = DefaultTTL
+	}
+	return &Server{opts: opts}
+}
+
+// ListenAndServe serves UDP and TCP on addr until Shutdown is called
+func (s *Server) ListenAndServe(addr string) error {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.udp, s.tcp = udp, tcp
+	s.mu.Unlock()
+
+	errc := make(chan error, 2)
+	go func() { errc <- s.serveUDP(udp) }()
+	go func() { errc <- s.serveTCP(tcp) }()
+
+	err = <-errc
+	s.Shutdown()
+	<-errc
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops both listeners
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shutdown = true
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+}
+
+func (s *Server) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		query := make([]byte, n)
+		copy(query, buf[:n])
+
+		go func() {
+			if resp := s.handle(query, "udp"); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveTCPConn(conn)
+	}
+}
+
+// serveTCPConn handles length-prefixed messages until the client goes away
+func (s *Server) serveTCPConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+
+		resp := s.handle(query, "tcp")
+		if resp == nil {
+			return
+		}
+		if err := writeTCPMessage(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers one query. It returns nil if the query is too broken to
+// answer at all.
+func (s *Server) handle(query []byte, network string) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil || header.Response {
+		return nil
+	}
+
+	q, err := p.Question()
+	if err != nil {
+		return reply(header, nil, dnsmessage.RCodeFormatError, nil, network)
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(q.Name.String()), ".")
+
+	if zone, ok := findZone(s.opts.Zones, name); ok {
+		answers := s.answer(zone, q)
+		s.logf("%s %s → %d answer(s)", q.Type, name, len(answers))
+		return reply(header, &q, dnsmessage.RCodeSuccess, answers, network)
+	}
+
+	if len(s.opts.Forward) > 0 {
+		resp, err := s.forward(query, network)
+		if err == nil {
+			s.logf("%s %s → forwarded", q.Type, name)
+			return resp
+		}
+		s.logf("%s %s → forwarding failed: %v", q.Type, name, err)
+		return reply(header, &q, dnsmessage.RCodeServerFailure, nil, network)
+	}
+
+	s.logf("%s %s → refused", q.Type, name)
+	return reply(header, &q, dnsmessage.RCodeRefused, nil, network)
+}
+
+// answer builds the records for a zone. Other record types get an empty
+// (NODATA) answer so resolvers do not fall through to another server.
+func (s *Server) answer(zone Zone, q dnsmessage.Question) []dnsmessage.Resource {
+	hdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: s.opts.TTL}
+
+	var answers []dnsmessage.Resource
+	if q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL {
+		for _, ip := range zone.IPv4 {
+			var a dnsmessage.AResource
+			copy(a.A[:], ip.To4())
+			answers = append(answers, dnsmessage.Resource{Header: hdr, Body: &a})
+		}
+	}
+	if q.Type == dnsmessage.TypeAAAA || q.Type == dnsmessage.TypeALL {
+		for _, ip := range zone.IPv6 {
+			var aaaa dnsmessage.AAAAResource
+			copy(aaaa.AAAA[:], ip.To16())
+			answers = append(answers, dnsmessage.Resource{Header: hdr, Body: &aaaa})
+		}
+	}
+	return answers
+}
+
+// forward relays a query to the first upstream that answers
+func (s *Server) forward(query []byte, network string) ([]byte, error) {
+	var lastErr error
+	for _, upstream := range s.opts.Forward {
+		resp, err := exchange(query, network, upstream)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func exchange(query []byte, network, upstream string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(forwardTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func reply(query dnsmessage.Header, q *dnsmessage.Question, rcode dnsmessage.RCode, answers []dnsmessage.Resource, network string) []byte {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.ID,
+			Response:           true,
+			OpCode:             query.OpCode,
+			Authoritative:      rcode == dnsmessage.RCodeSuccess,
+			RecursionDesired:   query.RecursionDesired,
+			RecursionAvailable: false,
+			RCode:              rcode,
+		},
+		Answers: answers,
+	}
+	if q != nil {
+		msg.Questions = []dnsmessage.Question{*q}
+	}
+
+	resp, err := msg.Pack()
+	if err != nil {
+		return nil
+	}
+	if network == "udp" && len(resp) > maxUDPSize {
+		// Only possible with very many addresses; the client retries
+		// over TCP
+		msg.Header.Truncated = true
+		msg.Answers = nil
+		resp, _ = msg.Pack()
+	}
+	return resp
+}
+
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, errors.New("empty DNS message")
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.opts.Logf != nil {
+		s.opts.Logf(format, args...)
+	}
+}
@@ -0,0 +1,91 @@
+// Package dns implements the small DNS server behind "instanttls dns serve".
+// It answers A and AAAA queries for local development zones and forwards or
+// refuses everything else.
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DefaultZone is served when no zones are configured
+const DefaultZone = "*.local.test=127.0.0.1,::1"
+
+// Zone maps a domain to fixed addresses
+type Zone struct {
+	// Domain is the zone apex, e.g. "local.test"
+	Domain string
+	// Wildcard also matches every name below Domain
+	Wildcard bool
+	IPv4     []net.IP
+	IPv6     []net.IP
+}
+
+// ParseZone parses "domain=ip[,ip...]". A leading "*." makes the zone match
+// the domain and every name below it, like dnsmasq's address=/domain/ip.
+func ParseZone(arg string) (Zone, error) {
+	name, addrs, ok := strings.Cut(arg, "=")
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if !ok || name == "" || strings.TrimSpace(addrs) == "" {
+		return Zone{}, fmt.Errorf("invalid zone %q: expected domain=ip[,ip...]", arg)
+	}
+
+	zone := Zone{Domain: name}
+	if domain, found := strings.CutPrefix(name, "*."); found {
+		zone.Domain = domain
+		zone.Wildcard = true
+	}
+	if zone.Domain == "" || strings.Contains(zone.Domain, "*") {
+		return Zone{}, fmt.Errorf("invalid zone domain %q", name)
+	}
+
+	for _, a := range strings.Split(addrs, ",") {
+		ip := net.ParseIP(strings.TrimSpace(a))
+		if ip == nil {
+			return Zone{}, fmt.Errorf("invalid address %q in zone %s", a, name)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			zone.IPv4 = append(zone.IPv4, ip4)
+		} else {
+			zone.IPv6 = append(zone.IPv6, ip)
+		}
+	}
+
+	return zone, nil
+}
+
+// Matches reports whether the zone answers for a (lowercase, dot-less) name
+func (z Zone) Matches(name string) bool {
+	if name == z.Domain {
+		return true
+	}
+	return z.Wildcard && strings.HasSuffix(name, "."+z.Domain)
+}
+
+// String formats the zone like the argument it was parsed from
+func (z Zone) String() string {
+	name := z.Domain
+	if z.Wildcard {
+		name = "*." + name
+	}
+
+	var addrs []string
+	for _, ip := range append(append([]net.IP{}, z.IPv4...), z.IPv6...) {
+		addrs = append(addrs, ip.String())
+	}
+	return name + " → " + strings.Join(addrs, ", ")
+}
+
+// findZone returns the most specific zone for a name
+func findZone(zones []Zone, name string) (Zone, bool) {
+	var best Zone
+	found := false
+	for _, z := range zones {
+		if z.Matches(name) && (!found || len(z.Domain) > len(best.Domain)) {
+			best = z
+			found = true
+		}
+	}
+	return best, found
+}
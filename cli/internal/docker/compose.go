@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultOverrideFile is the name of the compose override written next to
+// the project's compose file
+const DefaultOverrideFile = "docker-compose.instanttls.yml"
+
+// composeFiles are the names docker compose looks for, in its order
+var composeFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// FindComposeFile returns the compose file docker compose would use in dir
+func FindComposeFile(dir string) (string, error) {
+	for _, name := range composeFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no compose file in %s (looked for %s)", dir, strings.Join(composeFiles, ", "))
+}
+
+// ComposeServices lists the services defined in a compose file. Only the
+// keys directly under the top-level services mapping are read, which is
+// all an override needs.
+func ComposeServices(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+	defer f.Close()
+
+	var services []string
+	inServices := false
+	indent := ""
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if trimmed == line {
+			// A top-level key starts or ends the services mapping
+			inServices = strings.HasPrefix(line, "services:")
+			continue
+		}
+		if !inServices {
+			continue
+		}
+
+		lineIndent := line[:len(line)-len(trimmed)]
+		if indent == "" {
+			indent = lineIndent
+		}
+		if lineIndent != indent {
+			continue
+		}
+
+		name, _, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		services = append(services, strings.Trim(name, `"'`))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no services found in %s", path)
+	}
+	return services, nil
+}
+
+// ComposeOverride returns a compose file that mounts bundleDir at MountDir
+// and certsDir below it in each service, read-only, and sets the trust
+// variables. Compose merges volumes and environment with those of the
+// project's own file.
+func ComposeOverride(services []string, bundleDir, certsDir string) string {
+	var b strings.Builder
+	b.WriteString("# Generated by instanttls docker compose. Use it alongside your compose file:\n")
+	b.WriteString("#   docker compose -f <compose file> -f " + DefaultOverrideFile + " up\n")
+	b.WriteString("services:\n")
+
+	for _, service := range services {
+		fmt.Fprintf(&b, "  %s:\n", yamlQuote(service))
+		b.WriteString("    volumes:\n")
+		fmt.Fprintf(&b, "      - %s\n", yamlQuote(bundleDir+":"+MountDir+":ro"))
+		fmt.Fprintf(&b, "      - %s\n", yamlQuote(certsDir+":"+MountDir+"/"+CertsDir+":ro"))
+		b.WriteString("    environment:\n")
+		for _, v := range MountedEnv() {
+			fmt.Fprintf(&b, "      %s: %s\n", v.Name, yamlQuote(v.Value))
+		}
+	}
+	return b.String()
+}
+
+// yamlQuote double-quotes a scalar unless it is plainly safe as is
+func yamlQuote(s string) string {
+	safe := s != ""
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("/._-:", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe && !strings.Contains(s, ": ") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,140 @@
+// Package docker gets the InstantTLS CA trusted inside containers: in
+// images through a Dockerfile snippet, in compose services through a
+// mounted bundle directory, and in running containers through docker cp.
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/instanttls/cli/internal/config"
+	"github.com/instanttls/cli/internal/trust"
+)
+
+const (
+	// MountDir is where the bundle directory lives inside a container
+	MountDir = "/etc/instanttls"
+
+	// CAFile and BundleFile are the names used in a bundle directory. The
+	// bundle is the host's system roots plus the CA, for runtimes that take
+	// a single file in place of the system store.
+	CAFile     = "ca.crt"
+	BundleFile = "ca-bundle.pem"
+
+	// CertsDir is the leaf certificate directory, below MountDir
+	CertsDir = "certs"
+
+	// ContextCAFile is the name the CA is given in a Docker build context
+	ContextCAFile = "instanttls-ca.crt"
+
+	// systemBundle is the file OpenSSL reads on Debian, Ubuntu and Alpine.
+	// The install script makes sure it exists on Red Hat based images too.
+	systemBundle = "/etc/ssl/certs/ca-certificates.crt"
+)
+
+// WriteBundleDir writes ca.crt and ca-bundle.pem into dir, along with an
+// empty certs directory for the leaf certificates to be mounted onto
+func WriteBundleDir(dir string) error {
+	caPEM, err := os.ReadFile(filepath.Join(config.GetCADir(), "ca.crt"))
+	if err != nil {
+		return fmt.Errorf("failed to read CA certificate: %w (run 'instanttls init' first)", err)
+	}
+
+	bundle, err := trust.Bundle()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, CertsDir), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, CAFile), caPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", CAFile, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, BundleFile), bundle, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", BundleFile, err)
+	}
+	return nil
+}
+
+// WriteContextCA copies the CA into a Docker build context as
+// instanttls-ca.crt and returns the path written
+func WriteContextCA(contextDir string) (string, error) {
+	caPEM, err := os.ReadFile(filepath.Join(config.GetCADir(), "ca.crt"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA certificate: %w (run 'instanttls init' first)", err)
+	}
+
+	path := filepath.Join(contextDir, ContextCAFile)
+	if err := os.WriteFile(path, caPEM, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// MountedEnv returns the trust variables for a container with a bundle
+// directory mounted at MountDir. Node only needs the CA, as it keeps its
+// own roots.
+func MountedEnv() []trust.EnvVar {
+	bundle := MountDir + "/" + BundleFile
+	return []trust.EnvVar{
+		{Name: "SSL_CERT_FILE", Value: bundle},
+		{Name: "REQUESTS_CA_BUNDLE", Value: bundle},
+		{Name: "CURL_CA_BUNDLE", Value: bundle},
+		{Name: "NODE_EXTRA_CA_CERTS", Value: MountDir + "/" + CAFile},
+	}
+}
+
+// installedEnv returns the trust variables for an image whose system store
+// has had the CA added by installScript
+func installedEnv() []trust.EnvVar {
+	return []trust.EnvVar{
+		{Name: "NODE_EXTRA_CA_CERTS", Value: MountDir + "/" + CAFile},
+		{Name: "REQUESTS_CA_BUNDLE", Value: systemBundle},
+	}
+}
+
+// Dockerfile returns the lines that add the CA to an image built from a
+// context holding instanttls-ca.crt. The CA goes into whichever system
+// store the base image has, so it needs a shell; for distroless and
+// scratch images use DistrolessDockerfile.
+func Dockerfile() string {
+	var b strings.Builder
+	b.WriteString("# Trust the InstantTLS development CA (generated by instanttls docker dockerfile)\n")
+	fmt.Fprintf(&b, "COPY %s %s/%s\n", ContextCAFile, MountDir, CAFile)
+	fmt.Fprintf(&b, "RUN ca=%s/%s; \\\n    %s\n", MountDir, CAFile, strings.Join(installScript(), " \\\n    "))
+	for _, v := range installedEnv() {
+		fmt.Fprintf(&b, "ENV %s=%s\n", v.Name, v.Value)
+	}
+	return b.String()
+}
+
+// DistrolessDockerfile returns the lines that copy a bundle directory into
+// an image without a shell and point the runtimes at it
+func DistrolessDockerfile(bundleDir string) string {
+	var b strings.Builder
+	b.WriteString("# Trust the InstantTLS development CA (generated by instanttls docker dockerfile)\n")
+	fmt.Fprintf(&b, "COPY %s/%s %s/%s %s/\n", bundleDir, CAFile, bundleDir, BundleFile, MountDir)
+	for _, v := range MountedEnv() {
+		fmt.Fprintf(&b, "ENV %s=%s\n", v.Name, v.Value)
+	}
+	return b.String()
+}
+
+// installScript adds the certificate in $ca to the image's trust store:
+// Debian and Alpine's update-ca-certificates, Red Hat's update-ca-trust, or
+// p11-kit's trust. Images with none of them get the CA appended to the
+// OpenSSL bundle. The lines are joined either by newlines or by escaped
+// newlines in a Dockerfile RUN.
+func installScript() []string {
+	return []string{
+		`if command -v update-ca-certificates >/dev/null 2>&1; then mkdir -p /usr/local/share/ca-certificates && cp "$ca" /usr/local/share/ca-certificates/instanttls.crt && update-ca-certificates;`,
+		`elif command -v update-ca-trust >/dev/null 2>&1; then cp "$ca" /etc/pki/ca-trust/source/anchors/instanttls.crt && update-ca-trust extract;`,
+		`elif command -v trust >/dev/null 2>&1; then trust anchor "$ca";`,
+		`else mkdir -p /etc/ssl/certs && { grep -qF "$(sed -n 2p "$ca")" ` + systemBundle + ` 2>/dev/null || cat "$ca" >> ` + systemBundle + `; };`,
+		`fi;`,
+		`if [ ! -e ` + systemBundle + ` ] && [ -e /etc/pki/tls/certs/ca-bundle.crt ]; then ln -s /etc/pki/tls/certs/ca-bundle.crt ` + systemBundle + `; fi`,
+	}
+}
@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+// containerTmp is where docker cp puts the CA before the install script
+// moves it into place
+const containerTmp = "/tmp/" + ContextCAFile
+
+// RunningContainers returns the names of the running containers
+func RunningContainers() ([]string, error) {
+	out, err := exec.Command("docker", "ps", "--format", "{{.Names}}").Output()
+	if err != nil {
+		return nil, dockerError("list containers", err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// Inject copies the CA into a running container and adds it to the
+// container's trust store, as root. Processes that read the store at
+// startup, such as JVMs, still need a restart.
+func Inject(container string) error {
+	caPath := filepath.Join(config.GetCADir(), "ca.crt")
+
+	if out, err := exec.Command("docker", "cp", caPath, container+":"+containerTmp).CombinedOutput(); err != nil {
+		return dockerError("copy the CA", outputError(err, out))
+	}
+
+	script := strings.Join(append([]string{
+		"set -e",
+		// MountDir is read-only when the compose override mounts it
+		"ca=" + containerTmp,
+		"if mkdir -p " + MountDir + " 2>/dev/null && cp " + containerTmp + " " + MountDir + "/" + CAFile + " 2>/dev/null; then ca=" + MountDir + "/" + CAFile + "; fi",
+	}, installScript()...), "\n")
+
+	out, err := exec.Command("docker", "exec", "-u", "0", container, "sh", "-c", script).CombinedOutput()
+	if err != nil {
+		return dockerError("update the trust store", outputError(err, out))
+	}
+	return nil
+}
+
+// outputError adds a command's last line of output to its error
+func outputError(err error, out []byte) error {
+	lines := strings.Split(string(bytes.TrimSpace(out)), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return fmt.Errorf("%w: %s", err, last)
+	}
+	return err
+}
+
+func dockerError(action string, err error) error {
+	if _, lookErr := exec.LookPath("docker"); lookErr != nil {
+		return fmt.Errorf("failed to %s: docker is not installed or not on PATH", action)
+	}
+	return fmt.Errorf("failed to %s: %w", action, err)
+}
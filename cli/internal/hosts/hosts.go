@@ -0,0 +1,348 @@
+// Package hosts maintains a delimited InstantTLS block in the system hosts
+// file. Lines outside the block are never touched, and removing the last
+// entry removes the block itself.
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	// PathEnv overrides the hosts file location
+	PathEnv = "INSTANTTLS_HOSTS_FILE"
+
+	// DefaultIP is the address new entries point at
+	DefaultIP = "127.0.0.1"
+
+	beginMarker = "# BEGIN InstantTLS (managed by instanttls hosts, do not edit)"
+	endMarker   = "# END InstantTLS"
+)
+
+// Entry maps a hostname to an address
+type Entry struct {
+	IP       string
+	Hostname string
+}
+
+// DefaultPath returns the hosts file location for this OS, honoring
+// INSTANTTLS_HOSTS_FILE
+func DefaultPath() string {
+	if path := os.Getenv(PathEnv); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		return filepath.Join(root, "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// File is a hosts file with an InstantTLS block
+type File struct {
+	Path string
+
+	before  []string
+	entries []Entry
+	after   []string
+	newline string
+	// trailingNewline records whether the original file ended with one
+	trailingNewline bool
+	original        string
+}
+
+// Load reads and parses a hosts file. A missing file is treated as empty.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	f := &File{Path: path, original: string(data), newline: "\n"}
+	content := string(data)
+	if strings.Contains(content, "\r\n") {
+		f.newline = "\r\n"
+	}
+	f.trailingNewline = content == "" || strings.HasSuffix(content, "\n")
+
+	content = strings.TrimSuffix(content, "\n")
+	content = strings.TrimSuffix(content, "\r")
+	if content == "" {
+		return f, nil
+	}
+
+	inBlock, seenBlock := false, false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == beginMarker && !seenBlock:
+			inBlock, seenBlock = true, true
+		case trimmed == endMarker && inBlock:
+			inBlock = false
+		case inBlock:
+			f.entries = append(f.entries, parseLine(trimmed)...)
+		case seenBlock:
+			f.after = append(f.after, line)
+		default:
+			f.before = append(f.before, line)
+		}
+	}
+
+	if inBlock {
+		return nil, fmt.Errorf("%s has an unterminated InstantTLS block; add %q after it or remove it", path, endMarker)
+	}
+
+	return f, nil
+}
+
+func parseLine(line string) []Entry {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil
+	}
+
+	entries := make([]Entry, 0, len(fields)-1)
+	for _, hostname := range fields[1:] {
+		entries = append(entries, Entry{IP: fields[0], Hostname: strings.ToLower(hostname)})
+	}
+	return entries
+}
+
+// Entries returns the managed entries sorted by hostname
+func (f *File) Entries() []Entry {
+	entries := append([]Entry(nil), f.entries...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Hostname < entries[j].Hostname })
+	return entries
+}
+
+// Add maps hostnames to ip, replacing any managed entry with a different
+// address. It reports whether anything changed.
+func (f *File) Add(ip string, hostnames ...string) (bool, error) {
+	if net.ParseIP(ip) == nil {
+		return false, fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	changed := false
+	for _, hostname := range hostnames {
+		hostname, err := normalizeHostname(hostname)
+		if err != nil {
+			return false, err
+		}
+
+		found := false
+		for i, e := range f.entries {
+			if e.Hostname != hostname {
+				continue
+			}
+			found = true
+			if e.IP != ip {
+				f.entries[i].IP = ip
+				changed = true
+			}
+		}
+		if !found {
+			f.entries = append(f.entries, Entry{IP: ip, Hostname: hostname})
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// Remove drops the managed entries for hostnames. It reports whether
+// anything changed.
+func (f *File) Remove(hostnames ...string) bool {
+	drop := make(map[string]bool)
+	for _, hostname := range hostnames {
+		drop[strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")] = true
+	}
+
+	kept := f.entries[:0]
+	for _, e := range f.entries {
+		if !drop[e.Hostname] {
+			kept = append(kept, e)
+		}
+	}
+
+	changed := len(kept) != len(f.entries)
+	f.entries = kept
+	return changed
+}
+
+// RemoveAll drops every managed entry, and with them the block
+func (f *File) RemoveAll() bool {
+	changed := len(f.entries) > 0
+	f.entries = nil
+	return changed
+}
+
+// Unmanaged returns hostnames from the list that are already mapped outside
+// the InstantTLS block, which would shadow or conflict with managed entries
+func (f *File) Unmanaged(hostnames ...string) []string {
+	outside := make(map[string]bool)
+	for _, line := range append(append([]string{}, f.before...), f.after...) {
+		for _, e := range parseLine(strings.TrimSpace(line)) {
+			outside[e.Hostname] = true
+		}
+	}
+
+	var found []string
+	for _, hostname := range hostnames {
+		if outside[strings.ToLower(hostname)] {
+			found = append(found, hostname)
+		}
+	}
+	return found
+}
+
+// String renders the file. Without managed entries the block is left out
+// entirely, so adding and then removing entries restores the original file.
+func (f *File) String() string {
+	lines := append([]string{}, f.before...)
+
+	if len(f.entries) > 0 {
+		lines = append(lines, beginMarker)
+		for _, e := range f.Entries() {
+			lines = append(lines, e.IP+"\t"+e.Hostname)
+		}
+		lines = append(lines, endMarker)
+	}
+
+	lines = append(lines, f.after...)
+	if len(lines) == 0 {
+		return ""
+	}
+
+	content := strings.Join(lines, f.newline)
+	if f.trailingNewline || len(f.entries) > 0 && len(f.after) == 0 {
+		content += f.newline
+	}
+	return content
+}
+
+// Changed reports whether the rendered file differs from what was loaded
+func (f *File) Changed() bool {
+	return f.String() != f.original
+}
+
+// Save writes the file atomically: the new contents go to a temporary file
+// next to the hosts file, which is then renamed over it. If the hosts file
+// is not writable, the same is done through sudo.
+func (f *File) Save() error {
+	if !f.Changed() {
+		return nil
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(f.Path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	err := writeAtomic(f.Path, []byte(f.String()), mode)
+	if err == nil || !os.IsPermission(err) || runtime.GOOS == "windows" {
+		return err
+	}
+
+	return writeWithSudo(f.Path, []byte(f.String()), mode)
+}
+
+func writeAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".hosts-instanttls-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		// Hosts files bind-mounted into containers cannot be replaced,
+		// only rewritten in place
+		if linkErr, ok := err.(*os.LinkError); ok && isBusy(linkErr.Err) {
+			return os.WriteFile(path, data, mode)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeWithSudo stages the new file in the temp directory and moves it into
+// place with sudo, keeping the final step a single rename
+func writeWithSudo(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp("", "instanttls-hosts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	staged := path + ".instanttls-new"
+	steps := [][]string{
+		{"install", "-m", fmt.Sprintf("%o", mode), tmp.Name(), staged},
+		{"mv", "-f", staged, path},
+	}
+	for _, step := range steps {
+		cmd := exec.Command("sudo", step...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to update %s with sudo: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func normalizeHostname(hostname string) (string, error) {
+	hostname = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	switch {
+	case hostname == "":
+		return "", fmt.Errorf("empty hostname")
+	case strings.Contains(hostname, "*"):
+		return "", fmt.Errorf("%q: hosts files do not support wildcards (use 'instanttls dns serve' instead)", hostname)
+	case net.ParseIP(hostname) != nil:
+		return "", fmt.Errorf("%q is an IP address, not a hostname", hostname)
+	case strings.ContainsAny(hostname, " \t#"):
+		return "", fmt.Errorf("invalid hostname %q", hostname)
+	}
+	return hostname, nil
+}
+
+func isBusy(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,224 @@
+package hosts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testHosts = "127.0.0.1\tlocalhost\n" +
+	"::1\tlocalhost ip6-localhost\n" +
+	"# a comment someone left\n" +
+	"10.0.0.5\tbuild.internal\n"
+
+// tempHosts writes content to a temporary hosts file and points PathEnv at
+// it, so the tests go through the same lookup as the commands
+func tempHosts(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(PathEnv, path)
+	if got := DefaultPath(); got != path {
+		t.Fatalf("DefaultPath() = %q, want %q from %s", got, path, PathEnv)
+	}
+	return path
+}
+
+func load(t *testing.T) *File {
+	t.Helper()
+
+	f, err := Load(DefaultPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAddRemove(t *testing.T) {
+	path := tempHosts(t, testHosts)
+
+	f := load(t)
+	changed, err := f.Add(DefaultIP, "App.Local.Test.", "api.local.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("Add reported no change")
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := testHosts +
+		beginMarker + "\n" +
+		"127.0.0.1\tapi.local.test\n" +
+		"127.0.0.1\tapp.local.test\n" +
+		endMarker + "\n"
+	if got := read(t, path); got != want {
+		t.Fatalf("after add:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Adding the same entries again changes nothing
+	f = load(t)
+	changed, err = f.Add(DefaultIP, "app.local.test", "api.local.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || f.Changed() {
+		t.Error("adding existing entries reported a change")
+	}
+
+	// A new address replaces the managed entry instead of adding another
+	changed, err = f.Add("127.0.0.2", "app.local.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("changing an address reported no change")
+	}
+	if entries := f.Entries(); len(entries) != 2 || entries[1] != (Entry{IP: "127.0.0.2", Hostname: "app.local.test"}) {
+		t.Errorf("entries = %+v", entries)
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	f = load(t)
+	if !f.Remove("app.local.test") {
+		t.Error("Remove reported no change")
+	}
+	if f.Remove("app.local.test", "missing.local.test") {
+		t.Error("removing absent entries reported a change")
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the last entry drops the block and restores the original
+	f = load(t)
+	if !f.Remove("API.local.test") {
+		t.Error("Remove reported no change")
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, path); got != testHosts {
+		t.Fatalf("after removing every entry:\n%s\nwant:\n%s", got, testHosts)
+	}
+}
+
+func TestContentOutsideBlock(t *testing.T) {
+	before := "# header\r\n127.0.0.1 localhost\r\n"
+	after := "\r\n# added by another tool\r\n192.168.1.10 nas"
+	path := tempHosts(t, before+beginMarker+"\r\n127.0.0.1\told.local.test\r\n"+endMarker+after)
+
+	f := load(t)
+	if _, err := f.Add(DefaultIP, "new.local.test"); err != nil {
+		t.Fatal(err)
+	}
+	f.Remove("old.local.test")
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := before + beginMarker + "\r\n127.0.0.1\tnew.local.test\r\n" + endMarker + after
+	if got := read(t, path); got != want {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, want)
+	}
+
+	f = load(t)
+	if !f.RemoveAll() {
+		t.Error("RemoveAll reported no change")
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, path); got != before+strings.TrimPrefix(after, "\r\n") {
+		t.Fatalf("after RemoveAll:\n%q", got)
+	}
+}
+
+func TestUnmanaged(t *testing.T) {
+	tempHosts(t, testHosts)
+
+	f := load(t)
+	if _, err := f.Add(DefaultIP, "app.local.test"); err != nil {
+		t.Fatal(err)
+	}
+	got := f.Unmanaged("app.local.test", "Build.Internal", "localhost")
+	if len(got) != 2 || got[0] != "Build.Internal" || got[1] != "localhost" {
+		t.Errorf("Unmanaged = %v", got)
+	}
+}
+
+// A dry run renders the changes without saving them
+func TestDryRun(t *testing.T) {
+	path := tempHosts(t, testHosts)
+
+	f := load(t)
+	if _, err := f.Add(DefaultIP, "app.local.test"); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Changed() {
+		t.Fatal("Changed() is false after Add")
+	}
+	if !strings.Contains(f.String(), "127.0.0.1\tapp.local.test") {
+		t.Errorf("rendered file lacks the new entry:\n%s", f.String())
+	}
+	if got := read(t, path); got != testHosts {
+		t.Errorf("file was written without Save:\n%s", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv(PathEnv, filepath.Join(t.TempDir(), "hosts"))
+
+		f := load(t)
+		if _, err := f.Add(DefaultIP, "app.local.test"); err != nil {
+			t.Fatal(err)
+		}
+		want := beginMarker + "\n127.0.0.1\tapp.local.test\n" + endMarker + "\n"
+		if got := f.String(); got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("unterminated block", func(t *testing.T) {
+		tempHosts(t, testHosts+beginMarker+"\n127.0.0.1\tapp.local.test\n")
+		if _, err := Load(DefaultPath()); err == nil {
+			t.Error("loaded a file with an unterminated block")
+		}
+	})
+}
+
+func TestAddInvalid(t *testing.T) {
+	f := &File{}
+	for _, tc := range []struct {
+		ip, hostname string
+	}{
+		{"localhost", "app.local.test"},
+		{DefaultIP, "*.local.test"},
+		{DefaultIP, "10.0.0.1"},
+		{DefaultIP, "app local"},
+		{DefaultIP, " "},
+	} {
+		if _, err := f.Add(tc.ip, tc.hostname); err == nil {
+			t.Errorf("Add(%q, %q) succeeded", tc.ip, tc.hostname)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package k8s renders InstantTLS certificates and the CA as Kubernetes
+// manifests: kubernetes.io/tls Secrets for workloads, and a CA keypair
+// Secret with a cert-manager Issuer or ClusterIssuer that signs from it.
+package k8s
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	KindIssuer        = "Issuer"
+	KindClusterIssuer = "ClusterIssuer"
+
+	// DefaultNamespace is where Secrets and Issuers go unless told otherwise
+	DefaultNamespace = "default"
+
+	// ClusterResourceNamespace is where cert-manager looks for the Secrets
+	// of ClusterIssuers in a default installation
+	ClusterResourceNamespace = "cert-manager"
+
+	// DefaultIssuerName names the issuer, and with a -ca suffix its Secret
+	DefaultIssuerName = "instanttls"
+)
+
+// invalidNameChars are the characters a DNS-1123 name cannot contain
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// SecretName derives a Secret name from a certificate's domain, e.g.
+// "*.local.test" becomes "wildcard-local-test-tls"
+func SecretName(domain string) string {
+	name := strings.ToLower(domain)
+	if strings.HasPrefix(name, "*.") {
+		name = "wildcard." + name[2:]
+	}
+	name = strings.Trim(invalidNameChars.ReplaceAllString(name, "-"), "-")
+
+	// Names are limited to 253 characters; leave room for the suffix
+	if len(name) > 249 {
+		name = strings.TrimRight(name[:249], "-")
+	}
+	return name + "-tls"
+}
+
+// ParseKind validates an issuer kind, accepting any capitalisation
+func ParseKind(s string) (string, error) {
+	for _, kind := range []string{KindIssuer, KindClusterIssuer} {
+		if strings.EqualFold(s, kind) {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported issuer kind %q (supported: %s, %s)", s, KindIssuer, KindClusterIssuer)
+}
+
+// TLSSecret renders a kubernetes.io/tls Secret. caPEM is optional; when
+// given it is added as ca.crt, which ingress controllers and service
+// meshes use to verify clients and upstreams.
+func TLSSecret(name, namespace string, chainPEM, keyPEM, caPEM []byte) string {
+	var b strings.Builder
+	writeSecretHeader(&b, name, namespace)
+	b.WriteString("type: kubernetes.io/tls\n")
+	b.WriteString("data:\n")
+	writeData(&b, "tls.crt", chainPEM)
+	writeData(&b, "tls.key", keyPEM)
+	if len(caPEM) > 0 {
+		writeData(&b, "ca.crt", caPEM)
+	}
+	return b.String()
+}
+
+// CAIssuer renders a Secret holding the CA keypair and a cert-manager
+// issuer of the given kind that signs with it. A ClusterIssuer's Secret
+// must live in cert-manager's cluster resource namespace.
+func CAIssuer(kind, name, namespace string, chainPEM, keyPEM []byte) string {
+	secretName := name + "-ca"
+
+	var b strings.Builder
+	b.WriteString(TLSSecret(secretName, namespace, chainPEM, keyPEM, nil))
+	b.WriteString("---\n")
+	b.WriteString("apiVersion: cert-manager.io/v1\n")
+	fmt.Fprintf(&b, "kind: %s\n", kind)
+	b.WriteString("metadata:\n")
+	fmt.Fprintf(&b, "  name: %s\n", name)
+	if kind == KindIssuer {
+		fmt.Fprintf(&b, "  namespace: %s\n", namespace)
+	}
+	writeLabels(&b)
+	b.WriteString("spec:\n")
+	b.WriteString("  ca:\n")
+	fmt.Fprintf(&b, "    secretName: %s\n", secretName)
+	return b.String()
+}
+
+func writeSecretHeader(b *strings.Builder, name, namespace string) {
+	b.WriteString("apiVersion: v1\n")
+	b.WriteString("kind: Secret\n")
+	b.WriteString("metadata:\n")
+	fmt.Fprintf(b, "  name: %s\n", name)
+	fmt.Fprintf(b, "  namespace: %s\n", namespace)
+	writeLabels(b)
+}
+
+func writeLabels(b *strings.Builder) {
+	b.WriteString("  labels:\n")
+	b.WriteString("    app.kubernetes.io/managed-by: instanttls\n")
+}
+
+func writeData(b *strings.Builder, key string, value []byte) {
+	fmt.Fprintf(b, "  %s: %s\n", key, base64.StdEncoding.EncodeToString(value))
+}
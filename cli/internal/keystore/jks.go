@@ -0,0 +1,27 @@
+package keystore
+
+import "crypto/sha1"
+
+const (
+	JKSMagic   = 0xFEEDFEED
+	JCEKSMagic = 0xCECECECE
+	JKSVersion = 2
+
+	JKSPrivateKeyTag  = 1
+	JKSTrustedCertTag = 2
+
+	// jksWhitener is mixed into the integrity digest by every JKS
+	// implementation since JDK 1.2
+	jksWhitener = "Mighty Aphrodite"
+)
+
+// JKSDigest is the keystore integrity check appended to a JKS file: SHA-1
+// over the password as UTF-16BE, a fixed whitener and the keystore
+// contents
+func JKSDigest(body []byte, password string) []byte {
+	h := sha1.New()
+	h.Write(EncodeBMPString(password))
+	h.Write([]byte(jksWhitener))
+	h.Write(body)
+	return h.Sum(nil)
+}
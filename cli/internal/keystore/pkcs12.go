@@ -0,0 +1,198 @@
+// Package keystore holds the PBES2, PKCS#12 and JKS encodings shared by the
+// keys and keystores "instanttls cert" writes and the trust stores
+// "instanttls install" edits.
+package keystore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+)
+
+var (
+	OIDDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	OIDEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	OIDShroudedKeyBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	OIDCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	OIDX509Certificate          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	OIDFriendlyName             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	OIDLocalKeyID               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	OIDSHA1                     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	OIDSHA256                   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// PFXPDU is the outer structure of a PKCS#12 file. MacData is absent from
+// keystores without a password.
+type PFXPDU struct {
+	Version  int
+	AuthSafe ContentInfo
+	MacData  MacData `asn1:"optional"`
+}
+
+type ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type MacData struct {
+	Mac        DigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type EncryptedData struct {
+	Version              int
+	EncryptedContentInfo EncryptedContentInfo
+}
+
+type EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue `asn1:"tag:0,explicit"`
+	Attributes []Attribute   `asn1:"set,optional"`
+}
+
+type Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// ExplicitContent wraps DER as the [0] EXPLICIT content of a ContentInfo
+// or SafeBag
+func ExplicitContent(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// Set wraps DER as the value set of an Attribute
+func Set(der []byte) asn1.RawValue {
+	return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+}
+
+// DataContentInfo wraps DER content in an unencrypted ContentInfo
+func DataContentInfo(content []byte) ([]byte, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ContentInfo{ContentType: OIDDataContentType, Content: ExplicitContent(octets)})
+}
+
+// FriendlyName returns the friendly name attribute carrying alias
+func FriendlyName(alias string) (Attribute, error) {
+	name, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: EncodeBMPString(alias)})
+	if err != nil {
+		return Attribute{}, err
+	}
+	return Attribute{ID: OIDFriendlyName, Value: Set(name)}, nil
+}
+
+// MACHash returns the hash of a MAC algorithm keystores use
+func MACHash(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case oid.Equal(OIDSHA1):
+		return sha1.New, nil
+	case oid.Equal(OIDSHA256):
+		return sha256.New, nil
+	}
+	return nil, fmt.Errorf("unsupported keystore MAC algorithm %s", oid)
+}
+
+// MAC computes the integrity MAC of a PKCS#12 file over its authenticated
+// safe, with a key derived from the password
+func MAC(newHash func() hash.Hash, data []byte, password string, salt []byte, iterations int) []byte {
+	key := KDF(newHash, EncodeBMPPassword(password), salt, iterations, 3, newHash().Size())
+	mac := hmac.New(newHash, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// KDF is the key derivation function of RFC 7292, appendix B.2. id selects
+// the purpose: 1 for keys, 2 for IVs, 3 for MAC keys.
+func KDF(newHash func() hash.Hash, password, salt []byte, iterations int, id byte, size int) []byte {
+	const v = 64 // block size of SHA-1 and SHA-256
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h := newHash()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for n := 1; n < iterations; n++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+
+		// I_j = (I_j + B + 1) mod 2^v for every v-byte block of I
+		b := fill(a)[:v]
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// EncodeBMPPassword encodes a password for the PKCS#12 KDF: UTF-16BE with
+// a terminating zero
+func EncodeBMPPassword(password string) []byte {
+	return append(EncodeBMPString(password), 0, 0)
+}
+
+// EncodeBMPString encodes s as UTF-16BE, as ASN.1 BMPStrings and JKS
+// passwords are
+func EncodeBMPString(s string) []byte {
+	var out []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(c>>8), byte(c))
+	}
+	return out
+}
+
+func DecodeBMPString(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,211 @@
+// Package license verifies and caches the signed license the API issues,
+// so plan checks hold offline and cannot be changed by editing a file.
+package license
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/instanttls/cli/internal/config"
+)
+
+// PublicKey is the base64 Ed25519 key licenses must be signed with, set at
+// build time with
+// -ldflags "-X github.com/instanttls/cli/internal/license.PublicKey=..."
+// Builds without it accept no license at all.
+var PublicKey = ""
+
+// ErrNoPublicKey means the CLI was built without a license public key
+var ErrNoPublicKey = errors.New("this build of the CLI has no license public key; rebuild it with LICENSE_PUBLIC_KEY set")
+
+const (
+	// File is where the last verified license is cached, in the config
+	// directory
+	File = "license"
+
+	// GracePeriod is how long past its expiry a cached license is still
+	// honored while the API cannot be reached
+	GracePeriod = 7 * 24 * time.Hour
+)
+
+// License is a verified license
+type License struct {
+	OrgID     string         `json:"org_id"`
+	OrgName   string         `json:"org_name"`
+	Email     string         `json:"email"`
+	Plan      string         `json:"plan"`
+	Limits    map[string]int `json:"limits"`
+	Machine   string         `json:"machine"`
+	IssuedAt  time.Time      `json:"issued_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// Expired reports whether the license needs renewing from the API. An
+// expired license is still usable offline until GraceEnds.
+func (l *License) Expired() bool {
+	return time.Now().After(l.ExpiresAt)
+}
+
+// GraceEnds returns when the license stops being honored offline
+func (l *License) GraceEnds() time.Time {
+	return l.ExpiresAt.Add(GracePeriod)
+}
+
+// Unlimited reports whether the plan places no limit on the named resource
+func (l *License) Unlimited(limit string) bool {
+	return l.Limits[limit] < 0
+}
+
+// MachineID identifies this machine to the API. Licenses are bound to it so
+// a cached license cannot simply be copied to another machine. It is derived
+// from the OS machine ID (/etc/machine-id, the macOS IOPlatformUUID or the
+// Windows MachineGuid), falling back to the hostname. This is a weak
+// binding: the values are under the user's control, so it stops casual
+// copying, not someone who sets them on purpose.
+func MachineID() string {
+	id := osMachineID()
+	if id == "" {
+		hostname, _ := os.Hostname()
+		id = "hostname:" + hostname
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{id, runtime.GOOS, runtime.GOARCH}, "|")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// osMachineID returns the ID the OS assigned to this installation, or "" if
+// it cannot be read
+func osMachineID() string {
+	switch runtime.GOOS {
+	case "linux", "freebsd":
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id", "/etc/hostid"} {
+			if data, err := os.ReadFile(path); err == nil {
+				if id := strings.TrimSpace(string(data)); id != "" {
+					return id
+				}
+			}
+		}
+	case "darwin":
+		out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.Contains(line, "IOPlatformUUID") {
+				if _, value, ok := strings.Cut(line, "="); ok {
+					return strings.Trim(strings.TrimSpace(value), `"`)
+				}
+			}
+		}
+	case "windows":
+		out, err := exec.Command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid").Output()
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Fields(line); len(fields) == 3 && fields[0] == "MachineGuid" {
+				return fields[2]
+			}
+		}
+	}
+	return ""
+}
+
+// Verify checks a signed license against PublicKey and this machine. It
+// does not check expiry, so callers can apply the grace period.
+func Verify(signed string) (*License, error) {
+	if PublicKey == "" {
+		return nil, ErrNoPublicKey
+	}
+	key, err := base64.StdEncoding.DecodeString(PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid embedded license public key")
+	}
+
+	payload, sig, ok := strings.Cut(strings.TrimSpace(signed), ".")
+	if !ok {
+		return nil, errors.New("malformed license")
+	}
+	rawSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), []byte(payload), rawSig) {
+		return nil, errors.New("license signature is invalid")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("malformed license")
+	}
+	var l License
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("malformed license: %w", err)
+	}
+
+	if l.Machine != MachineID() {
+		return nil, errors.New("license was issued to another machine")
+	}
+	return &l, nil
+}
+
+// Save verifies a signed license and caches it
+func Save(signed string) (*License, error) {
+	l, err := Verify(signed)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(config.GetConfigDir(), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path(), []byte(signed), 0600); err != nil {
+		return nil, fmt.Errorf("failed to cache license: %w", err)
+	}
+	return l, nil
+}
+
+// Load returns the cached license if it verifies and its grace period has
+// not ended
+func Load() (*License, error) {
+	if PublicKey == "" {
+		return nil, ErrNoPublicKey
+	}
+
+	data, err := os.ReadFile(path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("no cached license; connect to the API once to get one")
+		}
+		return nil, err
+	}
+
+	l, err := Verify(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("cached license: %w", err)
+	}
+	if time.Now().After(l.GraceEnds()) {
+		return nil, fmt.Errorf("cached license expired on %s; connect to the API to renew it", l.ExpiresAt.Local().Format("2006-01-02"))
+	}
+	return l, nil
+}
+
+// Remove deletes the cached license
+func Remove() error {
+	err := os.Remove(path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func path() string {
+	return filepath.Join(config.GetConfigDir(), File)
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/instanttls/cli/internal/cert"
+)
+
+// renewBefore is how close to expiry a certificate may get before the proxy
+// stops using it and issues a fresh one
+const renewBefore = 24 * time.Hour
+
+// CertSource picks certificates by SNI from ~/.instanttls/certs and issues
+// missing ones on demand from the local CA
+type CertSource struct {
+	routes []Route
+	// Issue enables on-demand issuance for routed hosts without a certificate
+	Issue bool
+	// OnIssue, if set, is called after a certificate has been issued
+	OnIssue func(host, certDir string)
+
+	// mu guards cache and inflight only; it is never held while reading
+	// the disk or issuing, so cached hosts are served during an issuance
+	mu       sync.Mutex
+	cache    map[string]*tls.Certificate
+	inflight map[string]*certFlight
+	// issueMu serializes issuance: the CA key and its cached passphrase
+	// are not safe for concurrent use
+	issueMu sync.Mutex
+}
+
+// certFlight is a lookup in progress for one host, which handshakes for
+// the same host wait on rather than starting their own
+type certFlight struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// NewCertSource creates a certificate source for the given routes
+func NewCertSource(routes []Route) *CertSource {
+	return &CertSource{
+		routes:   routes,
+		Issue:    true,
+		cache:    make(map[string]*tls.Certificate),
+		inflight: make(map[string]*certFlight),
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (s *CertSource) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if host == "" {
+		return nil, fmt.Errorf("client did not send SNI")
+	}
+
+	// Only serve hosts that are routed, so the proxy never issues
+	// certificates for arbitrary names clients ask for
+	if _, ok := match(s.routes, host); !ok {
+		return nil, fmt.Errorf("no route for %s", host)
+	}
+
+	s.mu.Lock()
+	if c := s.cache[host]; c != nil && usable(c) {
+		s.mu.Unlock()
+		return c, nil
+	}
+	if f := s.inflight[host]; f != nil {
+		s.mu.Unlock()
+		<-f.done
+		return f.cert, f.err
+	}
+	f := &certFlight{done: make(chan struct{})}
+	s.inflight[host] = f
+	s.mu.Unlock()
+
+	f.cert, f.err = s.obtain(host)
+
+	s.mu.Lock()
+	delete(s.inflight, host)
+	if f.err == nil {
+		s.cache[host] = f.cert
+	}
+	s.mu.Unlock()
+	close(f.done)
+
+	return f.cert, f.err
+}
+
+// obtain loads a certificate for host from disk, issuing one if there is
+// none and issuance is enabled
+func (s *CertSource) obtain(host string) (*tls.Certificate, error) {
+	c, err := findCertificate(host)
+	if err != nil || c != nil {
+		return c, err
+	}
+	if !s.Issue {
+		return nil, fmt.Errorf("no certificate for %s", host)
+	}
+
+	s.issueMu.Lock()
+	defer s.issueMu.Unlock()
+
+	// A single host name never counts toward a quota, so there is nothing
+	// to report
+	certDir, err := cert.GenerateCert(cert.CertRequest{Names: []string{host}}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate for %s: %w", host, err)
+	}
+	if c, err = loadCertificate(certDir); err != nil {
+		return nil, err
+	}
+	if s.OnIssue != nil {
+		s.OnIssue(host, certDir)
+	}
+	return c, nil
+}
+
+// findCertificate returns the longest-lived usable certificate on disk that
+// covers host, or nil if there is none
+func findCertificate(host string) (*tls.Certificate, error) {
+	certs, err := cert.ListCerts()
+	if err != nil {
+		return nil, err
+	}
+
+	var best *cert.CertInfo
+	for i, info := range certs {
+		if time.Until(info.NotAfter) < renewBefore || !covers(info.Names, host) {
+			continue
+		}
+		if best == nil || info.NotAfter.After(best.NotAfter) {
+			best = &certs[i]
+		}
+	}
+
+	if best == nil {
+		return nil, nil
+	}
+	return loadCertificate(best.Path)
+}
+
+// loadCertificate loads a certificate directory, preferring the full chain
+func loadCertificate(certDir string) (*tls.Certificate, error) {
+	certFile := filepath.Join(certDir, "fullchain.pem")
+	if _, err := os.Stat(certFile); err != nil {
+		certFile = filepath.Join(certDir, "cert.pem")
+	}
+
+	c, err := tls.LoadX509KeyPair(certFile, filepath.Join(certDir, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate from %s: %w", certDir, err)
+	}
+	if c.Leaf == nil {
+		if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("failed to parse certificate from %s: %w", certDir, err)
+		}
+	}
+	return &c, nil
+}
+
+func covers(names []string, host string) bool {
+	for _, name := range names {
+		if hostMatches(name, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func usable(c *tls.Certificate) bool {
+	return c.Leaf != nil && time.Until(c.Leaf.NotAfter) >= renewBefore
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/instanttls/cli/internal/cert"
+)
+
+func TestGetCertificateConcurrentIssuance(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := cert.GenerateCA(cert.CAOptions{KeyType: cert.KeyTypeECDSAP256}); err != nil {
+		t.Fatal(err)
+	}
+
+	src := NewCertSource([]Route{{Host: "*.local.test"}})
+	hello := func(host string) *tls.ClientHelloInfo {
+		return &tls.ClientHelloInfo{ServerName: host}
+	}
+
+	cached, err := src.GetCertificate(hello("cached.local.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the next issuance open until the checks below are done
+	issuing := make(chan struct{})
+	release := make(chan struct{})
+	var issued atomic.Int32
+	src.OnIssue = func(host, certDir string) {
+		if issued.Add(1) == 1 {
+			close(issuing)
+		}
+		<-release
+	}
+
+	const handshakes = 5
+	results := make([]*tls.Certificate, handshakes)
+	errs := make([]error, handshakes)
+	var wg sync.WaitGroup
+	for i := 0; i < handshakes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = src.GetCertificate(hello("slow.local.test"))
+		}(i)
+	}
+
+	select {
+	case <-issuing:
+	case <-time.After(30 * time.Second):
+		t.Fatal("issuance did not start")
+	}
+
+	// A cached host is served while another one is being issued
+	done := make(chan *tls.Certificate)
+	go func() {
+		c, _ := src.GetCertificate(hello("cached.local.test"))
+		done <- c
+	}()
+	select {
+	case c := <-done:
+		if c != cached {
+			t.Error("cached host was not served from the cache")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cached host blocked behind an issuance")
+	}
+
+	close(release)
+	wg.Wait()
+
+	if n := issued.Load(); n != 1 {
+		t.Errorf("issued %d certificates for one host, want 1", n)
+	}
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("handshake %d: %v", i, errs[i])
+		}
+		if results[i] != results[0] {
+			t.Errorf("handshake %d got a different certificate", i)
+		}
+	}
+}
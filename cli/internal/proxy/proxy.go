@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// Handler routes requests to upstreams by Host header
+type Handler struct {
+	routes  []Route
+	proxies map[string]*httputil.ReverseProxy
+}
+
+// Options configures how requests are forwarded
+type Options struct {
+	// PreserveHost forwards the original Host header instead of the
+	// upstream's host
+	PreserveHost bool
+	// Logf, if set, receives upstream errors
+	Logf func(format string, args ...interface{})
+}
+
+// NewHandler creates a reverse proxy for the given routes. WebSocket upgrades
+// are forwarded as-is; clients may speak HTTP/1.1 or HTTP/2 to the proxy.
+func NewHandler(routes []Route, opts Options) *Handler {
+	h := &Handler{
+		routes:  routes,
+		proxies: make(map[string]*httputil.ReverseProxy),
+	}
+
+	for _, route := range routes {
+		route := route
+		h.proxies[route.Host] = &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(route.Upstream)
+				pr.SetXForwarded()
+				if addr, ok := pr.In.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+					if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+						pr.Out.Header.Set("X-Forwarded-Port", port)
+					}
+				}
+				if opts.PreserveHost {
+					pr.Out.Host = pr.In.Host
+				}
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				if opts.Logf != nil {
+					opts.Logf("%s %s%s → %s: %v", r.Method, r.Host, r.URL.Path, route.Upstream, err)
+				}
+				http.Error(w, fmt.Sprintf("InstantTLS proxy: upstream %s is unavailable", route.Upstream), http.StatusBadGateway)
+			},
+		}
+	}
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	route, ok := match(h.routes, host)
+	if !ok {
+		http.Error(w, fmt.Sprintf("InstantTLS proxy: no route for %s", host), http.StatusNotFound)
+		return
+	}
+
+	h.proxies[route.Host].ServeHTTP(w, r)
+}
+
+// RedirectHandler sends plain HTTP requests to the HTTPS proxy
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
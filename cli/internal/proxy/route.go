@@ -0,0 +1,104 @@
+// Package proxy implements the HTTPS reverse proxy behind "instanttls proxy".
+// It terminates TLS with certificates from the local CA and forwards plain
+// HTTP, WebSocket and HTTP/2 traffic to local upstream servers.
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Route sends requests for a host to an upstream server
+type Route struct {
+	// Host is an exact hostname or a wildcard such as "*.local.test"
+	Host     string
+	Upstream *url.URL
+}
+
+// ParseRoute parses a "host=upstream" argument. The upstream may be a port
+// ("3000"), a host and port ("localhost:3000") or a full http:// or https://
+// URL.
+func ParseRoute(arg string) (Route, error) {
+	host, upstream, ok := strings.Cut(arg, "=")
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	upstream = strings.TrimSpace(upstream)
+	if !ok || host == "" || upstream == "" {
+		return Route{}, fmt.Errorf("invalid route %q: expected host=upstream", arg)
+	}
+
+	if strings.Contains(host, "*") && !strings.HasPrefix(host, "*.") {
+		return Route{}, fmt.Errorf("invalid route host %q: wildcard is only allowed as the leftmost label", host)
+	}
+
+	target, err := parseUpstream(upstream)
+	if err != nil {
+		return Route{}, fmt.Errorf("invalid upstream for %s: %w", host, err)
+	}
+
+	return Route{Host: host, Upstream: target}, nil
+}
+
+func parseUpstream(s string) (*url.URL, error) {
+	if _, err := strconv.Atoi(s); err == nil {
+		s = "localhost:" + s
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+
+	return u, nil
+}
+
+// Matches reports whether the route serves the given hostname
+func (r Route) Matches(host string) bool {
+	return hostMatches(r.Host, host)
+}
+
+// hostMatches compares a hostname with an exact or wildcard pattern. A
+// wildcard matches exactly one label, like a wildcard certificate.
+func hostMatches(pattern, host string) bool {
+	if pattern == host {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		label, rest, found := strings.Cut(host, ".")
+		return found && label != "" && "."+rest == suffix
+	}
+	return false
+}
+
+// String formats the route for display
+func (r Route) String() string {
+	return r.Host + " → " + r.Upstream.String()
+}
+
+// match returns the route for a hostname, preferring exact routes over
+// wildcards
+func match(routes []Route, host string) (Route, bool) {
+	var wildcard *Route
+	for i, r := range routes {
+		if r.Host == host {
+			return r, true
+		}
+		if wildcard == nil && r.Matches(host) {
+			wildcard = &routes[i]
+		}
+	}
+	if wildcard != nil {
+		return *wildcard, true
+	}
+	return Route{}, false
+}
@@ -0,0 +1,169 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	// PassphraseEnv holds the CA key passphrase directly
+	PassphraseEnv = "INSTANTTLS_CA_PASSPHRASE"
+	// PassphraseCommandEnv names a command that prints the passphrase,
+	// e.g. "pass show instanttls" or "op read op://dev/instanttls/password"
+	PassphraseCommandEnv = "INSTANTTLS_CA_PASSPHRASE_COMMAND"
+
+	keyringService = "instanttls"
+	keyringAccount = "ca-key-passphrase"
+)
+
+// ErrNotFound is returned by a provider that has no secret to offer
+var ErrNotFound = errors.New("secret not found")
+
+// Provider is a source of the CA key passphrase
+type Provider interface {
+	Name() string
+	Passphrase() ([]byte, error)
+}
+
+// Providers returns the non-interactive providers in lookup order
+func Providers() []Provider {
+	return []Provider{EnvProvider{}, CommandProvider{}, KeyringProvider{}}
+}
+
+// Lookup returns the first passphrase any provider can supply
+func Lookup(providers ...Provider) ([]byte, error) {
+	for _, p := range providers {
+		passphrase, err := p.Passphrase()
+		if err == nil {
+			return passphrase, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// EnvProvider reads the passphrase from INSTANTTLS_CA_PASSPHRASE
+type EnvProvider struct{}
+
+func (EnvProvider) Name() string { return PassphraseEnv }
+
+func (EnvProvider) Passphrase() ([]byte, error) {
+	if v, ok := os.LookupEnv(PassphraseEnv); ok && v != "" {
+		return []byte(v), nil
+	}
+	return nil, ErrNotFound
+}
+
+// CommandProvider runs INSTANTTLS_CA_PASSPHRASE_COMMAND and uses its output,
+// which lets any password manager act as the secret store
+type CommandProvider struct{}
+
+func (CommandProvider) Name() string { return PassphraseCommandEnv }
+
+func (CommandProvider) Passphrase() ([]byte, error) {
+	command := os.Getenv(PassphraseCommandEnv)
+	if command == "" {
+		return nil, ErrNotFound
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("passphrase command failed: %w", err)
+	}
+
+	passphrase := bytes.TrimRight(out, "\r\n")
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase command printed nothing")
+	}
+	return passphrase, nil
+}
+
+// KeyringProvider stores the passphrase in the OS keyring: the login
+// Keychain on macOS, or the Secret Service via secret-tool on Linux
+type KeyringProvider struct{}
+
+func (KeyringProvider) Name() string { return "OS keyring" }
+
+func (KeyringProvider) Passphrase() ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, ErrNotFound
+		}
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	default:
+		return nil, ErrNotFound
+	}
+
+	out, err := cmd.Output()
+	passphrase := bytes.TrimRight(out, "\r\n")
+	if err != nil || len(passphrase) == 0 {
+		return nil, ErrNotFound
+	}
+	return passphrase, nil
+}
+
+// Store saves the passphrase in the OS keyring
+func (KeyringProvider) Store(passphrase []byte) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// Feed the command through "security -i" with a hex-encoded password
+		// so the passphrase never shows up in the process list
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+			keyringService, keyringAccount, hex.EncodeToString(passphrase)))
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return fmt.Errorf("secret-tool not found; install libsecret-tools to use the keyring")
+		}
+		cmd = exec.Command("secret-tool", "store", "--label=InstantTLS CA key passphrase",
+			"service", keyringService, "account", keyringAccount)
+		cmd.Stdin = bytes.NewReader(passphrase)
+	default:
+		return fmt.Errorf("OS keyring is not supported on %s", runtime.GOOS)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store passphrase in keyring: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Delete removes the passphrase from the OS keyring, if present
+func (KeyringProvider) Delete() error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", keyringAccount)
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil
+		}
+		cmd = exec.Command("secret-tool", "clear", "service", keyringService, "account", keyringAccount)
+	default:
+		return nil
+	}
+
+	_ = cmd.Run()
+	return nil
+}
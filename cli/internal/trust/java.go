@@ -0,0 +1,230 @@
+package trust
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/instanttls/cli/internal/keystore"
+)
+
+// javaStorePassword is the well-known password of JDK cacerts files
+const javaStorePassword = "changeit"
+
+// JavaKeystore is the cacerts file of a JDK or JRE
+type JavaKeystore struct {
+	Path string
+}
+
+func (ks JavaKeystore) String() string {
+	return "Java, " + ks.Path
+}
+
+// javaAlias names the CA in a keystore. The fingerprint keeps CAs from
+// different machines or rotations apart.
+func javaAlias(caCert *x509.Certificate) string {
+	sum := sha256.Sum256(caCert.Raw)
+	return "instanttls-" + hex.EncodeToString(sum[:8])
+}
+
+// FindJavaKeystores returns the cacerts files of JAVA_HOME, of the java on
+// PATH and of the JDKs in the usual install locations. Distributions that
+// share one cacerts between JDKs through symlinks yield it once.
+func FindJavaKeystores() []JavaKeystore {
+	var homes []string
+	if home := os.Getenv("JAVA_HOME"); home != "" {
+		homes = append(homes, home)
+	}
+	if java, err := exec.LookPath("java"); err == nil {
+		if resolved, err := filepath.EvalSymlinks(java); err == nil {
+			// <home>/bin/java
+			homes = append(homes, filepath.Dir(filepath.Dir(resolved)))
+		}
+	}
+
+	var patterns []string
+	switch runtime.GOOS {
+	case "linux":
+		patterns = []string{"/usr/lib/jvm/*", "/usr/lib64/jvm/*", "/opt/java/*"}
+	case "darwin":
+		patterns = []string{"/Library/Java/JavaVirtualMachines/*/Contents/Home"}
+	case "windows":
+		patterns = []string{`C:\Program Files\Java\*`, `C:\Program Files\Eclipse Adoptium\*`}
+	}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		homes = append(homes, matches...)
+	}
+
+	seen := make(map[string]bool)
+	var stores []JavaKeystore
+	for _, home := range homes {
+		for _, rel := range []string{"lib/security/cacerts", "jre/lib/security/cacerts"} {
+			path := filepath.Join(home, filepath.FromSlash(rel))
+			resolved, err := filepath.EvalSymlinks(path)
+			if err != nil || seen[resolved] {
+				continue
+			}
+			seen[resolved] = true
+			stores = append(stores, JavaKeystore{Path: resolved})
+		}
+	}
+	return stores
+}
+
+// keystoreEntry is a trusted certificate entry of a keystore
+type keystoreEntry struct {
+	Alias string
+	Cert  *x509.Certificate
+}
+
+// javaStore is a JKS or PKCS#12 keystore read into memory. Entries other
+// than trusted certificates are carried through a rewrite unchanged.
+type javaStore interface {
+	trusted() []keystoreEntry
+	addTrusted(alias string, cert *x509.Certificate) error
+	removeTrusted(match func(*x509.Certificate) bool) int
+	marshal(password string) ([]byte, error)
+}
+
+// load reads the keystore, telling JKS and PKCS#12 apart by their first
+// bytes since cacerts files have no extension
+func (ks JavaKeystore) load() (javaStore, error) {
+	data, err := os.ReadFile(ks.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var store javaStore
+	if len(data) >= 4 && (binary.BigEndian.Uint32(data) == keystore.JKSMagic || binary.BigEndian.Uint32(data) == keystore.JCEKSMagic) {
+		store, err = parseJKS(data, javaStorePassword)
+	} else {
+		store, err = parsePKCS12(data, javaStorePassword)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ks.Path, err)
+	}
+	return store, nil
+}
+
+// Contains reports whether the CA is in the keystore, under any alias
+func (ks JavaKeystore) Contains(caCert *x509.Certificate) (bool, error) {
+	store, err := ks.load()
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range store.trusted() {
+		if entry.Cert.Equal(caCert) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Install adds the CA to the keystore as a trusted certificate, unless it
+// is already there. Keystores owned by root are replaced through run.
+func (ks JavaKeystore) Install(caCert *x509.Certificate, run Runner) error {
+	store, err := ks.load()
+	if err != nil {
+		return err
+	}
+	for _, entry := range store.trusted() {
+		if entry.Cert.Equal(caCert) {
+			return nil
+		}
+	}
+
+	if err := store.addTrusted(javaAlias(caCert), caCert); err != nil {
+		return err
+	}
+	return ks.save(store, run)
+}
+
+// Uninstall removes the CA from the keystore, whatever alias it was
+// imported under. Keystores owned by root are replaced through run.
+func (ks JavaKeystore) Uninstall(caCert *x509.Certificate, run Runner) error {
+	store, err := ks.load()
+	if err != nil {
+		return err
+	}
+	if store.removeTrusted(caCert.Equal) == 0 {
+		return nil
+	}
+	return ks.save(store, run)
+}
+
+func (ks JavaKeystore) save(store javaStore, run Runner) error {
+	data, err := store.marshal(javaStorePassword)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", ks.Path, err)
+	}
+
+	if isWritable(ks.Path) {
+		return writeFileAtomic(ks.Path, data)
+	}
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("%s is not writable. Try running as Administrator", ks.Path)
+	}
+
+	// cp onto the existing file keeps its owner and mode
+	tmp, err := os.CreateTemp("", "instanttls-cacerts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := run("cp", tmp.Name(), ks.Path); err != nil {
+		return fmt.Errorf("failed to update %s: %w", ks.Path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file through a temporary file next to it,
+// keeping its mode
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".instanttls-*")
+	if err != nil {
+		// The directory may be read-only even though the file is not
+		return os.WriteFile(path, data, mode)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func isWritable(path string) bool {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
@@ -0,0 +1,242 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/instanttls/cli/internal/keystore"
+)
+
+// jksEntry is one entry of a JKS keystore. Private key entries are kept as
+// the raw bytes following their alias and date, so they survive a rewrite
+// untouched.
+type jksEntry struct {
+	tag   uint32
+	alias string
+	date  int64
+	cert  *x509.Certificate
+	raw   []byte
+}
+
+// jksKeystore is a JKS keystore, the format of JDK cacerts files up to
+// JDK 17 and of the Debian and Fedora system Java trust stores
+type jksKeystore struct {
+	entries []jksEntry
+}
+
+// parseJKS reads a JKS keystore and checks its integrity digest against
+// password
+func parseJKS(data []byte, password string) (*jksKeystore, error) {
+	if len(data) < 4+sha1.Size {
+		return nil, fmt.Errorf("keystore is truncated")
+	}
+
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if !bytes.Equal(keystore.JKSDigest(body, password), digest) {
+		return nil, fmt.Errorf("keystore password is not %q, or the keystore is corrupt", password)
+	}
+
+	r := bytes.NewReader(body)
+	var header struct {
+		Magic   uint32
+		Version uint32
+		Count   uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("keystore is truncated")
+	}
+	switch {
+	case header.Magic == keystore.JCEKSMagic:
+		return nil, fmt.Errorf("JCEKS keystores are not supported")
+	case header.Magic != keystore.JKSMagic:
+		return nil, fmt.Errorf("not a JKS keystore")
+	case header.Version != keystore.JKSVersion:
+		return nil, fmt.Errorf("unsupported JKS version %d", header.Version)
+	}
+
+	ks := &jksKeystore{}
+	for i := uint32(0); i < header.Count; i++ {
+		entry, err := readJKSEntry(r)
+		if err != nil {
+			return nil, fmt.Errorf("malformed keystore entry %d: %w", i+1, err)
+		}
+		ks.entries = append(ks.entries, entry)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("trailing data after keystore entries")
+	}
+	return ks, nil
+}
+
+func readJKSEntry(r *bytes.Reader) (jksEntry, error) {
+	var entry jksEntry
+	if err := binary.Read(r, binary.BigEndian, &entry.tag); err != nil {
+		return entry, err
+	}
+	alias, err := readJavaUTF(r)
+	if err != nil {
+		return entry, err
+	}
+	entry.alias = alias
+	if err := binary.Read(r, binary.BigEndian, &entry.date); err != nil {
+		return entry, err
+	}
+
+	switch entry.tag {
+	case keystore.JKSTrustedCertTag:
+		der, err := readJKSCert(r)
+		if err != nil {
+			return entry, err
+		}
+		if entry.cert, err = x509.ParseCertificate(der); err != nil {
+			return entry, err
+		}
+
+	case keystore.JKSPrivateKeyTag:
+		start := int(r.Size()) - r.Len()
+		if _, err := readJKSBlob(r); err != nil {
+			return entry, err
+		}
+		var chainLen uint32
+		if err := binary.Read(r, binary.BigEndian, &chainLen); err != nil {
+			return entry, err
+		}
+		for i := uint32(0); i < chainLen; i++ {
+			if _, err := readJKSCert(r); err != nil {
+				return entry, err
+			}
+		}
+		end := int(r.Size()) - r.Len()
+
+		entry.raw = make([]byte, end-start)
+		if _, err := r.ReadAt(entry.raw, int64(start)); err != nil {
+			return entry, err
+		}
+
+	default:
+		return entry, fmt.Errorf("unknown entry type %d", entry.tag)
+	}
+	return entry, nil
+}
+
+// readJKSCert reads a certificate type and its encoding. Only X.509
+// certificates occur in practice.
+func readJKSCert(r *bytes.Reader) ([]byte, error) {
+	certType, err := readJavaUTF(r)
+	if err != nil {
+		return nil, err
+	}
+	if certType != "X.509" {
+		return nil, fmt.Errorf("unsupported certificate type %q", certType)
+	}
+	return readJKSBlob(r)
+}
+
+func readJKSBlob(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	blob := make([]byte, n)
+	_, err := io.ReadFull(r, blob)
+	return blob, err
+}
+
+// readJavaUTF reads a string written by DataOutput.writeUTF. Aliases are
+// compared and written back byte for byte, so the modified UTF-8 encoding
+// needs no decoding.
+func readJavaUTF(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	if int(n) > r.Len() {
+		return "", io.ErrUnexpectedEOF
+	}
+	s := make([]byte, n)
+	_, err := io.ReadFull(r, s)
+	return string(s), err
+}
+
+func (ks *jksKeystore) trusted() []keystoreEntry {
+	var entries []keystoreEntry
+	for _, e := range ks.entries {
+		if e.tag == keystore.JKSTrustedCertTag {
+			entries = append(entries, keystoreEntry{Alias: e.alias, Cert: e.cert})
+		}
+	}
+	return entries
+}
+
+func (ks *jksKeystore) addTrusted(alias string, cert *x509.Certificate) error {
+	ks.entries = append(ks.entries, jksEntry{
+		tag:   keystore.JKSTrustedCertTag,
+		alias: alias,
+		date:  time.Now().UnixMilli(),
+		cert:  cert,
+	})
+	return nil
+}
+
+func (ks *jksKeystore) removeTrusted(match func(*x509.Certificate) bool) int {
+	kept := ks.entries[:0]
+	removed := 0
+	for _, e := range ks.entries {
+		if e.tag == keystore.JKSTrustedCertTag && match(e.cert) {
+			removed++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	ks.entries = kept
+	return removed
+}
+
+func (ks *jksKeystore) marshal(password string) ([]byte, error) {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeUTF := func(s string) error {
+		if len(s) > 0xFFFF {
+			return errors.New("string too long for keystore")
+		}
+		write(uint16(len(s)))
+		buf.WriteString(s)
+		return nil
+	}
+
+	write(uint32(keystore.JKSMagic))
+	write(uint32(keystore.JKSVersion))
+	write(uint32(len(ks.entries)))
+
+	for _, e := range ks.entries {
+		write(e.tag)
+		if err := writeUTF(e.alias); err != nil {
+			return nil, err
+		}
+		write(e.date)
+
+		if e.tag == keystore.JKSPrivateKeyTag {
+			buf.Write(e.raw)
+			continue
+		}
+		if err := writeUTF("X.509"); err != nil {
+			return nil, err
+		}
+		write(uint32(len(e.cert.Raw)))
+		buf.Write(e.cert.Raw)
+	}
+
+	buf.Write(keystore.JKSDigest(buf.Bytes(), password))
+	return buf.Bytes(), nil
+}
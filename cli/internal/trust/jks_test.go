@@ -0,0 +1,139 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/instanttls/cli/internal/keystore"
+)
+
+// jksTestDigest computes the JKS integrity digest as keytool does, without
+// going through keystore.JKSDigest: SHA-1 over the UTF-16BE password, the
+// string "Mighty Aphrodite" and the keystore body
+func jksTestDigest(body []byte, password string) []byte {
+	h := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// newTestJKS builds a keystore in the layout keytool writes, with a private
+// key entry and a trusted certificate entry
+func newTestJKS(t *testing.T, magic uint32, password string) ([]byte, []byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		buf.WriteString(s)
+	}
+
+	write(magic)
+	write(uint32(keystore.JKSVersion))
+	write(uint32(2))
+
+	server := newTestCA(t, "Tomcat Server")
+	var key bytes.Buffer
+	keyBlob := []byte("opaque encrypted private key")
+	binary.Write(&key, binary.BigEndian, uint32(len(keyBlob)))
+	key.Write(keyBlob)
+	binary.Write(&key, binary.BigEndian, uint32(1))
+	binary.Write(&key, binary.BigEndian, uint16(len("X.509")))
+	key.WriteString("X.509")
+	binary.Write(&key, binary.BigEndian, uint32(len(server.Raw)))
+	key.Write(server.Raw)
+
+	write(uint32(keystore.JKSPrivateKeyTag))
+	writeUTF("tomcat")
+	write(int64(1700000000000))
+	buf.Write(key.Bytes())
+
+	existing := newTestCA(t, "Existing CA")
+	write(uint32(keystore.JKSTrustedCertTag))
+	writeUTF("existing")
+	write(int64(1700000000000))
+	writeUTF("X.509")
+	write(uint32(len(existing.Raw)))
+	buf.Write(existing.Raw)
+
+	buf.Write(jksTestDigest(buf.Bytes(), password))
+	return buf.Bytes(), key.Bytes()
+}
+
+func TestJKSRoundTrip(t *testing.T) {
+	data, keyEntry := newTestJKS(t, keystore.JKSMagic, javaStorePassword)
+
+	if _, err := parseJKS(data, "wrong"); err == nil {
+		t.Fatal("parsed with a wrong password")
+	}
+	corrupt := append([]byte{}, data...)
+	corrupt[20] ^= 0xFF
+	if _, err := parseJKS(corrupt, javaStorePassword); err == nil {
+		t.Fatal("parsed a corrupt keystore")
+	}
+	jceks, _ := newTestJKS(t, keystore.JCEKSMagic, javaStorePassword)
+	if _, err := parseJKS(jceks, javaStorePassword); err == nil {
+		t.Fatal("parsed a JCEKS keystore")
+	}
+
+	ks, err := parseJKS(data, javaStorePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ks.trusted(); len(got) != 1 || got[0].Alias != "existing" {
+		t.Fatalf("trusted entries = %+v", got)
+	}
+
+	ca := newTestCA(t, "Round Trip CA")
+	if err := ks.addTrusted("instanttls", ca); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ks.marshal(javaStorePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, digest := out[:len(out)-sha1.Size], out[len(out)-sha1.Size:]
+	if !bytes.Equal(digest, jksTestDigest(body, javaStorePassword)) {
+		t.Fatal("rewritten keystore has a wrong digest")
+	}
+	ks, err = parseJKS(out, javaStorePassword)
+	if err != nil {
+		t.Fatalf("rewritten keystore: %v", err)
+	}
+
+	entries := ks.trusted()
+	if len(entries) != 2 || entries[1].Alias != "instanttls" || !entries[1].Cert.Equal(ca) {
+		t.Fatalf("trusted entries after add = %+v", entries)
+	}
+	if e := ks.entries[0]; e.tag != keystore.JKSPrivateKeyTag || e.alias != "tomcat" || !bytes.Equal(e.raw, keyEntry) {
+		t.Errorf("private key entry was not carried over")
+	}
+
+	if n := ks.removeTrusted(ca.Equal); n != 1 {
+		t.Fatalf("removeTrusted removed %d entries, want 1", n)
+	}
+	out, err = ks.marshal(javaStorePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err = parseJKS(out, javaStorePassword)
+	if err != nil {
+		t.Fatalf("keystore after removal: %v", err)
+	}
+	if got := ks.trusted(); len(got) != 1 || got[0].Alias != "existing" {
+		t.Errorf("trusted entries after removal = %+v", got)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("keystore after removal differs from the original")
+	}
+}
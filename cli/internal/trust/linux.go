@@ -0,0 +1,381 @@
+package trust
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+// LinuxBackend installs the CA into the system trust store of one family of
+// distributions
+type LinuxBackend interface {
+	// Name describes the backend, e.g. "Fedora/RHEL (update-ca-trust)"
+	Name() string
+	// Commands and UninstallCommands list what Install and Uninstall run,
+	// for the user to confirm
+	Commands(certPath string) []string
+	UninstallCommands(certPath string) []string
+	Install(certPath string) error
+	// Uninstall does nothing if the CA is not present
+	Uninstall(certPath string) error
+	// Present reports whether the CA is installed at all, even if the
+	// generated bundle is out of date
+	Present(certPath string) bool
+	// Verify returns nil if the CA at certPath is in the trust store,
+	// including the bundle generated from it
+	Verify(certPath string) error
+}
+
+// anchorBackend covers distributions that build their bundle from a
+// directory of anchor certificates
+type anchorBackend struct {
+	name   string
+	anchor string
+	update []string
+	bundle string
+	run    Runner
+}
+
+// NewDebianBackend returns the backend for Debian and Ubuntu
+func NewDebianBackend(run Runner) LinuxBackend {
+	return &anchorBackend{
+		name:   "Debian/Ubuntu (update-ca-certificates)",
+		anchor: "/usr/local/share/ca-certificates/instanttls.crt",
+		update: []string{"update-ca-certificates"},
+		bundle: "/etc/ssl/certs/ca-certificates.crt",
+		run:    run,
+	}
+}
+
+// NewAlpineBackend returns the backend for Alpine, which uses the Debian
+// layout
+func NewAlpineBackend(run Runner) LinuxBackend {
+	return &anchorBackend{
+		name:   "Alpine (update-ca-certificates)",
+		anchor: "/usr/local/share/ca-certificates/instanttls.crt",
+		update: []string{"update-ca-certificates"},
+		bundle: "/etc/ssl/certs/ca-certificates.crt",
+		run:    run,
+	}
+}
+
+// NewFedoraBackend returns the backend for Fedora, RHEL and derivatives
+func NewFedoraBackend(run Runner) LinuxBackend {
+	return &anchorBackend{
+		name:   "Fedora/RHEL (update-ca-trust)",
+		anchor: "/etc/pki/ca-trust/source/anchors/instanttls.crt",
+		update: []string{"update-ca-trust", "extract"},
+		bundle: "/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+		run:    run,
+	}
+}
+
+// NewSUSEBackend returns the backend for openSUSE and SLES
+func NewSUSEBackend(run Runner) LinuxBackend {
+	return &anchorBackend{
+		name:   "openSUSE/SLES (update-ca-certificates)",
+		anchor: "/etc/pki/trust/anchors/instanttls.crt",
+		update: []string{"update-ca-certificates"},
+		bundle: "/var/lib/ca-certificates/ca-bundle.pem",
+		run:    run,
+	}
+}
+
+func (b *anchorBackend) Name() string {
+	return b.name
+}
+
+func (b *anchorBackend) Commands(certPath string) []string {
+	return []string{
+		fmt.Sprintf("sudo install -D -m 0644 %s %s", certPath, b.anchor),
+		"sudo " + strings.Join(b.update, " "),
+	}
+}
+
+func (b *anchorBackend) UninstallCommands(certPath string) []string {
+	return []string{
+		"sudo rm -f " + b.anchor,
+		"sudo " + strings.Join(b.update, " "),
+	}
+}
+
+func (b *anchorBackend) Install(certPath string) error {
+	if err := b.run("install", "-D", "-m", "0644", certPath, b.anchor); err != nil {
+		return fmt.Errorf("failed to copy CA certificate: %w", err)
+	}
+	if err := b.run(b.update[0], b.update[1:]...); err != nil {
+		return fmt.Errorf("failed to update CA certificates: %w", err)
+	}
+	return nil
+}
+
+func (b *anchorBackend) Present(certPath string) bool {
+	return fileExists(b.anchor)
+}
+
+func (b *anchorBackend) Uninstall(certPath string) error {
+	if !b.Present(certPath) {
+		return nil
+	}
+	if err := b.run("rm", "-f", b.anchor); err != nil {
+		return fmt.Errorf("failed to remove CA certificate: %w", err)
+	}
+	if err := b.run(b.update[0], b.update[1:]...); err != nil {
+		return fmt.Errorf("failed to update CA certificates: %w", err)
+	}
+	return nil
+}
+
+func (b *anchorBackend) Verify(certPath string) error {
+	caDER, err := readCertDER(certPath)
+	if err != nil {
+		return err
+	}
+
+	anchored, err := pemFileContains(b.anchor, caDER)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !anchored {
+		return fmt.Errorf("CA is not in %s", b.anchor)
+	}
+
+	bundled, err := pemFileContains(b.bundle, caDER)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !bundled {
+		return fmt.Errorf("CA is in %s but not in %s; run 'sudo %s'", b.anchor, b.bundle, strings.Join(b.update, " "))
+	}
+	return nil
+}
+
+// p11KitBackend uses p11-kit's trust tool, which keeps its own anchor store
+// and regenerates the bundles itself. Arch Linux uses it directly.
+type p11KitBackend struct {
+	run     Runner
+	bundles []string
+}
+
+// NewP11KitBackend returns the backend for Arch Linux and other systems
+// managed with p11-kit's 'trust anchor'
+func NewP11KitBackend(run Runner) LinuxBackend {
+	return &p11KitBackend{
+		run: run,
+		bundles: []string{
+			"/etc/ca-certificates/extracted/tls-ca-bundle.pem",
+			"/etc/ssl/certs/ca-certificates.crt",
+		},
+	}
+}
+
+func (b *p11KitBackend) Name() string {
+	return "p11-kit (trust anchor)"
+}
+
+func (b *p11KitBackend) Commands(certPath string) []string {
+	return []string{"sudo trust anchor --store " + certPath}
+}
+
+func (b *p11KitBackend) UninstallCommands(certPath string) []string {
+	return []string{"sudo trust anchor --remove " + certPath}
+}
+
+func (b *p11KitBackend) Install(certPath string) error {
+	if err := b.run("trust", "anchor", "--store", certPath); err != nil {
+		return fmt.Errorf("failed to add trust anchor: %w", err)
+	}
+	return nil
+}
+
+func (b *p11KitBackend) Present(certPath string) bool {
+	return b.Verify(certPath) == nil
+}
+
+func (b *p11KitBackend) Uninstall(certPath string) error {
+	if !b.Present(certPath) {
+		return nil
+	}
+	if err := b.run("trust", "anchor", "--remove", certPath); err != nil {
+		return fmt.Errorf("failed to remove trust anchor: %w", err)
+	}
+	return nil
+}
+
+func (b *p11KitBackend) Verify(certPath string) error {
+	caDER, err := readCertDER(certPath)
+	if err != nil {
+		return err
+	}
+
+	for _, bundle := range b.bundles {
+		found, err := pemFileContains(bundle, caDER)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("CA is not in %s", bundle)
+		}
+		return nil
+	}
+	return fmt.Errorf("no CA bundle found (looked for %s)", strings.Join(b.bundles, ", "))
+}
+
+// linuxFamilies maps os-release IDs to backends. Families marked as
+// fallback are also tried, in order, when the IDs match nothing usable.
+var linuxFamilies = []struct {
+	ids      []string
+	tool     string
+	backend  func(Runner) LinuxBackend
+	fallback bool
+}{
+	{[]string{"fedora", "rhel", "centos", "rocky", "almalinux", "ol", "amzn"}, "update-ca-trust", NewFedoraBackend, true},
+	{[]string{"debian", "ubuntu"}, "update-ca-certificates", NewDebianBackend, true},
+	{[]string{"alpine"}, "update-ca-certificates", NewAlpineBackend, false},
+	{[]string{"opensuse", "suse", "sles", "opensuse-leap", "opensuse-tumbleweed"}, "update-ca-certificates", NewSUSEBackend, false},
+	{[]string{"arch", "manjaro", "endeavouros"}, "trust", NewP11KitBackend, true},
+}
+
+// DetectLinuxBackend picks the backend for this system from /etc/os-release
+// and the tools on PATH
+func DetectLinuxBackend(run Runner) (LinuxBackend, error) {
+	osRelease, _ := readOSRelease("/etc/os-release")
+	return detectLinuxBackend(osRelease, exec.LookPath, run)
+}
+
+func detectLinuxBackend(osRelease map[string]string, lookPath func(string) (string, error), run Runner) (LinuxBackend, error) {
+	ids := append([]string{osRelease["ID"]}, strings.Fields(osRelease["ID_LIKE"])...)
+
+	for _, id := range ids {
+		for _, family := range linuxFamilies {
+			if !containsString(family.ids, id) {
+				continue
+			}
+			if _, err := lookPath(family.tool); err == nil {
+				return family.backend(run), nil
+			}
+		}
+	}
+
+	for _, family := range linuxFamilies {
+		if !family.fallback {
+			continue
+		}
+		if _, err := lookPath(family.tool); err == nil {
+			return family.backend(run), nil
+		}
+	}
+
+	return nil, errors.New("no supported trust store tool found (update-ca-certificates, update-ca-trust or trust)")
+}
+
+// readOSRelease parses the KEY=value lines of os-release(5)
+func readOSRelease(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return map[string]string{}, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[key] = strings.ToLower(strings.Trim(value, `"'`))
+	}
+	return values, scanner.Err()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func readCertDER(certPath string) ([]byte, error) {
+	caCert, err := loadCACert(certPath)
+	if err != nil {
+		return nil, err
+	}
+	return caCert.Raw, nil
+}
+
+// pemFileContains reports whether a PEM file holds the certificate
+func pemFileContains(path string, der []byte) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return false, nil
+		}
+		if block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, der) {
+			return true, nil
+		}
+	}
+}
+
+func installLinux(certPath string) error {
+	backend, err := DetectLinuxBackend(SudoRunner)
+	if err != nil {
+		pterm.Warning.Println(err.Error())
+		pterm.Println()
+		pterm.DefaultBox.WithTitle("Manual Installation").Println(`
+For Debian/Ubuntu/Alpine:
+  sudo cp ` + certPath + ` /usr/local/share/ca-certificates/instanttls.crt
+  sudo update-ca-certificates
+
+For Fedora/RHEL/CentOS:
+  sudo cp ` + certPath + ` /etc/pki/ca-trust/source/anchors/
+  sudo update-ca-trust
+
+For openSUSE:
+  sudo cp ` + certPath + ` /etc/pki/trust/anchors/
+  sudo update-ca-certificates
+
+For Arch Linux:
+  sudo trust anchor --store ` + certPath + `
+
+For other distributions, consult your documentation.
+`)
+		return fmt.Errorf("automatic installation not supported on this distribution")
+	}
+
+	title := fmt.Sprintf("Installing CA certificate into the %s trust store...", backend.Name())
+	if err := confirmSudo(title, backend.Commands(certPath)); err != nil {
+		return fmt.Errorf("installation %w", err)
+	}
+
+	if err := backend.Install(certPath); err != nil {
+		return err
+	}
+
+	if err := backend.Verify(certPath); err != nil {
+		return fmt.Errorf("installation finished but could not be verified: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package trust
+
+import (
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeLookPath finds only the named tools
+func fakeLookPath(tools ...string) func(string) (string, error) {
+	return func(name string) (string, error) {
+		for _, tool := range tools {
+			if tool == name {
+				return "/usr/bin/" + name, nil
+			}
+		}
+		return "", errors.New("not found")
+	}
+}
+
+// recordRunner returns a Runner that records the commands it is given
+// instead of running them
+func recordRunner(calls *[][]string) Runner {
+	return func(name string, args ...string) error {
+		*calls = append(*calls, append([]string{name}, args...))
+		return nil
+	}
+}
+
+func TestDetectLinuxBackend(t *testing.T) {
+	const (
+		debian = "Debian/Ubuntu (update-ca-certificates)"
+		fedora = "Fedora/RHEL (update-ca-trust)"
+		suse   = "openSUSE/SLES (update-ca-certificates)"
+		alpine = "Alpine (update-ca-certificates)"
+		p11kit = "p11-kit (trust anchor)"
+	)
+
+	tests := []struct {
+		name      string
+		osRelease string
+		tools     []string
+		want      string
+	}{
+		{"Debian", "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\n", []string{"update-ca-certificates"}, debian},
+		{"Ubuntu", "ID=ubuntu\nID_LIKE=debian\n", []string{"update-ca-certificates", "trust"}, debian},
+		{"Fedora", "ID=fedora\nVERSION_ID=40\n", []string{"update-ca-trust", "trust"}, fedora},
+		{"RHEL", "ID=\"rhel\"\nID_LIKE=\"fedora\"\n", []string{"update-ca-trust"}, fedora},
+		{"Rocky", "ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n", []string{"update-ca-trust"}, fedora},
+		{"Amazon Linux", "ID=\"amzn\"\nID_LIKE=\"centos rhel fedora\"\n", []string{"update-ca-trust"}, fedora},
+		{"Arch", "ID=arch\n", []string{"trust"}, p11kit},
+		{"Manjaro", "ID=manjaro\nID_LIKE=arch\n", []string{"trust"}, p11kit},
+		{"openSUSE Tumbleweed", "ID=\"opensuse-tumbleweed\"\nID_LIKE=\"opensuse suse\"\n", []string{"update-ca-certificates", "trust"}, suse},
+		{"SLES", "ID=\"sles\"\nID_LIKE=\"suse\"\n", []string{"update-ca-certificates"}, suse},
+		{"Alpine", "ID=alpine\n", []string{"update-ca-certificates"}, alpine},
+		{"commented and capitalized", "# ID=arch\nID='Debian'\n", []string{"update-ca-certificates", "trust"}, debian},
+		{"Debian without ca-certificates", "ID=debian\n", []string{"trust"}, p11kit},
+		{"unknown with update-ca-trust", "ID=mystery\n", []string{"update-ca-trust"}, fedora},
+		{"unknown with update-ca-certificates", "ID=mystery\n", []string{"update-ca-certificates"}, debian},
+		{"no os-release", "", []string{"trust"}, p11kit},
+		{"Alpine without ca-certificates", "ID=alpine\n", nil, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "os-release")
+			if err := os.WriteFile(path, []byte(tc.osRelease), 0644); err != nil {
+				t.Fatal(err)
+			}
+			osRelease, err := readOSRelease(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var calls [][]string
+			backend, err := detectLinuxBackend(osRelease, fakeLookPath(tc.tools...), recordRunner(&calls))
+			if tc.want == "" {
+				if err == nil {
+					t.Fatalf("detected %s, want an error", backend.Name())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if backend.Name() != tc.want {
+				t.Errorf("detected %s, want %s", backend.Name(), tc.want)
+			}
+			if len(calls) != 0 {
+				t.Errorf("detection ran %v", calls)
+			}
+		})
+	}
+}
+
+func TestLinuxBackendCommands(t *testing.T) {
+	const certPath = "/home/user/.instanttls/ca.pem"
+
+	tests := []struct {
+		name      string
+		backend   func(Runner) LinuxBackend
+		install   [][]string
+		uninstall [][]string
+	}{
+		{
+			"Debian", NewDebianBackend,
+			[][]string{
+				{"install", "-D", "-m", "0644", certPath, "/usr/local/share/ca-certificates/instanttls.crt"},
+				{"update-ca-certificates"},
+			},
+			[][]string{
+				{"rm", "-f", "/usr/local/share/ca-certificates/instanttls.crt"},
+				{"update-ca-certificates"},
+			},
+		},
+		{
+			"Alpine", NewAlpineBackend,
+			[][]string{
+				{"install", "-D", "-m", "0644", certPath, "/usr/local/share/ca-certificates/instanttls.crt"},
+				{"update-ca-certificates"},
+			},
+			[][]string{
+				{"rm", "-f", "/usr/local/share/ca-certificates/instanttls.crt"},
+				{"update-ca-certificates"},
+			},
+		},
+		{
+			"Fedora", NewFedoraBackend,
+			[][]string{
+				{"install", "-D", "-m", "0644", certPath, "/etc/pki/ca-trust/source/anchors/instanttls.crt"},
+				{"update-ca-trust", "extract"},
+			},
+			[][]string{
+				{"rm", "-f", "/etc/pki/ca-trust/source/anchors/instanttls.crt"},
+				{"update-ca-trust", "extract"},
+			},
+		},
+		{
+			"openSUSE", NewSUSEBackend,
+			[][]string{
+				{"install", "-D", "-m", "0644", certPath, "/etc/pki/trust/anchors/instanttls.crt"},
+				{"update-ca-certificates"},
+			},
+			[][]string{
+				{"rm", "-f", "/etc/pki/trust/anchors/instanttls.crt"},
+				{"update-ca-certificates"},
+			},
+		},
+		{
+			"p11-kit", NewP11KitBackend,
+			[][]string{{"trust", "anchor", "--store", certPath}},
+			[][]string{{"trust", "anchor", "--remove", certPath}},
+		},
+	}
+
+	// The commands shown for confirmation are the ones run, through sudo
+	shown := func(calls [][]string) []string {
+		var lines []string
+		for _, call := range calls {
+			lines = append(lines, "sudo "+strings.Join(call, " "))
+		}
+		return lines
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls [][]string
+			backend := tc.backend(recordRunner(&calls))
+
+			if err := backend.Install(certPath); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(calls, tc.install) {
+				t.Errorf("Install ran %q, want %q", calls, tc.install)
+			}
+			if got := backend.Commands(certPath); !reflect.DeepEqual(got, shown(tc.install)) {
+				t.Errorf("Commands = %q, want %q", got, shown(tc.install))
+			}
+			if got := backend.UninstallCommands(certPath); !reflect.DeepEqual(got, shown(tc.uninstall)) {
+				t.Errorf("UninstallCommands = %q, want %q", got, shown(tc.uninstall))
+			}
+		})
+	}
+}
+
+// Uninstall runs nothing unless the CA is present, which for anchor
+// backends means the anchor file exists and for p11-kit that a bundle holds
+// the CA
+func TestLinuxBackendUninstall(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Uninstall CA")
+	certPath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCA(t, "Other CA")
+	otherPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.Raw})
+
+	t.Run("anchor", func(t *testing.T) {
+		var calls [][]string
+		backend := NewFedoraBackend(recordRunner(&calls)).(*anchorBackend)
+		backend.anchor = filepath.Join(dir, "anchors", "instanttls.crt")
+
+		if err := backend.Uninstall(certPath); err != nil {
+			t.Fatal(err)
+		}
+		if len(calls) != 0 {
+			t.Fatalf("Uninstall without an anchor ran %q", calls)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(backend.anchor), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(backend.anchor, otherPEM, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Uninstall(certPath); err != nil {
+			t.Fatal(err)
+		}
+		want := [][]string{{"rm", "-f", backend.anchor}, {"update-ca-trust", "extract"}}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("Uninstall ran %q, want %q", calls, want)
+		}
+	})
+
+	t.Run("p11-kit", func(t *testing.T) {
+		var calls [][]string
+		backend := NewP11KitBackend(recordRunner(&calls)).(*p11KitBackend)
+		bundle := filepath.Join(dir, "tls-ca-bundle.pem")
+		backend.bundles = []string{filepath.Join(dir, "missing.pem"), bundle}
+
+		if err := os.WriteFile(bundle, otherPEM, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Uninstall(certPath); err != nil {
+			t.Fatal(err)
+		}
+		if len(calls) != 0 {
+			t.Fatalf("Uninstall with the CA absent ran %q", calls)
+		}
+
+		data, err := os.ReadFile(certPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bundle, append(otherPEM, data...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Uninstall(certPath); err != nil {
+			t.Fatal(err)
+		}
+		want := [][]string{{"trust", "anchor", "--remove", certPath}}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("Uninstall ran %q, want %q", calls, want)
+		}
+	})
+}
@@ -0,0 +1,383 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+// nssTrustFlags marks a certificate as a CA trusted to issue TLS server
+// certificates, and nothing else
+const nssTrustFlags = "C,,"
+
+// NSSDatabase is an NSS certificate database. Firefox keeps one per
+// profile; Chromium and Chrome on Linux share ~/.pki/nssdb.
+type NSSDatabase struct {
+	// Name describes the owner, e.g. "Firefox (default-release)"
+	Name string
+	Dir  string
+	// Legacy reports a cert8.db (Berkeley DB) database, which only certutil
+	// can modify
+	Legacy bool
+}
+
+func (db NSSDatabase) String() string {
+	return fmt.Sprintf("%s, %s", db.Name, db.Dir)
+}
+
+// FindNSSDatabases returns the NSS databases of the current user's Firefox
+// profiles and of Chromium. Databases that do not exist yet, because the
+// browser has never been started, are skipped.
+func FindNSSDatabases() []NSSDatabase {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	var profileRoots []string
+	switch runtime.GOOS {
+	case "linux":
+		profileRoots = []string{
+			filepath.Join(home, ".mozilla", "firefox"),
+			filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox"),
+			filepath.Join(home, ".var", "app", "org.mozilla.firefox", ".mozilla", "firefox"),
+		}
+	case "darwin":
+		profileRoots = []string{filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles")}
+	default:
+		// Firefox on Windows trusts the system store
+		return nil
+	}
+
+	var dbs []NSSDatabase
+	for _, root := range profileRoots {
+		profiles, _ := filepath.Glob(filepath.Join(root, "*"))
+		for _, dir := range profiles {
+			if db, ok := nssDatabaseAt(dir, "Firefox ("+profileName(dir)+")"); ok {
+				dbs = append(dbs, db)
+			}
+		}
+	}
+
+	if runtime.GOOS == "linux" {
+		for _, dir := range []string{
+			filepath.Join(home, ".pki", "nssdb"),
+			filepath.Join(home, "snap", "chromium", "current", ".pki", "nssdb"),
+		} {
+			if db, ok := nssDatabaseAt(dir, "Chromium/Chrome"); ok {
+				dbs = append(dbs, db)
+			}
+		}
+	}
+
+	return dbs
+}
+
+func nssDatabaseAt(dir, name string) (NSSDatabase, bool) {
+	if fileExists(filepath.Join(dir, "cert9.db")) {
+		return NSSDatabase{Name: name, Dir: dir}, true
+	}
+	if fileExists(filepath.Join(dir, "cert8.db")) {
+		return NSSDatabase{Name: name, Dir: dir, Legacy: true}, true
+	}
+	return NSSDatabase{}, false
+}
+
+// profileName strips the random prefix from Firefox profile directories
+// such as "x1y2z3.default-release"
+func profileName(dir string) string {
+	name := filepath.Base(dir)
+	if _, rest, ok := strings.Cut(name, "."); ok && rest != "" {
+		return rest
+	}
+	return name
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// certutilPath returns the NSS certutil, if installed. On Windows the name
+// belongs to an unrelated system tool.
+func certutilPath() (string, bool) {
+	if runtime.GOOS == "windows" {
+		return "", false
+	}
+	path, err := exec.LookPath("certutil")
+	return path, err == nil
+}
+
+// certutilHint tells the user how to get certutil on this system
+func certutilHint() string {
+	if runtime.GOOS == "darwin" {
+		return "brew install nss"
+	}
+	return "install libnss3-tools (Debian/Ubuntu), nss-tools (Fedora/RHEL) or nss (Arch)"
+}
+
+// certDBArg returns the -d argument for certutil
+func (db NSSDatabase) certDBArg() string {
+	if db.Legacy {
+		return "dbm:" + db.Dir
+	}
+	return "sql:" + db.Dir
+}
+
+// nssNickname is the name the CA is listed under in the browser. NSS ties
+// nicknames to subjects, so it is derived from the subject as well.
+func nssNickname(caCert *x509.Certificate) string {
+	if caCert.Subject.CommonName != "" {
+		return caCert.Subject.CommonName
+	}
+	return "InstantTLS Local CA"
+}
+
+// Install adds the CA to the database, trusted for TLS server certificates.
+// certutil is used when installed; otherwise cert9.db is written directly.
+func (db NSSDatabase) Install(caCert *x509.Certificate) error {
+	if certutil, ok := certutilPath(); ok {
+		// Replace an existing entry so the trust flags are reset too
+		db.certutilDelete(certutil, caCert)
+
+		certFile, cleanup, err := writeTempCert(caCert)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		return runCertutil(certutil, "-A", "-d", db.certDBArg(), "-t", nssTrustFlags, "-n", nssNickname(caCert), "-i", certFile)
+	}
+
+	if db.Legacy {
+		return fmt.Errorf("legacy cert8.db database needs certutil (%s)", certutilHint())
+	}
+	return installNSSNative(db.Dir, caCert)
+}
+
+// Uninstall removes the CA from the database. A CA that is not there is not
+// an error.
+func (db NSSDatabase) Uninstall(caCert *x509.Certificate) error {
+	if certutil, ok := certutilPath(); ok {
+		return db.certutilDelete(certutil, caCert)
+	}
+
+	if db.Legacy {
+		return fmt.Errorf("legacy cert8.db database needs certutil (%s)", certutilHint())
+	}
+	return uninstallNSSNative(db.Dir, caCert)
+}
+
+// IsTrusted reports whether the CA is in the database and trusted for TLS
+// server certificates. For legacy databases only its presence is checked.
+func (db NSSDatabase) IsTrusted(caCert *x509.Certificate) (bool, error) {
+	if !db.Legacy {
+		return isTrustedNSSNative(db.Dir, caCert)
+	}
+
+	certutil, ok := certutilPath()
+	if !ok {
+		return false, fmt.Errorf("legacy cert8.db database needs certutil (%s)", certutilHint())
+	}
+	certs, err := db.certutilList(certutil, caCert)
+	if err != nil {
+		return false, err
+	}
+	for _, der := range certs {
+		if bytes.Equal(der, caCert.Raw) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// certutilDelete deletes certificates under the CA's nickname until the CA
+// itself is gone. Deletion goes by nickname, so an older InstantTLS CA with
+// the same subject may be removed along the way.
+func (db NSSDatabase) certutilDelete(certutil string, caCert *x509.Certificate) error {
+	for i := 0; i < 10; i++ {
+		certs, err := db.certutilList(certutil, caCert)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, der := range certs {
+			if bytes.Equal(der, caCert.Raw) {
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+
+		if err := runCertutil(certutil, "-D", "-d", db.certDBArg(), "-n", nssNickname(caCert)); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("could not remove %q from %s", nssNickname(caCert), db.Dir)
+}
+
+// certutilList returns the certificates stored under the CA's nickname
+func (db NSSDatabase) certutilList(certutil string, caCert *x509.Certificate) ([][]byte, error) {
+	out, err := exec.Command(certutil, "-L", "-d", db.certDBArg(), "-n", nssNickname(caCert), "-a").Output()
+	if err != nil {
+		// certutil exits non-zero when the nickname is unknown
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var certs [][]byte
+	for {
+		var block *pem.Block
+		block, out = pem.Decode(out)
+		if block == nil {
+			break
+		}
+		certs = append(certs, block.Bytes)
+	}
+	return certs, nil
+}
+
+func runCertutil(certutil string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(certutil, args...)
+	// certutil asks for the Primary Password, if one is set
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("certutil %s failed: %s", args[0], msg)
+	}
+	return nil
+}
+
+func writeTempCert(caCert *x509.Certificate) (string, func(), error) {
+	f, err := os.CreateTemp("", "instanttls-ca-*.crt")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+
+	if err := pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// installNSS adds the CA to every browser database found. Failures are
+// reported per database and do not affect the system store installation.
+func installNSS(certPath string) {
+	dbs := FindNSSDatabases()
+	if len(dbs) == 0 {
+		return
+	}
+
+	caCert, err := loadCACert(certPath)
+	if err != nil {
+		pterm.Warning.Println(err.Error())
+		return
+	}
+
+	pterm.Println()
+	pterm.Info.Println("Installing CA certificate into browser certificate databases...")
+	if _, ok := certutilPath(); !ok {
+		pterm.Info.Println("certutil not found, writing the databases directly")
+	}
+
+	installed := 0
+	for _, db := range dbs {
+		if err := db.Install(caCert); err != nil {
+			pterm.Warning.Printfln("%s: %v", db, err)
+			continue
+		}
+		pterm.Success.Printfln("%s", db)
+		installed++
+	}
+
+	if installed > 0 {
+		pterm.Info.Println("Restart Firefox and Chromium for the change to take effect")
+	}
+}
+
+// NSSStatus is the state of the CA in one database
+type NSSStatus struct {
+	DB      NSSDatabase
+	Trusted bool
+	Err     error
+}
+
+// CheckNSS reports whether the CA is trusted in each browser database found
+func CheckNSS(certPath string) ([]NSSStatus, error) {
+	caCert, err := loadCACert(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []NSSStatus
+	for _, db := range FindNSSDatabases() {
+		trusted, err := db.IsTrusted(caCert)
+		statuses = append(statuses, NSSStatus{DB: db, Trusted: trusted, Err: err})
+	}
+	return statuses, nil
+}
+
+// NSSResult is the outcome of a change to one database
+type NSSResult struct {
+	DB  NSSDatabase
+	Err error
+}
+
+// UninstallNSS removes the CA from every browser database found
+func UninstallNSS(certPath string) ([]NSSResult, error) {
+	caCert, err := loadCACert(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []NSSResult
+	for _, db := range FindNSSDatabases() {
+		results = append(results, NSSResult{DB: db, Err: db.Uninstall(caCert)})
+	}
+	return results, nil
+}
+
+func loadCACert(certPath string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode CA certificate PEM")
+	}
+
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	return caCert, nil
+}
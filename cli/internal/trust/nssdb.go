@@ -0,0 +1,385 @@
+package trust
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"database/sql"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	// Pure Go SQLite, for cert9.db and key4.db without certutil
+	_ "modernc.org/sqlite"
+)
+
+// PKCS#11 attribute types and values as stored by NSS. Vendor-specific
+// values live under the NSS prefix 0xce534350.
+const (
+	ckaClass           = 0x00000000
+	ckaToken           = 0x00000001
+	ckaPrivate         = 0x00000002
+	ckaLabel           = 0x00000003
+	ckaValue           = 0x00000011
+	ckaCertificateType = 0x00000080
+	ckaIssuer          = 0x00000081
+	ckaSerialNumber    = 0x00000082
+	ckaSubject         = 0x00000101
+	ckaID              = 0x00000102
+	ckaModifiable      = 0x00000170
+
+	ckaTrustServerAuth      = 0xce536358
+	ckaTrustClientAuth      = 0xce536359
+	ckaTrustCodeSigning     = 0xce53635a
+	ckaTrustEmailProtection = 0xce53635b
+	ckaTrustStepUpApproved  = 0xce536360
+	ckaCertSHA1Hash         = 0xce5363b4
+	ckaCertMD5Hash          = 0xce5363b5
+
+	ckoCertificate = 0x00000001
+	ckoNSSTrust    = 0xce534353
+	ckcX509        = 0x00000000
+
+	cktNSSTrustedDelegator = 0xce534352
+	cktNSSMustVerifyTrust  = 0xce534353
+	cktNSSValidDelegator   = 0xce53435b
+
+	// nssMaxObjectID bounds object IDs, whose top bits NSS uses for the
+	// object type at runtime
+	nssMaxObjectID = 0x3fffffff
+)
+
+// nssExplicitNull is how NSS stores a zero-length attribute value
+var nssExplicitNull = []byte{0xa5, 0x00, 0x5a}
+
+// nssAttribute is an attribute as stored in the database: integers are 4
+// bytes big-endian and booleans a single byte
+type nssAttribute struct {
+	typ   uint32
+	value []byte
+}
+
+func nssULong(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func nssBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// nssColumn returns the column holding an attribute
+func nssColumn(typ uint32) string {
+	return fmt.Sprintf("a%x", typ)
+}
+
+// isAuthenticatedAttribute reports whether NSS expects a signature for the
+// attribute in key4.db, which stops trust from being copied between
+// certificates
+func isAuthenticatedAttribute(typ uint32) bool {
+	switch typ {
+	case ckaTrustServerAuth, ckaTrustClientAuth, ckaTrustCodeSigning,
+		ckaTrustEmailProtection, ckaTrustStepUpApproved, ckaCertSHA1Hash, ckaCertMD5Hash:
+		return true
+	}
+	return false
+}
+
+// openNSSDB opens cert9.db in dir. Unless readOnly, key4.db is attached as
+// "keydb" when present, so a single transaction covers both files.
+func openNSSDB(dir string, readOnly bool) (*sql.DB, bool, error) {
+	query := url.Values{}
+	query.Add("_pragma", "busy_timeout(5000)")
+	if readOnly {
+		query.Add("mode", "ro")
+	}
+	dsn := (&url.URL{Scheme: "file", OmitHost: true, Path: filepath.Join(dir, "cert9.db"), RawQuery: query.Encode()}).String()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, false, err
+	}
+	// ATTACH applies per connection
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, false, fmt.Errorf("failed to open %s: %w", filepath.Join(dir, "cert9.db"), err)
+	}
+
+	keyDB := filepath.Join(dir, "key4.db")
+	if readOnly || !fileExists(keyDB) {
+		return db, false, nil
+	}
+	if _, err := db.Exec("ATTACH DATABASE ? AS keydb", keyDB); err != nil {
+		db.Close()
+		return nil, false, fmt.Errorf("failed to open %s: %w", keyDB, err)
+	}
+	return db, true, nil
+}
+
+func installNSSNative(dir string, caCert *x509.Certificate) error {
+	db, hasKeyDB, err := openNSSDB(dir, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var passKey []byte
+	if hasKeyDB {
+		if passKey, err = nssPasswordKey(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := deleteNSSObjects(tx, hasKeyDB, caCert); err != nil {
+		return err
+	}
+	for _, attrs := range [][]nssAttribute{nssCertAttributes(caCert), nssTrustAttributes(caCert)} {
+		if err := insertNSSObject(tx, passKey, attrs); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func uninstallNSSNative(dir string, caCert *x509.Certificate) error {
+	db, hasKeyDB, err := openNSSDB(dir, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteNSSObjects(tx, hasKeyDB, caCert); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func isTrustedNSSNative(dir string, caCert *x509.Certificate) (bool, error) {
+	db, _, err := openNSSDB(dir, true)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	var certs, trusts int
+	err = db.QueryRow("SELECT count(*) FROM nssPublic WHERE a0 = ? AND a11 = ?",
+		nssULong(ckoCertificate), caCert.Raw).Scan(&certs)
+	if err != nil {
+		return false, err
+	}
+
+	serial, err := asn1.Marshal(caCert.SerialNumber)
+	if err != nil {
+		return false, err
+	}
+	err = db.QueryRow("SELECT count(*) FROM nssPublic WHERE a0 = ? AND a81 = ? AND a82 = ? AND "+nssColumn(ckaTrustServerAuth)+" = ?",
+		nssULong(ckoNSSTrust), caCert.RawIssuer, serial, nssULong(cktNSSTrustedDelegator)).Scan(&trusts)
+	if err != nil {
+		return false, err
+	}
+
+	return certs > 0 && trusts > 0, nil
+}
+
+// deleteNSSObjects removes the CA's certificate and trust objects, along
+// with their attribute signatures
+func deleteNSSObjects(tx *sql.Tx, hasKeyDB bool, caCert *x509.Certificate) error {
+	serial, err := asn1.Marshal(caCert.SerialNumber)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	for _, q := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"SELECT id FROM nssPublic WHERE a0 = ? AND a11 = ?", []interface{}{nssULong(ckoCertificate), caCert.Raw}},
+		{"SELECT id FROM nssPublic WHERE a0 = ? AND a81 = ? AND a82 = ?", []interface{}{nssULong(ckoNSSTrust), caCert.RawIssuer, serial}},
+	} {
+		rows, err := tx.Query(q.query, q.args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec("DELETE FROM nssPublic WHERE id = ?", id); err != nil {
+			return err
+		}
+		if hasKeyDB {
+			prefix := fmt.Sprintf("sig_cert_%08x_", id)
+			if _, err := tx.Exec("DELETE FROM keydb.metaData WHERE substr(id, 1, ?) = ?", len(prefix), prefix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertNSSObject stores an object under a fresh ID. With a password key,
+// authenticated attributes are signed the way NSS does it, which it
+// requires once the database has been logged in to, even with an empty
+// password.
+func insertNSSObject(tx *sql.Tx, passKey []byte, attrs []nssAttribute) error {
+	id, err := newNSSObjectID(tx)
+	if err != nil {
+		return err
+	}
+
+	columns := []string{"id"}
+	args := []interface{}{id}
+	for _, attr := range attrs {
+		value := attr.value
+		if len(value) == 0 {
+			value = nssExplicitNull
+		}
+		columns = append(columns, nssColumn(attr.typ))
+		args = append(args, value)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	query := fmt.Sprintf("INSERT INTO nssPublic (%s) VALUES (%s)", strings.Join(columns, ", "), placeholders)
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to add certificate: %w", err)
+	}
+
+	if passKey == nil {
+		return nil
+	}
+	for _, attr := range attrs {
+		if !isAuthenticatedAttribute(attr.typ) {
+			continue
+		}
+		sig, err := nssSignAttribute(passKey, uint32(id), attr.typ, attr.value)
+		if err != nil {
+			return err
+		}
+		sigID := fmt.Sprintf("sig_cert_%08x_%08x", id, attr.typ)
+		if _, err := tx.Exec("INSERT OR REPLACE INTO keydb.metaData (id, item1) VALUES (?, ?)", sigID, sig); err != nil {
+			return fmt.Errorf("failed to sign certificate trust: %w", err)
+		}
+	}
+	return nil
+}
+
+func newNSSObjectID(tx *sql.Tx) (int64, error) {
+	for i := 0; i < 100; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(nssMaxObjectID))
+		if err != nil {
+			return 0, err
+		}
+		id := n.Int64() + 1
+
+		var exists int
+		err = tx.QueryRow("SELECT 1 FROM nssPublic WHERE id = ?", id).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return id, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("failed to find a free object ID")
+}
+
+func nssCertAttributes(caCert *x509.Certificate) []nssAttribute {
+	serial, _ := asn1.Marshal(caCert.SerialNumber)
+	return []nssAttribute{
+		{ckaClass, nssULong(ckoCertificate)},
+		{ckaToken, nssBool(true)},
+		{ckaPrivate, nssBool(false)},
+		{ckaModifiable, nssBool(true)},
+		{ckaLabel, []byte(nssNickname(caCert))},
+		{ckaCertificateType, nssULong(ckcX509)},
+		{ckaValue, caCert.Raw},
+		{ckaIssuer, caCert.RawIssuer},
+		{ckaSerialNumber, serial},
+		{ckaSubject, caCert.RawSubject},
+		{ckaID, nssKeyID(caCert)},
+	}
+}
+
+// nssTrustAttributes matches what certutil writes for "C,,"
+func nssTrustAttributes(caCert *x509.Certificate) []nssAttribute {
+	serial, _ := asn1.Marshal(caCert.SerialNumber)
+	sha1Hash := sha1.Sum(caCert.Raw)
+	md5Hash := md5.Sum(caCert.Raw)
+	return []nssAttribute{
+		{ckaClass, nssULong(ckoNSSTrust)},
+		{ckaToken, nssBool(true)},
+		{ckaPrivate, nssBool(false)},
+		{ckaModifiable, nssBool(true)},
+		{ckaLabel, nil},
+		{ckaIssuer, caCert.RawIssuer},
+		{ckaSerialNumber, serial},
+		{ckaTrustServerAuth, nssULong(cktNSSTrustedDelegator)},
+		{ckaTrustClientAuth, nssULong(cktNSSValidDelegator)},
+		{ckaTrustCodeSigning, nssULong(cktNSSMustVerifyTrust)},
+		{ckaTrustEmailProtection, nssULong(cktNSSMustVerifyTrust)},
+		{ckaTrustStepUpApproved, nssBool(false)},
+		{ckaCertSHA1Hash, sha1Hash[:]},
+		{ckaCertMD5Hash, md5Hash[:]},
+	}
+}
+
+// nssKeyID is the SHA-1 of the raw public key, which NSS uses to pair
+// certificates with private keys
+func nssKeyID(c *x509.Certificate) []byte {
+	var raw []byte
+	switch pub := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		raw = pub.N.Bytes()
+	case *ecdsa.PublicKey:
+		if key, err := pub.ECDH(); err == nil {
+			raw = key.Bytes()
+		}
+	case ed25519.PublicKey:
+		raw = pub
+	}
+	if raw == nil {
+		raw = c.RawSubjectPublicKeyInfo
+	}
+	sum := sha1.Sum(raw)
+	return sum[:]
+}
@@ -0,0 +1,377 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/instanttls/cli/internal/keystore"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// newTestCA returns a self-signed CA certificate
+func newTestCA(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// nssTestColumns are the attributes the native writer stores
+var nssTestColumns = []uint32{
+	ckaClass, ckaToken, ckaPrivate, ckaLabel, ckaValue, ckaCertificateType,
+	ckaIssuer, ckaSerialNumber, ckaSubject, ckaID, ckaModifiable,
+	ckaTrustServerAuth, ckaTrustClientAuth, ckaTrustCodeSigning,
+	ckaTrustEmailProtection, ckaTrustStepUpApproved, ckaCertSHA1Hash, ckaCertMD5Hash,
+}
+
+// newNSSProfile creates a profile directory with an empty cert9.db laid out
+// like NSS does, and a key4.db whose password entry checks against
+// password unless withKeyDB is false
+func newNSSProfile(t *testing.T, withKeyDB bool, password string) (dir string, passKey []byte) {
+	t.Helper()
+	dir = t.TempDir()
+
+	columns := []string{"id PRIMARY KEY UNIQUE ON CONFLICT ABORT"}
+	for _, typ := range nssTestColumns {
+		columns = append(columns, nssColumn(typ))
+	}
+	execSQL(t, filepath.Join(dir, "cert9.db"), "CREATE TABLE nssPublic ("+strings.Join(columns, ", ")+")")
+
+	if !withKeyDB {
+		return dir, nil
+	}
+
+	salt := bytes.Repeat([]byte{0x42}, sha1.Size)
+	sum := sha1.Sum(append(append([]byte{}, salt...), password...))
+	if password == "" {
+		sum = sha1.Sum(salt)
+	}
+	check := nssTestEncrypt(t, sum[:], []byte(nssPasswordCheck))
+
+	keyDB := filepath.Join(dir, "key4.db")
+	execSQL(t, keyDB, "CREATE TABLE metaData (id PRIMARY KEY UNIQUE ON CONFLICT REPLACE, item1, item2)")
+	execSQL(t, keyDB, "INSERT INTO metaData (id, item1, item2) VALUES ('password', ?, ?)", salt, check)
+
+	emptyKey := sha1.Sum(salt)
+	return dir, emptyKey[:]
+}
+
+func execSQL(t *testing.T, path, query string, args ...interface{}) {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nssTestEncrypt encrypts a value the way current NSS does: PBES2 with
+// PBKDF2-HMAC-SHA256 and AES-256-CBC
+func nssTestEncrypt(t *testing.T, passKey, plain []byte) []byte {
+	t.Helper()
+
+	salt := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(salt)
+	rand.Read(iv)
+
+	block, err := aes.NewCipher(pbkdf2.Key(passKey, salt, 1, 32, sha256.New))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	kdf, err := asn1.Marshal(keystore.PBKDF2Params{
+		Salt:           salt,
+		IterationCount: 1,
+		KeyLength:      32,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: keystore.OIDHMACWithSHA256},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := asn1.Marshal(keystore.PBES2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: keystore.OIDPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: keystore.OIDAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := asn1.Marshal(nssEncryptedData{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: keystore.OIDPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		Data:      ciphertext,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// nssObjects returns the IDs of the CA's certificate and trust objects
+func nssObjects(t *testing.T, dir string, caCert *x509.Certificate) (certs, trusts []int64) {
+	t.Helper()
+	db, _, err := openNSSDB(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	serial, _ := asn1.Marshal(caCert.SerialNumber)
+	for _, q := range []struct {
+		ids   *[]int64
+		query string
+		args  []interface{}
+	}{
+		{&certs, "SELECT id FROM nssPublic WHERE a0 = ? AND a11 = ?", []interface{}{nssULong(ckoCertificate), caCert.Raw}},
+		{&trusts, "SELECT id FROM nssPublic WHERE a0 = ? AND a81 = ? AND a82 = ?", []interface{}{nssULong(ckoNSSTrust), caCert.RawIssuer, serial}},
+	} {
+		rows, err := db.Query(q.query, q.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			*q.ids = append(*q.ids, id)
+		}
+		rows.Close()
+	}
+	return certs, trusts
+}
+
+// nssSignatures returns the attribute signatures in key4.db by ID
+func nssSignatures(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(dir, "key4.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, item1 FROM metaData WHERE id LIKE 'sig_cert_%'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	sigs := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var sig []byte
+		if err := rows.Scan(&id, &sig); err != nil {
+			t.Fatal(err)
+		}
+		sigs[id] = sig
+	}
+	return sigs
+}
+
+// verifyNSSSignature checks a PBMAC1 attribute signature the way NSS does
+func verifyNSSSignature(passKey []byte, objectID, typ uint32, value, sig []byte) error {
+	var enc nssEncryptedData
+	if _, err := asn1.Unmarshal(sig, &enc); err != nil {
+		return err
+	}
+	if !enc.Algorithm.Algorithm.Equal(oidPBMAC1) {
+		return fmt.Errorf("signature algorithm is %s, not PBMAC1", enc.Algorithm.Algorithm)
+	}
+	var params pbmac1Params
+	if _, err := asn1.Unmarshal(enc.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return err
+	}
+	var kdf keystore.PBKDF2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return err
+	}
+
+	var prefix [8]byte
+	binary.BigEndian.PutUint32(prefix[:4], objectID)
+	binary.BigEndian.PutUint32(prefix[4:], typ)
+	mac := hmac.New(sha256.New, pbkdf2.Key(passKey, kdf.Salt, kdf.IterationCount, kdf.KeyLength, sha256.New))
+	mac.Write(prefix[:])
+	mac.Write(value)
+	if !hmac.Equal(mac.Sum(nil), enc.Data) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+func TestNSSNativeRoundTrip(t *testing.T) {
+	dir, passKey := newNSSProfile(t, true, "")
+	caCert := newTestCA(t, "InstantTLS Test CA")
+
+	trusted, err := isTrustedNSSNative(dir, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trusted {
+		t.Fatal("CA trusted before it was installed")
+	}
+
+	// Installing twice replaces the first install
+	for i := 0; i < 2; i++ {
+		if err := installNSSNative(dir, caCert); err != nil {
+			t.Fatalf("install %d: %v", i+1, err)
+		}
+	}
+
+	trusted, err = isTrustedNSSNative(dir, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trusted {
+		t.Fatal("CA not trusted after install")
+	}
+
+	certs, trusts := nssObjects(t, dir, caCert)
+	if len(certs) != 1 || len(trusts) != 1 {
+		t.Fatalf("got %d certificate and %d trust objects, want one of each", len(certs), len(trusts))
+	}
+
+	// Every authenticated attribute of the trust object is signed, and
+	// nothing is left of the first install
+	sigs := nssSignatures(t, dir)
+	attrs := nssTrustAttributes(caCert)
+	signed := 0
+	for _, attr := range attrs {
+		if !isAuthenticatedAttribute(attr.typ) {
+			continue
+		}
+		signed++
+		id := fmt.Sprintf("sig_cert_%08x_%08x", trusts[0], attr.typ)
+		sig, ok := sigs[id]
+		if !ok {
+			t.Fatalf("no signature %s", id)
+		}
+		if err := verifyNSSSignature(passKey, uint32(trusts[0]), attr.typ, attr.value, sig); err != nil {
+			t.Errorf("signature %s: %v", id, err)
+		}
+	}
+	if len(sigs) != signed {
+		t.Errorf("got %d signatures, want %d", len(sigs), signed)
+	}
+
+	if err := uninstallNSSNative(dir, caCert); err != nil {
+		t.Fatal(err)
+	}
+	trusted, err = isTrustedNSSNative(dir, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trusted {
+		t.Fatal("CA still trusted after uninstall")
+	}
+	if certs, trusts := nssObjects(t, dir, caCert); len(certs)+len(trusts) != 0 {
+		t.Fatalf("%d objects left after uninstall", len(certs)+len(trusts))
+	}
+	if sigs := nssSignatures(t, dir); len(sigs) != 0 {
+		t.Fatalf("%d signatures left after uninstall", len(sigs))
+	}
+}
+
+func TestNSSNativeKeepsOtherCAs(t *testing.T) {
+	dir, _ := newNSSProfile(t, true, "")
+	other := newTestCA(t, "Other CA")
+	caCert := newTestCA(t, "InstantTLS Test CA")
+
+	if err := installNSSNative(dir, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := installNSSNative(dir, caCert); err != nil {
+		t.Fatal(err)
+	}
+	if err := uninstallNSSNative(dir, caCert); err != nil {
+		t.Fatal(err)
+	}
+
+	trusted, err := isTrustedNSSNative(dir, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trusted {
+		t.Fatal("uninstall removed another CA")
+	}
+}
+
+func TestNSSNativeWithoutKeyDB(t *testing.T) {
+	dir, _ := newNSSProfile(t, false, "")
+	caCert := newTestCA(t, "InstantTLS Test CA")
+
+	if err := installNSSNative(dir, caCert); err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := isTrustedNSSNative(dir, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trusted {
+		t.Fatal("CA not trusted after install")
+	}
+}
+
+func TestNSSNativePrimaryPassword(t *testing.T) {
+	dir, _ := newNSSProfile(t, true, "hunter2")
+	caCert := newTestCA(t, "InstantTLS Test CA")
+
+	err := installNSSNative(dir, caCert)
+	if err == nil || !strings.Contains(err.Error(), "Primary Password") {
+		t.Fatalf("install into a database with a Primary Password: got %v", err)
+	}
+	if certs, trusts := nssObjects(t, dir, caCert); len(certs)+len(trusts) != 0 {
+		t.Fatal("failed install left objects behind")
+	}
+}
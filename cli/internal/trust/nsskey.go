@@ -0,0 +1,236 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/instanttls/cli/internal/keystore"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	oidPBMAC1             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 14}
+	oidPBEWithSHA1And3DES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 5, 1, 3}
+	oidDESEDE3CBC         = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// nssPasswordCheck is the plaintext NSS encrypts to verify a password
+const nssPasswordCheck = "password-check"
+
+// nssEncryptedData is the format of both encrypted values and attribute
+// signatures in key4.db
+type nssEncryptedData struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+type pbmac1Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	MessageAuthScheme pkix.AlgorithmIdentifier
+}
+
+type nssPBEParams struct {
+	Salt           []byte
+	IterationCount int
+}
+
+// nssPasswordKey returns the key NSS derives from an empty password, which
+// signs authenticated attributes. It returns nil if the key database has no
+// password entry at all, and an error if a Primary Password is set.
+func nssPasswordKey(tx *sql.Tx) ([]byte, error) {
+	var salt, check []byte
+	err := tx.QueryRow("SELECT item1, item2 FROM keydb.metaData WHERE id = 'password'").Scan(&salt, &check)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key database: %w", err)
+	}
+
+	sum := sha1.Sum(salt)
+	passKey := sum[:]
+
+	plain, err := nssDecrypt(passKey, check)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(plain, []byte(nssPasswordCheck)) {
+		return nil, fmt.Errorf("database is protected by a Primary Password; certutil is needed to unlock it (%s)", certutilHint())
+	}
+	return passKey, nil
+}
+
+// nssDecrypt decrypts a key4.db value: PBES2 as written by current NSS, or
+// the SHA-1/3DES scheme of older databases
+func nssDecrypt(passKey, data []byte) ([]byte, error) {
+	var enc nssEncryptedData
+	if rest, err := asn1.Unmarshal(data, &enc); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("malformed encrypted value in key database")
+	}
+
+	switch {
+	case enc.Algorithm.Algorithm.Equal(keystore.OIDPBES2):
+		return nssDecryptPBES2(passKey, enc)
+	case enc.Algorithm.Algorithm.Equal(oidPBEWithSHA1And3DES):
+		return nssDecryptPBE3DES(passKey, enc)
+	default:
+		return nil, fmt.Errorf("unsupported key database encryption %s; install certutil (%s)", enc.Algorithm.Algorithm, certutilHint())
+	}
+}
+
+func nssDecryptPBES2(passKey []byte, enc nssEncryptedData) ([]byte, error) {
+	var params keystore.PBES2Params
+	if _, err := asn1.Unmarshal(enc.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("malformed PBES2 parameters: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(keystore.OIDPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdf keystore.PBKDF2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("malformed PBKDF2 parameters: %w", err)
+	}
+	prf, err := keystore.PRFHash(kdf.PRF.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(keystore.OIDAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(keystore.OIDAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(keystore.OIDAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported cipher %s", scheme)
+	}
+
+	block, err := newCipher(pbkdf2.Key(passKey, kdf.Salt, kdf.IterationCount, keyLen, prf))
+	if err != nil {
+		return nil, err
+	}
+
+	// NSS uses the DER encoding of a 14 byte OCTET STRING as the 16 byte
+	// AES IV, so the encoded parameter itself is the IV
+	iv := params.EncryptionScheme.Parameters.FullBytes
+	if len(iv) != block.BlockSize() {
+		iv = nil
+		if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != block.BlockSize() {
+			return nil, fmt.Errorf("malformed cipher IV")
+		}
+	}
+
+	return cbcDecrypt(block, iv, enc.Data)
+}
+
+func nssDecryptPBE3DES(passKey []byte, enc nssEncryptedData) ([]byte, error) {
+	var params nssPBEParams
+	if _, err := asn1.Unmarshal(enc.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("malformed PBE parameters: %w", err)
+	}
+	salt := params.Salt
+
+	hmacSHA1 := func(key []byte, parts ...[]byte) []byte {
+		mac := hmac.New(sha1.New, key)
+		for _, p := range parts {
+			mac.Write(p)
+		}
+		return mac.Sum(nil)
+	}
+
+	paddedSalt := make([]byte, 20)
+	copy(paddedSalt, salt)
+	chp := sha1.Sum(append(append([]byte{}, passKey...), salt...))
+
+	k1 := hmacSHA1(chp[:], paddedSalt, salt)
+	tk := hmacSHA1(chp[:], paddedSalt)
+	k2 := hmacSHA1(chp[:], tk, salt)
+	k := append(k1, k2...)
+
+	block, err := des.NewTripleDESCipher(k[:24])
+	if err != nil {
+		return nil, err
+	}
+	return cbcDecrypt(block, k[len(k)-8:], enc.Data)
+}
+
+func cbcDecrypt(block cipher.Block, iv, data []byte) ([]byte, error) {
+	size := block.BlockSize()
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, fmt.Errorf("malformed ciphertext")
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// A wrong key yields garbage padding, reported as a mismatch by the
+	// caller rather than an error here
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > size {
+		return plain, nil
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// nssSignAttribute computes the PBMAC1 (PBKDF2, HMAC-SHA256) signature NSS
+// stores for an authenticated attribute. The MAC covers the object ID and
+// attribute type, so it cannot be moved to another object.
+func nssSignAttribute(passKey []byte, objectID, typ uint32, value []byte) ([]byte, error) {
+	salt := make([]byte, sha256.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	// NSS uses a single iteration when the password is empty, which is
+	// the only case signatures are written for
+	const iterations = 1
+	key := pbkdf2.Key(passKey, salt, iterations, sha256.Size, sha256.New)
+
+	var prefix [8]byte
+	binary.BigEndian.PutUint32(prefix[:4], objectID)
+	binary.BigEndian.PutUint32(prefix[4:], typ)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(prefix[:])
+	mac.Write(value)
+
+	kdf, err := asn1.Marshal(keystore.PBKDF2Params{
+		Salt:           salt,
+		IterationCount: iterations,
+		KeyLength:      sha256.Size,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: keystore.OIDHMACWithSHA256},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := asn1.Marshal(pbmac1Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: keystore.OIDPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		MessageAuthScheme: pkix.AlgorithmIdentifier{Algorithm: keystore.OIDHMACWithSHA256},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(nssEncryptedData{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBMAC1, Parameters: asn1.RawValue{FullBytes: params}},
+		Data:      mac.Sum(nil),
+	})
+}
@@ -0,0 +1,348 @@
+package trust
+
+import (
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/instanttls/cli/internal/keystore"
+)
+
+var (
+	oidPBEWithSHAAnd3KeyDES  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd40BitRC2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	// oidJavaTrustedKeyUsage marks a certificate bag as a Java trusted
+	// certificate entry; its value lists the usages, here any
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+// pkcs12Keystore is a PKCS#12 keystore as written by keytool. JDK 18 and
+// later ship cacerts in this format, without a password.
+//
+// Safes are written back as they were read unless an entry was removed from
+// them, so keys and their encryption are never touched. A changed safe, and
+// the one holding new entries, is written unencrypted, as JDK cacerts files
+// are.
+type pkcs12Keystore struct {
+	safes []pkcs12Safe
+	// macAlgorithm is nil for a password-less keystore
+	macAlgorithm  asn1.ObjectIdentifier
+	macIterations int
+}
+
+type pkcs12Safe struct {
+	// raw is the ContentInfo as read, reused while the safe is unchanged
+	raw     []byte
+	bags    []pkcs12Bag
+	changed bool
+}
+
+type pkcs12Bag struct {
+	raw []byte
+	// alias and cert are set for trusted certificate entries only
+	alias string
+	cert  *x509.Certificate
+}
+
+// parsePKCS12 reads a PKCS#12 keystore, checking its MAC against password
+// unless the keystore has none
+func parsePKCS12(data []byte, password string) (*pkcs12Keystore, error) {
+	var pfx keystore.PFXPDU
+	if rest, err := asn1.Unmarshal(data, &pfx); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("not a PKCS#12 keystore")
+	}
+	if pfx.Version != 3 || !pfx.AuthSafe.ContentType.Equal(keystore.OIDDataContentType) {
+		return nil, fmt.Errorf("unsupported PKCS#12 keystore")
+	}
+
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, fmt.Errorf("malformed PKCS#12 keystore: %w", err)
+	}
+
+	ks := &pkcs12Keystore{}
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) > 0 {
+		newHash, err := keystore.MACHash(pfx.MacData.Mac.Algorithm.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		mac := keystore.MAC(newHash, authSafe, password, pfx.MacData.MacSalt, pfx.MacData.Iterations)
+		if !hmac.Equal(mac, pfx.MacData.Mac.Digest) {
+			return nil, fmt.Errorf("keystore password is not %q, or the keystore is corrupt", password)
+		}
+		ks.macAlgorithm = pfx.MacData.Mac.Algorithm.Algorithm
+		ks.macIterations = pfx.MacData.Iterations
+	}
+
+	var contents []asn1.RawValue
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, fmt.Errorf("malformed PKCS#12 keystore: %w", err)
+	}
+
+	for _, raw := range contents {
+		safe, err := parsePKCS12Safe(raw.FullBytes, password)
+		if err != nil {
+			return nil, err
+		}
+		ks.safes = append(ks.safes, safe)
+	}
+	return ks, nil
+}
+
+func parsePKCS12Safe(raw []byte, password string) (pkcs12Safe, error) {
+	safe := pkcs12Safe{raw: raw}
+
+	var ci keystore.ContentInfo
+	if _, err := asn1.Unmarshal(raw, &ci); err != nil {
+		return safe, fmt.Errorf("malformed PKCS#12 safe: %w", err)
+	}
+
+	var data []byte
+	switch {
+	case ci.ContentType.Equal(keystore.OIDDataContentType):
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &data); err != nil {
+			return safe, fmt.Errorf("malformed PKCS#12 safe: %w", err)
+		}
+	case ci.ContentType.Equal(keystore.OIDEncryptedDataContentType):
+		var ed keystore.EncryptedData
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+			return safe, fmt.Errorf("malformed PKCS#12 safe: %w", err)
+		}
+		var err error
+		data, err = pkcs12Decrypt(ed.EncryptedContentInfo.ContentEncryptionAlgorithm, ed.EncryptedContentInfo.EncryptedContent, password)
+		if err != nil {
+			return safe, err
+		}
+	default:
+		return safe, fmt.Errorf("unsupported PKCS#12 safe type %s", ci.ContentType)
+	}
+
+	var bags []asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &bags); err != nil {
+		return safe, fmt.Errorf("malformed PKCS#12 safe contents: %w", err)
+	}
+
+	for _, raw := range bags {
+		bag, err := parsePKCS12Bag(raw.FullBytes)
+		if err != nil {
+			return safe, err
+		}
+		safe.bags = append(safe.bags, bag)
+	}
+	return safe, nil
+}
+
+func parsePKCS12Bag(raw []byte) (pkcs12Bag, error) {
+	bag := pkcs12Bag{raw: raw}
+
+	var sb keystore.SafeBag
+	if _, err := asn1.Unmarshal(raw, &sb); err != nil {
+		return bag, fmt.Errorf("malformed PKCS#12 bag: %w", err)
+	}
+	if !sb.ID.Equal(keystore.OIDCertBag) {
+		return bag, nil
+	}
+
+	trusted := false
+	for _, attr := range sb.Attributes {
+		switch {
+		case attr.ID.Equal(oidJavaTrustedKeyUsage):
+			trusted = true
+		case attr.ID.Equal(keystore.OIDFriendlyName):
+			var value asn1.RawValue
+			if _, err := asn1.Unmarshal(attr.Value.Bytes, &value); err == nil {
+				bag.alias = keystore.DecodeBMPString(value.Bytes)
+			}
+		}
+	}
+	if !trusted {
+		return bag, nil
+	}
+
+	var cb keystore.CertBag
+	if _, err := asn1.Unmarshal(sb.Value.Bytes, &cb); err != nil {
+		return bag, fmt.Errorf("malformed PKCS#12 certificate bag: %w", err)
+	}
+	if !cb.ID.Equal(keystore.OIDX509Certificate) {
+		return bag, nil
+	}
+
+	cert, err := x509.ParseCertificate(cb.Data)
+	if err != nil {
+		return bag, fmt.Errorf("malformed certificate in keystore: %w", err)
+	}
+	bag.cert = cert
+	return bag, nil
+}
+
+func (ks *pkcs12Keystore) trusted() []keystoreEntry {
+	var entries []keystoreEntry
+	for _, safe := range ks.safes {
+		for _, bag := range safe.bags {
+			if bag.cert != nil {
+				entries = append(entries, keystoreEntry{Alias: bag.alias, Cert: bag.cert})
+			}
+		}
+	}
+	return entries
+}
+
+func (ks *pkcs12Keystore) addTrusted(alias string, cert *x509.Certificate) error {
+	raw, err := newTrustedCertBag(alias, cert)
+	if err != nil {
+		return err
+	}
+	ks.safes = append(ks.safes, pkcs12Safe{
+		bags:    []pkcs12Bag{{raw: raw, alias: alias, cert: cert}},
+		changed: true,
+	})
+	return nil
+}
+
+func (ks *pkcs12Keystore) removeTrusted(match func(*x509.Certificate) bool) int {
+	removed := 0
+	for i := range ks.safes {
+		safe := &ks.safes[i]
+		kept := safe.bags[:0]
+		for _, bag := range safe.bags {
+			if bag.cert != nil && match(bag.cert) {
+				removed++
+				safe.changed = true
+				continue
+			}
+			kept = append(kept, bag)
+		}
+		safe.bags = kept
+	}
+	return removed
+}
+
+func (ks *pkcs12Keystore) marshal(password string) ([]byte, error) {
+	var contents []asn1.RawValue
+	for _, safe := range ks.safes {
+		if !safe.changed {
+			contents = append(contents, asn1.RawValue{FullBytes: safe.raw})
+			continue
+		}
+		if len(safe.bags) == 0 {
+			continue
+		}
+
+		bags := make([]asn1.RawValue, len(safe.bags))
+		for i, bag := range safe.bags {
+			bags[i] = asn1.RawValue{FullBytes: bag.raw}
+		}
+		data, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		ci, err := keystore.DataContentInfo(data)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, asn1.RawValue{FullBytes: ci})
+	}
+
+	authSafe, err := asn1.Marshal(contents)
+	if err != nil {
+		return nil, err
+	}
+	octets, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	pfx := keystore.PFXPDU{
+		Version:  3,
+		AuthSafe: keystore.ContentInfo{ContentType: keystore.OIDDataContentType, Content: keystore.ExplicitContent(octets)},
+	}
+
+	if ks.macAlgorithm != nil {
+		newHash, err := keystore.MACHash(ks.macAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		salt := make([]byte, 20)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		iterations := ks.macIterations
+		if iterations < 1 {
+			iterations = 1
+		}
+		pfx.MacData = keystore.MacData{
+			Mac: keystore.DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: ks.macAlgorithm, Parameters: asn1.NullRawValue},
+				Digest:    keystore.MAC(newHash, authSafe, password, salt, iterations),
+			},
+			MacSalt:    salt,
+			Iterations: iterations,
+		}
+	}
+
+	return asn1.Marshal(pfx)
+}
+
+// newTrustedCertBag encodes a certificate the way keytool -importcert does:
+// a certificate bag with the alias as friendly name, marked as trusted for
+// any purpose
+func newTrustedCertBag(alias string, cert *x509.Certificate) ([]byte, error) {
+	value, err := asn1.Marshal(keystore.CertBag{ID: keystore.OIDX509Certificate, Data: cert.Raw})
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := keystore.FriendlyName(alias)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := asn1.Marshal(oidAnyExtendedKeyUsage)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(keystore.SafeBag{
+		ID:    keystore.OIDCertBag,
+		Value: keystore.ExplicitContent(value),
+		Attributes: []keystore.Attribute{
+			name,
+			{ID: oidJavaTrustedKeyUsage, Value: keystore.Set(usage)},
+		},
+	})
+}
+
+// pkcs12Decrypt decrypts an encrypted safe. keytool uses PBES2 with AES
+// since JDK 11.0.12 and SHA-1/3DES before that for keystores with a
+// password.
+func pkcs12Decrypt(alg pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	switch {
+	case alg.Algorithm.Equal(keystore.OIDPBES2):
+		return nssDecryptPBES2([]byte(password), nssEncryptedData{Algorithm: alg, Data: data})
+
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd3KeyDES):
+		var params nssPBEParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("malformed PBE parameters: %w", err)
+		}
+		pw := keystore.EncodeBMPPassword(password)
+		key := keystore.KDF(sha1.New, pw, params.Salt, params.IterationCount, 1, 24)
+		iv := keystore.KDF(sha1.New, pw, params.Salt, params.IterationCount, 2, des.BlockSize)
+		block, err := des.NewTripleDESCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cbcDecrypt(block, iv, data)
+
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2):
+		return nil, fmt.Errorf("keystore is encrypted with 40-bit RC2, which is not supported; convert it with 'keytool -importkeystore'")
+
+	default:
+		return nil, fmt.Errorf("unsupported keystore encryption %s", alg.Algorithm)
+	}
+}
@@ -0,0 +1,126 @@
+package trust
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/instanttls/cli/internal/keystore"
+)
+
+// The fixtures were written by openssl pkcs12 -export with the password
+// "changeit", and hold the key and certificate of a server:
+//
+//	keystore.p12       PBES2 with AES-256, HMAC-SHA256 MAC (openssl 3 default)
+//	keystore-3des.p12  pbeWithSHA1And3-KeyTripleDES, HMAC-SHA1 MAC (-legacy)
+func TestPKCS12RoundTrip(t *testing.T) {
+	for _, name := range []string{"keystore.p12", "keystore-3des.p12"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := parsePKCS12(data, "wrong"); err == nil {
+				t.Fatal("parsed with a wrong password")
+			}
+			ks, err := parsePKCS12(data, javaStorePassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ks.trusted(); len(got) != 0 {
+				t.Fatalf("fixture has %d trusted entries, want 0", len(got))
+			}
+			original := len(ks.safes)
+			macAlgorithm := ks.macAlgorithm
+
+			ca := newTestCA(t, "Round Trip CA")
+			if err := ks.addTrusted("instanttls", ca); err != nil {
+				t.Fatal(err)
+			}
+			out, err := ks.marshal(javaStorePassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := parsePKCS12(out, "wrong"); err == nil {
+				t.Fatal("rewritten keystore parsed with a wrong password")
+			}
+			ks, err = parsePKCS12(out, javaStorePassword)
+			if err != nil {
+				t.Fatalf("rewritten keystore: %v", err)
+			}
+			if !ks.macAlgorithm.Equal(macAlgorithm) {
+				t.Errorf("MAC algorithm changed from %s to %s", macAlgorithm, ks.macAlgorithm)
+			}
+
+			entries := ks.trusted()
+			if len(entries) != 1 || entries[0].Alias != "instanttls" || !entries[0].Cert.Equal(ca) {
+				t.Fatalf("trusted entries after add = %+v", entries)
+			}
+
+			// The safes of the fixture, including the encrypted key, must
+			// be carried over byte for byte
+			orig, err := parsePKCS12(data, javaStorePassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, safe := range orig.safes {
+				if !bytes.Equal(ks.safes[i].raw, safe.raw) {
+					t.Errorf("safe %d was rewritten", i)
+				}
+			}
+
+			if n := ks.removeTrusted(ca.Equal); n != 1 {
+				t.Fatalf("removeTrusted removed %d entries, want 1", n)
+			}
+			out, err = ks.marshal(javaStorePassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ks, err = parsePKCS12(out, javaStorePassword)
+			if err != nil {
+				t.Fatalf("keystore after removal: %v", err)
+			}
+			if got := ks.trusted(); len(got) != 0 {
+				t.Errorf("trusted entries after removal = %+v", got)
+			}
+			if len(ks.safes) != original {
+				t.Errorf("keystore has %d safes after removal, want %d", len(ks.safes), original)
+			}
+		})
+	}
+}
+
+// JDK 18 and later ship cacerts as a PKCS#12 file without a MAC, which must
+// stay without one
+func TestPKCS12WithoutPassword(t *testing.T) {
+	ks := &pkcs12Keystore{}
+	ca := newTestCA(t, "Password-less CA")
+	if err := ks.addTrusted("instanttls", ca); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ks.marshal(javaStorePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pfx keystore.PFXPDU
+	if _, err := asn1.Unmarshal(out, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) != 0 {
+		t.Errorf("password-less keystore was written with a MAC")
+	}
+
+	ks, err = parsePKCS12(out, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := ks.trusted()
+	if len(entries) != 1 || !entries[0].Cert.Equal(ca) {
+		t.Fatalf("trusted entries = %+v", entries)
+	}
+}
@@ -0,0 +1,474 @@
+package trust
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/config"
+)
+
+// Runtime is a language runtime or tool that does not consistently use the
+// OS trust store
+type Runtime string
+
+const (
+	RuntimeJava   Runtime = "java"
+	RuntimeNode   Runtime = "node"
+	RuntimePython Runtime = "python"
+	RuntimeGo     Runtime = "go"
+	RuntimeCurl   Runtime = "curl"
+)
+
+// Runtimes lists every supported runtime, in the order "all" selects them
+var Runtimes = []Runtime{RuntimeJava, RuntimeNode, RuntimePython, RuntimeGo, RuntimeCurl}
+
+// runtimeEnv lists the variables that point each runtime at a CA bundle.
+// Java reads none of them and gets the CA imported into its keystores.
+var runtimeEnv = map[Runtime][]string{
+	RuntimeNode:   {"NODE_EXTRA_CA_CERTS"},
+	RuntimePython: {"REQUESTS_CA_BUNDLE", "SSL_CERT_FILE"},
+	RuntimeGo:     {"SSL_CERT_FILE"},
+	RuntimeCurl:   {"CURL_CA_BUNDLE", "SSL_CERT_FILE"},
+}
+
+// linuxRootBundles are the system CA bundles of the common distributions,
+// in the order Go's crypto/x509 looks for them
+var linuxRootBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+const (
+	rcBeginMarker = "# BEGIN InstantTLS (managed by instanttls trust --runtime, do not edit)"
+	rcEndMarker   = "# END InstantTLS"
+)
+
+// EnvVar is an environment variable to set for a runtime
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// ParseRuntimes turns names such as "java", "node,python" or "all" into
+// runtimes, without duplicates
+func ParseRuntimes(names []string) ([]Runtime, error) {
+	selected := make(map[Runtime]bool)
+	for _, name := range names {
+		for _, part := range strings.Split(name, ",") {
+			part = strings.ToLower(strings.TrimSpace(part))
+			switch {
+			case part == "":
+			case part == "all":
+				for _, rt := range Runtimes {
+					selected[rt] = true
+				}
+			case isRuntime(Runtime(part)):
+				selected[Runtime(part)] = true
+			default:
+				return nil, fmt.Errorf("unknown runtime %q (expected java, node, python, go, curl or all)", part)
+			}
+		}
+	}
+
+	var runtimes []Runtime
+	for _, rt := range Runtimes {
+		if selected[rt] {
+			runtimes = append(runtimes, rt)
+		}
+	}
+	return runtimes, nil
+}
+
+func isRuntime(rt Runtime) bool {
+	for _, known := range Runtimes {
+		if rt == known {
+			return true
+		}
+	}
+	return false
+}
+
+// BundlePath is where WriteBundle puts the combined CA bundle
+func BundlePath() string {
+	return filepath.Join(config.GetCADir(), "ca-bundle.pem")
+}
+
+// envFilePath records the persisted variables as a shell script that the
+// shell startup files source
+func envFilePath() string {
+	return filepath.Join(config.GetCertDir(), "env.sh")
+}
+
+// InstallJava imports the CA into every JDK keystore found. Keystores
+// owned by root are changed through sudo.
+func InstallJava() ([]StoreStatus, error) {
+	caCert, err := loadCACert(filepath.Join(config.GetCADir(), "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []StoreStatus
+	for _, ks := range FindJavaKeystores() {
+		err := ks.Install(caCert, SudoRunner)
+		statuses = append(statuses, StoreStatus{Store: ks.String(), Trusted: err == nil, Err: err})
+	}
+	return statuses, nil
+}
+
+// WriteBundle writes Bundle to BundlePath
+func WriteBundle() (string, error) {
+	data, err := Bundle()
+	if err != nil {
+		return "", err
+	}
+
+	path := BundlePath()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	return path, nil
+}
+
+// Bundle returns the OS trust store's roots plus the CA as PEM. Runtimes
+// pointed at it through an environment variable trust the same public CAs
+// as before, and the CA as well.
+func Bundle() ([]byte, error) {
+	caCert, err := loadCACert(filepath.Join(config.GetCADir(), "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := systemRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+	for _, c := range append(roots, caCert) {
+		fp := cert.Fingerprint(c)
+		if seen[fp] {
+			continue
+		}
+		seen[fp] = true
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return buf.Bytes(), nil
+}
+
+// systemRoots returns the certificates the OS trusts for TLS
+func systemRoots() ([]*x509.Certificate, error) {
+	switch runtime.GOOS {
+	case "linux":
+		for _, path := range linuxRootBundles {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			if certs := parsePEMCerts(data); len(certs) > 0 {
+				return certs, nil
+			}
+		}
+		return nil, fmt.Errorf("no system CA bundle found (looked for %s)", strings.Join(linuxRootBundles, ", "))
+
+	case "darwin":
+		roots, err := keychainCerts("/System/Library/Keychains/SystemRootCertificates.keychain")
+		if err != nil {
+			return nil, err
+		}
+		// CAs added by an administrator, which may include this one
+		added, _ := keychainCerts(systemKeychain)
+		return append(roots, added...), nil
+
+	case "windows":
+		return windowsRootCerts()
+
+	default:
+		return nil, fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
+}
+
+// RuntimeEnv returns the variables that point the runtimes at bundle,
+// sorted by name
+func RuntimeEnv(runtimes []Runtime, bundle string) []EnvVar {
+	names := make(map[string]bool)
+	for _, rt := range runtimes {
+		for _, name := range runtimeEnv[rt] {
+			names[name] = true
+		}
+	}
+
+	var vars []EnvVar
+	for name := range names {
+		vars = append(vars, EnvVar{Name: name, Value: bundle})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// PersistEnv makes the variables permanent for the current user, merged
+// with those persisted earlier, and returns where they were written. On
+// Unix they go to ~/.instanttls/env.sh, which a delimited block in the
+// shell startup files sources; on Windows they are set with setx and
+// env.sh only records them for untrust.
+func PersistEnv(vars []EnvVar) ([]string, error) {
+	merged := make(map[string]string)
+	for _, v := range readEnvFile() {
+		merged[v.Name] = v.Value
+	}
+	for _, v := range vars {
+		merged[v.Name] = v.Value
+	}
+
+	var all []EnvVar
+	for name, value := range merged {
+		all = append(all, EnvVar{Name: name, Value: value})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+
+	if err := writeEnvFile(all); err != nil {
+		return nil, err
+	}
+
+	if runtime.GOOS == "windows" {
+		for _, v := range vars {
+			if out, err := exec.Command("setx", v.Name, v.Value).CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("setx %s failed: %s", v.Name, strings.TrimSpace(string(out)))
+			}
+		}
+		return []string{`HKCU\Environment`}, nil
+	}
+
+	written := []string{envFilePath()}
+	source := fmt.Sprintf("[ -f %s ] && . %s", shellQuote(envFilePath()), shellQuote(envFilePath()))
+	for _, rc := range shellStartupFiles() {
+		if err := updateRCBlock(rc, []string{source}); err != nil {
+			return written, err
+		}
+		written = append(written, rc)
+	}
+
+	if fish := fishConfigPath(); fish != "" {
+		var lines []string
+		for _, v := range all {
+			lines = append(lines, fmt.Sprintf("set -gx %s %s", v.Name, fishQuote(v.Value)))
+		}
+		if err := os.MkdirAll(filepath.Dir(fish), 0755); err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(fish, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", fish, err)
+		}
+		written = append(written, fish)
+	}
+
+	return written, nil
+}
+
+// uninstallRuntimeEnv removes the persisted variables and the combined
+// bundle. Nothing is reported as removed if neither exists.
+func uninstallRuntimeEnv() StoreResult {
+	result := StoreResult{Store: "Runtime environment (" + envFilePath() + ")"}
+
+	vars := readEnvFile()
+	if runtime.GOOS == "windows" {
+		for _, v := range vars {
+			if err := exec.Command("reg", "delete", `HKCU\Environment`, "/v", v.Name, "/f").Run(); err == nil {
+				result.Removed = true
+			}
+		}
+	} else {
+		for _, rc := range shellStartupFiles() {
+			if !fileExists(rc) {
+				continue
+			}
+			removed, err := removeRCBlock(rc)
+			if err != nil {
+				result.Err = err
+				return result
+			}
+			result.Removed = result.Removed || removed
+		}
+		if fish := fishConfigPath(); fish != "" && fileExists(fish) {
+			if err := os.Remove(fish); err != nil {
+				result.Err = err
+				return result
+			}
+			result.Removed = true
+		}
+	}
+
+	for _, path := range []string{envFilePath(), BundlePath()} {
+		err := os.Remove(path)
+		if err == nil {
+			result.Removed = true
+		} else if !os.IsNotExist(err) {
+			result.Err = err
+		}
+	}
+	return result
+}
+
+func readEnvFile() []EnvVar {
+	data, err := os.ReadFile(envFilePath())
+	if err != nil {
+		return nil
+	}
+
+	var vars []EnvVar
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "export ")
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if unquoted, err := unquoteShell(value); err == nil {
+			value = unquoted
+		}
+		vars = append(vars, EnvVar{Name: name, Value: value})
+	}
+	return vars
+}
+
+func writeEnvFile(vars []EnvVar) error {
+	lines := []string{"# Written by instanttls trust --runtime. Remove with instanttls untrust."}
+	for _, v := range vars {
+		lines = append(lines, fmt.Sprintf("export %s=%s", v.Name, shellQuote(v.Value)))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(envFilePath()), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(envFilePath(), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", envFilePath(), err)
+	}
+	return nil
+}
+
+// shellStartupFiles returns the startup files of the shells in use: those
+// of bash and zsh that exist, plus the one for $SHELL. ~/.profile is the
+// fallback when there is neither.
+func shellStartupFiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	rcFiles := map[string]string{
+		"bash": filepath.Join(home, ".bashrc"),
+		"zsh":  filepath.Join(home, ".zshrc"),
+	}
+
+	var files []string
+	for _, sh := range []string{"bash", "zsh"} {
+		if fileExists(rcFiles[sh]) || filepath.Base(os.Getenv("SHELL")) == sh {
+			files = append(files, rcFiles[sh])
+		}
+	}
+	if len(files) == 0 {
+		files = append(files, filepath.Join(home, ".profile"))
+	}
+	return files
+}
+
+// fishConfigPath returns the fish snippet to write, or "" if fish is not
+// configured for this user
+func fishConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Join(home, ".config", "fish")
+	if _, err := os.Stat(dir); err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "conf.d", "instanttls.fish")
+}
+
+// updateRCBlock replaces the InstantTLS block in a startup file with lines,
+// appending the block if the file has none
+func updateRCBlock(path string, lines []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	content, _ := stripRCBlock(string(data))
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	block := append(append([]string{rcBeginMarker}, lines...), rcEndMarker)
+	content += strings.Join(block, "\n") + "\n"
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	return nil
+}
+
+func removeRCBlock(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	content, found := stripRCBlock(string(data))
+	if !found {
+		return false, nil
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return false, fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// stripRCBlock removes the InstantTLS block, leaving every other line as it
+// was
+func stripRCBlock(content string) (string, bool) {
+	var kept []string
+	inBlock, found := false, false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == rcBeginMarker:
+			inBlock, found = true, true
+		case inBlock && trimmed == rcEndMarker:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, ""), found
+}
+
+// shellQuote quotes a value for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func unquoteShell(s string) (string, error) {
+	if strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") && len(s) >= 2 {
+		return strings.ReplaceAll(s[1:len(s)-1], `'\''`, "'"), nil
+	}
+	return strconv.Unquote(s)
+}
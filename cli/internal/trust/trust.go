@@ -1,16 +1,41 @@
 package trust
 
 import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/instanttls/cli/internal/config"
 	"github.com/pterm/pterm"
 )
 
+// Runner runs a command as root. Backends take one so they can be tested
+// without touching the system.
+type Runner func(name string, args ...string) error
+
+// SudoRunner runs a command through sudo, or directly when already root or
+// on Windows, with the terminal attached so sudo can ask for a password
+func SudoRunner(name string, args ...string) error {
+	cmd := exec.Command("sudo", append([]string{name}, args...)...)
+	if os.Geteuid() == 0 || runtime.GOOS == "windows" {
+		cmd = exec.Command(name, args...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
+
 // InstallCA installs the CA certificate into the OS trust store
 func InstallCA() error {
 	caDir := config.GetCADir()
@@ -20,16 +45,24 @@ func InstallCA() error {
 		return fmt.Errorf("CA certificate not found. Run 'instanttls init' first")
 	}
 
+	var err error
 	switch runtime.GOOS {
 	case "darwin":
-		return installDarwin(certPath)
+		err = installDarwin(certPath)
 	case "linux":
-		return installLinux(certPath)
+		err = installLinux(certPath)
 	case "windows":
-		return installWindows(certPath)
+		err = installWindows(certPath)
 	default:
-		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+		err = fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
+	if err != nil {
+		return err
 	}
+
+	// Firefox, and Chromium on Linux, keep their own NSS databases
+	installNSS(certPath)
+	return nil
 }
 
 func installDarwin(certPath string) error {
@@ -60,65 +93,6 @@ func installDarwin(certPath string) error {
 	return nil
 }
 
-func installLinux(certPath string) error {
-	// Check for common Linux distributions
-	destPath := "/usr/local/share/ca-certificates/instanttls.crt"
-
-	cmd := fmt.Sprintf("sudo cp %s %s && sudo update-ca-certificates", certPath, destPath)
-
-	pterm.Info.Println("Installing CA certificate into Linux trust store...")
-	pterm.Println()
-	pterm.DefaultBox.WithTitle("Commands to run").Println(cmd)
-	pterm.Println()
-
-	// Check if update-ca-certificates exists
-	if _, err := exec.LookPath("update-ca-certificates"); err != nil {
-		pterm.Warning.Println("update-ca-certificates not found.")
-		pterm.Println()
-		pterm.DefaultBox.WithTitle("Manual Installation").Println(`
-For Fedora/RHEL/CentOS:
-  sudo cp ` + certPath + ` /etc/pki/ca-trust/source/anchors/
-  sudo update-ca-trust
-
-For Arch Linux:
-  sudo trust anchor --store ` + certPath + `
-
-For other distributions, consult your documentation.
-`)
-		return fmt.Errorf("automatic installation not supported on this distribution")
-	}
-
-	result, _ := pterm.DefaultInteractiveConfirm.
-		WithDefaultValue(true).
-		Show("This requires sudo. Continue?")
-
-	if !result {
-		return fmt.Errorf("installation cancelled by user")
-	}
-
-	// Copy certificate
-	cpCmd := exec.Command("sudo", "cp", certPath, destPath)
-	cpCmd.Stdin = os.Stdin
-	cpCmd.Stdout = os.Stdout
-	cpCmd.Stderr = os.Stderr
-
-	if err := cpCmd.Run(); err != nil {
-		return fmt.Errorf("failed to copy CA certificate: %w", err)
-	}
-
-	// Update CA certificates
-	updateCmd := exec.Command("sudo", "update-ca-certificates")
-	updateCmd.Stdin = os.Stdin
-	updateCmd.Stdout = os.Stdout
-	updateCmd.Stderr = os.Stderr
-
-	if err := updateCmd.Run(); err != nil {
-		return fmt.Errorf("failed to update CA certificates: %w", err)
-	}
-
-	return nil
-}
-
 func installWindows(certPath string) error {
 	cmd := fmt.Sprintf("certutil -addstore Root \"%s\"", certPath)
 
@@ -147,46 +121,149 @@ func installWindows(certPath string) error {
 	return nil
 }
 
-// IsTrusted checks if the CA is installed in the trust store
-func IsTrusted() bool {
-	caDir := config.GetCADir()
-	certPath := filepath.Join(caDir, "ca.crt")
+// StoreResult is the outcome of removing the CA from one trust store
+type StoreResult struct {
+	Store string
+	// Removed is false if the store did not hold the CA
+	Removed bool
+	Err     error
+}
 
-	if _, err := os.Stat(certPath); os.IsNotExist(err) {
-		return false
+// UninstallCA removes the CA from the OS trust store, the Firefox and
+// Chromium NSS databases and Java keystores, and undoes the runtime
+// environment set up by trust --runtime. Stores that do not hold the CA
+// are left untouched, so running it again is harmless.
+func UninstallCA() ([]StoreResult, error) {
+	certPath := filepath.Join(config.GetCADir(), "ca.crt")
+
+	caCert, err := loadCACert(certPath)
+	if err != nil {
+		return nil, err
 	}
 
+	var results []StoreResult
 	switch runtime.GOOS {
 	case "darwin":
-		return isTrustedDarwin(certPath)
+		removed, err := uninstallDarwin(certPath, caCert)
+		results = append(results, StoreResult{Store: "macOS System Keychain", Removed: removed, Err: err})
 	case "linux":
-		return isTrustedLinux()
+		results = append(results, uninstallLinux(certPath))
 	case "windows":
-		return isTrustedWindows()
-	default:
-		return false
+		removed, err := uninstallWindows(caCert)
+		results = append(results, StoreResult{Store: "Windows Root store", Removed: removed, Err: err})
 	}
+
+	for _, db := range FindNSSDatabases() {
+		trusted, _ := db.IsTrusted(caCert)
+		err := db.Uninstall(caCert)
+		results = append(results, StoreResult{Store: db.String(), Removed: trusted && err == nil, Err: err})
+	}
+
+	for _, ks := range FindJavaKeystores() {
+		found, _ := ks.Contains(caCert)
+		err := ks.Uninstall(caCert, SudoRunner)
+		results = append(results, StoreResult{Store: ks.String(), Removed: found && err == nil, Err: err})
+	}
+
+	results = append(results, uninstallRuntimeEnv())
+
+	return results, nil
 }
 
-func isTrustedDarwin(certPath string) bool {
-	cmd := exec.Command("security", "find-certificate", "-c", "InstantTLS Local Development CA", "/Library/Keychains/System.keychain")
-	if err := cmd.Run(); err != nil {
-		return false
+// confirmSudo shows the commands about to run and asks before running them
+func confirmSudo(title string, commands []string) error {
+	pterm.Info.Println(title)
+	pterm.Println()
+	pterm.DefaultBox.WithTitle("Commands to run").Println(strings.Join(commands, "\n"))
+	pterm.Println()
+
+	result, _ := pterm.DefaultInteractiveConfirm.
+		WithDefaultValue(true).
+		Show("This requires sudo. Continue?")
+
+	if !result {
+		return fmt.Errorf("cancelled by user")
 	}
-	return true
+	return nil
 }
 
-func isTrustedLinux() bool {
-	destPath := "/usr/local/share/ca-certificates/instanttls.crt"
-	_, err := os.Stat(destPath)
-	return err == nil
+// certSHA1 is the thumbprint macOS and Windows identify certificates by
+func certSHA1(caCert *x509.Certificate) string {
+	sum := sha1.Sum(caCert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
 }
 
-func isTrustedWindows() bool {
-	cmd := exec.Command("certutil", "-verify", "-urlfetch")
+func uninstallDarwin(certPath string, caCert *x509.Certificate) (bool, error) {
+	const keychain = "/Library/Keychains/System.keychain"
+
+	out, _ := exec.Command("security", "find-certificate", "-a", "-Z", "-c", caCert.Subject.CommonName, keychain).Output()
+	if !strings.Contains(strings.ToUpper(string(out)), certSHA1(caCert)) {
+		return false, nil
+	}
+
+	err := confirmSudo("Removing CA certificate from macOS Keychain...", []string{
+		"sudo security remove-trusted-cert -d " + certPath,
+		"sudo security delete-certificate -Z " + certSHA1(caCert) + " " + keychain,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// The trust settings may already be gone; the certificate is what counts
+	_ = SudoRunner("security", "remove-trusted-cert", "-d", certPath)
+	if err := SudoRunner("security", "delete-certificate", "-Z", certSHA1(caCert), keychain); err != nil {
+		return false, fmt.Errorf("failed to remove CA: %w", err)
+	}
+	return true, nil
+}
+
+func uninstallLinux(certPath string) StoreResult {
+	backend, err := DetectLinuxBackend(SudoRunner)
+	if err != nil {
+		return StoreResult{Store: "System trust store", Err: err}
+	}
+
+	result := StoreResult{Store: backend.Name()}
+	if !backend.Present(certPath) {
+		return result
+	}
+
+	if err := confirmSudo("Removing CA certificate from Linux trust store...", backend.UninstallCommands(certPath)); err != nil {
+		result.Err = err
+		return result
+	}
+	if err := backend.Uninstall(certPath); err != nil {
+		result.Err = err
+		return result
+	}
+	result.Removed = true
+	return result
+}
+
+func uninstallWindows(caCert *x509.Certificate) (bool, error) {
+	if err := exec.Command("certutil", "-store", "Root", certSHA1(caCert)).Run(); err != nil {
+		// Not in the store
+		return false, nil
+	}
+
+	pterm.Info.Println("Removing CA certificate from Windows trust store...")
+	cmd := exec.Command("certutil", "-delstore", "Root", certSHA1(caCert))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		// This is a rough check, Windows cert verification is complex
+		return false, fmt.Errorf("failed to remove CA: %w. Try running as Administrator", err)
+	}
+	return true, nil
+}
+
+// IsTrusted reports whether the OS trust store holds the CA in ca.crt,
+// matched by fingerprint
+func IsTrusted() bool {
+	certPath := filepath.Join(config.GetCADir(), "ca.crt")
+
+	caCert, err := loadCACert(certPath)
+	if err != nil {
 		return false
 	}
-	return true
+	return verifyOSStore(certPath, caCert).Trusted
 }
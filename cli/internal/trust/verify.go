@@ -0,0 +1,235 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/instanttls/cli/internal/cert"
+	"github.com/instanttls/cli/internal/config"
+)
+
+// systemKeychain holds the CAs an administrator added on macOS
+const systemKeychain = "/Library/Keychains/System.keychain"
+
+// errNotInstalled is the reason given for a store that does not hold the CA
+var errNotInstalled = errors.New("CA not installed")
+
+// StoreStatus is the state of the CA in one trust store
+type StoreStatus struct {
+	Store   string
+	Trusted bool
+	// Err says why the CA is not trusted, or why the store could not be
+	// checked
+	Err error
+}
+
+// VerifyStores checks the OS trust store and every browser database for
+// the CA in ca.crt. Certificates are matched by SHA-256 fingerprint, so a
+// store holding an earlier InstantTLS CA is reported as not trusted.
+func VerifyStores() ([]StoreStatus, error) {
+	certPath := filepath.Join(config.GetCADir(), "ca.crt")
+
+	caCert, err := loadCACert(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []StoreStatus{verifyOSStore(certPath, caCert)}
+
+	nss, err := CheckNSS(certPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range nss {
+		status := StoreStatus{Store: s.DB.String(), Trusted: s.Trusted, Err: s.Err}
+		if !s.Trusted && s.Err == nil {
+			status.Err = errNotInstalled
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// VerifyChain builds a chain from leaf to a root in the system certificate
+// pool, the way Go programs and most command line tools will. On macOS and
+// Windows the pool defers to the platform verifier, which also honours
+// trust settings.
+func VerifyChain(leaf *tls.Certificate) error {
+	if len(leaf.Certificate) == 0 {
+		return fmt.Errorf("empty certificate chain")
+	}
+
+	leafCert := leaf.Leaf
+	if leafCert == nil {
+		var err error
+		if leafCert, err = x509.ParseCertificate(leaf.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		return fmt.Errorf("failed to load system certificate pool: %w", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, der := range leaf.Certificate[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse intermediate certificate: %w", err)
+		}
+		intermediates.AddCert(c)
+	}
+
+	_, err = leafCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+func verifyOSStore(certPath string, caCert *x509.Certificate) StoreStatus {
+	switch runtime.GOOS {
+	case "darwin":
+		return verifyDarwin(caCert)
+	case "linux":
+		return verifyLinux(certPath)
+	case "windows":
+		return verifyWindows(caCert)
+	default:
+		return StoreStatus{Store: "System trust store", Err: fmt.Errorf("unsupported operating system: %s", runtime.GOOS)}
+	}
+}
+
+func verifyDarwin(caCert *x509.Certificate) StoreStatus {
+	const store = "macOS System Keychain"
+
+	installed, err := keychainCerts(systemKeychain, "-c", caCert.Subject.CommonName)
+	if err != nil {
+		// security exits non-zero when nothing matches
+		return StoreStatus{Store: store, Err: errNotInstalled}
+	}
+	return matchCA(store, installed, caCert)
+}
+
+func verifyLinux(certPath string) StoreStatus {
+	backend, err := DetectLinuxBackend(SudoRunner)
+	if err != nil {
+		return StoreStatus{Store: "System trust store", Err: err}
+	}
+
+	status := StoreStatus{Store: backend.Name()}
+	if err := backend.Verify(certPath); err != nil {
+		status.Err = err
+		return status
+	}
+	status.Trusted = true
+	return status
+}
+
+func verifyWindows(caCert *x509.Certificate) StoreStatus {
+	const store = "Windows Root store"
+
+	installed, err := windowsRootCerts()
+	if err != nil {
+		return StoreStatus{Store: store, Err: err}
+	}
+	return matchCA(store, installed, caCert)
+}
+
+// keychainCerts lists the certificates in a macOS keychain; args narrow
+// the search, e.g. "-c" and a common name
+func keychainCerts(keychain string, args ...string) ([]*x509.Certificate, error) {
+	args = append(append([]string{"find-certificate", "-a", "-p"}, args...), keychain)
+	out, err := exec.Command("security", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", keychain, err)
+	}
+	return parsePEMCerts(out), nil
+}
+
+// windowsRootCerts lists the machine's trusted root certificates. certutil
+// can only look certificates up by SHA-1, so they are exported raw and
+// compared here.
+func windowsRootCerts() ([]*x509.Certificate, error) {
+	script := `Get-ChildItem Cert:\LocalMachine\Root | ForEach-Object { [Convert]::ToBase64String($_.RawData) }`
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the Root store: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for _, line := range strings.Fields(string(out)) {
+		der, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			continue
+		}
+		if c, err := x509.ParseCertificate(der); err == nil {
+			certs = append(certs, c)
+		}
+	}
+	return certs, nil
+}
+
+// parsePEMCerts returns the certificates in PEM data, skipping anything
+// that does not parse
+func parsePEMCerts(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, c)
+		}
+	}
+}
+
+// matchCA looks for the CA by fingerprint among the certificates a store
+// holds. Every InstantTLS CA has the same subject, so a subject match alone
+// means the store still trusts an earlier CA.
+func matchCA(store string, installed []*x509.Certificate, caCert *x509.Certificate) StoreStatus {
+	status := StoreStatus{Store: store}
+	want := cert.Fingerprint(caCert)
+
+	for _, c := range installed {
+		if cert.Fingerprint(c) == want {
+			status.Trusted = true
+			return status
+		}
+	}
+
+	for _, c := range installed {
+		if bytes.Equal(c.RawSubject, caCert.RawSubject) {
+			status.Err = fmt.Errorf("holds a different CA (SHA-256 %s), not ca.crt (SHA-256 %s)",
+				shortFingerprint(cert.Fingerprint(c)), shortFingerprint(want))
+			return status
+		}
+	}
+
+	status.Err = errNotInstalled
+	return status
+}
+
+func shortFingerprint(fp string) string {
+	if len(fp) > 16 {
+		return fp[:16] + "…"
+	}
+	return fp
+}
@@ -0,0 +1,5 @@
+package version
+
+// Version is the CLI release version, set at build time with
+// -ldflags "-X github.com/instanttls/cli/internal/version.Version=v1.2.3"
+var Version = "dev"